@@ -0,0 +1,102 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// AggregationFormat packs the user records of a shard into a single Kinesis record
+// instead of the KPL aggregated record format, for consumers that are not based on the
+// KCL, see Config.AggregationFormat. Formats are called under the lock of the aggregator
+// and must be safe for concurrent use by aggregators of different shards.
+type AggregationFormat interface {
+	// RecordSize returns the largest number of bytes the user record adds to an
+	// aggregated record
+	RecordSize(userRecord UserRecord) int
+	// Overhead returns the number of bytes of an aggregated record besides its user
+	// records
+	Overhead() int
+	// Encode returns the aggregated record of the user records
+	Encode(userRecords []UserRecord) ([]byte, error)
+}
+
+var (
+	// NDJSON aggregates user records whose data is a JSON document into newline delimited
+	// JSON, one document per line. Documents are compacted so they fit on one line, and
+	// Put returns *ErrInvalidJSON for aggregated records whose data is not valid JSON.
+	// It is not supported with the options that rewrite the data, e.g. Compression.
+	NDJSON AggregationFormat = ndjsonFormat{}
+
+	// LengthPrefixed aggregates user records as the big endian uint32 length of their data
+	// followed by their data, see deaggregation.SplitLengthPrefixed.
+	LengthPrefixed AggregationFormat = lengthPrefixedFormat{}
+
+	// PassThrough does not aggregate user records, each one is sent as its own Kinesis
+	// record, as with WithoutAggregation.
+	PassThrough AggregationFormat = passThroughFormat{}
+)
+
+type ndjsonFormat struct{}
+
+func (ndjsonFormat) RecordSize(userRecord UserRecord) int { return userRecord.Size() + 1 }
+func (ndjsonFormat) Overhead() int                        { return 0 }
+
+func (ndjsonFormat) Encode(userRecords []UserRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, userRecord := range userRecords {
+		if err := json.Compact(&buf, userRecord.Data()); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+type lengthPrefixedFormat struct{}
+
+func (lengthPrefixedFormat) RecordSize(userRecord UserRecord) int { return 4 + userRecord.Size() }
+func (lengthPrefixedFormat) Overhead() int                        { return 0 }
+
+func (f lengthPrefixedFormat) Encode(userRecords []UserRecord) ([]byte, error) {
+	var size int
+	for _, userRecord := range userRecords {
+		size += f.RecordSize(userRecord)
+	}
+	out := make([]byte, 0, size)
+	for _, userRecord := range userRecords {
+		out = binary.BigEndian.AppendUint32(out, uint32(userRecord.Size()))
+		out = append(out, userRecord.Data()...)
+	}
+	return out, nil
+}
+
+// passThroughFormat is never used by the aggregators, records are put as plain records
+type passThroughFormat struct{}
+
+func (passThroughFormat) RecordSize(userRecord UserRecord) int { return userRecord.Size() }
+func (passThroughFormat) Overhead() int                        { return 0 }
+
+func (passThroughFormat) Encode(userRecords []UserRecord) ([]byte, error) {
+	if len(userRecords) != 1 {
+		return nil, errors.New("kinesis: PassThrough can not aggregate user records")
+	}
+	return userRecords[0].Data(), nil
+}
+
+// drainFormat drains the aggregator into an aggregated record of its format
+func (a *Aggregator) drainFormat() (*AggregatedRecordRequest, error) {
+	data, err := a.format.Encode(a.buf)
+	if err != nil {
+		drainErr := &DrainError{
+			Err:         err,
+			UserRecords: a.buf,
+		}
+		a.clear()
+		return nil, drainErr
+	}
+	request := a.request(data)
+	a.clear()
+	return request, nil
+}
@@ -0,0 +1,165 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/deaggregation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregationFormats(t *testing.T) {
+	for name, tc := range map[string]struct {
+		format AggregationFormat
+		data   []string
+		split  func(data []byte) ([][]byte, error)
+	}{
+		"ndjson": {
+			format: NDJSON,
+			data:   []string{"{\n\"a\": 1\n}", `{"b":2}`},
+			split: func(data []byte) ([][]byte, error) {
+				return deaggregation.SplitNDJSON(data), nil
+			},
+		},
+		"length prefixed": {
+			format: LengthPrefixed,
+			data:   []string{"hello", "world"},
+			split:  deaggregation.SplitLengthPrefixed,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &clientMock{
+				incoming: make(map[int][]string),
+				responses: []responseMock{
+					{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+				},
+			}
+			p := New(&Config{
+				StreamName:        "foo",
+				MaxConnections:    1,
+				FlushInterval:     time.Hour,
+				Logger:            &NopLogger{},
+				Client:            client,
+				AggregationFormat: tc.format,
+			})
+			p.Start()
+			defer p.Stop()
+
+			for _, data := range tc.data {
+				require.NoError(t, p.Put([]byte(data), "foo"))
+			}
+			require.NoError(t, p.Flush(context.Background()))
+			require.Len(t, client.data, 1)
+			records, err := tc.split(client.data[0])
+			require.NoError(t, err)
+			require.Len(t, records, len(tc.data))
+		})
+	}
+}
+
+func TestAggregationFormatSize(t *testing.T) {
+	a := NewAggregator(nil)
+	a.format = LengthPrefixed
+	a.Put(NewDataRecord([]byte("hello"), "foo"))
+	require.Equal(t, 9, a.Size())
+	// the partition key of the request counts towards the size
+	require.False(t, a.willExceed(NewDataRecord([]byte("world"), "bar"), 9+9+len("foo")))
+	require.True(t, a.willExceed(NewDataRecord([]byte("world"), "bar"), 9+9+len("foo")-1))
+}
+
+func TestNDJSONInvalid(t *testing.T) {
+	a := NewAggregator(nil)
+	a.format = NDJSON
+	a.Put(NewDataRecord([]byte("hello"), "foo"))
+	_, err := a.Drain()
+	var drainErr *DrainError
+	require.ErrorAs(t, err, &drainErr)
+	require.Len(t, drainErr.UserRecords, 1)
+	require.Zero(t, a.Count())
+
+	// the producer rejects invalid documents at Put time, valid ones are still delivered
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:        "foo",
+		MaxConnections:    1,
+		FlushInterval:     time.Hour,
+		Logger:            &NopLogger{},
+		Client:            client,
+		AggregationFormat: NDJSON,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.IsType(t, &ErrInvalidJSON{}, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Put([]byte(`{"a":1}`), "foo"))
+	// records that are not aggregated are sent as they are
+	require.NoError(t, p.Put([]byte("raw"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+	require.ElementsMatch(t, [][]byte{[]byte("{\"a\":1}\n"), []byte("raw")}, client.data)
+	require.Zero(t, p.Stats().BacklogLength)
+
+	c := &Config{StreamName: "foo", Client: client, AggregationFormat: NDJSON, MaxChunkSize: 1024}
+	require.Equal(t, []error{
+		errors.New("kinesis: NDJSON is not supported with Envelope, Compression, Encryptor, MaxChunkSize or LargeRecordStore"),
+	}, c.Validate())
+}
+
+func TestPassThrough(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:        "foo",
+		MaxConnections:    1,
+		FlushInterval:     time.Hour,
+		Logger:            &NopLogger{},
+		Client:            client,
+		AggregationFormat: PassThrough,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Put([]byte("world"), "bar"))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, [][]byte{[]byte("hello"), []byte("world")}, client.data)
+	require.Equal(t, []string{"foo", "bar"}, client.incoming[0])
+}
+
+func TestDisableAggregation(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p, err := NewProducer(
+		WithStreamName("foo"),
+		WithClient(client),
+		WithLogger(&NopLogger{}),
+		WithFlushInterval(time.Hour),
+		WithAggregationDisabled(),
+	)
+	require.NoError(t, err)
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Put([]byte("world"), "bar"))
+	require.NoError(t, p.Flush(context.Background()))
+	// both records are sent in the same request
+	require.Equal(t, 1, client.calls)
+	require.Equal(t, [][]byte{[]byte("hello"), []byte("world")}, client.data)
+}
@@ -2,11 +2,13 @@ package producer
 
 import (
 	"crypto/md5"
+	"math/big"
 	"sync"
 
 	"github.com/achunariov/kinesis-producer/pb"
+	"github.com/achunariov/kinesis-producer/wire"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
-	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -18,6 +20,17 @@ var (
 type AggregatedRecordRequest struct {
 	Entry       types.PutRecordsRequestEntry
 	UserRecords []UserRecord
+	// stream the record is put to. Empty means Config.StreamName or Config.StreamARN
+	stream string
+	// priority records are sent ahead of the other records, see WithPriority
+	priority bool
+	// plain records hold a single user record that is sent without aggregation. They are
+	// not aggregated when the shards are updated
+	plain bool
+	// checksum of the data of plain records, with Config.VerifyIntegrity
+	checksum uint32
+	// attempts are the failed attempts to put the record, see FailureRecord.History
+	attempts []Attempt
 }
 
 func NewAggregatedRecordRequest(data []byte, partitionKey, explicitHashKey *string, userRecords []UserRecord) *AggregatedRecordRequest {
@@ -37,10 +50,27 @@ type Aggregator struct {
 	sync.RWMutex
 	// explicitHashKey will be used for aggregated PutRecordsRequestEntry
 	explicitHashKey *string
-	buf             []UserRecord
-	pkeys           []string
-	pkeysIndex      map[string]int
-	nbytes          int
+	// hasher computes the explicit hash key of user records without one, when it does not
+	// hash partition keys like Kinesis. nil leaves them to Kinesis
+	hasher      Hasher
+	buf         []UserRecord
+	pkeys       []string
+	pkeysIndex  map[string]int
+	ehkeys      []string
+	ehkeysIndex map[string]int
+	nbytes      int
+	// verify checksums the data of the user records on Put and verifies it on Drain, see
+	// Config.VerifyIntegrity
+	verify    bool
+	checksums []uint32
+	// wireVersion frames the aggregated records, see Config.WireVersion
+	wireVersion byte
+	// format encodes the aggregated records instead of the KPL format, see
+	// Config.AggregationFormat
+	format AggregationFormat
+	// retired is set once the shards are updated and the user records are moved to the
+	// aggregators of the new shards
+	retired bool
 }
 
 // NewAggregator initializes a new Aggregator with the given partitionKey
@@ -48,6 +78,7 @@ func NewAggregator(explicitHashKey *string) *Aggregator {
 	a := new(Aggregator)
 	a.explicitHashKey = explicitHashKey
 	a.pkeysIndex = make(map[string]int)
+	a.ehkeysIndex = make(map[string]int)
 	return a
 }
 
@@ -64,7 +95,7 @@ func (a *Aggregator) Count() int {
 
 // Put record using `data` and `partitionKey`. This method is thread-safe.
 func (a *Aggregator) Put(userRecord UserRecord) {
-	nbytes, addPartitionKey := a.userRecordNBytes(userRecord)
+	nbytes, addPartitionKey, addExplicitHashKey := a.userRecordNBytes(userRecord)
 	// The protobuf message allows more efficient partition and explicit hash key packing
 	// by allowing multiple records to point to the same key in a table.
 	if addPartitionKey {
@@ -73,9 +104,21 @@ func (a *Aggregator) Put(userRecord UserRecord) {
 		a.pkeys = append(a.pkeys, partitionKey)
 		a.pkeysIndex[partitionKey] = len(a.pkeys) - 1
 	}
+	if addExplicitHashKey {
+		explicitHashKey := a.recordHashKey(userRecord).String()
+		// nbytes already includes the length of the explicit hash key
+		a.ehkeys = append(a.ehkeys, explicitHashKey)
+		a.ehkeysIndex[explicitHashKey] = len(a.ehkeys) - 1
+	}
 
+	if a.format != nil {
+		nbytes = a.format.RecordSize(userRecord)
+	}
 	a.buf = append(a.buf, userRecord)
 	a.nbytes += nbytes
+	if a.verify {
+		a.checksums = append(a.checksums, checksum(userRecord.Data()))
+	}
 }
 
 // Drain create an aggregated `kinesis.PutRecordsRequestEntry`
@@ -86,11 +129,39 @@ func (a *Aggregator) Drain() (*AggregatedRecordRequest, error) {
 	if a.nbytes == 0 {
 		return nil, nil
 	}
+	if err := a.verifyChecksums(); err != nil {
+		drainErr := &DrainError{
+			Err:         err,
+			UserRecords: a.buf,
+		}
+		a.clear()
+		return nil, drainErr
+	}
+	if a.format != nil {
+		return a.drainFormat()
+	}
 
-	data, err := proto.Marshal(&pb.AggregatedRecord{
-		PartitionKeyTable: a.pkeys,
-		Records:           a.aggregateUserRecords(),
+	// the aggregated record is marshaled straight after the wire header and the magic
+	// number, into a buffer sized for the whole record
+	headerSize := wire.HeaderSize(a.wireVersion)
+	buf, err := wire.AppendHeader(make([]byte, 0, headerSize+len(magicNumber)+a.nbytes+md5.Size), a.wireVersion)
+	if err != nil {
+		drainErr := &DrainError{
+			Err:         err,
+			UserRecords: a.buf,
+		}
+		a.clear()
+		return nil, drainErr
+	}
+	buf = append(buf, magicNumber...)
+	scratch := scratchPool.Get().(*aggregateScratch)
+	aggData, err := proto.MarshalOptions{}.MarshalAppend(buf, &pb.AggregatedRecord{
+		PartitionKeyTable:    a.pkeys,
+		ExplicitHashKeyTable: a.ehkeys,
+		Records:              a.aggregateUserRecords(scratch),
 	})
+	scratch.reset()
+	scratchPool.Put(scratch)
 	if err != nil {
 		drainErr := &DrainError{
 			Err:         err,
@@ -102,45 +173,79 @@ func (a *Aggregator) Drain() (*AggregatedRecordRequest, error) {
 		return nil, drainErr
 	}
 
-	h := md5.New()
-	h.Write(data)
-	checkSum := h.Sum(nil)
-	aggData := append(magicNumber, data...)
-	aggData = append(aggData, checkSum...)
+	checkSum := md5.Sum(aggData[headerSize+len(magicNumber):])
+	aggData = append(aggData, checkSum[:]...)
 
-	request := NewAggregatedRecordRequest(aggData, &a.pkeys[0], a.explicitHashKey, a.buf)
+	request := a.request(aggData)
 	a.clear()
 	return request, nil
 }
 
+// request returns the request of the aggregated record data of the buffered user records
+func (a *Aggregator) request(data []byte) *AggregatedRecordRequest {
+	// Without a shard assigned, the aggregated record is routed like its first user record
+	explicitHashKey := a.explicitHashKey
+	if explicitHashKey == nil {
+		if hk := a.recordHashKey(a.buf[0]); hk != nil {
+			ehk := hk.String()
+			explicitHashKey = &ehk
+		}
+	}
+
+	// the partition key table is reused by the next records
+	partitionKey := a.pkeys[0]
+	return NewAggregatedRecordRequest(data, &partitionKey, explicitHashKey, a.buf)
+}
+
+// recordHashKey returns the explicit hash key the user record is aggregated with, or nil
+func (a *Aggregator) recordHashKey(userRecord UserRecord) *big.Int {
+	if hk := userRecord.ExplicitHashKey(); hk != nil || isMD5Hasher(a.hasher) {
+		return hk
+	}
+	return a.hasher.HashKey(userRecord.PartitionKey())
+}
+
 // WillOverflow checks if the aggregator will exceed max record size by attempting to Put
 // the user record. If true, the aggregator should be drained before attempting a Put.
 func (a *Aggregator) WillOverflow(userRecord UserRecord) bool {
+	return a.willExceed(userRecord, maxRecordSize)
+}
+
+// willExceed checks if the aggregated record, including the partition key of its request
+// entry, will be larger than limit bytes by attempting to Put the user record
+func (a *Aggregator) willExceed(userRecord UserRecord, limit int) bool {
 	if a.nbytes == 0 {
 		return false
 	}
 
-	newbytes, _ := a.userRecordNBytes(userRecord)
-
-	size := len(magicNumber)
-	size += a.nbytes
-	size += newbytes
-	size += md5.Size
+	var size int
+	if a.format != nil {
+		size = a.format.Overhead() + a.nbytes + a.format.RecordSize(userRecord)
+	} else {
+		newbytes, _, _ := a.userRecordNBytes(userRecord)
+		size = wire.HeaderSize(a.wireVersion)
+		size += len(magicNumber)
+		size += a.nbytes
+		size += newbytes
+		size += md5.Size
+	}
 	// need to also add length of partition key that will be sent in the
 	// kinesis.PutRecordsRequestEntry
 	size += len(a.pkeys[0])
 
-	return size > maxRecordSize
+	return size > limit
 }
 
 // userRecordNBytes calculates the number of bytes that will be added when adding the
-// user record to the aggregator. It also returns a bool indicating if the size of the
-// partition key is included in the results.
-func (a *Aggregator) userRecordNBytes(userRecord UserRecord) (int, bool) {
+// user record to the aggregator. It also returns bools indicating if the size of the
+// partition key and explicit hash key are included in the results.
+func (a *Aggregator) userRecordNBytes(userRecord UserRecord) (int, bool, bool) {
 	var (
-		nbytes            int
-		partitionKeyIndex int
-		includesPkSize    bool
+		nbytes               int
+		partitionKeyIndex    int
+		explicitHashKeyIndex = -1
+		includesPkSize       bool
+		includesEhkSize      bool
 	)
 
 	partitionKey := userRecord.PartitionKey()
@@ -154,34 +259,93 @@ func (a *Aggregator) userRecordNBytes(userRecord UserRecord) (int, bool) {
 		partitionKeyIndex = len(a.pkeys)
 	}
 
-	nbytes += calculateRecordFieldSize(partitionKeyIndex, userRecord.Data())
+	if hk := a.recordHashKey(userRecord); hk != nil {
+		explicitHashKey := hk.String()
+		if index, ok := a.ehkeysIndex[explicitHashKey]; ok {
+			explicitHashKeyIndex = index
+		} else {
+			nbytes += calculateStringFieldSize(explicitHashKey)
+			includesEhkSize = true
+			explicitHashKeyIndex = len(a.ehkeys)
+		}
+	}
+
+	nbytes += calculateRecordFieldSize(partitionKeyIndex, explicitHashKeyIndex, userRecord.Data())
+
+	return nbytes, includesPkSize, includesEhkSize
+}
 
-	return nbytes, includesPkSize
+// aggregateScratch holds the protobuf records of a Drain, reused by the next ones
+type aggregateScratch struct {
+	records []pb.Record
+	ptrs    []*pb.Record
+	indexes []uint64
 }
 
-func (a *Aggregator) aggregateUserRecords() []*pb.Record {
+// scratchPool pools the aggregateScratch of Drain
+var scratchPool = sync.Pool{New: func() any { return new(aggregateScratch) }}
+
+// reset drops the references to the data of the user records
+func (s *aggregateScratch) reset() {
+	for i := range s.records {
+		s.records[i] = pb.Record{}
+	}
+	clear(s.ptrs)
+	s.records, s.ptrs, s.indexes = s.records[:0], s.ptrs[:0], s.indexes[:0]
+}
+
+func (a *Aggregator) aggregateUserRecords(scratch *aggregateScratch) []*pb.Record {
 	count := len(a.buf)
-	records := make([]*pb.Record, count)
+	scratch.records = slicesGrow(scratch.records, count)
+	scratch.ptrs = slicesGrow(scratch.ptrs, count)
+	// each record points to its partition key index and explicit hash key index
+	scratch.indexes = slicesGrow(scratch.indexes, 2*count)
 	for i := 0; i < count; i++ {
 		userRecord := a.buf[i]
-		keyIndex := uint64(a.pkeysIndex[userRecord.PartitionKey()])
-		records[i] = &pb.Record{
-			Data:              userRecord.Data(),
-			PartitionKeyIndex: &keyIndex,
+		record := &scratch.records[i]
+		keyIndex := &scratch.indexes[2*i]
+		*keyIndex = uint64(a.pkeysIndex[userRecord.PartitionKey()])
+		record.Data = userRecord.Data()
+		record.PartitionKeyIndex = keyIndex
+		if hk := a.recordHashKey(userRecord); hk != nil {
+			ehkIndex := &scratch.indexes[2*i+1]
+			*ehkIndex = uint64(a.ehkeysIndex[hk.String()])
+			record.ExplicitHashKeyIndex = ehkIndex
 		}
+		scratch.ptrs[i] = record
 	}
-	return records
+	return scratch.ptrs
+}
+
+// slicesGrow returns s resized to n elements, reusing its backing array if it is large
+// enough
+func slicesGrow[T any](s []T, n int) []T {
+	if cap(s) < n {
+		return make([]T, n)
+	}
+	return s[:n]
 }
 
 func (a *Aggregator) clear() {
+	// the user records are handed to the request, the key tables are reused
 	a.buf = make([]UserRecord, 0)
-	a.pkeys = make([]string, 0)
-	a.pkeysIndex = make(map[string]int, 0)
+	clear(a.pkeys)
+	a.pkeys = a.pkeys[:0]
+	clear(a.pkeysIndex)
+	clear(a.ehkeys)
+	a.ehkeys = a.ehkeys[:0]
+	clear(a.ehkeysIndex)
 	a.nbytes = 0
+	a.checksums = a.checksums[:0]
 }
 
-func calculateRecordFieldSize(keyIndex int, data []byte) (size int) {
+// calculateRecordFieldSize returns the size of a Record. explicitHashKeyIndex is -1 if the
+// record has no explicit hash key
+func calculateRecordFieldSize(keyIndex int, explicitHashKeyIndex int, data []byte) (size int) {
 	recordBytes := calculateUint64FieldSize(uint64(keyIndex))
+	if explicitHashKeyIndex >= 0 {
+		recordBytes += calculateUint64FieldSize(uint64(explicitHashKeyIndex))
+	}
 	recordBytes += calculateBytesFieldSize(data)
 
 	// protobuf message index and wire type for Record
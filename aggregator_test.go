@@ -1,12 +1,14 @@
 package producer
 
 import (
+	"crypto/md5"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"strconv"
 	"testing"
 
 	"github.com/achunariov/kinesis-producer/deaggregation"
+	"github.com/achunariov/kinesis-producer/wire"
 	k "github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/stretchr/testify/require"
 )
@@ -120,6 +122,75 @@ func TestAggregation(t *testing.T) {
 	}
 }
 
+func TestAggregationExplicitHashKeys(t *testing.T) {
+	a := NewAggregator(nil)
+	records := []UserRecord{
+		newTestUserRecord("foo", "100", []byte("hello")),
+		newTestUserRecord("bar", "200", []byte("world")),
+		newTestUserRecord("baz", "100", []byte("again")),
+		newTestUserRecord("qux", "", []byte("nokey")),
+	}
+	for _, r := range records {
+		a.Put(r)
+	}
+	size := a.Size()
+
+	record, err := a.Drain()
+	require.NoError(t, err)
+	require.Equal(t, "100", *record.Entry.ExplicitHashKey, "Entry should use first ExplicitHashKey")
+
+	agg, err := deaggregation.Unmarshal(record.Entry.Data)
+	require.NoError(t, err)
+	require.Equal(t, size, len(record.Entry.Data)-len(magicNumber)-md5.Size, "size should match marshaled size")
+	require.Equal(t, []string{"100", "200"}, agg.ExplicitHashKeyTable)
+	require.Equal(t, uint64(0), agg.Records[0].GetExplicitHashKeyIndex())
+	require.Equal(t, uint64(1), agg.Records[1].GetExplicitHashKeyIndex())
+	require.Equal(t, uint64(0), agg.Records[2].GetExplicitHashKeyIndex())
+	require.Nil(t, agg.Records[3].ExplicitHashKeyIndex)
+}
+
+func TestAggregationReusesTables(t *testing.T) {
+	a := NewAggregator(nil)
+	var drained []*AggregatedRecordRequest
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 10; i++ {
+			a.Put(newTestUserRecord(fmt.Sprintf("key-%d-%d", round, i%3), "", []byte(fmt.Sprintf("data-%d-%d", round, i))))
+		}
+		record, err := a.Drain()
+		require.NoError(t, err)
+		require.Equal(t, len(record.Entry.Data), cap(record.Entry.Data), "buffer should be sized for the record")
+		drained = append(drained, record)
+	}
+	// the records drained earlier are not overwritten by the next drains
+	for round, record := range drained {
+		require.Equal(t, fmt.Sprintf("key-%d-0", round), *record.Entry.PartitionKey)
+		records := extractRecords(record.Entry)
+		require.Len(t, records, 10)
+		for i, r := range records {
+			require.Equal(t, fmt.Sprintf("data-%d-%d", round, i), string(r.Data))
+			require.Equal(t, fmt.Sprintf("key-%d-%d", round, i%3), *r.PartitionKey)
+		}
+	}
+}
+
+func BenchmarkAggregatorDrain(b *testing.B) {
+	a := NewAggregator(nil)
+	data := make([]byte, 512)
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			a.Put(NewDataRecord(data, key))
+		}
+		if _, err := a.Drain(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func extractRecords(entry types.PutRecordsRequestEntry) (out []*k.PutRecordsRequestEntry) {
 	dest, err := deaggregation.Unmarshal(entry.Data)
 	if err != nil {
@@ -145,3 +216,31 @@ func TestAggregatorWillOverflow(t *testing.T) {
 	record = NewDataRecord(mockData("", maxRecordSize/2), "foo")
 	require.True(t, a.WillOverflow(record))
 }
+
+func TestAggregationWireVersion(t *testing.T) {
+	a := NewAggregator(nil)
+	a.wireVersion = wire.Version1
+	a.Put(NewDataRecord([]byte("hello"), "foo"))
+	a.Put(NewDataRecord([]byte("world"), "bar"))
+	// the header counts towards the size of the aggregated record
+	next := NewDataRecord([]byte("!"), "baz")
+	nbytes, _, _ := a.userRecordNBytes(next)
+	limit := len(magicNumber) + a.Size() + nbytes + md5.Size + len("foo")
+	require.True(t, a.willExceed(next, limit))
+	require.False(t, a.willExceed(next, limit+wire.HeaderSize(wire.Version1)))
+
+	record, err := a.Drain()
+	require.NoError(t, err)
+	require.True(t, wire.IsFramed(record.Entry.Data))
+	require.False(t, record.corrupt())
+	records, err := deaggregation.Deaggregate(record.Entry.Data, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []deaggregation.Record{
+		{PartitionKey: "foo", Data: []byte("hello")},
+		{PartitionKey: "bar", Data: []byte("world")},
+	}, records)
+
+	c := &Config{StreamName: "foo", Client: &clientMock{}, WireVersion: 2}
+	c.defaults()
+	require.EqualError(t, c.validate(), "kinesis: unsupported WireVersion")
+}
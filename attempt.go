@@ -0,0 +1,57 @@
+package producer
+
+import "time"
+
+// maxAttemptHistory is the number of failed attempts kept per Kinesis record. The oldest
+// attempts are dropped first
+const maxAttemptHistory = 32
+
+// Attempt is a failed attempt to put a Kinesis record, see FailureRecord.History. The
+// shards and error codes of the attempts tell throttling of a hot shard, retried on the
+// same shard, from a regional issue failing every shard.
+type Attempt struct {
+	// Time the PutRecords request was sent
+	Time time.Time `json:"time"`
+	// Error of the attempt, either the error of the request or the error of the record in
+	// the response
+	Error string `json:"error"`
+	// ErrorCode is the AWS error code of Error, e.g.
+	// ProvisionedThroughputExceededException. Empty if the error did not come from AWS
+	ErrorCode string `json:"errorCode,omitempty"`
+	// ShardId is the shard the record maps to, according to the shards known to the
+	// Producer. Empty if unknown
+	ShardId string `json:"shardId,omitempty"`
+	// ResponseMetadata of the PutRecords request, with the AWS request id
+	ResponseMetadata
+}
+
+// recordAttempt appends a failed attempt to the history of the record
+func (r *AggregatedRecordRequest) recordAttempt(attempt Attempt) {
+	if len(r.attempts) == maxAttemptHistory {
+		r.attempts = append(r.attempts[:0], r.attempts[1:]...)
+	}
+	r.attempts = append(r.attempts, attempt)
+}
+
+// requestAttempt records a failed attempt of every record of work, sent to stream at start,
+// for the error of the whole request
+func (wp *WorkerPool) requestAttempt(work *Work, stream string, start time.Time, err error, metadata ResponseMetadata) {
+	code := errorCode(err)
+	for _, r := range work.records {
+		r.recordAttempt(Attempt{
+			Time:             start,
+			Error:            err.Error(),
+			ErrorCode:        code,
+			ShardId:          wp.entryShardID(stream, r),
+			ResponseMetadata: metadata,
+		})
+	}
+}
+
+// entryShardID returns the id of the shard the record maps to, or the empty string
+func (wp *WorkerPool) entryShardID(stream string, record *AggregatedRecordRequest) string {
+	if wp.shardID == nil {
+		return ""
+	}
+	return wp.shardID(stream, record.Entry)
+}
@@ -0,0 +1,89 @@
+package producer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureHistory(t *testing.T) {
+	throttled := &k.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(1),
+		Records: []types.PutRecordsResultEntry{
+			{ErrorCode: aws.String("ProvisionedThroughputExceededException"), ErrorMessage: aws.String("slow down")},
+		},
+	}
+	awsmiddleware.SetRequestIDMetadata(&throttled.ResultMetadata, "req-1")
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: throttled},
+			{Error: &smithy.OperationError{
+				ServiceID:     "Kinesis",
+				OperationName: "PutRecords",
+				Err: &awshttp.ResponseError{
+					ResponseError: &smithyhttp.ResponseError{
+						Response: &smithyhttp.Response{Response: &http.Response{StatusCode: 500}},
+						Err:      &smithy.GenericAPIError{Code: "InternalFailure", Message: "boom"},
+					},
+					RequestID: "req-2",
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		MaxRetries:     1,
+		FlushInterval:  time.Hour,
+		Backoff:        &FixedBackoff{},
+		Logger:         &NopLogger{},
+		Client:         client,
+		ErrorClassifier: func(err error) ErrorClass {
+			if errorCode(err) == "ProvisionedThroughputExceededException" {
+				return ErrorThrottle
+			}
+			return ErrorRetryable
+		},
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	var got []*FailureRecord
+	for err := range failures {
+		got = append(got, err.(*FailureRecord))
+	}
+	require.Len(t, got, 1)
+	history := got[0].History
+	require.Len(t, history, 2)
+	require.Equal(t, "ProvisionedThroughputExceededException", history[0].ErrorCode)
+	require.Equal(t, "ProvisionedThroughputExceededException: slow down", history[0].Error)
+	require.Equal(t, "req-1", history[0].RequestID)
+	require.Equal(t, "InternalFailure", history[1].ErrorCode)
+	require.Equal(t, "req-2", history[1].RequestID)
+	require.False(t, history[1].Time.Before(history[0].Time))
+
+	records := NewDeadLetterRecords(got[0])
+	require.Equal(t, history, records[0].History)
+}
+
+func TestAttemptHistoryLimit(t *testing.T) {
+	r := &AggregatedRecordRequest{}
+	for i := 0; i < maxAttemptHistory+3; i++ {
+		r.recordAttempt(Attempt{ResponseMetadata: ResponseMetadata{RequestID: string(rune('a' + i))}})
+	}
+	require.Len(t, r.attempts, maxAttemptHistory)
+	// the oldest attempts are dropped
+	require.Equal(t, string(rune('a'+3)), r.attempts[0].RequestID)
+}
@@ -0,0 +1,219 @@
+package producer
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	defaultAutoScalingInterval             = time.Minute
+	defaultAutoScalingCoolDown             = 3 * time.Hour
+	defaultAutoScalingThrottleRate         = 0.01
+	defaultAutoScalingScaleUpUtilization   = 0.8
+	defaultAutoScalingScaleDownUtilization = 0.3
+	defaultAutoScalingTimeout              = 10 * time.Second
+)
+
+// StreamScaler is the interface that wraps the Kinesis API methods used to scale a
+// stream. *kinesis.Client implements it.
+type StreamScaler interface {
+	StreamDescriber
+	UpdateShardCount(ctx context.Context, params *k.UpdateShardCountInput, optFns ...func(*k.Options)) (*k.UpdateShardCountOutput, error)
+}
+
+// AutoScalingConfig configures the controller that scales the shards of StreamName, or
+// StreamARN, to the throughput of the producer. Every Interval the records and bytes
+// delivered are compared to the per shard limits of 1000 records/s and 1MiB/s. The stream
+// is scaled up when the utilization exceeds ScaleUpUtilization or too many records are
+// throttled, and down when the utilization is below ScaleDownUtilization, with uniform
+// scaling of at most twice or half the shards at a time. Once the reshard completed the
+// shard map is refreshed with GetShards.
+//
+// Only the records of this producer to the stream are measured. If other producers write
+// to the stream too, its utilization is underestimated and it may be scaled down below
+// their needs, until the throttling of this producer scales it up again. Set MinShards to
+// the shards the other producers need, or scale the stream from the producer writing to it.
+type AutoScalingConfig struct {
+	// Client calls DescribeStreamSummary and UpdateShardCount. Required.
+	Client StreamScaler
+
+	// MinShards is the least number of shards the stream is scaled down to. Default to 1.
+	MinShards int
+
+	// MaxShards is the largest number of shards the stream is scaled up to. Required.
+	MaxShards int
+
+	// Interval is the period the throughput is measured over. Default to 1m.
+	Interval time.Duration
+
+	// CoolDown is the least time between two UpdateShardCount calls. Default to 3h, which
+	// stays within the ten calls Kinesis allows per stream and rolling 24 hours.
+	CoolDown time.Duration
+
+	// ThrottleRate scales the stream up when the fraction of kinesis records throttled
+	// within Interval exceeds it. Between 0 and 1. Default to 0.01.
+	ThrottleRate float64
+
+	// ScaleUpUtilization scales the stream up when the utilization of the shards exceeds
+	// it. The number of shards is chosen so that the utilization falls back to it. Between
+	// 0 and 1. Default to 0.8.
+	ScaleUpUtilization float64
+
+	// ScaleDownUtilization scales the stream down when the utilization of the shards is
+	// below it and no records were throttled. Between 0 and ScaleUpUtilization. Default to
+	// 0.3.
+	ScaleDownUtilization float64
+
+	// Timeout bounds each DescribeStreamSummary and UpdateShardCount call, which are made
+	// from the producer loop. Default to 10s.
+	Timeout time.Duration
+
+	// OnScale is called after UpdateShardCount was called to scale the stream. It is
+	// called from the producer loop and must not block. Default to nil.
+	OnScale func(from, to int)
+}
+
+// autoScaler scales the default stream of the producer, see AutoScalingConfig
+type autoScaler struct {
+	config AutoScalingConfig
+	stream string
+	stats  *stats
+	logger Logger
+	// counters of the stream in stats at the last step
+	records, bytes, throttles int64
+	measuredAt                time.Time
+	// scaledAt is the time of the last UpdateShardCount call
+	scaledAt time.Time
+	// resharding is set after UpdateShardCount until the stream is active again
+	resharding bool
+}
+
+func newAutoScaler(config *Config, stats *stats) *autoScaler {
+	if config.AutoScaling == nil {
+		return nil
+	}
+	return &autoScaler{
+		config:     *config.AutoScaling,
+		stream:     config.defaultStream(),
+		stats:      stats,
+		logger:     config.Logger,
+		measuredAt: config.Clock.Now(),
+	}
+}
+
+// step measures the throughput since the last step and scales the stream if needed.
+// Returns true once a reshard started by the autoScaler completed, so that the shard map
+// is refreshed.
+func (s *autoScaler) step(now time.Time) bool {
+	// the records put to other streams, e.g. with a StreamRouter, do not use the stream
+	var (
+		sent    = s.stats.streamCounters(s.stream)
+		elapsed = now.Sub(s.measuredAt)
+		u       = usage{
+			records:   sent.records - s.records,
+			bytes:     sent.bytes - s.bytes,
+			throttles: sent.throttles - s.throttles,
+			elapsed:   elapsed,
+		}
+	)
+	s.records, s.bytes, s.throttles, s.measuredAt = sent.records, sent.bytes, sent.throttles, now
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	summary, err := describeStreamSummary(ctx, s.config.Client, s.stream)
+	if err != nil {
+		s.logger.Error("auto scaling", err, LogValue{"stream", s.stream})
+		return false
+	}
+	if summary.StreamStatus != types.StreamStatusActive {
+		// the stream is being resharded or updated
+		return false
+	}
+	resharded := s.resharding
+	s.resharding = false
+	if (!s.scaledAt.IsZero() && now.Sub(s.scaledAt) < s.config.CoolDown) || elapsed <= 0 {
+		return resharded
+	}
+
+	shards := int(aws.ToInt32(summary.OpenShardCount))
+	target := s.target(shards, u)
+	if target == shards {
+		return resharded
+	}
+	update := &k.UpdateShardCountInput{
+		TargetShardCount: aws.Int32(int32(target)),
+		ScalingType:      types.ScalingTypeUniformScaling,
+	}
+	if isStreamARN(s.stream) {
+		update.StreamARN = aws.String(s.stream)
+	} else {
+		update.StreamName = aws.String(s.stream)
+	}
+	if _, err := s.config.Client.UpdateShardCount(ctx, update); err != nil {
+		s.logger.Error("auto scaling", err, LogValue{"stream", s.stream}, LogValue{"shards", shards}, LogValue{"target", target})
+		return resharded
+	}
+	s.logger.Info("auto scaling",
+		LogValue{"stream", s.stream},
+		LogValue{"from", shards},
+		LogValue{"to", target},
+		LogValue{"utilization", u.utilization(shards)},
+		LogValue{"throttle_rate", u.throttleRate()},
+	)
+	s.resharding, s.scaledAt = true, now
+	if s.config.OnScale != nil {
+		s.config.OnScale(shards, target)
+	}
+	return resharded
+}
+
+// target returns the number of shards for the usage of the stream with shards shards
+func (s *autoScaler) target(shards int, u usage) int {
+	if shards == 0 {
+		return shards
+	}
+	utilization := u.utilization(shards)
+	// number of shards that would be used at ScaleUpUtilization
+	target := int(math.Ceil(float64(shards) * utilization / s.config.ScaleUpUtilization))
+	switch {
+	case u.throttleRate() > s.config.ThrottleRate || utilization > s.config.ScaleUpUtilization:
+		target = max(target, shards+1)
+	case u.throttles == 0 && utilization < s.config.ScaleDownUtilization:
+	default:
+		return shards
+	}
+	// uniform scaling at most doubles or halves the shards
+	target = min(max(target, (shards+1)/2), 2*shards)
+	return min(max(target, s.config.MinShards), s.config.MaxShards)
+}
+
+// usage is the throughput of the producer over elapsed
+type usage struct {
+	records, bytes, throttles int64
+	elapsed                   time.Duration
+}
+
+// utilization returns the fraction of the per shard limits of shards shards used
+func (u usage) utilization(shards int) float64 {
+	capacity := u.elapsed.Seconds() * float64(shards)
+	if capacity <= 0 {
+		return 0
+	}
+	return math.Max(
+		float64(u.records)/(capacity*shardRecordsPerSecond),
+		float64(u.bytes)/(capacity*shardBytesPerSecond),
+	)
+}
+
+// throttleRate returns the fraction of the kinesis records that were throttled
+func (u usage) throttleRate() float64 {
+	if u.records+u.throttles == 0 {
+		return 0
+	}
+	return float64(u.throttles) / float64(u.records+u.throttles)
+}
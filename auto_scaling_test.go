@@ -0,0 +1,121 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStreamScaler struct {
+	status  types.StreamStatus
+	shards  int32
+	updates []*k.UpdateShardCountInput
+}
+
+func (m *mockStreamScaler) DescribeStreamSummary(ctx context.Context, params *k.DescribeStreamSummaryInput, optFns ...func(*k.Options)) (*k.DescribeStreamSummaryOutput, error) {
+	return &k.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &types.StreamDescriptionSummary{
+			StreamName:     params.StreamName,
+			StreamStatus:   m.status,
+			OpenShardCount: aws.Int32(m.shards),
+		},
+	}, nil
+}
+
+func (m *mockStreamScaler) UpdateShardCount(ctx context.Context, params *k.UpdateShardCountInput, optFns ...func(*k.Options)) (*k.UpdateShardCountOutput, error) {
+	m.updates = append(m.updates, params)
+	m.status = types.StreamStatusUpdating
+	return &k.UpdateShardCountOutput{}, nil
+}
+
+func TestAutoScalerTarget(t *testing.T) {
+	// records a shard accepts per minute
+	const perShard = shardRecordsPerSecond * 60
+	testCases := []struct {
+		name      string
+		minShards int
+		maxShards int
+		usage     usage
+		expected  int
+	}{
+		{name: "halves idle shards", usage: usage{}, expected: 2},
+		{name: "keeps steady shards", usage: usage{records: 4 * perShard / 2}, expected: 4},
+		{name: "scales up to ScaleUpUtilization", usage: usage{records: 4 * perShard * 9 / 10}, expected: 5},
+		{name: "scales up on bytes", usage: usage{bytes: 4 * shardBytesPerSecond * 60 * 9 / 10}, expected: 5},
+		{name: "scales up throttled shards", usage: usage{records: 4 * perShard / 2, throttles: perShard / 10}, expected: 5},
+		{name: "keeps shards throttled below ThrottleRate", usage: usage{records: 4 * perShard / 2, throttles: 10}, expected: 4},
+		{name: "at most doubles shards", maxShards: 100, usage: usage{records: 4 * perShard * 3}, expected: 8},
+		{name: "stops at MaxShards", maxShards: 6, usage: usage{records: 4 * perShard * 3}, expected: 6},
+		{name: "stops at MinShards", minShards: 3, usage: usage{}, expected: 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &Config{AutoScaling: &AutoScalingConfig{MinShards: tc.minShards, MaxShards: tc.maxShards}}
+			if config.AutoScaling.MaxShards == 0 {
+				config.AutoScaling.MaxShards = 10
+			}
+			config.defaults()
+			s := newAutoScaler(config, &stats{})
+			tc.usage.elapsed = time.Minute
+			require.Equal(t, tc.expected, s.target(4, tc.usage))
+		})
+	}
+}
+
+func TestAutoScalerStep(t *testing.T) {
+	client := &mockStreamScaler{status: types.StreamStatusActive, shards: 2}
+	type scale struct{ from, to int }
+	var scales []scale
+	config := &Config{
+		StreamName: "foo",
+		Logger:     &NopLogger{},
+		AutoScaling: &AutoScalingConfig{
+			Client:    client,
+			MinShards: 2,
+			MaxShards: 8,
+			CoolDown:  time.Hour,
+			OnScale:   func(from, to int) { scales = append(scales, scale{from, to}) },
+		},
+	}
+	config.defaults()
+	s := newAutoScaler(config, &stats{})
+	now := s.measuredAt
+
+	// the records put to other streams are not counted
+	now = now.Add(time.Minute)
+	s.stats.sent("bar", map[string]*shardCounters{"shardId-0": {records: 4 * shardRecordsPerSecond * 60}}, 0)
+	require.False(t, s.step(now))
+	require.Empty(t, client.updates)
+
+	// 2 shards used at 90%
+	now = now.Add(time.Minute)
+	s.stats.sent("foo", map[string]*shardCounters{"shardId-0": {records: 2 * shardRecordsPerSecond * 60 * 9 / 10}}, 0)
+	require.False(t, s.step(now))
+	require.Len(t, client.updates, 1)
+	require.Equal(t, "foo", aws.ToString(client.updates[0].StreamName))
+	require.Equal(t, int32(3), aws.ToInt32(client.updates[0].TargetShardCount))
+	require.Equal(t, types.ScalingTypeUniformScaling, client.updates[0].ScalingType)
+	require.Equal(t, []scale{{2, 3}}, scales)
+
+	// the shard map is refreshed once the reshard completed
+	now = now.Add(time.Minute)
+	require.False(t, s.step(now))
+	client.status, client.shards = types.StreamStatusActive, 3
+	now = now.Add(time.Minute)
+	require.True(t, s.step(now))
+
+	// idle shards are not scaled down within the cool-down
+	now = now.Add(time.Minute)
+	require.False(t, s.step(now))
+	require.Len(t, client.updates, 1)
+	now = now.Add(time.Hour)
+	require.False(t, s.step(now))
+	require.Len(t, client.updates, 2)
+	require.Equal(t, int32(2), aws.ToInt32(client.updates[1].TargetShardCount))
+	require.Equal(t, []scale{{2, 3}, {3, 2}}, scales)
+}
@@ -0,0 +1,71 @@
+package kpfirehose
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	"github.com/aws/aws-sdk-go-v2/service/firehose/types"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	ktypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+// Client is the interface that wraps the firehose.Client PutRecordBatch method.
+type Client interface {
+	PutRecordBatch(ctx context.Context, params *firehose.PutRecordBatchInput, optFns ...func(*firehose.Options)) (*firehose.PutRecordBatchOutput, error)
+}
+
+// Putter implements producer.Putter by sending PutRecords requests to a Firehose delivery
+// stream with PutRecordBatch. The stream name of the request is used as the delivery
+// stream name and partition keys are ignored. The RecordId of each delivered record is
+// returned as its sequence number.
+//
+// Use it with producer.BackendFirehose so records are not aggregated and the Firehose
+// request limits are applied.
+type Putter struct {
+	Client Client
+}
+
+var _ producer.Putter = (*Putter)(nil)
+
+// New creates a Putter sending requests with client
+func New(client Client) *Putter {
+	return &Putter{Client: client}
+}
+
+// Options returns the producer options to put records to the delivery stream
+func Options(client Client, deliveryStreamName string) []producer.Option {
+	return []producer.Option{
+		producer.WithStreamName(deliveryStreamName),
+		producer.WithClient(New(client)),
+		producer.WithBackend(producer.BackendFirehose),
+	}
+}
+
+// PutRecords sends the records of input with PutRecordBatch
+func (p *Putter) PutRecords(ctx context.Context, input *k.PutRecordsInput, _ ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	records := make([]types.Record, len(input.Records))
+	for i, r := range input.Records {
+		records[i] = types.Record{Data: r.Data}
+	}
+	out, err := p.Client.PutRecordBatch(ctx, &firehose.PutRecordBatchInput{
+		DeliveryStreamName: input.StreamName,
+		Records:            records,
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ktypes.PutRecordsResultEntry, len(out.RequestResponses))
+	for i, r := range out.RequestResponses {
+		results[i] = ktypes.PutRecordsResultEntry{
+			ErrorCode:      r.ErrorCode,
+			ErrorMessage:   r.ErrorMessage,
+			SequenceNumber: r.RecordId,
+		}
+	}
+	return &k.PutRecordsOutput{
+		FailedRecordCount: out.FailedPutCount,
+		Records:           results,
+	}, nil
+}
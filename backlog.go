@@ -0,0 +1,249 @@
+package producer
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// backlog limits the number of Puts in progress and queues the aggregated records they
+// drained until the worker pool takes them. Each Put holds a slot while it runs, and a
+// drained record holds the slot of its Put until it is taken, so that at most
+// BacklogCount Puts and records are held.
+//
+// The queue is a ring buffer with a single consumer, which takes all the queued records
+// at once. The oldest record can be evicted by a Put applying OverflowDropOldest.
+type backlog struct {
+	mu sync.Mutex
+	// held is the number of slots held by Puts and queued records, at most slots
+	held  int
+	slots int
+	// blocked is the number of slots held by wait, and waiters the number of callers of
+	// wait that did not call open yet
+	blocked int
+	waiters int
+	// ring holds the queued records from head, count of them
+	ring  []*AggregatedRecordRequest
+	head  int
+	count int
+	// bytes is the size of the queued records
+	bytes  int
+	closed bool
+	// pushed is the number of records ever queued, and handed the number of them added to
+	// the worker pool or evicted since, see waitHanded
+	pushed, handed uint64
+
+	// changed is signaled when a slot is released or a record is queued
+	changed broadcast
+	// queued wakes up the consumer waiting in take
+	queued chan struct{}
+}
+
+func newBacklog(slots int) *backlog {
+	return &backlog{
+		slots:  slots,
+		ring:   make([]*AggregatedRecordRequest, slots),
+		queued: make(chan struct{}, 1),
+	}
+}
+
+// len returns the number of slots held
+func (b *backlog) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.held
+}
+
+// cap returns the number of slots
+func (b *backlog) cap() int {
+	return b.slots
+}
+
+// tryAcquire acquires a slot without blocking and reports whether it did
+func (b *backlog) tryAcquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.held == b.slots {
+		return false
+	}
+	b.held++
+	return true
+}
+
+// acquire acquires a slot, blocking until one is released. Returns false if cancel is
+// closed first.
+func (b *backlog) acquire(cancel <-chan struct{}) bool {
+	for {
+		b.mu.Lock()
+		if b.held < b.slots {
+			b.held++
+			b.mu.Unlock()
+			return true
+		}
+		changed := b.changed.wait()
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// release releases n slots
+func (b *backlog) release(n int) {
+	b.mu.Lock()
+	b.held -= n
+	b.mu.Unlock()
+	b.changed.signal()
+}
+
+// hand releases the slots of n taken records once they were added to the worker pool
+func (b *backlog) hand(n int) {
+	b.mu.Lock()
+	b.held -= n
+	b.handed += uint64(n)
+	b.mu.Unlock()
+	b.changed.signal()
+}
+
+// mark returns the number of records queued so far, to wait for with waitHanded
+func (b *backlog) mark() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pushed
+}
+
+// waitHanded blocks until the records queued before mark were added to the worker pool or
+// evicted. Returns false if cancel is closed first.
+func (b *backlog) waitHanded(cancel <-chan struct{}, mark uint64) bool {
+	for {
+		b.mu.Lock()
+		if b.handed >= mark {
+			b.mu.Unlock()
+			return true
+		}
+		changed := b.changed.wait()
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// wait blocks until all the slots are released, holding them as they are so that no Put
+// gets in meanwhile. The slots are held until every caller of wait calls open.
+func (b *backlog) wait() {
+	b.mu.Lock()
+	b.waiters++
+	b.mu.Unlock()
+	for {
+		b.mu.Lock()
+		free := b.slots - b.held
+		b.held += free
+		b.blocked += free
+		if b.blocked == b.slots {
+			b.mu.Unlock()
+			return
+		}
+		changed := b.changed.wait()
+		b.mu.Unlock()
+		<-changed
+	}
+}
+
+// open releases the slots held by wait, once every caller of wait called it
+func (b *backlog) open() {
+	b.mu.Lock()
+	b.waiters--
+	if b.waiters > 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.held -= b.blocked
+	b.blocked = 0
+	b.mu.Unlock()
+	b.changed.signal()
+}
+
+// push queues a record drained by a Put, which hands its slot over to the record
+func (b *backlog) push(record *AggregatedRecordRequest) {
+	b.mu.Lock()
+	b.ring[(b.head+b.count)%len(b.ring)] = record
+	b.count++
+	b.pushed++
+	b.bytes += requestSize(record)
+	b.mu.Unlock()
+	select {
+	case b.queued <- struct{}{}:
+	default:
+	}
+	b.changed.signal()
+}
+
+// evict removes the oldest queued record. Its slot is handed over to the caller. Returns
+// nil if no record is queued.
+func (b *backlog) evict() *AggregatedRecordRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.count == 0 {
+		return nil
+	}
+	record := b.ring[b.head]
+	b.ring[b.head] = nil
+	b.head = (b.head + 1) % len(b.ring)
+	b.count--
+	b.bytes -= requestSize(record)
+	b.handed++
+	return record
+}
+
+// take blocks until records are queued and removes them all, oldest first. Their slots
+// are held until the caller releases them. Returns nil once the backlog is closed.
+func (b *backlog) take() []*AggregatedRecordRequest {
+	for {
+		b.mu.Lock()
+		if b.count > 0 {
+			records := make([]*AggregatedRecordRequest, b.count)
+			for i := range records {
+				j := (b.head + i) % len(b.ring)
+				records[i] = b.ring[j]
+				b.ring[j] = nil
+			}
+			b.head, b.count, b.bytes = 0, 0, 0
+			b.mu.Unlock()
+			return records
+		}
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return nil
+		}
+		<-b.queued
+	}
+}
+
+// queue returns the number of queued records and their size
+func (b *backlog) queue() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count, b.bytes
+}
+
+// close wakes up the consumer once there are no more records to take
+func (b *backlog) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	select {
+	case b.queued <- struct{}{}:
+	default:
+	}
+}
+
+// requestSize returns the size of an aggregated record, including its partition key
+func requestSize(record *AggregatedRecordRequest) int {
+	return len(record.Entry.Data) + len(aws.ToString(record.Entry.PartitionKey))
+}
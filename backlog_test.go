@@ -0,0 +1,114 @@
+package producer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(pk string) *AggregatedRecordRequest {
+	return NewAggregatedRecordRequest([]byte("hello"), &pk, nil, []UserRecord{NewDataRecord([]byte("hello"), pk)})
+}
+
+func TestBacklogQueue(t *testing.T) {
+	b := newBacklog(3)
+	// the ring wraps around after a few rounds
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 3; i++ {
+			require.True(t, b.tryAcquire())
+			b.push(newTestRequest(strconv.Itoa(round*3 + i)))
+		}
+		require.False(t, b.tryAcquire())
+		count, bytes := b.queue()
+		require.Equal(t, 3, count)
+		require.Equal(t, 3*len("hello0"), bytes)
+
+		// the oldest record is evicted and its slot handed over
+		evicted := b.evict()
+		require.Equal(t, strconv.Itoa(round*3), *evicted.Entry.PartitionKey)
+		require.Equal(t, 3, b.len())
+		b.release(1)
+
+		records := b.take()
+		require.Len(t, records, 2)
+		require.Equal(t, strconv.Itoa(round*3+1), *records[0].Entry.PartitionKey)
+		require.Equal(t, strconv.Itoa(round*3+2), *records[1].Entry.PartitionKey)
+		// the slots are held until the records are released
+		require.Equal(t, 2, b.len())
+		b.release(len(records))
+		require.Nil(t, b.evict())
+	}
+
+	b.close()
+	require.Nil(t, b.take())
+}
+
+func TestBacklogAcquire(t *testing.T) {
+	b := newBacklog(1)
+	require.True(t, b.tryAcquire())
+
+	cancel := make(chan struct{})
+	close(cancel)
+	require.False(t, b.acquire(cancel))
+
+	acquired := make(chan bool)
+	go func() { acquired <- b.acquire(nil) }()
+	select {
+	case <-acquired:
+		t.Fatal("expected acquire to wait for a slot")
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.release(1)
+	require.True(t, <-acquired)
+}
+
+func TestBacklogWait(t *testing.T) {
+	b := newBacklog(2)
+	require.True(t, b.tryAcquire())
+	b.push(newTestRequest("foo"))
+
+	waited := make(chan struct{})
+	go func() {
+		b.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("expected wait to wait for the queued record")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// the free slot is held by wait meanwhile
+	require.False(t, b.tryAcquire())
+
+	b.release(len(b.take()))
+	<-waited
+	require.Equal(t, 2, b.len())
+	b.open()
+	require.Equal(t, 0, b.len())
+	require.True(t, b.tryAcquire())
+}
+
+func TestBacklogHanded(t *testing.T) {
+	b := newBacklog(3)
+	for i := 0; i < 3; i++ {
+		require.True(t, b.tryAcquire())
+		b.push(newTestRequest(strconv.Itoa(i)))
+	}
+	mark := b.mark()
+	require.Equal(t, uint64(3), mark)
+
+	cancel := make(chan struct{})
+	close(cancel)
+	require.False(t, b.waitHanded(cancel, mark))
+
+	// evicted records count as handed
+	b.evict()
+	b.release(1)
+	records := b.take()
+	require.False(t, b.waitHanded(cancel, mark))
+	b.hand(len(records))
+	require.True(t, b.waitHanded(nil, mark))
+	require.Zero(t, b.len())
+}
@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultBackoffBase = 100 * time.Millisecond
+	defaultBackoffCap  = 10 * time.Second
+)
+
+// Backoff is the interface that determines how long to wait before retrying a failed
+// PutRecords request.
+type Backoff interface {
+	// Duration returns the delay before the given retry attempt. attempt starts at 1 and
+	// previous is the delay returned for the prior attempt, 0 on the first attempt.
+	Duration(attempt int, previous time.Duration) time.Duration
+}
+
+// FullJitterBackoff sleeps a random duration between 0 and
+// min(Cap, Base * 2^(attempt-1)).
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type FullJitterBackoff struct {
+	// Base is the initial delay. Default to 100ms.
+	Base time.Duration
+	// Cap is the maximum delay. Default to 10s.
+	Cap time.Duration
+}
+
+func (b *FullJitterBackoff) Duration(attempt int, _ time.Duration) time.Duration {
+	base, limit := backoffBounds(b.Base, b.Cap)
+	return time.Duration(rand.Int63n(int64(exponential(base, limit, attempt)) + 1))
+}
+
+// DecorrelatedJitterBackoff sleeps a random duration between Base and 3 times the previous
+// delay, capped at Cap.
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type DecorrelatedJitterBackoff struct {
+	// Base is the minimum delay. Default to 100ms.
+	Base time.Duration
+	// Cap is the maximum delay. Default to 10s.
+	Cap time.Duration
+}
+
+func (b *DecorrelatedJitterBackoff) Duration(_ int, previous time.Duration) time.Duration {
+	base, limit := backoffBounds(b.Base, b.Cap)
+	if previous < base {
+		previous = base
+	}
+	upper := previous * 3
+	if upper > limit || upper < previous {
+		upper = limit
+	}
+	if upper <= base {
+		return base
+	}
+	return base + time.Duration(rand.Int63n(int64(upper-base)+1))
+}
+
+// FixedBackoff always sleeps Delay between attempts.
+type FixedBackoff struct {
+	Delay time.Duration
+}
+
+func (b *FixedBackoff) Duration(_ int, _ time.Duration) time.Duration {
+	return b.Delay
+}
+
+func backoffBounds(base, limit time.Duration) (time.Duration, time.Duration) {
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	if limit <= 0 {
+		limit = defaultBackoffCap
+	}
+	if limit < base {
+		limit = base
+	}
+	return base, limit
+}
+
+// exponential returns min(limit, base * 2^(attempt-1)) without overflowing
+func exponential(base, limit time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d > limit || d <= 0 {
+			return limit
+		}
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
@@ -0,0 +1,95 @@
+package producer
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDuration(t *testing.T) {
+	testCases := []struct {
+		name     string
+		backoff  Backoff
+		attempts int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:     "full jitter stays within zero and cap",
+			backoff:  &FullJitterBackoff{Base: 10 * time.Millisecond, Cap: 50 * time.Millisecond},
+			attempts: 20,
+			min:      0,
+			max:      50 * time.Millisecond,
+		},
+		{
+			name:     "decorrelated jitter stays within base and cap",
+			backoff:  &DecorrelatedJitterBackoff{Base: 10 * time.Millisecond, Cap: 50 * time.Millisecond},
+			attempts: 20,
+			min:      10 * time.Millisecond,
+			max:      50 * time.Millisecond,
+		},
+		{
+			name:     "fixed always returns delay",
+			backoff:  &FixedBackoff{Delay: 25 * time.Millisecond},
+			attempts: 5,
+			min:      25 * time.Millisecond,
+			max:      25 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var previous time.Duration
+			for attempt := 1; attempt <= tc.attempts; attempt++ {
+				previous = tc.backoff.Duration(attempt, previous)
+				require.True(t, previous >= tc.min, "expected %v >= %v", previous, tc.min)
+				require.True(t, previous <= tc.max, "expected %v <= %v", previous, tc.max)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "throttled",
+			err:      &types.ProvisionedThroughputExceededException{},
+			expected: true,
+		},
+		{
+			name:     "server error",
+			err:      &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: 503}}},
+			expected: true,
+		},
+		{
+			name:     "client error",
+			err:      &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: 400}}},
+			expected: false,
+		},
+		{
+			name:     "resource not found",
+			err:      &types.ResourceNotFoundException{},
+			expected: false,
+		},
+		{
+			name:     "unknown error",
+			err:      errors.New("unknown"),
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, DefaultErrorClassifier(tc.err) != ErrorFatal)
+		})
+	}
+}
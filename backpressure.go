@@ -0,0 +1,105 @@
+package producer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainRateWindow is the minimum period the throughput is measured over
+const drainRateWindow = time.Second
+
+// BacklogInfo describes how close the producer is to blocking Puts, see Producer.Backlog
+type BacklogInfo struct {
+	// Length is the number of Puts in the backlog, and Capacity the BacklogCount. Puts
+	// block once Length reaches Capacity
+	Length   int
+	Capacity int
+	// Queued is the number of aggregated records in the backlog waiting to be added to the
+	// worker pool, and QueuedBytes their size. Each holds the slot of the Put that drained
+	// it
+	Queued      int
+	QueuedBytes int
+	// BufferedBytes is the size of the records held by the producer, and MaxBufferedBytes
+	// the limit Puts block at. Zero if there is no limit
+	BufferedBytes    int
+	MaxBufferedBytes int
+	// DrainTime estimates how long sending BufferedBytes takes at the throughput measured
+	// since the previous call, or at least over the last second. Zero until a throughput
+	// was measured
+	DrainTime time.Duration
+}
+
+// drainRate measures the bytes delivered per second between calls to Backlog
+type drainRate struct {
+	mu sync.Mutex
+	// at is the time of the last measurement, and bytes the bytes delivered then
+	at    time.Time
+	bytes int64
+	rate  float64
+}
+
+// update returns the rate given the bytes delivered so far
+func (d *drainRate) update(bytes int64, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.at.IsZero() {
+		d.at, d.bytes = now, bytes
+		return 0
+	}
+	if elapsed := now.Sub(d.at); elapsed >= drainRateWindow {
+		d.rate = float64(bytes-d.bytes) / elapsed.Seconds()
+		d.at, d.bytes = now, bytes
+	}
+	return d.rate
+}
+
+// Backlog returns how full the backlog and buffers of the producer are, so that callers
+// can slow down their own intake before Put blocks. This method is thread-safe.
+func (p *Producer) Backlog() BacklogInfo {
+	info := BacklogInfo{
+		Length:           p.backlog.len(),
+		Capacity:         p.backlog.cap(),
+		BufferedBytes:    p.buffered.size(),
+		MaxBufferedBytes: p.MaxBufferedBytes,
+	}
+	info.Queued, info.QueuedBytes = p.backlog.queue()
+	rate := p.drainRate.update(atomic.LoadInt64(&p.pool.stats.bytes), p.Clock.Now())
+	if rate > 0 {
+		info.DrainTime = time.Duration(float64(info.BufferedBytes) / rate * float64(time.Second))
+	}
+	return info
+}
+
+// Ready returns a channel that is closed once a Put would not block on the backlog or
+// MaxBufferedBytes, immediately if it would not already. The channel is also closed once
+// the producer is stopped. This method is thread-safe.
+func (p *Producer) Ready() <-chan struct{} {
+	ready := make(chan struct{})
+	if p.ready() {
+		close(ready)
+		return ready
+	}
+	go func() {
+		defer close(ready)
+		for {
+			// wait before checking, so that a release in between is not missed
+			freed, released := p.freed.wait(), p.buffered.waitRelease()
+			if p.ready() {
+				return
+			}
+			select {
+			case <-freed:
+			case <-released:
+			case <-p.stopped:
+				return
+			}
+		}
+	}()
+	return ready
+}
+
+// ready reports whether a Put would not block on the backlog or MaxBufferedBytes
+func (p *Producer) ready() bool {
+	return p.backlog.len() < p.backlog.cap() && !p.buffered.full(1)
+}
@@ -0,0 +1,85 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBacklog(t *testing.T) {
+	// the producer is not started and every record bypasses aggregation so the first Put
+	// fills the backlog
+	p := New(&Config{
+		StreamName:         "foo",
+		BacklogCount:       1,
+		AggregateBatchSize: 1,
+		MaxBufferedBytes:   1 << 20,
+		FlushInterval:      time.Hour,
+		Logger:             &NopLogger{},
+		DryRun:             &DryRunConfig{Dir: t.TempDir()},
+	})
+	info := p.Backlog()
+	require.Equal(t, BacklogInfo{Capacity: 1, MaxBufferedBytes: 1 << 20}, info)
+	select {
+	case <-p.Ready():
+	default:
+		t.Fatal("expected an empty producer to be ready")
+	}
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	info = p.Backlog()
+	require.Equal(t, 1, info.Length)
+	require.Equal(t, 1, info.Queued)
+	require.Greater(t, info.QueuedBytes, 0)
+	require.Greater(t, info.BufferedBytes, 0)
+	ready := p.Ready()
+	select {
+	case <-ready:
+		t.Fatal("expected a full producer not to be ready")
+	default:
+	}
+
+	p.Start()
+	defer p.Stop()
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the producer to be ready once the backlog is sent")
+	}
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 0, p.Backlog().Length)
+}
+
+func TestReadyStopped(t *testing.T) {
+	p := New(&Config{
+		StreamName:         "foo",
+		BacklogCount:       1,
+		AggregateBatchSize: 1,
+		Logger:             &NopLogger{},
+		DryRun:             &DryRunConfig{Dir: t.TempDir()},
+	})
+	p.Start()
+	p.Pause()
+	for p.Backlog().Length < 1 {
+		require.NoError(t, p.TryPut([]byte("hello"), "foo"))
+	}
+	ready := p.Ready()
+	p.Stop()
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Ready to be closed once the producer is stopped")
+	}
+}
+
+func TestDrainRate(t *testing.T) {
+	start := time.Now()
+	d := drainRate{at: start}
+	require.Zero(t, d.update(100, start.Add(time.Second/2)))
+	require.Equal(t, 200.0, d.update(200, start.Add(time.Second)))
+	// the rate is kept until the window elapsed again
+	require.Equal(t, 200.0, d.update(300, start.Add(3*time.Second/2)))
+	require.Equal(t, 100.0, d.update(300, start.Add(2*time.Second)))
+}
@@ -0,0 +1,136 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// barrier counts the user records accepted in each generation that were not delivered or
+// failed yet, see Producer.Barrier. Each call to Barrier starts a new generation and waits
+// for the older ones to have no records left.
+type barrier struct {
+	mu      sync.Mutex
+	gen     uint64
+	pending map[uint64]int
+	// changed is signaled when a generation has no records left
+	changed broadcast
+}
+
+func newBarrier(config *Config) *barrier {
+	if !config.Barriers {
+		return nil
+	}
+	return &barrier{pending: make(map[uint64]int)}
+}
+
+// enter counts the user record in the current generation, unless it was counted already,
+// e.g. when it is put again from the spill queue. Returns the tracked user record and
+// whether it was counted by this call.
+func (b *barrier) enter(userRecord UserRecord) (UserRecord, bool) {
+	switch r := userRecord.(type) {
+	case *trackedRecord:
+		if r.barrier != nil {
+			return r, false
+		}
+	case *chunkRecord, *claimCheckRecord, *compressedRecord, *encryptedRecord:
+		// created from a user record that was counted
+		return userRecord, false
+	}
+	tracked := track(userRecord)
+	b.mu.Lock()
+	tracked.gen = b.gen
+	b.pending[b.gen]++
+	b.mu.Unlock()
+	tracked.barrier = b
+	return tracked, true
+}
+
+// enterBarrier counts the user record in the current generation of the barrier, if any.
+// Returns the tracked user record, and the record to leave the generation with if it is
+// not accepted. Nil unless it was counted by this call.
+func (p *Producer) enterBarrier(userRecord UserRecord) (UserRecord, *trackedRecord) {
+	if p.barrier == nil {
+		return userRecord, nil
+	}
+	userRecord, counted := p.barrier.enter(userRecord)
+	if !counted {
+		return userRecord, nil
+	}
+	return userRecord, userRecord.(*trackedRecord)
+}
+
+// leave removes the user record from its generation. Only the first call has an effect.
+func (b *barrier) leave(r *trackedRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.left {
+		return
+	}
+	r.left = true
+	if b.pending[r.gen]--; b.pending[r.gen] == 0 {
+		delete(b.pending, r.gen)
+		b.changed.signal()
+	}
+}
+
+// next starts a new generation and returns the previous one
+func (b *barrier) next() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gen++
+	return b.gen - 1
+}
+
+// wait blocks until the generations up to gen have no records left or ctx is done
+func (b *barrier) wait(ctx context.Context, gen uint64) error {
+	for {
+		b.mu.Lock()
+		left := false
+		for g := range b.pending {
+			if g <= gen {
+				left = true
+				break
+			}
+		}
+		if !left {
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed.wait()
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Barrier blocks until every user record accepted by a Put that returned before the call
+// has been delivered or has failed permanently, without blocking Puts meanwhile, e.g. to
+// commit a transaction of an upstream system once its records are in Kinesis. Failed
+// records are reported to NotifyFailures as usual. The aggregators are flushed right away
+// rather than at the next flush interval, and records held by Pause or spilled by
+// OverflowSpill are waited for.
+//
+// Barrier requires Config.Barriers. It returns ctx.Err() if ctx is done first.
+func (p *Producer) Barrier(ctx context.Context) error {
+	if p.barrier == nil {
+		return errors.New("kinesis: Barrier requires Config.Barriers")
+	}
+	gen := p.barrier.next()
+	// spilled records are put back into the backlog before it is waited for
+	if p.spill != nil && !p.spill.waitPutBack(ctx.Done(), p.spill.mark()) {
+		return ctx.Err()
+	}
+	// the records drained by Puts are added to the worker pool before it is flushed
+	if !p.backlog.waitHanded(ctx.Done(), p.backlog.mark()) {
+		return ctx.Err()
+	}
+	select {
+	case p.pressure <- struct{}{}:
+	default:
+	}
+	return p.barrier.wait(ctx, gen)
+}
@@ -0,0 +1,129 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBarrierGenerations(t *testing.T) {
+	b := newBarrier(&Config{Barriers: true})
+	first, _ := b.enter(NewDataRecord([]byte("hello"), "a"))
+	gen := b.next()
+	second, _ := b.enter(NewDataRecord([]byte("world"), "b"))
+
+	// records counted already are not counted again
+	_, counted := b.enter(first)
+	require.False(t, counted)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.wait(ctx, gen), context.DeadlineExceeded)
+
+	// records of later generations are not waited for
+	b.leave(first.(*trackedRecord))
+	b.leave(first.(*trackedRecord))
+	require.NoError(t, b.wait(context.Background(), gen))
+	require.Equal(t, map[uint64]int{1: 1}, b.pending)
+	b.leave(second.(*trackedRecord))
+	require.Empty(t, b.pending)
+}
+
+func TestBarrier(t *testing.T) {
+	var (
+		sent    = make(chan []string, 10)
+		unblock = make(chan struct{})
+	)
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		var keys []string
+		out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+		for _, r := range input.Records {
+			keys = append(keys, aws.ToString(r.PartitionKey))
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")})
+		}
+		sent <- keys
+		<-unblock
+		return out, nil
+	})
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	require.EqualError(t, p.Barrier(context.Background()), "kinesis: Barrier requires Config.Barriers")
+
+	p = New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Barriers:       true,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "a", WithoutAggregation()))
+	barrier := make(chan error, 1)
+	go func() { barrier <- p.Barrier(context.Background()) }()
+	// the barrier flushes the record without waiting for the flush interval
+	require.Equal(t, []string{"a"}, <-sent)
+
+	// puts are not blocked while the barrier waits for the request
+	require.NoError(t, p.Put([]byte("world"), "b", WithoutAggregation()))
+	select {
+	case <-barrier:
+		t.Fatal("expected the barrier to wait for the request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unblock <- struct{}{}
+	require.NoError(t, <-barrier)
+	close(unblock)
+}
+
+func TestBarrierSpill(t *testing.T) {
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+		for range input.Records {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")})
+		}
+		return out, nil
+	})
+	p := New(&Config{
+		StreamName:       "foo",
+		MaxConnections:   1,
+		MaxBufferedBytes: 10,
+		OverflowPolicy:   OverflowSpill,
+		SpillDir:         t.TempDir(),
+		FlushInterval:    time.Hour,
+		Logger:           &NopLogger{},
+		Client:           client,
+		Barriers:         true,
+	})
+	p.Start()
+	defer p.Stop()
+
+	p.Pause()
+	require.NoError(t, p.Put([]byte("hello"), "a", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "b", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("again"), "c", WithoutAggregation()))
+	require.Equal(t, 2, p.Stats().Spilled)
+
+	// spilled records are waited for in the generation they were put in
+	barrier := make(chan error, 1)
+	go func() { barrier <- p.Barrier(context.Background()) }()
+	p.Resume()
+	select {
+	case err := <-barrier:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the barrier to return once the spilled records are delivered")
+	}
+}
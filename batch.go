@@ -0,0 +1,144 @@
+package producer
+
+import "fmt"
+
+// reservation is the part of the backlog slots and buffered bytes reserved by PutBatch
+// that was not used by its records yet
+type reservation struct {
+	slots int
+	bytes int
+}
+
+// PutBatch puts user records collected ahead of time, e.g. from a file or a message batch,
+// cheaper than calling PutUserRecord for each of them. The records are validated before
+// any of them is put, and the backlog slots and buffered bytes of the whole batch are
+// reserved at once according to the OverflowPolicy: the batch waits until all of them fit,
+// or, with OverflowError and OverflowDropNewest, none of its records is put if they do not
+// fit. OverflowSpill puts the records one by one. opts apply to every record.
+//
+// It returns the error of the first record that can not be put. The records before it
+// were put. A batch of more than BacklogCount records is rejected.
+func (p *Producer) PutBatch(userRecords []UserRecord, opts ...PutOption) error {
+	if len(userRecords) == 0 {
+		return nil
+	}
+	select {
+	case <-p.stopped:
+		return &ErrStoppedProducer{userRecords[0]}
+	default:
+	}
+	if len(userRecords) > p.backlog.cap() {
+		return fmt.Errorf("kinesis: batch of %d records exceeds BacklogCount %d", len(userRecords), p.backlog.cap())
+	}
+	for _, userRecord := range userRecords {
+		if err := p.validate(userRecord); err != nil {
+			return err
+		}
+	}
+	o := p.putOptions(p.OverflowPolicy, opts)
+	if o.policy != OverflowSpill {
+		res, err := p.reserve(userRecords, o.policy)
+		if res == nil {
+			return err
+		}
+		o.reservation = res
+		defer p.releaseReservation(res)
+	}
+	for _, userRecord := range userRecords {
+		if err := p.putUserRecord("", userRecord, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate returns the error put would return for the user record as it is given, before
+// it is intercepted, wrapped or compressed
+func (p *Producer) validate(userRecord UserRecord) error {
+	partitionKeySize := len(userRecord.PartitionKey())
+	if partitionKeySize > 256 || (partitionKeySize < 1 && p.Backend != BackendFirehose) {
+		return &ErrIllegalPartitionKey{userRecord}
+	}
+	if hk := userRecord.ExplicitHashKey(); hk != nil && !validHashKey(hk) {
+		return &ErrIllegalExplicitHashKey{UserRecord: userRecord, ExplicitHashKey: hk.String()}
+	}
+	// compression, chunking and the large record store put records above the limit
+	shrinks := p.Compression != nil || p.MaxChunkSize > 0 || p.LargeRecordStore != nil
+	if !shrinks && userRecord.Size()+partitionKeySize > p.recordSizeLimit() {
+		return &ErrRecordSizeExceeded{userRecord}
+	}
+	return nil
+}
+
+// reserve reserves a backlog slot for each user record and their buffered bytes according
+// to the overflow policy. Returns nil if the batch was not accepted, with the error to
+// return, if any.
+func (p *Producer) reserve(userRecords []UserRecord, policy OverflowPolicy) (*reservation, error) {
+	res := &reservation{}
+	for _, userRecord := range userRecords {
+		res.bytes += userRecord.Size() + len(userRecord.PartitionKey())
+	}
+
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+	if policy == OverflowError || policy == OverflowDropNewest {
+		if ok, _ := p.buffered.tryAcquire(res.bytes); ok {
+			for res.slots < len(userRecords) && p.backlog.tryAcquire() {
+				res.slots++
+			}
+			if res.slots == len(userRecords) {
+				return res, nil
+			}
+		} else {
+			res.bytes = 0
+		}
+		p.releaseReservation(res)
+		if policy == OverflowDropNewest {
+			for _, userRecord := range userRecords {
+				p.reject(userRecord)
+			}
+			return nil, nil
+		}
+		return nil, &ErrBacklogFull{userRecords[0]}
+	}
+
+	if ok, err := p.acquireBytes(userRecords[0], res.bytes, policy); !ok {
+		return nil, err
+	}
+	for res.slots < len(userRecords) {
+		if ok, err := p.acquireBacklog(userRecords[res.slots], policy); !ok {
+			p.releaseReservation(res)
+			return nil, err
+		}
+		res.slots++
+	}
+	return res, nil
+}
+
+// acquireReserved takes a backlog slot and the buffered bytes of the user record from the
+// reservation of PutBatch, and acquires them as usual once there is none left. Records
+// that grew, e.g. in an envelope, acquire the missing bytes.
+func (p *Producer) acquireReserved(res *reservation, userRecord UserRecord, size int, policy OverflowPolicy) (bool, error) {
+	if res == nil || res.slots == 0 {
+		return p.acquire(userRecord, size, policy)
+	}
+	taken := min(size, res.bytes)
+	if taken < size {
+		if ok, err := p.acquireBytes(userRecord, size-taken, policy); !ok {
+			return false, err
+		}
+	}
+	res.slots--
+	res.bytes -= taken
+	return true, nil
+}
+
+// releaseReservation releases what is left of the reservation
+func (p *Producer) releaseReservation(res *reservation) {
+	for ; res.slots > 0; res.slots-- {
+		p.backlog.release(1)
+		p.freed.signal()
+	}
+	p.buffered.release(res.bytes)
+	res.bytes = 0
+}
@@ -0,0 +1,113 @@
+package producer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutBatch(t *testing.T) {
+	dir := t.TempDir()
+	p := newDryRunProducer(dir)
+	p.Start()
+	require.NoError(t, p.PutBatch([]UserRecord{
+		NewDataRecord([]byte("hello"), "a"),
+		NewDataRecord([]byte("world"), "b"),
+		NewDataRecord([]byte("!"), "c"),
+	}))
+	require.NoError(t, p.PutBatch(nil))
+	require.NoError(t, p.Flush(context.Background()))
+	p.Stop()
+
+	var keys []string
+	for _, r := range readFileRecords(t, filepath.Join(dir, "foo.jsonl")) {
+		keys = append(keys, r.PartitionKey)
+	}
+	require.ElementsMatch(t, []string{"a", "b", "c"}, keys)
+	require.Equal(t, int64(3), p.Stats().Puts)
+	require.Equal(t, 0, p.Stats().BufferedBytes)
+}
+
+func TestPutBatchInvalid(t *testing.T) {
+	p := newDryRunProducer(t.TempDir())
+	err := p.PutBatch([]UserRecord{
+		NewDataRecord([]byte("hello"), "a"),
+		NewDataRecord([]byte("world"), ""),
+	})
+	require.IsType(t, &ErrIllegalPartitionKey{}, err)
+	// no record was put
+	require.Equal(t, int64(0), p.Stats().Puts)
+	require.Equal(t, 0, p.Backlog().Length)
+}
+
+func TestPutBatchOverflow(t *testing.T) {
+	newProducer := func(policy OverflowPolicy) *Producer {
+		// the producer is not started and every record bypasses aggregation so that each
+		// Put holds a backlog slot
+		return New(&Config{
+			StreamName:         "foo",
+			BacklogCount:       2,
+			AggregateBatchSize: 1,
+			OverflowPolicy:     policy,
+			FlushInterval:      time.Hour,
+			Logger:             &NopLogger{},
+			DryRun:             &DryRunConfig{Dir: t.TempDir()},
+		})
+	}
+	batch := []UserRecord{NewDataRecord([]byte("hello"), "a"), NewDataRecord([]byte("world"), "b")}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		p := newProducer(OverflowError)
+		require.Error(t, p.PutBatch(append(batch, NewDataRecord([]byte("!"), "c"))))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		p := newProducer(OverflowError)
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		err := p.PutBatch(batch)
+		require.IsType(t, &ErrBacklogFull{}, err)
+		// the batch is put all or nothing
+		require.Equal(t, 1, p.Backlog().Length)
+		require.Equal(t, int64(1), p.Stats().Puts)
+		require.NoError(t, p.PutBatch(batch[:1]))
+		require.Equal(t, 2, p.Backlog().Length)
+	})
+
+	t.Run("DropNewest", func(t *testing.T) {
+		p := newProducer(OverflowDropNewest)
+		failures := p.NotifyFailures()
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		done := make(chan error)
+		go func() {
+			done <- p.PutBatch(batch)
+		}()
+		for i := 0; i < len(batch); i++ {
+			require.IsType(t, &ErrBacklogFull{}, <-failures)
+		}
+		require.NoError(t, <-done)
+		require.Equal(t, int64(2), p.Stats().Drops)
+		require.Equal(t, 1, p.Backlog().Length)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		p := newProducer(OverflowBlock)
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		done := make(chan error)
+		go func() {
+			done <- p.PutBatch(batch)
+		}()
+		select {
+		case <-done:
+			t.Fatal("expected the batch to wait for room in the backlog")
+		case <-time.After(50 * time.Millisecond):
+		}
+		p.Start()
+		defer p.Stop()
+		require.NoError(t, <-done)
+		require.NoError(t, p.Flush(context.Background()))
+		require.Equal(t, int64(3), p.Stats().Puts)
+	})
+}
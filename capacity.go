@@ -0,0 +1,155 @@
+package producer
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	// minTunedConnections is the least MaxConnections set by AutoTune for a stream, so
+	// that streams with few shards still overlap requests
+	minTunedConnections = 4
+	// maxTunedConnections is the most MaxConnections set by AutoTune
+	maxTunedConnections = 256
+)
+
+// StreamDescriber is the interface that wraps the KinesisAPI.DescribeStreamSummary
+// method. *kinesis.Client implements it.
+type StreamDescriber interface {
+	DescribeStreamSummary(ctx context.Context, params *k.DescribeStreamSummaryInput, optFns ...func(*k.Options)) (*k.DescribeStreamSummaryOutput, error)
+}
+
+// describeStreamSummary calls DescribeStreamSummary for the stream name or ARN
+func describeStreamSummary(ctx context.Context, client StreamDescriber, stream string) (*types.StreamDescriptionSummary, error) {
+	input := &k.DescribeStreamSummaryInput{}
+	if isStreamARN(stream) {
+		input.StreamARN = aws.String(stream)
+	} else {
+		input.StreamName = aws.String(stream)
+	}
+	out, err := client.DescribeStreamSummary(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return out.StreamDescriptionSummary, nil
+}
+
+// capacity is the capacity mode and number of open shards of a stream, see
+// Config.AutoTune
+type capacity struct {
+	onDemand bool
+	shards   int
+}
+
+// describeCapacity returns the capacity of stream
+func (c *Config) describeCapacity(stream string) (*capacity, error) {
+	summary, err := describeStreamSummary(context.Background(), c.AutoTune, stream)
+	if err != nil {
+		return nil, err
+	}
+	return &capacity{
+		onDemand: summary.StreamModeDetails != nil &&
+			summary.StreamModeDetails.StreamMode == types.StreamModeOnDemand,
+		shards: int(aws.ToInt32(summary.OpenShardCount)),
+	}, nil
+}
+
+// maxConnections returns the MaxConnections tuned to the capacity
+func (c *capacity) maxConnections() int {
+	if c.onDemand {
+		// on-demand streams grow past their current shards
+		return min(max(c.shards, defaultMaxConnections), maxTunedConnections)
+	}
+	// one request in flight per shard is enough to reach the per shard limits
+	return min(max(c.shards, minTunedConnections), maxTunedConnections)
+}
+
+// rateLimited reports whether requests to stream are paced to the per shard limits.
+// On-demand streams add shards when they are throttled, which pacing would hide. Callers
+// must hold the streams lock, unless the producer has not been started yet.
+func (p *Producer) rateLimited(stream string) bool {
+	capacity := p.capacities[stream]
+	return !p.DisableRateLimit && (capacity == nil || !capacity.onDemand)
+}
+
+// payloadUnits returns the AggregatePayloadUnits of the shard map of stream. On-demand
+// streams are billed by the GiB rather than by payload units. Callers must hold the
+// streams lock, unless the producer has not been started yet.
+func (p *Producer) payloadUnits(stream string) int {
+	if capacity := p.capacities[stream]; capacity != nil && capacity.onDemand {
+		return 0
+	}
+	return p.AggregatePayloadUnits
+}
+
+// tuneStream applies the capacity of stream to its shard map and rate limiter. Callers
+// must hold the streams lock, unless the producer has not been started yet.
+func (p *Producer) tuneStream(stream string, shardMap *ShardMap) {
+	shardMap.setPayloadUnits(p.payloadUnits(stream))
+	switch limiter := p.pool.limiter(stream); {
+	case !p.rateLimited(stream) && limiter != nil:
+		p.pool.setLimiter(stream, nil)
+	case p.rateLimited(stream) && limiter == nil:
+		p.pool.setLimiter(stream, p.newRateLimiter(shardMap))
+	}
+}
+
+// capacityConnections returns the MaxConnections tuned to the capacities of all streams
+func (p *Producer) capacityConnections() int {
+	p.streamsMu.RLock()
+	defer p.streamsMu.RUnlock()
+	n := 0
+	for _, capacity := range p.capacities {
+		n += capacity.maxConnections()
+	}
+	return min(n, maxTunedConnections)
+}
+
+// retune describes the streams again and applies the settings that changed to the
+// running Producer. Called from the main loop.
+func (p *Producer) retune() {
+	for stream, shardMap := range p.streamShardMaps() {
+		capacity, err := p.describeCapacity(stream)
+		if err != nil {
+			p.Logger.Error("auto tune", err, LogValue{"stream", stream})
+			continue
+		}
+		p.streamsMu.Lock()
+		old := p.capacities[stream]
+		p.capacities[stream] = capacity
+		p.tuneStream(stream, shardMap)
+		p.streamsMu.Unlock()
+		if old == nil || *old != *capacity {
+			p.logCapacity(stream)
+		}
+	}
+
+	// MaxConnections set by UpdateConfig is kept until the capacities change
+	if n := p.capacityConnections(); p.tuneConnections && n != p.tunedConnections {
+		p.tunedConnections = n
+		update := settingsOf(p.Config)
+		update.maxConnections = n
+		p.applyUpdate(update)
+	}
+}
+
+// logCapacity logs the settings tuned to the capacity of stream
+func (p *Producer) logCapacity(stream string) {
+	p.streamsMu.RLock()
+	capacity := p.capacities[stream]
+	values := []LogValue{
+		{"stream", stream},
+		{"on_demand", capacity.onDemand},
+		{"shards", capacity.shards},
+		{"rate_limit", p.rateLimited(stream)},
+		{"payload_units", p.payloadUnits(stream)},
+	}
+	p.streamsMu.RUnlock()
+	if p.tuneConnections {
+		values = append(values, LogValue{"max_connections", p.capacityConnections()})
+	}
+	p.Logger.Info("auto tune", values...)
+}
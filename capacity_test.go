@@ -0,0 +1,163 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStreamDescriber struct {
+	sync.Mutex
+	mode   types.StreamMode
+	shards int32
+	// onDemand lists the streams in on-demand mode, instead of mode
+	onDemand map[string]bool
+}
+
+func (m *mockStreamDescriber) set(mode types.StreamMode, shards int32) {
+	m.Lock()
+	m.mode, m.shards = mode, shards
+	m.Unlock()
+}
+
+func (m *mockStreamDescriber) DescribeStreamSummary(ctx context.Context, params *k.DescribeStreamSummaryInput, optFns ...func(*k.Options)) (*k.DescribeStreamSummaryOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+	mode := m.mode
+	if m.onDemand != nil {
+		mode = types.StreamModeProvisioned
+		if m.onDemand[aws.ToString(params.StreamName)] {
+			mode = types.StreamModeOnDemand
+		}
+	}
+	return &k.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &types.StreamDescriptionSummary{
+			StreamName:        params.StreamName,
+			StreamStatus:      types.StreamStatusActive,
+			StreamModeDetails: &types.StreamModeDetails{StreamMode: mode},
+			OpenShardCount:    aws.Int32(m.shards),
+		},
+	}, nil
+}
+
+func TestCapacityMaxConnections(t *testing.T) {
+	require.Equal(t, 4, (&capacity{shards: 1}).maxConnections())
+	require.Equal(t, 40, (&capacity{shards: 40}).maxConnections())
+	require.Equal(t, 256, (&capacity{shards: 1000}).maxConnections())
+	require.Equal(t, 24, (&capacity{onDemand: true, shards: 4}).maxConnections())
+	require.Equal(t, 40, (&capacity{onDemand: true, shards: 40}).maxConnections())
+}
+
+func TestAutoTune(t *testing.T) {
+	describer := &mockStreamDescriber{mode: types.StreamModeProvisioned, shards: 2}
+	p, err := NewProducer(
+		WithStreamName("foo"),
+		WithClient(&clientMock{incoming: make(map[int][]string)}),
+		WithShards(StaticGetShardsFunc(2), 10*time.Millisecond),
+		WithFlushInterval(time.Hour),
+		WithAggregatePayloadUnits(2),
+		WithAutoTune(describer),
+		WithLogger(&NopLogger{}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 4, p.MaxConnections)
+	require.NotNil(t, p.pool.limiter("foo"))
+	require.Equal(t, int64(2), atomic.LoadInt64(&p.shardMap.payloadUnits))
+
+	payloadUnits := func() int {
+		return int(atomic.LoadInt64(&p.shardMap.payloadUnits))
+	}
+	p.Start()
+
+	// the stream switched to on-demand and grew
+	describer.set(types.StreamModeOnDemand, 30)
+	require.Eventually(t, func() bool {
+		return p.pool.limiter("foo") == nil && payloadUnits() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	// and back to provisioned
+	describer.set(types.StreamModeProvisioned, 8)
+	require.Eventually(t, func() bool {
+		return p.pool.limiter("foo") != nil && payloadUnits() == 2
+	}, time.Second, 5*time.Millisecond)
+
+	p.Stop()
+	require.Equal(t, 8, p.MaxConnections)
+}
+
+func TestAutoTuneMaxConnections(t *testing.T) {
+	describer := &mockStreamDescriber{mode: types.StreamModeProvisioned, shards: 2}
+	p, err := NewProducer(
+		WithStreamName("foo"),
+		WithClient(&clientMock{incoming: make(map[int][]string)}),
+		WithShards(StaticGetShardsFunc(2), 10*time.Millisecond),
+		WithFlushInterval(time.Hour),
+		WithMaxConnections(100),
+		WithAutoTune(describer),
+		WithLogger(&NopLogger{}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 100, p.MaxConnections)
+	p.Start()
+
+	// the stream grew, the explicit MaxConnections is kept
+	describer.set(types.StreamModeProvisioned, 8)
+	require.Eventually(t, func() bool {
+		p.streamsMu.RLock()
+		defer p.streamsMu.RUnlock()
+		return p.capacities["foo"].shards == 8
+	}, time.Second, 5*time.Millisecond)
+
+	p.Stop()
+	require.Equal(t, 100, p.MaxConnections)
+}
+
+func TestAutoTuneStreams(t *testing.T) {
+	describer := &mockStreamDescriber{shards: 2, onDemand: map[string]bool{"bar": true}}
+	p, err := NewProducer(
+		WithStreamName("foo"),
+		WithClient(&clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+				{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			},
+		}),
+		WithShards(StaticGetShardsFunc(2), 10*time.Millisecond),
+		WithStreamShards(func(stream string, old []types.Shard) ([]types.Shard, bool, error) {
+			return StaticGetShardsFunc(2)(old)
+		}),
+		WithFlushInterval(time.Hour),
+		WithAggregatePayloadUnits(2),
+		WithAutoTune(describer),
+		WithLogger(&NopLogger{}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 4, p.MaxConnections)
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.PutToStream("bar", []byte("hello"), "bar-1"))
+	require.NoError(t, p.Flush(context.Background()))
+
+	// the on-demand stream is neither rate limited nor drained by payload units, unlike
+	// the provisioned default stream
+	require.NotNil(t, p.pool.limiter("foo"))
+	require.Nil(t, p.pool.limiter("bar"))
+	require.Equal(t, int64(2), atomic.LoadInt64(&p.shardMap.payloadUnits))
+	bar, err := p.streamShardMap("bar")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), atomic.LoadInt64(&bar.payloadUnits))
+
+	// MaxConnections follows the capacity of both streams on the next refresh
+	require.Eventually(t, func() bool {
+		return p.Stats().Connections == 4+24
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,192 @@
+// Package chunking splits payloads that are too large for a single Kinesis record into
+// framed chunks, and reassembles them on the consumer side.
+//
+// Each chunk starts with a header:
+//
+//	magic    [4]byte  0x4B 0x50 0x43 0x01
+//	id       [16]byte identifies the chunks of a payload
+//	index    uint32   index of the chunk, starting at 0
+//	count    uint32   number of chunks of the payload
+//	checksum uint32   CRC-32 (IEEE) of the complete payload
+//
+// followed by the chunk data. All integers are big endian.
+package chunking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+const (
+	// HeaderSize is the number of bytes added to each chunk
+	HeaderSize = 32
+	// DefaultMaxPayloadSize is the default of Dechunker.MaxPayloadSize
+	DefaultMaxPayloadSize = 64 << 20
+)
+
+var magicNumber = []byte{0x4B, 0x50, 0x43, 0x01}
+
+var (
+	// ErrChecksumMismatch is returned by Dechunker.Add if a reassembled payload does not
+	// match its checksum
+	ErrChecksumMismatch = errors.New("chunking: checksum mismatch")
+	// ErrPayloadTooLarge is returned by Dechunker.Add for the chunks of a payload larger
+	// than Dechunker.MaxPayloadSize
+	ErrPayloadTooLarge = errors.New("chunking: payload too large")
+)
+
+// Chunk is a parsed chunk
+type Chunk struct {
+	ID       [16]byte
+	Index    int
+	Count    int
+	Checksum uint32
+	Data     []byte
+}
+
+// Split splits data into chunks of at most maxChunkSize bytes, including the header.
+func Split(id [16]byte, data []byte, maxChunkSize int) ([][]byte, error) {
+	size := maxChunkSize - HeaderSize
+	if size < 1 {
+		return nil, fmt.Errorf("chunking: max chunk size must be greater than %d", HeaderSize)
+	}
+	count := (len(data) + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	if count > math.MaxUint32 {
+		return nil, fmt.Errorf("chunking: too many chunks: %d", count)
+	}
+
+	checksum := crc32.ChecksumIEEE(data)
+	chunks := make([][]byte, count)
+	for i := range chunks {
+		start := i * size
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := make([]byte, HeaderSize+end-start)
+		copy(chunk, magicNumber)
+		copy(chunk[4:], id[:])
+		binary.BigEndian.PutUint32(chunk[20:], uint32(i))
+		binary.BigEndian.PutUint32(chunk[24:], uint32(count))
+		binary.BigEndian.PutUint32(chunk[28:], checksum)
+		copy(chunk[HeaderSize:], data[start:end])
+		chunks[i] = chunk
+	}
+	return chunks, nil
+}
+
+// IsChunk reports whether data starts with a chunk header
+func IsChunk(data []byte) bool {
+	return len(data) >= HeaderSize && bytes.Equal(data[:len(magicNumber)], magicNumber)
+}
+
+// Parse parses a chunk. The data of the returned chunk references data.
+func Parse(data []byte) (*Chunk, error) {
+	if !IsChunk(data) {
+		return nil, errors.New("chunking: not a chunk")
+	}
+	c := &Chunk{
+		Index:    int(binary.BigEndian.Uint32(data[20:])),
+		Count:    int(binary.BigEndian.Uint32(data[24:])),
+		Checksum: binary.BigEndian.Uint32(data[28:]),
+		Data:     data[HeaderSize:],
+	}
+	copy(c.ID[:], data[4:20])
+	if c.Count == 0 || c.Index >= c.Count {
+		return nil, fmt.Errorf("chunking: invalid chunk %d of %d", c.Index, c.Count)
+	}
+	return c, nil
+}
+
+// Dechunker reassembles payloads from their chunks. Chunks may be added in any order and
+// duplicates are ignored. Dechunker is not safe for concurrent use.
+type Dechunker struct {
+	// MaxPayloadSize bounds the size of the payloads reassembled, and so the memory held
+	// for a payload whatever the headers of its chunks claim. Chunks of larger payloads
+	// are rejected with ErrPayloadTooLarge. Default to DefaultMaxPayloadSize.
+	MaxPayloadSize int
+
+	pending map[[16]byte]*payload
+}
+
+type payload struct {
+	// chunks are copies of the data of the chunks received, by index
+	chunks map[int][]byte
+	count  int
+	size   int
+}
+
+// NewDechunker creates an empty Dechunker
+func NewDechunker() *Dechunker {
+	return &Dechunker{pending: make(map[[16]byte]*payload)}
+}
+
+// Add adds the data of a record. If data is not a chunk, it is returned as is. Otherwise
+// the reassembled payload is returned once all its chunks have been added, and false
+// until then. The chunks are copied, data may be reused once Add returns.
+func (d *Dechunker) Add(data []byte) ([]byte, bool, error) {
+	if !IsChunk(data) {
+		return data, true, nil
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	maxSize := d.MaxPayloadSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPayloadSize
+	}
+	// every chunk of a payload split in several carries data, and all but the last one the
+	// same amount
+	if c.Count > 1 && (c.Count > maxSize || (c.Index < c.Count-1 && (c.Count-1)*len(c.Data) >= maxSize)) {
+		d.Discard(c.ID)
+		return nil, false, ErrPayloadTooLarge
+	}
+
+	p, ok := d.pending[c.ID]
+	if !ok {
+		p = &payload{chunks: make(map[int][]byte), count: c.Count}
+		d.pending[c.ID] = p
+	}
+	if p.count != c.Count {
+		return nil, false, fmt.Errorf("chunking: chunk count changed from %d to %d", p.count, c.Count)
+	}
+	if _, ok := p.chunks[c.Index]; !ok {
+		if p.size += len(c.Data); p.size > maxSize {
+			d.Discard(c.ID)
+			return nil, false, ErrPayloadTooLarge
+		}
+		p.chunks[c.Index] = append([]byte(nil), c.Data...)
+	}
+	if len(p.chunks) < c.Count {
+		return nil, false, nil
+	}
+
+	delete(d.pending, c.ID)
+	out := make([]byte, 0, p.size)
+	for i := 0; i < p.count; i++ {
+		out = append(out, p.chunks[i]...)
+	}
+	if crc32.ChecksumIEEE(out) != c.Checksum {
+		return nil, false, ErrChecksumMismatch
+	}
+	return out, true, nil
+}
+
+// Pending returns the number of payloads with missing chunks
+func (d *Dechunker) Pending() int {
+	return len(d.pending)
+}
+
+// Discard drops the chunks received for the payload with the given id, e.g. after a
+// timeout when the remaining chunks will not arrive
+func (d *Dechunker) Discard(id [16]byte) {
+	delete(d.pending, id)
+}
@@ -0,0 +1,132 @@
+package chunking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitDechunk(t *testing.T) {
+	testCases := []struct {
+		name         string
+		size         int
+		maxChunkSize int
+		chunks       int
+	}{
+		{name: "empty", size: 0, maxChunkSize: 64, chunks: 1},
+		{name: "single chunk", size: 32, maxChunkSize: 64, chunks: 1},
+		{name: "exact chunks", size: 96, maxChunkSize: 64, chunks: 3},
+		{name: "last chunk partial", size: 100, maxChunkSize: 64, chunks: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := make([]byte, tc.size)
+			rand.Read(data)
+
+			chunks, err := Split([16]byte{1}, data, tc.maxChunkSize)
+			require.NoError(t, err)
+			require.Len(t, chunks, tc.chunks)
+			for _, chunk := range chunks {
+				require.True(t, IsChunk(chunk))
+				require.LessOrEqual(t, len(chunk), tc.maxChunkSize)
+			}
+
+			// add the chunks in reverse order with a duplicate
+			d := NewDechunker()
+			var (
+				out      []byte
+				complete bool
+			)
+			for i := len(chunks) - 1; i >= 0; i-- {
+				require.False(t, complete)
+				out, complete, err = d.Add(chunks[i])
+				require.NoError(t, err)
+				if i == len(chunks)-1 && len(chunks) > 1 {
+					_, complete, err = d.Add(chunks[i])
+					require.NoError(t, err)
+				}
+			}
+			require.True(t, complete)
+			require.True(t, bytes.Equal(data, out))
+			require.Equal(t, 0, d.Pending())
+		})
+	}
+}
+
+func TestDechunkerPassesThroughRecords(t *testing.T) {
+	d := NewDechunker()
+	out, complete, err := d.Add([]byte("hello"))
+	require.NoError(t, err)
+	require.True(t, complete)
+	require.Equal(t, []byte("hello"), out)
+}
+
+func TestDechunkerChecksumMismatch(t *testing.T) {
+	chunks, err := Split([16]byte{1}, []byte("hello world"), HeaderSize+6)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	chunks[1][HeaderSize] ^= 0xFF
+
+	d := NewDechunker()
+	_, complete, err := d.Add(chunks[0])
+	require.NoError(t, err)
+	require.False(t, complete)
+	require.Equal(t, 1, d.Pending())
+	_, _, err = d.Add(chunks[1])
+	require.Equal(t, ErrChecksumMismatch, err)
+	require.Equal(t, 0, d.Pending())
+}
+
+func TestSplitMaxChunkSizeTooSmall(t *testing.T) {
+	_, err := Split([16]byte{1}, []byte("hello"), HeaderSize)
+	require.Error(t, err)
+}
+
+func TestDechunkerMaxPayloadSize(t *testing.T) {
+	// a header claiming 2^32-1 chunks is rejected without allocating for them
+	chunk := make([]byte, HeaderSize+1)
+	copy(chunk, magicNumber)
+	binary.BigEndian.PutUint32(chunk[24:], math.MaxUint32)
+	d := NewDechunker()
+	_, _, err := d.Add(chunk)
+	require.Equal(t, ErrPayloadTooLarge, err)
+	require.Equal(t, 0, d.Pending())
+
+	chunks, err := Split([16]byte{1}, make([]byte, 100), HeaderSize+10)
+	require.NoError(t, err)
+	d = &Dechunker{MaxPayloadSize: 50, pending: make(map[[16]byte]*payload)}
+	_, _, err = d.Add(chunks[0])
+	require.Equal(t, ErrPayloadTooLarge, err)
+
+	// the last chunk does not tell the size of the others
+	chunks, err = Split([16]byte{2}, make([]byte, 95), HeaderSize+10)
+	require.NoError(t, err)
+	d = &Dechunker{MaxPayloadSize: 50, pending: make(map[[16]byte]*payload)}
+	_, complete, err := d.Add(chunks[len(chunks)-1])
+	require.NoError(t, err)
+	require.False(t, complete)
+	_, _, err = d.Add(chunks[0])
+	require.Equal(t, ErrPayloadTooLarge, err)
+	require.Equal(t, 0, d.Pending())
+}
+
+func TestDechunkerCopiesChunks(t *testing.T) {
+	chunks, err := Split([16]byte{1}, []byte("hello world"), HeaderSize+6)
+	require.NoError(t, err)
+
+	d := NewDechunker()
+	buf := append([]byte(nil), chunks[0]...)
+	_, _, err = d.Add(buf)
+	require.NoError(t, err)
+	// the caller reuses its buffer
+	copy(buf[HeaderSize:], "xxxxxx")
+	out, complete, err := d.Add(chunks[1])
+	require.NoError(t, err)
+	require.True(t, complete)
+	require.Equal(t, []byte("hello world"), out)
+}
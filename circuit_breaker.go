@@ -0,0 +1,236 @@
+package producer
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitBreakerMinRequests = 10
+	defaultCircuitBreakerWindow      = time.Minute
+	defaultCircuitBreakerCoolDown    = 30 * time.Second
+)
+
+// CircuitState is the state of the circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed sends requests normally
+	CircuitClosed CircuitState = iota
+	// CircuitOpen holds requests until the cool-down has elapsed and fails Puts with
+	// ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen sends a single request again after the cool-down, holding the others
+	// until its outcome. The circuit is closed if it succeeds and opened again if it fails.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText renders the state by name, e.g. in the JSON of DebugHandler
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CircuitBreakerConfig configures the circuit breaker of the producer. A PutRecords
+// request fails if it returns an error or every record of it is rejected.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after this many consecutive failed requests.
+	// Default to 0, disabled.
+	ConsecutiveFailures int
+
+	// ErrorRate opens the circuit when the fraction of failed requests within Window
+	// exceeds it, once at least MinRequests requests were sent. Between 0 and 1. Default
+	// to 0, disabled.
+	ErrorRate float64
+
+	// MinRequests is the number of requests within Window before ErrorRate applies.
+	// Default to 10.
+	MinRequests int
+
+	// Window is the period the error rate is computed over. Default to 1m.
+	Window time.Duration
+
+	// CoolDown is how long the circuit stays open before requests are sent again.
+	// Default to 30s.
+	CoolDown time.Duration
+
+	// OnStateChange is called when the circuit changes state. It is called from the worker
+	// sending the request and must not block. Default to nil.
+	OnStateChange func(from, to CircuitState)
+}
+
+// circuitBreaker tracks the outcome of PutRecords requests. A nil circuitBreaker is
+// always closed.
+type circuitBreaker struct {
+	sync.Mutex
+	config CircuitBreakerConfig
+	logger Logger
+	clock  Clock
+	state  CircuitState
+	// number of failed requests in a row
+	consecutive int
+	// requests and failures in the current window
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// probeAt is the time the trial request of the half-open circuit was let through,
+	// zero until then
+	probeAt time.Time
+	// changed is signaled when the state changes
+	changed broadcast
+}
+
+func newCircuitBreaker(config *CircuitBreakerConfig, logger Logger, clock Clock) *circuitBreaker {
+	if config == nil {
+		return nil
+	}
+	return &circuitBreaker{config: *config, logger: logger, clock: clock}
+}
+
+// isOpen reports whether the circuit is open. The circuit becomes half open once the
+// cool-down has elapsed, whether or not requests are pending.
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.Lock()
+	from, expired := b.expire()
+	state := b.state
+	b.Unlock()
+	if expired {
+		b.notify(from, CircuitHalfOpen)
+	}
+	return state == CircuitOpen
+}
+
+// expire makes an open circuit half open once the cool-down has elapsed. Callers must
+// hold the lock and notify the transition if it happened.
+func (b *circuitBreaker) expire() (CircuitState, bool) {
+	if b.state != CircuitOpen || b.clock.Now().Sub(b.openedAt) < b.config.CoolDown {
+		return b.state, false
+	}
+	return b.transition(CircuitHalfOpen), true
+}
+
+// currentState returns the state of the circuit
+func (b *circuitBreaker) currentState() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// delay returns how long a request must wait before it is sent, and a channel closed
+// when the state changes meanwhile. The circuit becomes half open once the cool-down has
+// elapsed, and lets a single trial request through. The others wait for its outcome, or
+// for another cool-down if it never comes, e.g. because the request was canceled.
+func (b *circuitBreaker) delay() (time.Duration, <-chan struct{}) {
+	if b == nil {
+		return 0, nil
+	}
+	b.Lock()
+	from, expired := b.expire()
+	d, changed := b.wait()
+	b.Unlock()
+	if expired {
+		b.notify(from, CircuitHalfOpen)
+	}
+	return d, changed
+}
+
+// wait returns the delay of the next request in the current state. Callers must hold the
+// lock.
+func (b *circuitBreaker) wait() (time.Duration, <-chan struct{}) {
+	now := b.clock.Now()
+	switch b.state {
+	case CircuitOpen:
+		return b.config.CoolDown - now.Sub(b.openedAt), b.changed.wait()
+	case CircuitHalfOpen:
+		if !b.probeAt.IsZero() {
+			if d := b.config.CoolDown - now.Sub(b.probeAt); d > 0 {
+				return d, b.changed.wait()
+			}
+		}
+		b.probeAt = now
+	}
+	return 0, nil
+}
+
+// record records the outcome of a request
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	now := b.clock.Now()
+	if now.Sub(b.windowStart) > b.config.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	var to CircuitState
+	switch {
+	case b.state == CircuitOpen:
+		// outcome of a request sent before the circuit was opened
+		b.Unlock()
+		return
+	case b.state == CircuitHalfOpen && !failed:
+		to = CircuitClosed
+	case b.state == CircuitHalfOpen, b.tripped():
+		to = CircuitOpen
+		b.openedAt = now
+	default:
+		b.Unlock()
+		return
+	}
+	from := b.transition(to)
+	b.Unlock()
+	b.notify(from, to)
+}
+
+// tripped reports whether the failure thresholds are exceeded
+func (b *circuitBreaker) tripped() bool {
+	if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+		return true
+	}
+	return b.config.ErrorRate > 0 && b.requests >= b.config.MinRequests &&
+		float64(b.failures)/float64(b.requests) > b.config.ErrorRate
+}
+
+// transition changes the state and resets the counters. Callers must hold the lock.
+func (b *circuitBreaker) transition(to CircuitState) CircuitState {
+	from := b.state
+	b.state = to
+	b.consecutive = 0
+	b.windowStart, b.requests, b.failures = b.clock.Now(), 0, 0
+	b.probeAt = time.Time{}
+	b.changed.signal()
+	return from
+}
+
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	b.logger.Warn("circuit breaker", LogValue{"from", from.String()}, LogValue{"to", to.String()})
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
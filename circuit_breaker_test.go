@@ -0,0 +1,146 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{
+		ErrorRate:   0.5,
+		MinRequests: 4,
+		Window:      time.Hour,
+		CoolDown:    time.Hour,
+	}, &NopLogger{}, systemClock{})
+
+	// below MinRequests
+	b.record(true)
+	b.record(true)
+	b.record(false)
+	require.Equal(t, CircuitClosed, b.currentState())
+
+	// 3 failures out of 4 requests
+	b.record(true)
+	require.Equal(t, CircuitOpen, b.currentState())
+	require.True(t, b.isOpen())
+	d, changed := b.delay()
+	require.Greater(t, d, time.Duration(0))
+	require.NotNil(t, changed)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		Window:              time.Hour,
+		CoolDown:            time.Millisecond,
+	}, &NopLogger{}, systemClock{})
+
+	b.record(true)
+	require.Equal(t, CircuitOpen, b.currentState())
+	time.Sleep(time.Millisecond)
+	d, _ := b.delay()
+	require.Equal(t, time.Duration(0), d)
+	require.Equal(t, CircuitHalfOpen, b.currentState())
+
+	// a failed trial opens the circuit again
+	b.record(true)
+	require.Equal(t, CircuitOpen, b.currentState())
+	time.Sleep(time.Millisecond)
+	d, _ = b.delay()
+	require.Equal(t, time.Duration(0), d)
+	b.record(false)
+	require.Equal(t, CircuitClosed, b.currentState())
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		Window:              time.Hour,
+		CoolDown:            50 * time.Millisecond,
+	}, &NopLogger{}, systemClock{})
+
+	b.record(true)
+	require.True(t, b.isOpen())
+	// the circuit becomes half open without a pending request
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, b.isOpen())
+	require.Equal(t, CircuitHalfOpen, b.currentState())
+
+	// a single trial request is let through, the others wait for its outcome
+	d, _ := b.delay()
+	require.Equal(t, time.Duration(0), d)
+	d, changed := b.delay()
+	require.Greater(t, d, time.Duration(0))
+	b.record(false)
+	require.Equal(t, CircuitClosed, b.currentState())
+	<-changed
+	d, _ = b.delay()
+	require.Equal(t, time.Duration(0), d)
+
+	// a trial without outcome lets another one through after the cool-down
+	b.record(true)
+	time.Sleep(50 * time.Millisecond)
+	d, _ = b.delay()
+	require.Equal(t, time.Duration(0), d)
+	d, _ = b.delay()
+	require.Greater(t, d, time.Duration(0))
+	time.Sleep(50 * time.Millisecond)
+	d, _ = b.delay()
+	require.Equal(t, time.Duration(0), d)
+}
+
+func TestNilCircuitBreaker(t *testing.T) {
+	var b *circuitBreaker
+	b.record(true)
+	require.False(t, b.isOpen())
+	d, _ := b.delay()
+	require.Equal(t, time.Duration(0), d)
+	require.Equal(t, CircuitClosed, b.currentState())
+}
+
+func TestCircuitBreakerIdle(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Error: errors.New("invalid argument")},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		CircuitBreaker: &CircuitBreakerConfig{
+			ConsecutiveFailures: 1,
+			CoolDown:            50 * time.Millisecond,
+		},
+		ErrorClassifier: func(err error) ErrorClass {
+			return ErrorFatal
+		},
+		Logger: &NopLogger{},
+		Client: client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	defer p.Stop()
+
+	// the non-retryable failure opens the circuit and leaves nothing pending
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Flush(context.Background()))
+	<-failures
+	require.Equal(t, CircuitOpen, p.Stats().Circuit)
+	require.IsType(t, &ErrCircuitOpen{}, p.Put([]byte("world"), "bar"))
+
+	// Puts are accepted again after the cool-down
+	require.Eventually(t, func() bool {
+		return p.Put([]byte("world"), "bar") == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, CircuitClosed, p.Stats().Circuit)
+}
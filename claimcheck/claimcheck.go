@@ -0,0 +1,81 @@
+// Package claimcheck defines the pointer records sent to Kinesis in place of large
+// payloads that were offloaded to an external store.
+//
+// A pointer record is the magic number 0x4B 0x50 0x43 0x43 followed by the ClaimCheck
+// encoded as JSON.
+package claimcheck
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var magicNumber = []byte{0x4B, 0x50, 0x43, 0x43}
+
+// ClaimCheck points to a payload stored outside of Kinesis
+type ClaimCheck struct {
+	// Bucket, or other container, the payload is stored in
+	Bucket string `json:"bucket"`
+	// Key of the payload in Bucket
+	Key string `json:"key"`
+	// Size of the payload in bytes
+	Size int `json:"size"`
+	// Checksum is the hex encoded SHA-256 of the payload
+	Checksum string `json:"checksum"`
+}
+
+// New creates a ClaimCheck for data stored at bucket and key
+func New(bucket, key string, data []byte) *ClaimCheck {
+	return &ClaimCheck{
+		Bucket:   bucket,
+		Key:      key,
+		Size:     len(data),
+		Checksum: checksum(data),
+	}
+}
+
+// Verify returns an error if data does not match the size and checksum of the claim check
+func (c *ClaimCheck) Verify(data []byte) error {
+	if len(data) != c.Size {
+		return fmt.Errorf("claimcheck: size mismatch: expected %d, got %d", c.Size, len(data))
+	}
+	if checksum(data) != c.Checksum {
+		return errors.New("claimcheck: checksum mismatch")
+	}
+	return nil
+}
+
+// Encode returns the pointer record of the claim check
+func Encode(c *ClaimCheck) ([]byte, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, magicNumber...), data...), nil
+}
+
+// IsClaimCheck reports whether data is a pointer record
+func IsClaimCheck(data []byte) bool {
+	return bytes.HasPrefix(data, magicNumber)
+}
+
+// Decode parses a pointer record
+func Decode(data []byte) (*ClaimCheck, error) {
+	if !IsClaimCheck(data) {
+		return nil, errors.New("claimcheck: not a claim check")
+	}
+	c := &ClaimCheck{}
+	if err := json.Unmarshal(data[len(magicNumber):], c); err != nil {
+		return nil, fmt.Errorf("claimcheck: %w", err)
+	}
+	return c, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,34 @@
+package claimcheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	c := New("bucket", "prefix/key", []byte("hello world"))
+	require.Equal(t, 11, c.Size)
+	require.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", c.Checksum)
+
+	data, err := Encode(c)
+	require.NoError(t, err)
+	require.True(t, IsClaimCheck(data))
+
+	got, err := Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, c, got)
+}
+
+func TestDecodeNotClaimCheck(t *testing.T) {
+	require.False(t, IsClaimCheck([]byte("hello")))
+	_, err := Decode([]byte("hello"))
+	require.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	c := New("bucket", "key", []byte("hello world"))
+	require.NoError(t, c.Verify([]byte("hello world")))
+	require.EqualError(t, c.Verify([]byte("hello")), "claimcheck: size mismatch: expected 11, got 5")
+	require.EqualError(t, c.Verify([]byte("hello_world")), "claimcheck: checksum mismatch")
+}
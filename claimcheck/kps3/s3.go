@@ -0,0 +1,73 @@
+package kps3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+
+	producer "github.com/achunariov/kinesis-producer"
+	"github.com/achunariov/kinesis-producer/claimcheck"
+)
+
+// Client is the interface that wraps the s3.Client PutObject and GetObject methods.
+type Client interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// Store implements producer.LargeRecordStore by uploading payloads to S3 objects keyed
+// <Prefix>/<yyyy>/<mm>/<dd>/<hh>/<uuid>. Consumers use Load to fetch the payload of a
+// pointer record.
+type Store struct {
+	Client Client
+	Bucket string
+	Prefix string
+}
+
+var _ producer.LargeRecordStore = (*Store)(nil)
+
+// New creates a Store uploading to bucket under prefix
+func New(client Client, bucket, prefix string) *Store {
+	return &Store{Client: client, Bucket: bucket, Prefix: prefix}
+}
+
+// Store uploads data to a new S3 object
+func (s *Store) Store(ctx context.Context, data []byte) (*claimcheck.ClaimCheck, error) {
+	key := path.Join(s.Prefix, time.Now().UTC().Format("2006/01/02/15"), uuid.New().String())
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kps3: put object %s: %w", key, err)
+	}
+	return claimcheck.New(s.Bucket, key, data), nil
+}
+
+// Load downloads the payload of the claim check and verifies its size and checksum
+func (s *Store) Load(ctx context.Context, c *claimcheck.ClaimCheck) ([]byte, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(c.Key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kps3: get object %s: %w", c.Key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("kps3: read object %s: %w", c.Key, err)
+	}
+	if err := c.Verify(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,231 @@
+// Command kinesis-produce reads records from stdin and puts them to a Kinesis stream with
+// the producer, e.g. for smoke tests and backfills.
+//
+// Records are either lines, or frames prefixed with their length as a big endian uint32:
+//
+//	kinesis-produce -stream events < events.jsonl
+//	kinesis-produce -stream events -format frames -rate 1000 < events.bin
+//
+// The partition key of each record is built from a text/template with the fields N, the
+// index of the record starting at 0, Data, the record as a string, and UUID, a random
+// UUID. It defaults to "{{.UUID}}". Credentials and region are loaded from the default AWS
+// configuration, unless -endpoint points to a local emulator.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	producer "github.com/achunariov/kinesis-producer"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/google/uuid"
+)
+
+// maxFrameSize bounds the length prefix of a frame, the maximum size of a Kinesis record
+const maxFrameSize = 10 << 20
+
+func main() {
+	var (
+		stream       = flag.String("stream", "", "name or ARN of the stream (required)")
+		format       = flag.String("format", "lines", `format of stdin, "lines" or "frames" prefixed with their length as a big endian uint32`)
+		keyTemplate  = flag.String("partition-key", "{{.UUID}}", "text/template of the partition key, with the fields N, Data and UUID")
+		aggregate    = flag.Bool("aggregate", true, "aggregate user records into kinesis records")
+		rate         = flag.Int("rate", 0, "maximum number of records put per second, 0 for no limit")
+		headroom     = flag.Int("rate-limit-headroom", 0, "percent of the per shard limits kept in reserve by the rate limiter")
+		region       = flag.String("region", "", "AWS region, default to the region of the AWS configuration")
+		endpoint     = flag.String("endpoint", "", "endpoint of a local emulator like LocalStack, e.g. http://localhost:4566")
+		verbose      = flag.Bool("verbose", false, "log every flush")
+		flushTimeout = flag.Duration("flush-timeout", time.Minute, "maximum time to wait for the records left at the end of stdin")
+	)
+	flag.Parse()
+	if *stream == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	key, err := template.New("partition-key").Parse(*keyTemplate)
+	if err != nil {
+		log.Fatalf("kinesis-produce: invalid -partition-key: %v", err)
+	}
+	read, err := newReader(*format, os.Stdin)
+	if err != nil {
+		log.Fatalf("kinesis-produce: %v", err)
+	}
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := newClient(ctx, *endpoint, *region)
+	if err != nil {
+		log.Fatalf("kinesis-produce: %v", err)
+	}
+	opts := []producer.Option{
+		producer.WithClient(client),
+		producer.WithRateLimit(*headroom),
+		producer.WithLogger(&producer.StdLogger{Logger: log.Default(), Level: level}),
+	}
+	if strings.HasPrefix(*stream, "arn:") {
+		opts = append(opts, producer.WithStreamARN(*stream))
+	} else {
+		opts = append(opts, producer.WithStreamName(*stream))
+	}
+	p, err := producer.NewProducer(opts...)
+	if err != nil {
+		log.Fatalf("kinesis-produce: %v", err)
+	}
+	var putOpts []producer.PutOption
+	if !*aggregate {
+		putOpts = append(putOpts, producer.WithoutAggregation())
+	}
+
+	var failed int64
+	failures := p.NotifyFailures()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range failures {
+			atomic.AddInt64(&failed, 1)
+			log.Printf("kinesis-produce: %v", err)
+		}
+	}()
+
+	p.Start()
+	start := time.Now()
+	n, err := produce(ctx, p, read, key, *rate, putOpts)
+	if err != nil {
+		log.Printf("kinesis-produce: %v", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *flushTimeout)
+	defer cancel()
+	if err := p.Shutdown(shutdownCtx); err != nil {
+		log.Printf("kinesis-produce: shutdown: %v", err)
+	}
+	<-done
+	log.Printf("kinesis-produce: put %d records in %s, %d failed", n, time.Since(start).Round(time.Millisecond), atomic.LoadInt64(&failed))
+	if err != nil || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// newClient returns the client of the emulator at endpoint, or the client of the default
+// AWS configuration
+func newClient(ctx context.Context, endpoint, region string) (*kinesis.Client, error) {
+	if endpoint != "" {
+		return producer.NewLocalClient(endpoint), nil
+	}
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return kinesis.NewFromConfig(cfg), nil
+}
+
+// keyFields are the fields of the partition key template
+type keyFields struct {
+	N    int
+	Data string
+	UUID string
+}
+
+// produce puts the records returned by read until io.EOF or ctx is done, at most rate per
+// second, and returns the number of records put
+func produce(ctx context.Context, p *producer.Producer, read func() ([]byte, error), key *template.Template, rate int, opts []producer.PutOption) (int, error) {
+	var (
+		start = time.Now()
+		buf   bytes.Buffer
+	)
+	for n := 0; ; n++ {
+		if rate > 0 {
+			if d := time.Until(start.Add(time.Duration(n) * time.Second / time.Duration(rate))); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		data, err := read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		buf.Reset()
+		fields := keyFields{N: n, Data: string(data), UUID: uuid.New().String()}
+		if err := key.Execute(&buf, fields); err != nil {
+			return n, fmt.Errorf("partition key of record %d: %w", n, err)
+		}
+		if err := p.Put(data, buf.String(), opts...); err != nil {
+			return n, err
+		}
+	}
+}
+
+// newReader returns a function reading the records of r in format, which returns io.EOF
+// at the end of r
+func newReader(format string, r io.Reader) (func() ([]byte, error), error) {
+	reader := bufio.NewReaderSize(r, 64<<10)
+	switch format {
+	case "lines":
+		return func() ([]byte, error) {
+			for {
+				line, err := reader.ReadBytes('\n')
+				if len(line) == 0 && err != nil {
+					return nil, err
+				}
+				line = bytes.TrimRight(line, "\r\n")
+				// skip empty lines, Kinesis rejects records without data
+				if len(line) > 0 {
+					return line, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}, nil
+	case "frames":
+		return func() ([]byte, error) {
+			var size uint32
+			if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+				return nil, err
+			}
+			if size > maxFrameSize {
+				return nil, fmt.Errorf("frame of %d bytes exceeds %d", size, maxFrameSize)
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			return data, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/achunariov/kinesis-producer/producertest"
+)
+
+func readAll(t *testing.T, read func() ([]byte, error)) []string {
+	var records []string
+	for {
+		data, err := read()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, string(data))
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	read, err := newReader("lines", strings.NewReader("hello\r\n\nworld"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello", "world"}, readAll(t, read))
+}
+
+func TestReadFrames(t *testing.T) {
+	var buf bytes.Buffer
+	for _, s := range []string{"hello\n", "world"} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	read, err := newReader("frames", bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello\n", "world"}, readAll(t, read))
+
+	// truncated frame
+	read, err = newReader("frames", bytes.NewReader(buf.Bytes()[:8]))
+	require.NoError(t, err)
+	_, err = read()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = newReader("csv", &buf)
+	require.EqualError(t, err, `unknown format "csv"`)
+}
+
+func TestProduce(t *testing.T) {
+	putter := producertest.NewPutter()
+	p := producertest.NewProducer(t, putter)
+	read, err := newReader("lines", strings.NewReader("hello\nworld\n"))
+	require.NoError(t, err)
+	key := template.Must(template.New("").Parse("{{.N}}-{{.Data}}"))
+
+	n, err := produce(context.Background(), p, read, key, 1000, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, p.Flush(context.Background()))
+	producertest.RequireDelivered(t, putter, "hello", "world")
+	var keys []string
+	for _, r := range putter.Delivered() {
+		keys = append(keys, r.PartitionKey)
+	}
+	require.ElementsMatch(t, []string{"0-hello", "1-world"}, keys)
+}
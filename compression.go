@@ -0,0 +1,45 @@
+package producer
+
+import "github.com/achunariov/kinesis-producer/compression"
+
+// compressedRecord is a user record whose data was compressed with Config.Compression
+type compressedRecord struct {
+	UserRecord
+	data []byte
+}
+
+func (r *compressedRecord) Data() []byte { return r.data }
+func (r *compressedRecord) Size() int    { return len(r.data) }
+
+// compresses reports whether the data of the user record must be compressed, or stored
+// in a compression header because it looks like a compressed payload. Chunks and pointer
+// records are created from records that went through compression already.
+func (p *Producer) compresses(userRecord UserRecord) bool {
+	if p.Compression == nil {
+		return false
+	}
+	switch userRecord.(type) {
+	case *compressedRecord, *encryptedRecord, *chunkRecord, *claimCheckRecord:
+		return false
+	}
+	return userRecord.Size() >= p.CompressionThreshold || compression.IsCompressed(userRecord.Data())
+}
+
+// compress returns the user record with compressed data, or the user record itself if
+// compression does not make it smaller. Uncompressed data starting with the compression
+// header is stored in a header of its own so that consumers do not decompress it.
+func (p *Producer) compress(userRecord UserRecord) (UserRecord, error) {
+	if userRecord.Size() >= p.CompressionThreshold {
+		data, err := compression.Compress(p.Compression, userRecord.Data())
+		if err != nil {
+			return nil, &ErrCompression{UserRecord: unwrapUserRecord(userRecord), Err: err}
+		}
+		if len(data) < userRecord.Size() {
+			return &compressedRecord{UserRecord: userRecord, data: data}, nil
+		}
+	}
+	if !compression.IsCompressed(userRecord.Data()) {
+		return userRecord, nil
+	}
+	return &compressedRecord{UserRecord: userRecord, data: compression.Store(userRecord.Data())}, nil
+}
@@ -0,0 +1,185 @@
+// Package compression compresses user record data before aggregation and decompresses it
+// on the consumer side.
+//
+// A compressed payload starts with a header:
+//
+//	magic [3]byte 0x4B 0x50 0x5A
+//	codec byte    ID of the codec, 0 for data stored without compression
+//
+// followed by the compressed data. Uncompressed data that starts with the magic number is
+// stored with codec 0, see Store, so that it is not mistaken for a compressed payload.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// HeaderSize is the number of bytes added to each compressed payload
+	HeaderSize = 4
+	// storedID is the codec ID of the payloads stored without compression
+	storedID = 0
+)
+
+var magicNumber = []byte{0x4B, 0x50, 0x5A}
+
+// Codec compresses and decompresses payloads. Codecs must be safe for concurrent use.
+type Codec interface {
+	// ID identifies the codec in the header of compressed payloads. IDs below 128 are
+	// reserved for the built-in codecs, 0 for payloads stored without compression.
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Built-in codecs
+var (
+	Gzip   Codec = gzipCodec{}
+	Zstd   Codec = &zstdCodec{}
+	Snappy Codec = snappyCodec{}
+)
+
+var (
+	codecs   = map[byte]Codec{}
+	codecsMu sync.RWMutex
+)
+
+func init() {
+	for _, codec := range []Codec{Gzip, Zstd, Snappy} {
+		Register(codec)
+	}
+}
+
+// Register makes a custom codec available to Decompress
+func Register(codec Codec) {
+	codecsMu.Lock()
+	codecs[codec.ID()] = codec
+	codecsMu.Unlock()
+}
+
+// Compress compresses data with codec and prepends the header
+func Compress(codec Codec, data []byte) ([]byte, error) {
+	compressed, err := codec.Compress(data)
+	if err != nil {
+		return nil, fmt.Errorf("compression: %w", err)
+	}
+	out := make([]byte, 0, HeaderSize+len(compressed))
+	out = append(out, magicNumber...)
+	out = append(out, codec.ID())
+	return append(out, compressed...), nil
+}
+
+// Store prepends the header of a payload stored without compression to data, for
+// uncompressed data that would otherwise be mistaken for a compressed payload.
+func Store(data []byte) []byte {
+	out := make([]byte, 0, HeaderSize+len(data))
+	out = append(out, magicNumber...)
+	out = append(out, storedID)
+	return append(out, data...)
+}
+
+// IsCompressed reports whether data starts with the header of a compressed payload
+func IsCompressed(data []byte) bool {
+	return len(data) >= HeaderSize && bytes.HasPrefix(data, magicNumber)
+}
+
+// Decompress decompresses a payload created by Compress or Store. Data that is not
+// compressed is returned unchanged.
+func Decompress(data []byte) ([]byte, error) {
+	if !IsCompressed(data) {
+		return data, nil
+	}
+	if data[len(magicNumber)] == storedID {
+		return data[HeaderSize:], nil
+	}
+	codecsMu.RLock()
+	codec, ok := codecs[data[len(magicNumber)]]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("compression: unknown codec %d", data[len(magicNumber)])
+	}
+	out, err := codec.Decompress(data[HeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("compression: %w", err)
+	}
+	return out, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte { return 1 }
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdCodec lazily creates an encoder and decoder that are shared by all callers
+type zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (c *zstdCodec) ID() byte { return 2 }
+
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil)
+	})
+	return c.err
+}
+
+func (c *zstdCodec) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeAll(data, nil)
+}
+
+// snappyCodec uses the snappy block format
+type snappyCodec struct{}
+
+func (snappyCodec) ID() byte { return 3 }
+
+func (snappyCodec) Compress(data []byte) ([]byte, error) {
+	return s2.EncodeSnappy(nil, data), nil
+}
+
+func (snappyCodec) Decompress(data []byte) ([]byte, error) {
+	return s2.Decode(nil, data)
+}
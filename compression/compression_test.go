@@ -0,0 +1,71 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world "), 100)
+	for name, codec := range map[string]Codec{"gzip": Gzip, "zstd": Zstd, "snappy": Snappy} {
+		t.Run(name, func(t *testing.T) {
+			compressed, err := Compress(codec, data)
+			require.NoError(t, err)
+			require.True(t, IsCompressed(compressed))
+			require.Equal(t, codec.ID(), compressed[3])
+			require.Less(t, len(compressed), len(data))
+
+			out, err := Decompress(compressed)
+			require.NoError(t, err)
+			require.Equal(t, data, out)
+		})
+	}
+}
+
+func TestDecompressPassThrough(t *testing.T) {
+	out, err := Decompress([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), out)
+}
+
+func TestStore(t *testing.T) {
+	// uncompressed data that starts with the magic number
+	data := []byte{0x4B, 0x50, 0x5A, 1, 'h', 'i'}
+	stored := Store(data)
+	require.Equal(t, append([]byte{0x4B, 0x50, 0x5A, 0}, data...), stored)
+	require.True(t, IsCompressed(stored))
+	out, err := Decompress(stored)
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+}
+
+func TestDecompressUnknownCodec(t *testing.T) {
+	_, err := Decompress([]byte{0x4B, 0x50, 0x5A, 201, 1, 2})
+	require.EqualError(t, err, "compression: unknown codec 201")
+}
+
+type reverseCodec struct{}
+
+func (reverseCodec) ID() byte { return 200 }
+
+func (reverseCodec) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (c reverseCodec) Decompress(data []byte) ([]byte, error) { return c.Compress(data) }
+
+func TestRegister(t *testing.T) {
+	Register(reverseCodec{})
+	compressed, err := Compress(reverseCodec{}, []byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x4B, 0x50, 0x5A, 200, 'c', 'b', 'a'}, compressed)
+	out, err := Decompress(compressed)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), out)
+}
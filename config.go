@@ -2,12 +2,22 @@ package producer
 
 import (
 	"context"
+	"errors"
 	"log"
+	"log/slog"
 	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/achunariov/kinesis-producer/compression"
+	"github.com/achunariov/kinesis-producer/envelope"
+	"github.com/achunariov/kinesis-producer/wire"
 )
 
 // Constants and default configuration take from:
@@ -22,6 +32,29 @@ const (
 	defaultMaxConnections  = 24
 	defaultFlushInterval   = 5 * time.Second
 	partitionKeyIndexSize  = 8
+
+	// payloadUnitSize is the size of a PUT payload unit, the unit Kinesis bills records in
+	payloadUnitSize = 25 << 10 // 25KiB
+	// maxPayloadUnits is the number of payload units that fit in a record
+	maxPayloadUnits = maxRecordSize / payloadUnitSize
+
+	minChunkSize = 1 << 10 // 1KiB
+
+	// Firehose PutRecordBatch limits
+	firehoseMaxRecordSize  = 1000 << 10 // 1000KiB
+	firehoseMaxRequestSize = 4 << 20    // 4MiB
+)
+
+// Backend is the service the Producer puts records to.
+type Backend int
+
+const (
+	// BackendKinesis puts records to a Kinesis data stream.
+	BackendKinesis Backend = iota
+	// BackendFirehose puts records to a Firehose delivery stream using a Putter such as
+	// kpfirehose.Putter. Records are not aggregated, partition keys are optional and
+	// requests are limited to 500 records and 4MiB.
+	BackendFirehose
 )
 
 // Putter is the interface that wraps the KinesisAPI.PutRecords method.
@@ -38,17 +71,131 @@ type GetShardsFunc func(old []types.Shard) ([]types.Shard, bool, error)
 
 func defaultGetShardsFunc(old []types.Shard) ([]types.Shard, bool, error) { return nil, false, nil }
 
+// GetStreamShardsFunc is the GetShardsFunc of streams other than Config.StreamName. It is
+// called with the stream name the first time a record is put to the stream and during
+// the shard refresh interval.
+type GetStreamShardsFunc func(stream string, old []types.Shard) ([]types.Shard, bool, error)
+
+// ShardChange describes a change of the shards of a stream found by a shard refresh, e.g.
+// after a split or a merge.
+type ShardChange struct {
+	// Stream is the name or ARN of the stream
+	Stream string
+	// Old are the shards before the refresh
+	Old []types.Shard
+	// New are the shards after the refresh
+	New []types.Shard
+}
+
+// StreamRouter returns the stream a user record is put to. Returning the empty string
+// puts the record to Config.StreamName.
+type StreamRouter func(userRecord UserRecord) string
+
+// OverflowPolicy determines the behavior of Put when the backlog is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks Put until there is room in the backlog.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the record being Put. Put returns nil and the record is
+	// reported as *ErrBacklogFull to NotifyFailures.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest aggregated record waiting to be handed to the
+	// flusher and reports it to NotifyFailures as a FailureRecord. If no record can be
+	// dropped, e.g. during a shard refresh or Flush, Put blocks.
+	OverflowDropOldest
+	// OverflowError returns *ErrBacklogFull from Put.
+	OverflowError
+	// OverflowSpill writes the record to a temporary file in SpillDir. Spilled records are
+	// put back in order as soon as there is room. Put returns an error if the record can
+	// not be written.
+	OverflowSpill
+)
+
 // Config is the Producer configuration.
 type Config struct {
-	// StreamName is the Kinesis stream.
+	// StreamName is the Kinesis stream, or the Firehose delivery stream when Backend is
+	// BackendFirehose.
 	StreamName string
 
+	// StreamARN is the ARN of the Kinesis stream, to put records to streams of other
+	// accounts or regions. Exactly one of StreamName and StreamARN must be set.
+	StreamARN string
+
+	// Backend is the service records are put to. Default to BackendKinesis.
+	Backend Backend
+
+	// StreamRouter selects the stream of each record put with Put or PutUserRecord. Records
+	// of each stream are aggregated and batched separately but share the connections of
+	// the Producer. Streams starting with "arn:" are put to by ARN. Default to nil, all
+	// records are put to StreamName or StreamARN.
+	StreamRouter StreamRouter
+
+	// Router selects the partition key and stream of each record put with PutData from its
+	// data. Default to nil, PutData fails.
+	Router Router
+
+	// Interceptors transform each user record at Put time, before it is routed, in order.
+	// A nil record returned by an interceptor drops the record: Put returns nil and the
+	// future of PutWithResult is resolved without error. Default to nil.
+	Interceptors []RecordInterceptor
+
+	// DeduplicationWindow suppresses user records put within DeduplicationWindow of a
+	// record with the same idempotency key, e.g. sent twice by upstream retries. Put
+	// returns nil for a duplicate, which is reported as *ErrDuplicateRecord to
+	// NotifyFailures. The key is set with WithIdempotencyKey or returned by
+	// IdempotencyKey. Records that are not accepted by Put, or that failed, were dropped or
+	// evicted, can be put again. Default to 0, records are not deduplicated.
+	DeduplicationWindow time.Duration
+
+	// DeduplicationSize is the maximum number of idempotency keys remembered. The oldest
+	// keys are forgotten first, which shortens the window under load. Default to 100000.
+	DeduplicationSize int
+
+	// IdempotencyKey returns the idempotency key of user records put without
+	// WithIdempotencyKey. Records with an empty key are not deduplicated. Default to a hash
+	// of the partition key and data.
+	IdempotencyKey func(userRecord UserRecord) string
+
+	// Envelope wraps the data of each user record in an envelope with headers, the id of
+	// the producer and the time it was put, after Interceptors. Consumers unwrap it with
+	// envelope.Decode. Default to nil, data is sent as is.
+	Envelope *EnvelopeConfig
+
+	// GetStreamShards is called to populate the ShardMap of streams other than StreamName.
+	// Default to nil, records of other streams are aggregated without a ShardMap.
+	GetStreamShards GetStreamShardsFunc
+
 	// FlushInterval is a regular interval for flushing the buffer. Defaults to 5s.
 	FlushInterval time.Duration
 
+	// AggregationMaxDelay is how long a user record may wait inside an aggregated record
+	// before it is drained to the worker pool. When set, it replaces FlushInterval. Default
+	// to 0, FlushInterval is used.
+	AggregationMaxDelay time.Duration
+
+	// RequestMaxDelay is how long a drained record may wait for a fuller PutRecords
+	// request. Batches are sent once they are full or their oldest record waited
+	// RequestMaxDelay, rather than at every flush. Default to 0, batches are sent at every
+	// flush.
+	RequestMaxDelay time.Duration
+
+	// AdaptiveFlush adjusts the flush interval to the rate of puts, starting at
+	// FlushInterval. The interval shortens under high throughput to bound latency and
+	// lengthens when idle to improve aggregation, within MinFlushInterval and
+	// MaxFlushInterval. Default to false.
+	AdaptiveFlush bool
+
+	// MinFlushInterval is the shortest flush interval with AdaptiveFlush. Default to 100ms.
+	MinFlushInterval time.Duration
+
+	// MaxFlushInterval is the longest flush interval with AdaptiveFlush. Default to 30s.
+	MaxFlushInterval time.Duration
+
 	// ShardRefreshInterval is a regular interval for refreshing the ShardMap.
-	// Config.GetShards will be called at this interval. A value of 0 means no refresh
-	// occurs. Default is 0
+	// Config.GetShards will be called at this interval. A value of 0 means no periodic
+	// refresh occurs. The ShardMap is also refreshed, at most once a second, when Kinesis
+	// writes a record to another shard than the one it was aggregated for. Default is 0
 	ShardRefreshInterval time.Duration
 
 	// GetShards is called on NewProducer to initialze the ShardMap.
@@ -57,12 +204,35 @@ type Config struct {
 	// aggregated to a single record.
 	GetShards GetShardsFunc
 
+	// OnShardChange is called after a shard refresh updated the shards of a stream. It is
+	// called from the producer loop, which does not flush until it returns, so it must
+	// not block. Default to nil.
+	OnShardChange func(ShardChange)
+
+	// Hasher maps the partition keys of user records without an explicit hash key to
+	// shards. Records mapped with a Hasher other than MD5Hasher are sent with explicit hash
+	// keys. Default to MD5Hasher with a cache of the 4096 most recently used partition keys.
+	Hasher Hasher
+
+	// AutoPartitionKey replaces the empty partition key of records put with Put, or its
+	// variants taking a partition key, by a random one, so that records without a natural
+	// key are spread evenly across shards instead of failing. Records put with
+	// PutUserRecord keep their partition key. Default to false.
+	AutoPartitionKey bool
+
+	// StickyPartitionInterval makes keyless records share a random partition key, like the
+	// sticky partitioner of Kafka, instead of getting one each. The key is rotated once
+	// the records put with it fill an aggregated record, or after StickyPartitionInterval,
+	// so that records are aggregated tightly and still spread across shards over time.
+	// Requires AutoPartitionKey. Default to 0, every keyless record gets its own key.
+	StickyPartitionInterval time.Duration
+
 	// BatchCount determine the maximum number of items to pack in batch.
 	// Must not exceed length. Defaults to 500.
 	BatchCount int
 
 	// BatchSize determine the maximum number of bytes to send with a PutRecords request.
-	// Must not exceed 5MiB; Default to 5MiB.
+	// Must not exceed 5MiB, or 4MiB for BackendFirehose; Default to the maximum.
 	BatchSize int
 
 	// AggregateBatchCount determine the maximum number of items to pack into an aggregated record.
@@ -72,63 +242,749 @@ type Config struct {
 	// than this will bypass aggregation.
 	AggregateBatchSize int
 
-	// BacklogCount determines the channel capacity before Put() will begin blocking. Default to `BatchCount`.
+	// MaxJSONSize rejects the values put with PutJSON whose JSON encoding is larger than
+	// that many bytes with *ErrJSONSizeExceeded, before the encoding is copied into a
+	// record. Default to 0, the encoding is only limited like the data of any record.
+	MaxJSONSize int
+
+	// DisableAggregation sends each user record as its own PutRecords entry, for consumers
+	// that do not deaggregate, e.g. Lambda or Firehose. Records are still batched, retried,
+	// rate limited and mapped to their shards. Default to false.
+	DisableAggregation bool
+
+	// AggregatePayloadUnits drains aggregated records before they grow past that many
+	// 25KiB PUT payload units, including the aggregation overhead and the partition key,
+	// since Kinesis bills every started unit. Aggregated records drained because they are
+	// full then fill the units they are billed for. Must not exceed 40. Default to 0,
+	// aggregated records grow up to the maximum record size.
+	AggregatePayloadUnits int
+
+	// BacklogCount determines the number of Puts in progress and drained records waiting for
+	// the worker pool before Put() will begin blocking. Default to `BatchCount`.
 	BacklogCount int
 
+	// MaxBufferedBytes limits the total size of the user records held by the producer,
+	// from Put until they are delivered or fail: in the aggregators, the backlog and the
+	// worker pool, including the requests in flight and the records waiting for a retry.
+	// Once exceeded, Put applies OverflowPolicy and the buffered records are flushed
+	// early. Default to 0, no limit.
+	MaxBufferedBytes int
+
+	// OverflowPolicy determines the behavior of Put when the backlog is full. Default to
+	// OverflowBlock.
+	OverflowPolicy OverflowPolicy
+
+	// SpillDir is the directory of the temporary files of records spilled by the
+	// OverflowSpill policy. Default to os.TempDir().
+	SpillDir string
+
 	// Number of requests to sent concurrently. Default to 24.
 	// If you are using the ListShards API in your GetShards function, those connections
 	// will not be counted in MaxConnections.
 	MaxConnections int
 
-	// Logger is the logger used. Default to producer.Logger.
+	// Backoff determines the delay between retries of throttled or failed PutRecords
+	// requests. Default to FullJitterBackoff with a 100ms base and 10s cap.
+	Backoff Backoff
+
+	// ErrorClassifier decides which errors of PutRecords requests, and of the records
+	// rejected in their responses, are retried, and which are counted as throttles. The
+	// class of the error of a failed record is reported in FailureRecord.Class. Default to
+	// DefaultErrorClassifier.
+	ErrorClassifier ErrorClassifier
+
+	// MaxChunkSize enables splitting user records larger than MaxChunkSize bytes, including
+	// the partition key, into chunks of at most MaxChunkSize bytes. Chunks are framed with
+	// the chunking package format and can be reassembled with chunking.Dechunker. Must be
+	// at least 1KiB and at most the maximum record size. Default to 0, records larger than
+	// the maximum record size are rejected.
+	MaxChunkSize int
+
+	// Compression compresses the data of user records before aggregation, e.g.
+	// compression.Zstd. Consumers decompress it with compression.Decompress. Data is sent
+	// uncompressed if compression does not make it smaller, in a header of its own if it
+	// starts with the compression header. Default to nil.
+	Compression compression.Codec
+
+	// CompressionThreshold is the minimum size in bytes of the data of user records that
+	// are compressed. Default to 0, compress all records.
+	CompressionThreshold int
+
+	// Encryptor encrypts the data of user records after compression, e.g.
+	// encryption.Encryptor with data keys of AWS KMS. Consumers decrypt it with
+	// encryption.Decryptor before decompressing it. Default to nil.
+	Encryptor Encryptor
+
+	// LargeRecordStore stores the payload of user records larger than
+	// LargeRecordThreshold outside of Kinesis, e.g. claimcheck/kps3.Store. A pointer record
+	// is put in its place. Takes precedence over MaxChunkSize. Default to nil.
+	LargeRecordStore LargeRecordStore
+
+	// LargeRecordThreshold is the size in bytes, including the partition key, above which
+	// user records are offloaded to the LargeRecordStore. Records whose data starts like a
+	// pointer record are offloaded whatever their size. Default to the maximum record
+	// size.
+	LargeRecordThreshold int
+
+	// WriteAheadLog stores the user records accepted by Put until they are delivered, e.g.
+	// wal.Log. Records that were not acknowledged, e.g. after a crash or because they
+	// failed or were abandoned by Shutdown, are put again by Start. Default to nil.
+	WriteAheadLog WriteAheadLog
+
+	// VerifyIntegrity checksums the data of user records when they are aggregated and
+	// verifies it when the aggregated record is built, and verifies the MD5 trailer of
+	// aggregated records and the checksum of plain records before each PutRecords request.
+	// Records whose data changed, e.g. because the application reused the buffer after Put,
+	// fail with ErrChecksumMismatch instead of being sent. Default to false.
+	VerifyIntegrity bool
+
+	// WireVersion is the version of the wire package format aggregated records are sent
+	// in. Version 1 frames them in a versioned header, which only the deaggregation package
+	// of this module understands, the KCL does not. Default to 0, the KPL aggregated record
+	// format.
+	WireVersion int
+
+	// AggregationFormat packs user records into aggregated records instead of the KPL
+	// format, for consumers that can not deaggregate it, e.g. NDJSON, LengthPrefixed or
+	// PassThrough to disable aggregation. Partition and explicit hash keys of the user
+	// records are not kept, and WireVersion does not apply. Default to nil, the KPL format.
+	AggregationFormat AggregationFormat
+
+	// ThrottleHook feeds external signals, e.g. the lag of downstream consumers, into the
+	// Producer. It is called every ThrottleInterval from a goroutine of its own and the
+	// Throttle it returns is applied as with Producer.SetThrottle. Default to nil.
+	ThrottleHook ThrottleHook
+
+	// ThrottleInterval is the interval ThrottleHook is called at. Default to 1s.
+	ThrottleInterval time.Duration
+
+	// Cost estimates the costs of the records delivered, reported in Stats.Cost, and
+	// optionally limits the records put per hour with a Budget. Default to nil, disabled.
+	Cost *CostConfig
+
+	// Barriers counts the user records accepted between calls to Producer.Barrier, which
+	// requires it. Default to false, records are not tracked.
+	Barriers bool
+
+	// OrderedDelivery preserves the order of records put to the same shard, e.g. with the
+	// same partition key, from a single goroutine. Each PutRecords request contains at
+	// most one record per shard and a shard only has one request in flight at a time,
+	// which is retried before newer records of the shard are sent. This limits the
+	// throughput of each shard to one request at a time. Default to false.
+	OrderedDelivery bool
+
+	// ShardConcurrency sends the records of each shard in their own PutRecords requests,
+	// with at most ShardConcurrency requests in flight per shard, so that a wide stream is
+	// sent in parallel without one shard using up the connections. MaxConnections still
+	// limits the requests in flight of all shards. Can not be used with OrderedDelivery.
+	// Default to 0, requests mix the records of all shards of a stream.
+	ShardConcurrency int
+
+	// RequestTimeout is the deadline of each PutRecords request. A request that times out
+	// is retried and reported with ErrRequestTimeout. Default to 0, no timeout.
+	RequestTimeout time.Duration
+
+	// CircuitBreaker stops sending requests for a cool-down after sustained failures and
+	// fails Puts with ErrCircuitOpen in the meantime. Default to nil, disabled.
+	CircuitBreaker *CircuitBreakerConfig
+
+	// CreateStream creates StreamName in NewProducer if it does not exist, and waits until
+	// it is active before returning the Producer, see EnsureStream. Errors are returned by
+	// NewProducer, and New panics if it is set. Default to nil, the stream must exist.
+	CreateStream *CreateStreamConfig
+
+	// AutoTune calls DescribeStreamSummary for StreamName, or StreamARN, on NewProducer,
+	// for every other stream when a record is first put to it, and for all of them every
+	// ShardRefreshInterval, and tunes the Producer to the capacity of each stream. Rate
+	// limiting and AggregatePayloadUnits are disabled for the on-demand streams, which add
+	// shards when they are throttled and are billed by the GiB. Unless MaxConnections is
+	// set, it is the sum over the streams of their open shards, at least 4, or at least 24
+	// for on-demand streams, and at most 256. Default to nil, disabled.
+	AutoTune StreamDescriber
+
+	// AutoScaling scales the shards of StreamName, or StreamARN, to the throughput of the
+	// Producer. Set GetShards, e.g. to GetKinesisShardsFunc, so that records are
+	// aggregated for the new shards once a reshard completed. Default to nil, disabled.
+	AutoScaling *AutoScalingConfig
+
+	// Sampling drops user records at Put time, at random or by partition key, and with a
+	// filter, e.g. for the head-based sampling of telemetry. Default to nil, every record is
+	// put.
+	Sampling *SamplingConfig
+
+	// ConnectionScaling scales MaxConnections between its MinConnections and
+	// MaxConnections to the backlog and the latency of the requests, starting at
+	// MaxConnections. Not supported with AutoTune. Default to nil, MaxConnections is fixed.
+	ConnectionScaling *ConnectionScalingConfig
+
+	// MaxRetries is the maximum number of times records are retried after a throttled or
+	// failed PutRecords request before they are failed with ErrMaxRetriesExceeded.
+	// Default to 0, retry until delivered.
+	MaxRetries int
+
+	// MaxRecordAge is the maximum time between the Put of a user record and the PutRecords
+	// request, or retry, that sends it. Older records are dropped and failed with
+	// ErrRecordExpired, and sent to the DeadLetter, instead of being delivered late, e.g.
+	// after the shards were throttled for a while. Default to 0, records do not expire.
+	MaxRecordAge time.Duration
+
+	// DeadLetter receives the records that failed permanently, including the ones dropped
+	// by OverflowPolicy or abandoned by Shutdown, in addition to them being sent to
+	// NotifyFailures. Default to nil.
+	DeadLetter DeadLetter
+
+	// DisableRateLimit disables pacing of PutRecords requests to stay below the per shard
+	// limits of 1000 records/s and 1MiB/s. Rate limiting only applies when GetShards
+	// returns a shard list. Default to false.
+	DisableRateLimit bool
+
+	// RateLimitHeadroom is the percentage of the per shard limits to keep in reserve, e.g.
+	// for other producers writing to the same stream. Default to 0.
+	RateLimitHeadroom int
+
+	// AdaptiveRateLimit slows down the rate limit of a shard when its records are
+	// throttled, e.g. by other producers writing to the same stream: the rate is halved,
+	// at most once a second and down to 10% of the limits, and grows back by 5% of the
+	// limits per second. Requires the rate limit. Default to false.
+	AdaptiveRateLimit bool
+
+	// Logger is the logger used. Default to producer.StdLogger writing to stdout.
 	Logger Logger
 
+	// Metrics receives the internal metrics of the Producer. Default to NopMetrics.
+	Metrics Metrics
+
+	// HotShardThreshold logs a warning and calls OnHotShard for the shards whose
+	// utilization of the per shard limits of 1000 records/s and 1MiB/s exceeds it over 10
+	// seconds. Between 0 and 1. Default to 0, disabled.
+	HotShardThreshold float64
+
+	// OnHotShard is called for each hot shard, see HotShardThreshold. It is called from
+	// the producer loop and must not block. Default to nil.
+	OnHotShard func(HotShard)
+
+	// TracerProvider is used to create spans for every Put, flush and PutRecords request.
+	// The span of a Put is a child of the span of the context of PutWithContext, if any.
+	// Default to the global OpenTelemetry TracerProvider.
+	TracerProvider trace.TracerProvider
+
+	// Clock tells the time and creates the timers of the Producer, e.g. a
+	// producertest.FakeClock in tests. Default to the time package.
+	Clock Clock
+
 	// Enabling verbose logging. Default to false.
+	//
+	// Deprecated: the result of each record is logged at debug level. Verbose only sets
+	// the level of the default StdLogger to debug; configure the level of the Logger
+	// instead.
 	Verbose bool
 
 	// Client is the Putter interface implementation.
 	Client Putter
+
+	// PutterFactory builds the Putter, e.g. when assumed-role credentials or custom
+	// endpoints rotate. It is called once by New, which replaces Client with the Putter it
+	// builds, then again in the background once PutterRefreshInterval has elapsed, with a
+	// context of its own bounded to 30 seconds. Requests keep using the previous Putter
+	// until the new one is built, and if the refresh fails. Default to nil.
+	PutterFactory PutterFactory
+
+	// PutterRefreshInterval is the interval between two calls to PutterFactory. Default to
+	// 0, the Putter is never rebuilt.
+	PutterRefreshInterval time.Duration
+
+	// DryRun writes the records to local files with a FilePutter instead of sending them to
+	// AWS. New replaces Client with it, PutterFactory is not called and CreateStream is
+	// skipped. Default to nil.
+	DryRun *DryRunConfig
+
+	// Mirror also puts every batch to a secondary stream, e.g. in another region, with its
+	// own connections and retries. Records are mirrored whatever the outcome of the
+	// primary request, and records that could not be mirrored are only logged and counted
+	// in Stats.Mirror. Default to nil.
+	Mirror *MirrorConfig
+
+	// Failover sends the requests to a standby stream, e.g. in another region, once the
+	// error rate of the primary stream stays above a threshold, and fails back once the
+	// primary stream recovers. Default to nil.
+	Failover *FailoverConfig
+
+	// Watchdog logs PutRecords requests running for longer than its Threshold, with the
+	// stacks of all goroutines, and optionally cancels them so that they are retried.
+	// Default to nil, disabled.
+	Watchdog *WatchdogConfig
+
+	// Middlewares wrap Client for every PutRecords request, the first one being the
+	// outermost, e.g. Hook to log or audit the requests. Default to nil.
+	Middlewares []Middleware
+
+	// ClientOptions are passed to every PutRecords request of Client and Failover.Client,
+	// e.g. to set a custom retryer, endpoint override or API call timeout on the SDK call.
+	// Use RequestOptions to set options per request. Default to nil.
+	ClientOptions []func(*k.Options)
+}
+
+// defaultStream returns the stream records are put to unless routed elsewhere, either
+// StreamName or StreamARN
+func (c *Config) defaultStream() string {
+	if c.StreamARN != "" {
+		return c.StreamARN
+	}
+	return c.StreamName
+}
+
+// isStreamARN reports whether the stream is identified by its ARN instead of its name
+func isStreamARN(stream string) bool {
+	return strings.HasPrefix(stream, "arn:")
 }
 
 // defaults for configuration
 func (c *Config) defaults() {
+	if cb := c.CircuitBreaker; cb != nil {
+		if cb.MinRequests <= 0 {
+			cb.MinRequests = defaultCircuitBreakerMinRequests
+		}
+		if cb.Window <= 0 {
+			cb.Window = defaultCircuitBreakerWindow
+		}
+		if cb.CoolDown <= 0 {
+			cb.CoolDown = defaultCircuitBreakerCoolDown
+		}
+	}
+	if c.CreateStream != nil {
+		c.CreateStream.defaults()
+	}
+	if c.Envelope != nil {
+		c.Envelope.defaults()
+	}
+	if as := c.AutoScaling; as != nil {
+		if as.MinShards == 0 {
+			as.MinShards = 1
+		}
+		if as.Interval <= 0 {
+			as.Interval = defaultAutoScalingInterval
+		}
+		if as.CoolDown <= 0 {
+			as.CoolDown = defaultAutoScalingCoolDown
+		}
+		if as.ThrottleRate == 0 {
+			as.ThrottleRate = defaultAutoScalingThrottleRate
+		}
+		if as.ScaleUpUtilization == 0 {
+			as.ScaleUpUtilization = defaultAutoScalingScaleUpUtilization
+		}
+		if as.ScaleDownUtilization == 0 {
+			as.ScaleDownUtilization = defaultAutoScalingScaleDownUtilization
+		}
+		if as.Timeout <= 0 {
+			as.Timeout = defaultAutoScalingTimeout
+		}
+	}
+	if c.DeduplicationWindow > 0 {
+		if c.DeduplicationSize == 0 {
+			c.DeduplicationSize = defaultDeduplicationSize
+		}
+		if c.IdempotencyKey == nil {
+			c.IdempotencyKey = contentIdempotencyKey
+		}
+	}
+	if c.OverflowPolicy == OverflowSpill && c.SpillDir == "" {
+		c.SpillDir = os.TempDir()
+	}
 	if c.Logger == nil {
-		c.Logger = &StdLogger{log.New(os.Stdout, "", log.LstdFlags)}
+		logger := &StdLogger{Logger: log.New(os.Stdout, "", log.LstdFlags), level: new(slog.LevelVar)}
+		if c.Verbose {
+			logger.level.Set(slog.LevelDebug)
+		}
+		c.Logger = logger
 	}
 	if c.BatchCount == 0 {
 		c.BatchCount = maxRecordsPerRequest
 	}
-	falseOrPanic(c.BatchCount > maxRecordsPerRequest, "kinesis: BatchCount exceeds 500")
 	if c.BatchSize == 0 {
-		c.BatchSize = maxRequestSize
+		c.BatchSize = c.requestSizeLimit()
 	}
-	falseOrPanic(c.BatchSize > maxRequestSize, "kinesis: BatchSize exceeds 5MiB")
 	if c.BacklogCount == 0 {
 		c.BacklogCount = maxRecordsPerRequest
 	}
 	if c.AggregateBatchCount == 0 {
 		c.AggregateBatchCount = maxAggregationCount
 	}
-	falseOrPanic(c.AggregateBatchCount > maxAggregationCount, "kinesis: AggregateBatchCount exceeds 4294967295")
 	if c.AggregateBatchSize == 0 {
-		c.AggregateBatchSize = defaultAggregationSize
+		// an aggregated record fits in a request
+		c.AggregateBatchSize = min(defaultAggregationSize, c.BatchSize)
 	}
-	falseOrPanic(c.AggregateBatchSize > maxAggregationSize, "kinesis: AggregateBatchSize exceeds 1MiB")
 	if c.MaxConnections == 0 {
 		c.MaxConnections = defaultMaxConnections
 	}
-	falseOrPanic(c.MaxConnections < 1 || c.MaxConnections > 256, "kinesis: MaxConnections must be between 1 and 256")
+	if c.ThrottleInterval == 0 {
+		c.ThrottleInterval = defaultThrottleInterval
+	}
+	if c.AggregationMaxDelay > 0 {
+		c.FlushInterval = c.AggregationMaxDelay
+	}
 	if c.FlushInterval == 0 {
 		c.FlushInterval = defaultFlushInterval
 	}
-	falseOrPanic(len(c.StreamName) == 0, "kinesis: StreamName length must be at least 1")
+	if c.MinFlushInterval == 0 {
+		c.MinFlushInterval = defaultMinFlushInterval
+	}
+	if c.MaxFlushInterval == 0 {
+		c.MaxFlushInterval = defaultMaxFlushInterval
+	}
+	if c.Clock == nil {
+		c.Clock = systemClock{}
+	}
 	if c.GetShards == nil {
 		c.GetShards = defaultGetShardsFunc
 	}
+	if c.Hasher == nil {
+		c.Hasher = NewCachedHasher(MD5Hasher{}, defaultHashCacheSize)
+	}
+	if c.Metrics == nil {
+		c.Metrics = &NopMetrics{}
+	}
+	if c.TracerProvider == nil {
+		c.TracerProvider = otel.GetTracerProvider()
+	}
+	if c.LargeRecordThreshold == 0 {
+		c.LargeRecordThreshold = c.recordSizeLimit()
+	}
+	if c.Backoff == nil {
+		c.Backoff = &FullJitterBackoff{}
+	}
+	if c.ErrorClassifier == nil {
+		c.ErrorClassifier = DefaultErrorClassifier
+	}
+	if c.Mirror != nil {
+		c.Mirror.defaults(c)
+	}
+	if c.Failover != nil {
+		c.Failover.defaults()
+	}
+	if c.Watchdog != nil {
+		c.Watchdog.defaults(c)
+	}
+	if c.ConnectionScaling != nil {
+		c.ConnectionScaling.defaults(c)
+	}
+	if c.DryRun != nil {
+		c.DryRun.defaults()
+	}
+	if c.Sampling != nil {
+		c.Sampling.defaults()
+	}
+	if c.Cost != nil {
+		c.Cost.defaults()
+	}
+}
+
+// Validate checks every constraint of the configuration, with the defaults applied as New
+// does, and returns all the problems found, so that they can be fixed at once. Nil if the
+// configuration is valid. The configuration is not modified.
+func (c *Config) Validate() []error {
+	check := c.clone()
+	check.defaults()
+	errs := check.problems()
+	if c.Client == nil && c.PutterFactory == nil && c.DryRun == nil {
+		errs = append(errs, errNoClient)
+	}
+	return errs
+}
+
+// errNoClient is reported by NewProducer and Validate. New does not require a Client, as
+// it did not before NewProducer was added, e.g. for a Producer that is never started
+var errNoClient = errors.New("kinesis: Client, PutterFactory or DryRun must be set")
+
+// clone returns a copy of the configuration whose sub-configurations, the pointers to
+// structs such as CircuitBreaker or Mirror, are copied too, so that applying the defaults
+// to the copy leaves the configuration untouched
+func (c *Config) clone() *Config {
+	clone := *c
+	v := reflect.ValueOf(&clone).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.Pointer || field.IsNil() || field.Elem().Kind() != reflect.Struct || !field.CanSet() {
+			continue
+		}
+		copied := reflect.New(field.Elem().Type())
+		copied.Elem().Set(field.Elem())
+		field.Set(copied)
+	}
+	return &clone
+}
+
+// validate checks the configuration after defaults have been applied and returns an
+// error describing the first invalid value
+func (c *Config) validate() error {
+	if errs := c.problems(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// problems checks the configuration after defaults have been applied and returns an error
+// for each invalid value
+func (c *Config) problems() []error {
+	var errs []error
+	if cb := c.CircuitBreaker; cb != nil {
+		if cb.ConsecutiveFailures < 0 {
+			errs = append(errs, errors.New("kinesis: CircuitBreaker.ConsecutiveFailures must not be negative"))
+		}
+		if cb.ErrorRate < 0 || cb.ErrorRate > 1 {
+			errs = append(errs, errors.New("kinesis: CircuitBreaker.ErrorRate must be between 0 and 1"))
+		}
+		if cb.ConsecutiveFailures == 0 && cb.ErrorRate == 0 {
+			errs = append(errs, errors.New("kinesis: CircuitBreaker requires ConsecutiveFailures or ErrorRate"))
+		}
+	}
+	if c.HotShardThreshold < 0 || c.HotShardThreshold > 1 {
+		errs = append(errs, errors.New("kinesis: HotShardThreshold must be between 0 and 1"))
+	}
+	if as := c.AutoScaling; as != nil {
+		if as.Client == nil {
+			errs = append(errs, errors.New("kinesis: AutoScaling.Client must be set"))
+		}
+		if as.MinShards < 1 || as.MaxShards < as.MinShards {
+			errs = append(errs, errors.New("kinesis: AutoScaling.MaxShards must be at least MinShards and MinShards at least 1"))
+		}
+		if as.ThrottleRate < 0 || as.ThrottleRate > 1 {
+			errs = append(errs, errors.New("kinesis: AutoScaling.ThrottleRate must be between 0 and 1"))
+		}
+		if as.ScaleUpUtilization < 0 || as.ScaleUpUtilization > 1 {
+			errs = append(errs, errors.New("kinesis: AutoScaling.ScaleUpUtilization must be between 0 and 1"))
+		}
+		if as.ScaleDownUtilization < 0 || as.ScaleDownUtilization >= as.ScaleUpUtilization {
+			errs = append(errs, errors.New("kinesis: AutoScaling.ScaleDownUtilization must be between 0 and ScaleUpUtilization"))
+		}
+		if c.Backend == BackendFirehose {
+			errs = append(errs, errors.New("kinesis: AutoScaling is not supported by BackendFirehose"))
+		}
+	}
+	if cs := c.CreateStream; cs != nil {
+		if cs.Client == nil {
+			errs = append(errs, errors.New("kinesis: CreateStream.Client must be set"))
+		}
+		if cs.ShardCount < 0 {
+			errs = append(errs, errors.New("kinesis: CreateStream.ShardCount must not be negative"))
+		}
+		if len(c.StreamARN) > 0 {
+			errs = append(errs, errors.New("kinesis: CreateStream requires StreamName"))
+		}
+		if c.Backend == BackendFirehose {
+			errs = append(errs, errors.New("kinesis: CreateStream is not supported by BackendFirehose"))
+		}
+	}
+	if e := c.Envelope; e != nil && e.Format != envelope.JSON && e.Format != envelope.Protobuf {
+		errs = append(errs, errors.New("kinesis: unknown Envelope.Format"))
+	}
+	if c.WireVersion < 0 || c.WireVersion > int(wire.LatestVersion) {
+		errs = append(errs, errors.New("kinesis: unsupported WireVersion"))
+	}
+	if e := c.Envelope; e != nil && e.Checksum != 0 && e.Checksum != envelope.CRC32C && e.Checksum != envelope.SHA256 {
+		errs = append(errs, errors.New("kinesis: unknown Envelope.Checksum"))
+	}
+	if m := c.Mirror; m != nil {
+		if m.Client == nil {
+			errs = append(errs, errors.New("kinesis: Mirror.Client must be set"))
+		}
+		if m.BacklogCount < 0 {
+			errs = append(errs, errors.New("kinesis: Mirror.BacklogCount must not be negative"))
+		}
+		if m.MaxConnections < 0 {
+			errs = append(errs, errors.New("kinesis: Mirror.MaxConnections must not be negative"))
+		}
+		if m.MaxRetries < 0 {
+			errs = append(errs, errors.New("kinesis: Mirror.MaxRetries must not be negative"))
+		}
+	}
+	if f := c.Failover; f != nil {
+		if f.Client == nil {
+			errs = append(errs, errors.New("kinesis: Failover.Client must be set"))
+		}
+		if f.ErrorRate < 0 || f.ErrorRate > 1 {
+			errs = append(errs, errors.New("kinesis: Failover.ErrorRate must be between 0 and 1"))
+		}
+	}
+	if c.Watchdog != nil && c.Watchdog.Threshold < 0 {
+		errs = append(errs, errors.New("kinesis: Watchdog.Threshold must not be negative"))
+	}
+	if c.Sampling != nil && c.Sampling.Rate != SampleNone && (c.Sampling.Rate < 0 || c.Sampling.Rate > 1) {
+		errs = append(errs, errors.New("kinesis: Sampling.Rate must be between 0 and 1, or SampleNone"))
+	}
+	if cost := c.Cost; cost != nil {
+		if cost.PayloadUnitPrice < 0 || cost.GBPrice < 0 {
+			errs = append(errs, errors.New("kinesis: Cost prices must not be negative"))
+		}
+		if cost.Budget != nil && (cost.Budget.Records < 0 || cost.Budget.Bytes < 0) {
+			errs = append(errs, errors.New("kinesis: Cost.Budget must not be negative"))
+		}
+	}
+	if cs := c.ConnectionScaling; cs != nil {
+		if cs.MinConnections < 1 || cs.MinConnections > cs.MaxConnections || cs.MaxConnections > 256 {
+			errs = append(errs, errors.New("kinesis: ConnectionScaling.MinConnections must be at least 1 and at most MaxConnections, at most 256"))
+		}
+		if cs.Interval < 0 {
+			errs = append(errs, errors.New("kinesis: ConnectionScaling.Interval must not be negative"))
+		}
+		if cs.LatencyThreshold < 0 {
+			errs = append(errs, errors.New("kinesis: ConnectionScaling.LatencyThreshold must not be negative"))
+		}
+		if c.AutoTune != nil {
+			errs = append(errs, errors.New("kinesis: ConnectionScaling is not supported with AutoTune"))
+		}
+	}
+	// the data of these records is no longer a JSON document
+	if c.AggregationFormat == NDJSON && (c.Envelope != nil || c.Compression != nil || c.Encryptor != nil || c.MaxChunkSize > 0 || c.LargeRecordStore != nil) {
+		errs = append(errs, errors.New("kinesis: NDJSON is not supported with Envelope, Compression, Encryptor, MaxChunkSize or LargeRecordStore"))
+	}
+	if c.AutoTune != nil && c.Backend == BackendFirehose {
+		errs = append(errs, errors.New("kinesis: AutoTune is not supported by BackendFirehose"))
+	}
+	if c.BatchCount < 0 {
+		errs = append(errs, errors.New("kinesis: BatchCount must not be negative"))
+	}
+	if c.BatchSize < 0 {
+		errs = append(errs, errors.New("kinesis: BatchSize must not be negative"))
+	}
+	if c.BacklogCount < 0 {
+		errs = append(errs, errors.New("kinesis: BacklogCount must not be negative"))
+	}
+	if c.AggregateBatchCount < 0 {
+		errs = append(errs, errors.New("kinesis: AggregateBatchCount must not be negative"))
+	}
+	if c.AggregateBatchSize < 0 {
+		errs = append(errs, errors.New("kinesis: AggregateBatchSize must not be negative"))
+	}
+	if c.BatchCount > maxRecordsPerRequest {
+		errs = append(errs, errors.New("kinesis: BatchCount exceeds 500"))
+	}
+	if c.Backend < BackendKinesis || c.Backend > BackendFirehose {
+		errs = append(errs, errors.New("kinesis: unknown Backend"))
+	}
+	if c.BatchSize > maxRequestSize {
+		errs = append(errs, errors.New("kinesis: BatchSize exceeds 5MiB"))
+	}
+	if c.Backend == BackendFirehose && c.BatchSize > firehoseMaxRequestSize {
+		errs = append(errs, errors.New("kinesis: BatchSize exceeds 4MiB"))
+	}
+	if c.AggregateBatchCount > maxAggregationCount {
+		errs = append(errs, errors.New("kinesis: AggregateBatchCount exceeds 4294967295"))
+	}
+	if c.AggregateBatchSize > maxAggregationSize {
+		errs = append(errs, errors.New("kinesis: AggregateBatchSize exceeds 1MiB"))
+	}
+	if c.AggregateBatchSize > c.BatchSize && c.BatchSize > 0 {
+		errs = append(errs, errors.New("kinesis: AggregateBatchSize exceeds BatchSize"))
+	}
+	if c.AggregatePayloadUnits < 0 || c.AggregatePayloadUnits > maxPayloadUnits {
+		errs = append(errs, errors.New("kinesis: AggregatePayloadUnits must be between 0 and 40"))
+	}
+	if c.MaxJSONSize < 0 {
+		errs = append(errs, errors.New("kinesis: MaxJSONSize must not be negative"))
+	}
+	if c.DeduplicationWindow < 0 {
+		errs = append(errs, errors.New("kinesis: DeduplicationWindow must not be negative"))
+	}
+	if c.DeduplicationSize < 0 {
+		errs = append(errs, errors.New("kinesis: DeduplicationSize must not be negative"))
+	}
+	if c.StickyPartitionInterval < 0 {
+		errs = append(errs, errors.New("kinesis: StickyPartitionInterval must not be negative"))
+	}
+	if c.StickyPartitionInterval > 0 && !c.AutoPartitionKey {
+		errs = append(errs, errors.New("kinesis: StickyPartitionInterval requires AutoPartitionKey"))
+	}
+	if c.MaxConnections < 1 || c.MaxConnections > 256 {
+		errs = append(errs, errors.New("kinesis: MaxConnections must be between 1 and 256"))
+	}
+	if c.MaxChunkSize != 0 && (c.MaxChunkSize < minChunkSize || c.MaxChunkSize > c.recordSizeLimit()) {
+		errs = append(errs, errors.New("kinesis: MaxChunkSize must be between 1KiB and the maximum record size"))
+	}
+	if c.LargeRecordThreshold < 0 || c.LargeRecordThreshold > c.recordSizeLimit() {
+		errs = append(errs, errors.New("kinesis: LargeRecordThreshold must be between 0 and the maximum record size"))
+	}
+	if c.ThrottleInterval < 0 {
+		errs = append(errs, errors.New("kinesis: ThrottleInterval must not be negative"))
+	}
+	if c.AggregationMaxDelay < 0 {
+		errs = append(errs, errors.New("kinesis: AggregationMaxDelay must not be negative"))
+	}
+	if c.RequestMaxDelay < 0 {
+		errs = append(errs, errors.New("kinesis: RequestMaxDelay must not be negative"))
+	}
+	if c.AdaptiveFlush && (c.MinFlushInterval < 0 || c.MinFlushInterval > c.MaxFlushInterval) {
+		errs = append(errs, errors.New("kinesis: MinFlushInterval must be between 0 and MaxFlushInterval"))
+	}
+	if c.CompressionThreshold < 0 {
+		errs = append(errs, errors.New("kinesis: CompressionThreshold must not be negative"))
+	}
+	if c.RequestTimeout < 0 {
+		errs = append(errs, errors.New("kinesis: RequestTimeout must not be negative"))
+	}
+	if c.MaxRetries < 0 {
+		errs = append(errs, errors.New("kinesis: MaxRetries must not be negative"))
+	}
+	if c.MaxRecordAge < 0 {
+		errs = append(errs, errors.New("kinesis: MaxRecordAge must not be negative"))
+	}
+	if c.ShardConcurrency < 0 {
+		errs = append(errs, errors.New("kinesis: ShardConcurrency must not be negative"))
+	}
+	if c.ShardConcurrency > 0 && c.OrderedDelivery {
+		errs = append(errs, errors.New("kinesis: ShardConcurrency can not be used with OrderedDelivery"))
+	}
+	if c.MaxBufferedBytes < 0 {
+		errs = append(errs, errors.New("kinesis: MaxBufferedBytes must not be negative"))
+	}
+	if c.OverflowPolicy < OverflowBlock || c.OverflowPolicy > OverflowSpill {
+		errs = append(errs, errors.New("kinesis: unknown OverflowPolicy"))
+	}
+	if c.RateLimitHeadroom < 0 || c.RateLimitHeadroom > 99 {
+		errs = append(errs, errors.New("kinesis: RateLimitHeadroom must be between 0 and 99"))
+	}
+	if len(c.StreamName) == 0 && len(c.StreamARN) == 0 {
+		errs = append(errs, errors.New("kinesis: StreamName length must be at least 1"))
+	}
+	if len(c.StreamName) > 0 && len(c.StreamARN) > 0 {
+		errs = append(errs, errors.New("kinesis: only one of StreamName and StreamARN can be set"))
+	}
+	if len(c.StreamARN) > 0 && !isStreamARN(c.StreamARN) {
+		errs = append(errs, errors.New("kinesis: invalid StreamARN"))
+	}
+	if len(c.StreamARN) > 0 && c.Backend == BackendFirehose {
+		errs = append(errs, errors.New("kinesis: StreamARN is not supported by BackendFirehose"))
+	}
+	if c.PutterRefreshInterval < 0 {
+		errs = append(errs, errors.New("kinesis: PutterRefreshInterval must not be negative"))
+	}
+	for _, middleware := range c.Middlewares {
+		if middleware == nil {
+			errs = append(errs, errors.New("kinesis: Middlewares must not be nil"))
+			break
+		}
+	}
+	for _, interceptor := range c.Interceptors {
+		if interceptor == nil {
+			errs = append(errs, errors.New("kinesis: Interceptors must not be nil"))
+			break
+		}
+	}
+	return errs
+}
+
+// recordSizeLimit returns the maximum size of a single record of the backend
+func (c *Config) recordSizeLimit() int {
+	if c.Backend == BackendFirehose {
+		return firehoseMaxRecordSize
+	}
+	return maxRecordSize
 }
 
-func falseOrPanic(p bool, msg string) {
-	if p {
-		panic(msg)
+// requestSizeLimit returns the maximum size of a request of the backend
+func (c *Config) requestSizeLimit() int {
+	if c.Backend == BackendFirehose {
+		return firehoseMaxRequestSize
 	}
+	return maxRequestSize
 }
@@ -0,0 +1,120 @@
+package producer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultConnectionScalingInterval = 10 * time.Second
+	defaultConnectionScalingLatency  = time.Second
+	maxConnections                   = 256
+)
+
+// ConnectionScalingConfig configures the controller that scales the number of concurrent
+// PutRecords requests, MaxConnections, to the load of the producer, see
+// Config.ConnectionScaling. Every Interval, connections are doubled while requests wait
+// for a connection and the backlog grows, as long as the mean latency of the requests
+// stays below LatencyThreshold. They are cut by a quarter when the latency exceeds it,
+// since Kinesis or the network is then the bottleneck, and removed one at a time while
+// fewer than half are in use.
+type ConnectionScalingConfig struct {
+	// MinConnections is the least number of connections. Default to 1.
+	MinConnections int
+
+	// MaxConnections is the largest number of connections. Default to 256.
+	MaxConnections int
+
+	// Interval is the period the load is measured over. Default to 10s.
+	Interval time.Duration
+
+	// LatencyThreshold is the mean latency of the requests above which connections are
+	// removed rather than added. Default to 1s.
+	LatencyThreshold time.Duration
+}
+
+// defaults applies the defaults and clamps Config.MaxConnections, the initial number of
+// connections, between MinConnections and MaxConnections
+func (c *ConnectionScalingConfig) defaults(config *Config) {
+	if c.MinConnections == 0 {
+		c.MinConnections = 1
+	}
+	if c.MaxConnections == 0 {
+		c.MaxConnections = maxConnections
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultConnectionScalingInterval
+	}
+	if c.LatencyThreshold == 0 {
+		c.LatencyThreshold = defaultConnectionScalingLatency
+	}
+	if c.MinConnections <= c.MaxConnections {
+		config.MaxConnections = min(max(config.MaxConnections, c.MinConnections), c.MaxConnections)
+	}
+}
+
+// connectionScaler scales the connections of the worker pool, see
+// ConnectionScalingConfig
+type connectionScaler struct {
+	config ConnectionScalingConfig
+	stats  *stats
+	// depth returns the number of Puts and records in the backlog
+	depth func() int
+	// counters at the last step
+	lastDepth         int
+	requests, latency int64
+}
+
+func newConnectionScaler(config *Config, stats *stats, depth func() int) *connectionScaler {
+	if config.ConnectionScaling == nil {
+		return nil
+	}
+	return &connectionScaler{
+		config: *config.ConnectionScaling,
+		stats:  stats,
+		depth:  depth,
+	}
+}
+
+// step measures the load since the last step and returns the number of connections to
+// use instead of current
+func (s *connectionScaler) step(current int) int {
+	var (
+		requests = atomic.LoadInt64(&s.stats.requests)
+		latency  = atomic.LoadInt64(&s.stats.latency)
+		pending  = int(atomic.LoadInt64(&s.stats.pending))
+		inflight = int(atomic.LoadInt64(&s.stats.inflight))
+		// requests waiting for a connection count towards the backlog
+		depth = s.depth() + pending
+		grew  = depth > 0 && depth >= s.lastDepth
+		mean  time.Duration
+	)
+	if n := requests - s.requests; n > 0 {
+		mean = time.Duration((latency - s.latency) / n)
+	}
+	s.requests, s.latency, s.lastDepth = requests, latency, depth
+
+	next := current
+	switch {
+	case mean > s.config.LatencyThreshold:
+		next = current - max(current/4, 1)
+	case grew && pending > 0:
+		next = 2 * current
+	case pending == 0 && inflight < current/2:
+		next = current - 1
+	}
+	return min(max(next, s.config.MinConnections), s.config.MaxConnections)
+}
+
+// scaleConnections runs a step of the connection scaler and reconfigures the worker pool
+// if the number of connections changed. Called from the main loop.
+func (p *Producer) scaleConnections() {
+	n := p.connections.step(p.MaxConnections)
+	if n == p.MaxConnections {
+		return
+	}
+	p.Logger.Info("connection scaling", LogValue{"from", p.MaxConnections}, LogValue{"to", n})
+	update := settingsOf(p.Config)
+	update.maxConnections = n
+	p.applyUpdate(update)
+}
@@ -0,0 +1,131 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionScalerStep(t *testing.T) {
+	config := &Config{
+		StreamName:        "foo",
+		ConnectionScaling: &ConnectionScalingConfig{MinConnections: 2, MaxConnections: 16},
+	}
+	config.defaults()
+	var (
+		s     = &stats{}
+		depth int
+	)
+	scaler := newConnectionScaler(config, s, func() int { return depth })
+
+	// requests wait for a connection while the backlog grows
+	s.pending, s.inflight, depth = 3, 4, 10
+	s.requests, s.latency = 10, int64(10*100*time.Millisecond)
+	require.Equal(t, 8, scaler.step(4))
+	depth = 20
+	s.requests, s.latency = 20, int64(20*100*time.Millisecond)
+	require.Equal(t, 16, scaler.step(8))
+	require.Equal(t, 16, scaler.step(16), "connections are capped at MaxConnections")
+
+	// slow requests remove connections
+	s.requests, s.latency = 30, s.latency+int64(10*2*time.Second)
+	require.Equal(t, 12, scaler.step(16))
+
+	// idle connections are removed one at a time
+	s.pending, s.inflight, depth = 0, 1, 0
+	require.Equal(t, 11, scaler.step(12))
+	require.Equal(t, 2, scaler.step(2), "connections are kept at MinConnections")
+
+	// busy connections are kept
+	s.inflight = 2
+	require.Equal(t, 3, scaler.step(3))
+}
+
+func TestConnectionScalingConfig(t *testing.T) {
+	config := &Config{
+		StreamName:        "foo",
+		Client:            &clientMock{},
+		MaxConnections:    100,
+		ConnectionScaling: &ConnectionScalingConfig{MaxConnections: 32},
+	}
+	config.defaults()
+	require.NoError(t, config.validate())
+	require.Equal(t, 32, config.MaxConnections, "initial connections should be clamped")
+	require.Equal(t, 1, config.ConnectionScaling.MinConnections)
+	require.Equal(t, defaultConnectionScalingInterval, config.ConnectionScaling.Interval)
+
+	config = &Config{
+		StreamName:        "foo",
+		Client:            &clientMock{},
+		ConnectionScaling: &ConnectionScalingConfig{MinConnections: 8, MaxConnections: 4},
+	}
+	config.defaults()
+	require.EqualError(t, config.validate(), "kinesis: ConnectionScaling.MinConnections must be at least 1 and at most MaxConnections, at most 256")
+
+	config = &Config{
+		StreamName:        "foo",
+		Client:            &clientMock{},
+		AutoTune:          &mockStreamDescriber{},
+		ConnectionScaling: &ConnectionScalingConfig{},
+	}
+	config.defaults()
+	require.EqualError(t, config.validate(), "kinesis: ConnectionScaling is not supported with AutoTune")
+}
+
+func TestConnectionScaling(t *testing.T) {
+	client := &clientMock{incoming: make(map[int][]string)}
+	p := New(&Config{
+		StreamName:        "foo",
+		MaxConnections:    4,
+		FlushInterval:     time.Hour,
+		ConnectionScaling: &ConnectionScalingConfig{MinConnections: 1, MaxConnections: 4, Interval: 10 * time.Millisecond},
+		Logger:            &NopLogger{},
+		Client:            client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// an idle producer drops to MinConnections
+	require.Eventually(t, func() bool {
+		return p.Stats().Connections == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConnectionScalingUpdateConfig(t *testing.T) {
+	client := &clientMock{incoming: make(map[int][]string)}
+	p := New(&Config{
+		StreamName:        "foo",
+		MaxConnections:    4,
+		FlushInterval:     time.Hour,
+		ConnectionScaling: &ConnectionScalingConfig{MinConnections: 1, MaxConnections: 4, Interval: time.Millisecond},
+		Logger:            &NopLogger{},
+		Client:            client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// UpdateConfig runs alongside the scaler
+	for i := 0; i < 50; i++ {
+		require.NoError(t, p.UpdateConfig(func(c *Config) {
+			c.BatchCount = 100 + i
+		}))
+	}
+	require.Eventually(t, func() bool {
+		return p.Stats().Connections == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// settings left unchanged keep the connections of the scaler
+	require.NoError(t, p.UpdateConfig(func(c *Config) {
+		c.BatchCount = 10
+	}))
+	require.Equal(t, 1, p.Stats().Connections)
+
+	// the scaler carries on from the connections set by UpdateConfig
+	require.NoError(t, p.UpdateConfig(func(c *Config) {
+		c.MaxConnections = 3
+	}))
+	require.Eventually(t, func() bool {
+		return p.Stats().Connections == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,197 @@
+package producer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultPayloadUnitPrice is the price in USD of a million PUT payload units of
+	// provisioned streams in us-east-1, at the time of writing
+	defaultPayloadUnitPrice = 0.014
+	// budgetWindow is the period of a Budget, the billing period of Kinesis
+	budgetWindow = time.Hour
+)
+
+// CostConfig prices the records delivered by the Producer to estimate what it costs in
+// real time, see Config.Cost and Stats.Cost. Prices are in USD. The defaults are those of
+// us-east-1 at the time of writing; set the prices of your region and capacity mode. Shard
+// hours and on-demand stream hours are not included, they do not depend on the records.
+type CostConfig struct {
+	// PayloadUnitPrice is the price of a million 25KiB PUT payload units, billed by
+	// provisioned streams. Default to 0.014, or 0 if GBPrice is set.
+	PayloadUnitPrice float64
+
+	// GBPrice is the price of a GiB of data ingested by on-demand streams, which round
+	// each record up to 1KiB. Default to 0, the stream is provisioned.
+	GBPrice float64
+
+	// Budget limits the user records put per hour. Default to nil, unlimited.
+	Budget *Budget
+}
+
+func (c *CostConfig) defaults() {
+	// on-demand streams are not billed payload units
+	if c.PayloadUnitPrice == 0 && c.GBPrice == 0 {
+		c.PayloadUnitPrice = defaultPayloadUnitPrice
+	}
+}
+
+// Budget limits the user records accepted by Put in each hour of the clock, the billing
+// period of Kinesis, see CostConfig.Budget. Once a limit is exceeded, OnExceeded is called
+// and a warning is logged, and with Throttle the Puts wait for the next hour.
+type Budget struct {
+	// Records is the number of user records per hour. Zero is unlimited.
+	Records int64
+
+	// Bytes is the size of the user records per hour, including their partition keys. Zero
+	// is unlimited.
+	Bytes int64
+
+	// Throttle holds back the Puts over the budget until the next hour. Put waits, and
+	// TryPut, or Put with the OverflowError policy, returns *ErrThrottled. A record larger
+	// than Bytes would never fit, Put returns *ErrOverBudget for it right away. Default to
+	// false, the records are put anyway.
+	Throttle bool
+
+	// OnExceeded is called once per hour, when the budget of the hour is exceeded, e.g. to
+	// alert. It is called from Put and must return quickly. Default to nil.
+	OnExceeded func(BudgetExceeded)
+}
+
+// BudgetExceeded is passed to Budget.OnExceeded
+type BudgetExceeded struct {
+	// Hour is the start of the hour whose budget is exceeded
+	Hour time.Time
+	// Records and Bytes are the user records accepted in the hour and their size, without
+	// the record exceeding the budget
+	Records int64
+	Bytes   int64
+}
+
+// CostStats are the estimated costs of the Producer, see Stats.Cost
+type CostStats struct {
+	// Estimated is the estimated cost in USD of the records delivered since the Producer
+	// was created
+	Estimated float64
+	// IngestedBytes is the size of the records delivered, each rounded up to 1KiB, as
+	// billed by on-demand streams
+	IngestedBytes int64
+	// HourRecords and HourBytes are the user records accepted by Put in the current hour
+	// of the Budget and their size. Zero without a Budget
+	HourRecords int64
+	HourBytes   int64
+	// OverBudget reports whether the Budget of the current hour is exceeded
+	OverBudget bool
+}
+
+// budget counts the user records of the current hour against a Budget
+type budget struct {
+	config Budget
+
+	mu      sync.Mutex
+	hour    time.Time
+	records int64
+	bytes   int64
+	// exceeded is set once the budget of the hour is exceeded
+	exceeded bool
+}
+
+func newBudget(config *Config) *budget {
+	if config.Cost == nil || config.Cost.Budget == nil {
+		return nil
+	}
+	return &budget{config: *config.Cost.Budget}
+}
+
+// admit counts a user record of size bytes put at now. If the record exceeds the budget
+// it returns the notice of the first record exceeding it in the hour, and with Throttle,
+// the time to wait for the next hour instead of counting the record.
+func (b *budget) admit(size int, now time.Time) (time.Duration, *BudgetExceeded) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if hour := now.Truncate(budgetWindow); !hour.Equal(b.hour) {
+		b.hour, b.records, b.bytes, b.exceeded = hour, 0, 0, false
+	}
+	over := (b.config.Records > 0 && b.records+1 > b.config.Records) ||
+		(b.config.Bytes > 0 && b.bytes+int64(size) > b.config.Bytes)
+	var notice *BudgetExceeded
+	if over && !b.exceeded {
+		b.exceeded = true
+		notice = &BudgetExceeded{Hour: b.hour, Records: b.records, Bytes: b.bytes}
+	}
+	if over && b.config.Throttle {
+		return b.hour.Add(budgetWindow).Sub(now), notice
+	}
+	b.records++
+	b.bytes += int64(size)
+	return 0, notice
+}
+
+// stats fills the counters of the current hour
+func (b *budget) stats(s *CostStats, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Truncate(budgetWindow).Equal(b.hour) {
+		s.HourRecords, s.HourBytes, s.OverBudget = b.records, b.bytes, b.exceeded
+	}
+}
+
+// budgeted counts the user record against the Budget. Over the budget with Throttle, it
+// waits for the next hour, or returns *ErrThrottled with the OverflowError policy, and
+// *ErrOverBudget for a record larger than the hourly Bytes.
+func (p *Producer) budgeted(userRecord UserRecord, policy OverflowPolicy) error {
+	if p.budget == nil {
+		return nil
+	}
+	size := userRecord.Size() + len(userRecord.PartitionKey())
+	if limit := p.budget.config.Bytes; p.budget.config.Throttle && limit > 0 && int64(size) > limit {
+		return &ErrOverBudget{UserRecord: unwrapUserRecord(userRecord), Bytes: limit}
+	}
+	for {
+		wait, notice := p.budget.admit(size, p.Clock.Now())
+		if notice != nil {
+			p.Logger.Warn("budget exceeded",
+				LogValue{"hour", notice.Hour},
+				LogValue{"records", notice.Records},
+				LogValue{"bytes", notice.Bytes},
+			)
+			if p.budget.config.OnExceeded != nil {
+				p.budget.config.OnExceeded(*notice)
+			}
+		}
+		if wait <= 0 {
+			return nil
+		}
+		if policy == OverflowError {
+			return &ErrThrottled{unwrapUserRecord(userRecord)}
+		}
+		timer := p.Clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-p.stopped:
+			timer.Stop()
+			return &ErrStoppedProducer{unwrapUserRecord(userRecord)}
+		}
+	}
+}
+
+// costStats estimates the costs of the records delivered. Nil unless Config.Cost is set
+func (p *Producer) costStats() *CostStats {
+	if p.Cost == nil {
+		return nil
+	}
+	var (
+		units    = atomic.LoadInt64(&p.pool.stats.units)
+		ingested = atomic.LoadInt64(&p.pool.stats.ingested)
+	)
+	stats := &CostStats{
+		Estimated:     float64(units)*p.Cost.PayloadUnitPrice/1e6 + float64(ingested)*p.Cost.GBPrice/(1<<30),
+		IngestedBytes: ingested,
+	}
+	if p.budget != nil {
+		p.budget.stats(stats, p.Clock.Now())
+	}
+	return stats
+}
@@ -0,0 +1,137 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	hour := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	b := newBudget(&Config{Cost: &CostConfig{Budget: &Budget{Records: 2, Bytes: 100}}})
+
+	wait, notice := b.admit(10, hour.Add(time.Minute))
+	require.Zero(t, wait)
+	require.Nil(t, notice)
+	_, notice = b.admit(10, hour.Add(2*time.Minute))
+	require.Nil(t, notice)
+
+	// records over the budget are put anyway without Throttle, and reported once
+	wait, notice = b.admit(10, hour.Add(3*time.Minute))
+	require.Zero(t, wait)
+	require.Equal(t, &BudgetExceeded{Hour: hour, Records: 2, Bytes: 20}, notice)
+	_, notice = b.admit(10, hour.Add(4*time.Minute))
+	require.Nil(t, notice)
+
+	var stats CostStats
+	b.stats(&stats, hour.Add(5*time.Minute))
+	require.Equal(t, CostStats{HourRecords: 4, HourBytes: 40, OverBudget: true}, stats)
+
+	// the budget is renewed every hour
+	wait, notice = b.admit(200, hour.Add(time.Hour))
+	require.Zero(t, wait)
+	require.Equal(t, &BudgetExceeded{Hour: hour.Add(time.Hour), Records: 0, Bytes: 0}, notice)
+
+	// with Throttle, records over the budget wait for the next hour
+	b = newBudget(&Config{Cost: &CostConfig{Budget: &Budget{Bytes: 100, Throttle: true}}})
+	_, notice = b.admit(60, hour)
+	require.Nil(t, notice)
+	wait, notice = b.admit(60, hour.Add(45*time.Minute))
+	require.Equal(t, 15*time.Minute, wait)
+	require.NotNil(t, notice)
+	wait, _ = b.admit(40, hour.Add(50*time.Minute))
+	require.Zero(t, wait)
+	stats = CostStats{}
+	b.stats(&stats, hour.Add(50*time.Minute))
+	require.Equal(t, CostStats{HourRecords: 2, HourBytes: 100, OverBudget: true}, stats)
+
+	c := &Config{StreamName: "foo", Client: &clientMock{}, Cost: &CostConfig{GBPrice: -1, Budget: &Budget{Records: -1}}}
+	require.Equal(t, []error{
+		errors.New("kinesis: Cost prices must not be negative"),
+		errors.New("kinesis: Cost.Budget must not be negative"),
+	}, c.Validate())
+}
+
+func TestCost(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+				},
+			}},
+		},
+	}
+	var exceeded []BudgetExceeded
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Cost: &CostConfig{
+			GBPrice: 0.08,
+			Budget: &Budget{
+				Records:  1,
+				Throttle: true,
+				OnExceeded: func(e BudgetExceeded) {
+					exceeded = append(exceeded, e)
+				},
+			},
+		},
+	})
+	require.Nil(t, New(&Config{StreamName: "foo", Logger: &NopLogger{}, Client: client}).Stats().Cost)
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put(make([]byte, 2000), "foo", WithoutAggregation()))
+	require.IsType(t, &ErrThrottled{}, p.TryPut([]byte("hello"), "foo"))
+	require.Len(t, exceeded, 1)
+	require.Equal(t, int64(1), exceeded[0].Records)
+	require.NoError(t, p.Flush(context.Background()))
+
+	stats := p.Stats().Cost
+	require.Equal(t, int64(1), stats.HourRecords)
+	require.True(t, stats.OverBudget)
+	// the record of 2003 bytes is billed as 2KiB on-demand and one payload unit
+	require.Equal(t, int64(2048), stats.IngestedBytes)
+	// on-demand streams are not billed payload units
+	require.InDelta(t, 2048*0.08/(1<<30), stats.Estimated, 1e-15)
+}
+
+func TestCostDefaults(t *testing.T) {
+	provisioned := &CostConfig{}
+	provisioned.defaults()
+	require.Equal(t, defaultPayloadUnitPrice, provisioned.PayloadUnitPrice)
+
+	onDemand := &CostConfig{GBPrice: 0.08}
+	onDemand.defaults()
+	require.Zero(t, onDemand.PayloadUnitPrice)
+}
+
+func TestBudgetRecordTooLarge(t *testing.T) {
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         &clientMock{incoming: make(map[int][]string)},
+		Cost:           &CostConfig{Budget: &Budget{Bytes: 100, Throttle: true}},
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the record would wait for an hour that never comes
+	err := p.Put(make([]byte, 100), "foo")
+	require.IsType(t, &ErrOverBudget{}, err)
+	require.EqualError(t, err, "Unable to Put record. Record of 103 bytes exceeds the budget of 100 bytes per hour")
+	require.Zero(t, p.Stats().Cost.HourBytes)
+}
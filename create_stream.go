@@ -0,0 +1,109 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	defaultCreateStreamTimeout      = 5 * time.Minute
+	defaultCreateStreamPollInterval = time.Second
+)
+
+// StreamCreator is the interface that wraps the Kinesis API methods used to create a
+// stream. *kinesis.Client implements it.
+type StreamCreator interface {
+	StreamDescriber
+	CreateStream(ctx context.Context, params *k.CreateStreamInput, optFns ...func(*k.Options)) (*k.CreateStreamOutput, error)
+}
+
+// CreateStreamConfig configures the creation of a stream that does not exist, see
+// EnsureStream.
+type CreateStreamConfig struct {
+	// Client calls DescribeStreamSummary and CreateStream. Required.
+	Client StreamCreator
+
+	// ShardCount is the number of shards of a provisioned stream. Default to 0, the stream
+	// is created in on-demand mode.
+	ShardCount int
+
+	// Timeout is how long to wait for the stream to become active. Default to 5m.
+	Timeout time.Duration
+
+	// PollInterval is the interval between two DescribeStreamSummary calls while waiting.
+	// Default to 1s.
+	PollInterval time.Duration
+}
+
+func (c *CreateStreamConfig) defaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultCreateStreamTimeout
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultCreateStreamPollInterval
+	}
+}
+
+// EnsureStream creates the stream streamName if it does not exist and waits until it is
+// active, e.g. in tests or ephemeral environments. It returns an error if ctx is done or
+// config.Timeout elapses before the stream is active.
+func EnsureStream(ctx context.Context, streamName string, config CreateStreamConfig) error {
+	config.defaults()
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	created := false
+	for {
+		out, err := config.Client.DescribeStreamSummary(ctx, &k.DescribeStreamSummaryInput{
+			StreamName: aws.String(streamName),
+		})
+		var notFound *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFound) && !created:
+			if err := createStream(ctx, streamName, config); err != nil {
+				return err
+			}
+			created = true
+		case errors.As(err, &notFound):
+			// the stream is not visible yet
+		case err != nil:
+			return fmt.Errorf("kinesis: describe stream %s: %w", streamName, err)
+		case out.StreamDescriptionSummary.StreamStatus == types.StreamStatusActive:
+			return nil
+		case out.StreamDescriptionSummary.StreamStatus == types.StreamStatusDeleting:
+			return fmt.Errorf("kinesis: stream %s is being deleted", streamName)
+		}
+
+		timer := time.NewTimer(config.PollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("kinesis: waiting for stream %s to become active: %w", streamName, ctx.Err())
+		}
+	}
+}
+
+// createStream creates the stream. A stream created concurrently by another process is
+// not an error.
+func createStream(ctx context.Context, streamName string, config CreateStreamConfig) error {
+	input := &k.CreateStreamInput{StreamName: aws.String(streamName)}
+	if config.ShardCount > 0 {
+		input.ShardCount = aws.Int32(int32(config.ShardCount))
+		input.StreamModeDetails = &types.StreamModeDetails{StreamMode: types.StreamModeProvisioned}
+	} else {
+		input.StreamModeDetails = &types.StreamModeDetails{StreamMode: types.StreamModeOnDemand}
+	}
+	_, err := config.Client.CreateStream(ctx, input)
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("kinesis: create stream %s: %w", streamName, err)
+	}
+	return nil
+}
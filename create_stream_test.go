@@ -0,0 +1,124 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+// mockStreamCreator creates a stream that becomes active after the given number of
+// DescribeStreamSummary calls
+type mockStreamCreator struct {
+	exists    bool
+	creating  int
+	createErr error
+	created   []*k.CreateStreamInput
+}
+
+func (m *mockStreamCreator) DescribeStreamSummary(ctx context.Context, params *k.DescribeStreamSummaryInput, optFns ...func(*k.Options)) (*k.DescribeStreamSummaryOutput, error) {
+	if !m.exists {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	status := types.StreamStatusActive
+	if m.creating > 0 {
+		m.creating--
+		status = types.StreamStatusCreating
+	}
+	return &k.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &types.StreamDescriptionSummary{
+			StreamName:   params.StreamName,
+			StreamStatus: status,
+		},
+	}, nil
+}
+
+func (m *mockStreamCreator) CreateStream(ctx context.Context, params *k.CreateStreamInput, optFns ...func(*k.Options)) (*k.CreateStreamOutput, error) {
+	m.created = append(m.created, params)
+	m.exists = true
+	return &k.CreateStreamOutput{}, m.createErr
+}
+
+func TestEnsureStream(t *testing.T) {
+	t.Run("creates a provisioned stream and waits until it is active", func(t *testing.T) {
+		client := &mockStreamCreator{creating: 2}
+		err := EnsureStream(context.Background(), "foo", CreateStreamConfig{
+			Client:       client,
+			ShardCount:   2,
+			PollInterval: time.Millisecond,
+		})
+		require.NoError(t, err)
+		require.Len(t, client.created, 1)
+		require.Equal(t, "foo", aws.ToString(client.created[0].StreamName))
+		require.Equal(t, int32(2), aws.ToInt32(client.created[0].ShardCount))
+		require.Equal(t, types.StreamModeProvisioned, client.created[0].StreamModeDetails.StreamMode)
+		require.Zero(t, client.creating)
+	})
+
+	t.Run("creates an on-demand stream", func(t *testing.T) {
+		client := &mockStreamCreator{}
+		require.NoError(t, EnsureStream(context.Background(), "foo", CreateStreamConfig{Client: client, PollInterval: time.Millisecond}))
+		require.Len(t, client.created, 1)
+		require.Nil(t, client.created[0].ShardCount)
+		require.Equal(t, types.StreamModeOnDemand, client.created[0].StreamModeDetails.StreamMode)
+	})
+
+	t.Run("waits for an existing stream", func(t *testing.T) {
+		client := &mockStreamCreator{exists: true, creating: 1}
+		require.NoError(t, EnsureStream(context.Background(), "foo", CreateStreamConfig{
+			Client:       client,
+			PollInterval: time.Millisecond,
+		}))
+		require.Empty(t, client.created)
+	})
+
+	t.Run("ignores a stream created concurrently", func(t *testing.T) {
+		client := &mockStreamCreator{createErr: &types.ResourceInUseException{}}
+		require.NoError(t, EnsureStream(context.Background(), "foo", CreateStreamConfig{Client: client, PollInterval: time.Millisecond}))
+	})
+
+	t.Run("returns create errors", func(t *testing.T) {
+		client := &mockStreamCreator{createErr: errors.New("denied")}
+		err := EnsureStream(context.Background(), "foo", CreateStreamConfig{Client: client})
+		require.EqualError(t, err, "kinesis: create stream foo: denied")
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		client := &mockStreamCreator{exists: true, creating: 1000}
+		err := EnsureStream(context.Background(), "foo", CreateStreamConfig{
+			Client:       client,
+			Timeout:      20 * time.Millisecond,
+			PollInterval: time.Millisecond,
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestNewProducerCreateStream(t *testing.T) {
+	client := &mockStreamCreator{creating: 1}
+	p, err := NewProducer(
+		WithStreamName("foo"),
+		WithClient(&clientMock{incoming: make(map[int][]string)}),
+		WithCreateStream(CreateStreamConfig{Client: client, ShardCount: 1, PollInterval: time.Millisecond}),
+		WithLogger(&NopLogger{}),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	require.Len(t, client.created, 1)
+	require.Zero(t, client.creating)
+
+	// New can not return the errors of creating the stream
+	require.PanicsWithError(t, "kinesis: CreateStream requires NewProducer", func() {
+		New(&Config{
+			StreamName:   "foo",
+			Client:       &clientMock{incoming: make(map[int][]string)},
+			CreateStream: &CreateStreamConfig{Client: client},
+			Logger:       &NopLogger{},
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter receives the records that could not be delivered to Kinesis: records that
+// failed with an unrecoverable error or were still failing after MaxRetries retries,
+// expired records, records dropped by the OverflowPolicy or that failed to aggregate, and
+// the records abandoned by Shutdown. Records canceled by their Put context, or dropped by
+// an interceptor or sampling, are not sent.
+//
+// Send is called synchronously by the worker sending the PutRecords request, or by the
+// Put or Shutdown dropping the records, so a slow DeadLetter delays them. Its context is
+// canceled after 30 seconds, and not by Abort or the context of Shutdown. Errors returned
+// by Send are logged and the records are lost.
+type DeadLetter interface {
+	Send(ctx context.Context, failure *FailureRecord) error
+}
+
+// deadLetterTimeout bounds DeadLetter.Send, whose context is not the one of the request
+// or Shutdown, which may be done by the time the records fail
+const deadLetterTimeout = 30 * time.Second
+
+// sendDeadLetter sends failure to the DeadLetter, if set
+func (c *Config) sendDeadLetter(failure *FailureRecord) {
+	if c.DeadLetter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+	if err := c.DeadLetter.Send(ctx, failure); err != nil {
+		c.Logger.Error(
+			"dead letter", err,
+			LogValue{"stream", failure.StreamName},
+			LogValue{"records", len(failure.UserRecords)},
+			LogValue{"attempt", failure.Attempts},
+		)
+	}
+}
+
+// DeadLetterRecord is the JSON representation of a single failed user record written by
+// EncodeDeadLetter.
+type DeadLetterRecord struct {
+	Time            time.Time `json:"time"`
+	Error           string    `json:"error"`
+	ErrorCode       string    `json:"errorCode,omitempty"`
+	Attempts        int       `json:"attempts"`
+	StreamName      string    `json:"streamName,omitempty"`
+	PartitionKey    string    `json:"partitionKey"`
+	ExplicitHashKey string    `json:"explicitHashKey,omitempty"`
+	// Data is base64 encoded in JSON
+	Data []byte `json:"data"`
+	// History are the failed attempts to put the record, see FailureRecord.History
+	History []Attempt `json:"history,omitempty"`
+}
+
+// NewDeadLetterRecords returns a DeadLetterRecord for each user record of failure.
+func NewDeadLetterRecords(failure *FailureRecord) []DeadLetterRecord {
+	now := time.Now().UTC()
+	records := make([]DeadLetterRecord, len(failure.UserRecords))
+	for i, userRecord := range failure.UserRecords {
+		records[i] = DeadLetterRecord{
+			Time:         now,
+			Error:        failure.Error(),
+			ErrorCode:    failure.ErrorCode,
+			Attempts:     failure.Attempts,
+			StreamName:   failure.StreamName,
+			PartitionKey: userRecord.PartitionKey(),
+			Data:         userRecord.Data(),
+			History:      failure.History,
+		}
+		if ehk := userRecord.ExplicitHashKey(); ehk != nil {
+			records[i].ExplicitHashKey = ehk.String()
+		}
+	}
+	return records
+}
+
+// EncodeDeadLetter writes the user records of failure to w as newline delimited JSON,
+// one DeadLetterRecord per line.
+func EncodeDeadLetter(w io.Writer, failure *FailureRecord) error {
+	enc := json.NewEncoder(w)
+	for _, record := range NewDeadLetterRecords(failure) {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileDeadLetter appends failed records to a local file as newline delimited JSON.
+type FileDeadLetter struct {
+	sync.Mutex
+	file *os.File
+}
+
+var _ DeadLetter = (*FileDeadLetter)(nil)
+
+// NewFileDeadLetter opens the file at path for appending, creating it if needed.
+func NewFileDeadLetter(path string) (*FileDeadLetter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetter{file: file}, nil
+}
+
+// Send appends the user records of failure to the file
+func (d *FileDeadLetter) Send(_ context.Context, failure *FailureRecord) error {
+	var buf bytes.Buffer
+	if err := EncodeDeadLetter(&buf, failure); err != nil {
+		return err
+	}
+	d.Lock()
+	defer d.Unlock()
+	// write all the lines at once so concurrent sends are not interleaved
+	_, err := d.file.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the file. Close must not be called before the Producer is stopped.
+func (d *FileDeadLetter) Close() error {
+	d.Lock()
+	defer d.Unlock()
+	return d.file.Close()
+}
@@ -0,0 +1,54 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	deadLetter, err := NewFileDeadLetter(path)
+	require.NoError(t, err)
+
+	failure := &FailureRecord{
+		Err:       &PutRecordsEntryError{Code: "InternalFailure", Message: "Internal service failure."},
+		ErrorCode: "InternalFailure",
+		Attempts:  3,
+		UserRecords: []UserRecord{
+			newTestUserRecord("foo", "", []byte("hello")),
+			newTestUserRecord("bar", "1", []byte("world")),
+		},
+	}
+	require.NoError(t, deadLetter.Send(context.Background(), failure))
+	require.NoError(t, deadLetter.Send(context.Background(), failure))
+	require.NoError(t, deadLetter.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var got []DeadLetterRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record DeadLetterRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		got = append(got, record)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, got, 4)
+	require.Equal(t, "InternalFailure: Internal service failure.", got[0].Error)
+	require.Equal(t, "InternalFailure", got[0].ErrorCode)
+	require.Equal(t, 3, got[0].Attempts)
+	require.Equal(t, "foo", got[0].PartitionKey)
+	require.Equal(t, "", got[0].ExplicitHashKey)
+	require.Equal(t, []byte("hello"), got[0].Data)
+	require.Equal(t, "bar", got[1].PartitionKey)
+	require.Equal(t, "1", got[1].ExplicitHashKey)
+	require.Equal(t, []byte("world"), got[1].Data)
+}
@@ -0,0 +1,55 @@
+package kps3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+// Putter is the interface that wraps the s3.Client PutObject method.
+type Putter interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// DeadLetter implements producer.DeadLetter by writing each failed aggregated record to a
+// S3 object as newline delimited JSON. Objects are keyed
+// <Prefix>/<yyyy>/<mm>/<dd>/<hh>/<uuid>.jsonl
+type DeadLetter struct {
+	Client Putter
+	Bucket string
+	Prefix string
+}
+
+var _ producer.DeadLetter = (*DeadLetter)(nil)
+
+// New creates a DeadLetter writing to bucket under prefix
+func New(client Putter, bucket, prefix string) *DeadLetter {
+	return &DeadLetter{Client: client, Bucket: bucket, Prefix: prefix}
+}
+
+// Send writes the user records of failure to a new S3 object
+func (d *DeadLetter) Send(ctx context.Context, failure *producer.FailureRecord) error {
+	var buf bytes.Buffer
+	if err := producer.EncodeDeadLetter(&buf, failure); err != nil {
+		return err
+	}
+	key := path.Join(d.Prefix, time.Now().UTC().Format("2006/01/02/15"), uuid.New().String()+".jsonl")
+	_, err := d.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(d.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("kps3: put object %s: %w", key, err)
+	}
+	return nil
+}
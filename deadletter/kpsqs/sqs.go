@@ -0,0 +1,74 @@
+package kpsqs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+// maxBatchSize is the maximum number of messages in a SendMessageBatch request
+const maxBatchSize = 10
+
+// Sender is the interface that wraps the sqs.Client SendMessageBatch method.
+type Sender interface {
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// DeadLetter implements producer.DeadLetter by sending each failed user record as a SQS
+// message. The message body is a producer.DeadLetterRecord encoded as JSON. Note that
+// SQS limits messages to 256KiB, larger user records can not be sent.
+type DeadLetter struct {
+	Client   Sender
+	QueueURL string
+}
+
+var _ producer.DeadLetter = (*DeadLetter)(nil)
+
+// New creates a DeadLetter sending to the queue at queueURL
+func New(client Sender, queueURL string) *DeadLetter {
+	return &DeadLetter{Client: client, QueueURL: queueURL}
+}
+
+// Send sends the user records of failure in batches of up to 10 messages
+func (d *DeadLetter) Send(ctx context.Context, failure *producer.FailureRecord) error {
+	records := producer.NewDeadLetterRecords(failure)
+	for start := 0; start < len(records); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		entries := make([]types.SendMessageBatchRequestEntry, 0, end-start)
+		for i, record := range records[start:end] {
+			body, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, types.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(body)),
+			})
+		}
+		out, err := d.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(d.QueueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return fmt.Errorf("kpsqs: send message batch: %w", err)
+		}
+		if len(out.Failed) > 0 {
+			f := out.Failed[0]
+			return fmt.Errorf(
+				"kpsqs: %d messages failed: %s: %s",
+				len(out.Failed), aws.ToString(f.Code), aws.ToString(f.Message),
+			)
+		}
+	}
+	return nil
+}
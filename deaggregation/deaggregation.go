@@ -5,15 +5,23 @@ package deaggregation
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
 
 	"github.com/achunariov/kinesis-producer/pb"
+	"github.com/achunariov/kinesis-producer/wire"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"google.golang.org/protobuf/proto"
 )
 
 var magicNumber = []byte{0xF3, 0x89, 0x9A, 0xC2}
 
 // IsAggregatedRecord judges whether input message is Kinesis Aggregated Record or not.
+// Aggregated records framed by the wire package are recognized in every version.
 func IsAggregatedRecord(target []byte) bool {
+	target = unframe(target)
 	length := int32(len(target))
 	if length < md5.Size {
 		return false
@@ -34,8 +42,22 @@ func IsAggregatedRecord(target []byte) bool {
 	return true
 }
 
+// unframe returns the KPL aggregated record of data framed by the wire package. Data that
+// can not be decoded is returned unchanged, like any other data that is not aggregated.
+func unframe(data []byte) []byte {
+	if !wire.IsFramed(data) {
+		return data
+	}
+	frame, err := wire.Decode(data)
+	if err != nil {
+		return data
+	}
+	return frame.Payload
+}
+
 // ExtractRecordDatas extracts Record.Data slice from Kinesis Aggregated Record.
 func ExtractRecordDatas(target []byte) ([][]byte, error) {
+	target = unframe(target)
 	length := int32(len(target))
 	aggregated := &pb.AggregatedRecord{}
 
@@ -54,6 +76,7 @@ func ExtractRecordDatas(target []byte) ([][]byte, error) {
 
 // Unmarshal extracts AggregatedRecord from Kinesis Aggregated Record.
 func Unmarshal(target []byte) (*pb.AggregatedRecord, error) {
+	target = unframe(target)
 	length := int32(len(target))
 	aggregated := &pb.AggregatedRecord{}
 
@@ -63,3 +86,99 @@ func Unmarshal(target []byte) (*pb.AggregatedRecord, error) {
 
 	return aggregated, nil
 }
+
+// Record is a user record extracted from a Kinesis record.
+type Record struct {
+	PartitionKey string
+	// ExplicitHashKey is empty if the user record has none
+	ExplicitHashKey string
+	Data            []byte
+}
+
+// Deaggregate extracts the user records of a Kinesis record with the given data and
+// partition key. Like the KCL, data that is not an aggregated record, or whose checksum
+// does not match, is returned as a single user record.
+func Deaggregate(data []byte, partitionKey string) ([]Record, error) {
+	if !IsAggregatedRecord(data) {
+		return []Record{{PartitionKey: partitionKey, Data: data}}, nil
+	}
+	aggregated, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, len(aggregated.Records))
+	for i, r := range aggregated.Records {
+		pkIndex := r.GetPartitionKeyIndex()
+		if pkIndex >= uint64(len(aggregated.PartitionKeyTable)) {
+			return nil, fmt.Errorf("deaggregation: partition key index %d out of range", pkIndex)
+		}
+		records[i] = Record{
+			PartitionKey: aggregated.PartitionKeyTable[pkIndex],
+			Data:         r.GetData(),
+		}
+		if r.ExplicitHashKeyIndex != nil {
+			ehkIndex := r.GetExplicitHashKeyIndex()
+			if ehkIndex >= uint64(len(aggregated.ExplicitHashKeyTable)) {
+				return nil, fmt.Errorf("deaggregation: explicit hash key index %d out of range", ehkIndex)
+			}
+			records[i].ExplicitHashKey = aggregated.ExplicitHashKeyTable[ehkIndex]
+		}
+	}
+	return records, nil
+}
+
+// DeaggregateEntry extracts the user records of a PutRecords request entry, e.g. one
+// received by a mock Putter in tests. A plain entry keeps its explicit hash key.
+func DeaggregateEntry(entry types.PutRecordsRequestEntry) ([]Record, error) {
+	records, err := Deaggregate(entry.Data, aws.ToString(entry.PartitionKey))
+	if err != nil {
+		return nil, err
+	}
+	if !IsAggregatedRecord(entry.Data) {
+		records[0].ExplicitHashKey = aws.ToString(entry.ExplicitHashKey)
+	}
+	return records, nil
+}
+
+// DeaggregateRecords extracts the user records of the records returned by GetRecords.
+func DeaggregateRecords(records []types.Record) ([]Record, error) {
+	var out []Record
+	for _, record := range records {
+		userRecords, err := Deaggregate(record.Data, aws.ToString(record.PartitionKey))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, userRecords...)
+	}
+	return out, nil
+}
+
+// SplitLengthPrefixed extracts the data of the user records of a record aggregated with
+// producer.LengthPrefixed.
+func SplitLengthPrefixed(data []byte) ([][]byte, error) {
+	var out [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("deaggregation: truncated length prefix")
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			return nil, errors.New("deaggregation: truncated record")
+		}
+		out = append(out, data[4:4+n])
+		data = data[4+n:]
+	}
+	return out, nil
+}
+
+// SplitNDJSON extracts the JSON documents of a record aggregated with producer.NDJSON.
+func SplitNDJSON(data []byte) [][]byte {
+	var out [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) > 0 {
+			out = append(out, line)
+		}
+	}
+	return out
+}
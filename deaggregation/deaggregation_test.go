@@ -7,6 +7,9 @@ import (
 	"testing"
 
 	"github.com/achunariov/kinesis-producer/pb"
+	"github.com/achunariov/kinesis-producer/wire"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -216,6 +219,142 @@ func Test_Unmarshal_FullAggregatedRecord(t *testing.T) {
 	}
 }
 
+// Deaggregate returns the user records of an aggregated record with their keys.
+func Test_Deaggregate_AggregatedRecord(t *testing.T) {
+	target := createAggregatedRecord(&pb.AggregatedRecord{
+		PartitionKeyTable:    []string{"pk1", "pk2"},
+		ExplicitHashKeyTable: []string{"100"},
+		Records: []*pb.Record{
+			{PartitionKeyIndex: proto.Uint64(0), Data: []byte("record1")},
+			{PartitionKeyIndex: proto.Uint64(1), ExplicitHashKeyIndex: proto.Uint64(0), Data: []byte("record2")},
+		},
+	})
+
+	actual, err := Deaggregate(target, "pk1")
+	if err != nil {
+		t.Fatalf("Deaggregate() returned error %v", err)
+	}
+	expected := []Record{
+		{PartitionKey: "pk1", Data: []byte("record1")},
+		{PartitionKey: "pk2", ExplicitHashKey: "100", Data: []byte("record2")},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Deaggregate() want %v but %v.", expected, actual)
+	}
+}
+
+// Deaggregate decodes aggregated records framed by the wire package.
+func Test_Deaggregate_FramedRecord(t *testing.T) {
+	aggregated := createAggregatedRecord(&pb.AggregatedRecord{
+		PartitionKeyTable: []string{"pk1"},
+		Records:           []*pb.Record{{PartitionKeyIndex: proto.Uint64(0), Data: []byte("record1")}},
+	})
+	target, err := wire.Encode(wire.Version1, aggregated)
+	if err != nil {
+		t.Fatalf("Encode() returned error %v", err)
+	}
+	if !IsAggregatedRecord(target) {
+		t.Errorf("IsAggregatedRecord(FramedRecord) want %v but %v.", true, false)
+	}
+
+	actual, err := DeaggregateEntry(types.PutRecordsRequestEntry{Data: target, PartitionKey: aws.String("pk1"), ExplicitHashKey: aws.String("100")})
+	if err != nil {
+		t.Fatalf("DeaggregateEntry() returned error %v", err)
+	}
+	expected := []Record{{PartitionKey: "pk1", Data: []byte("record1")}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("DeaggregateEntry() want %v but %v.", expected, actual)
+	}
+
+	// data of an unknown version is not aggregated, like data with a wrong checksum
+	unknown := append([]byte{0x4B, 0x50, 0x57, 9}, aggregated...)
+	actual, err = Deaggregate(unknown, "pk1")
+	if err != nil {
+		t.Fatalf("Deaggregate() returned error %v", err)
+	}
+	if len(actual) != 1 || !reflect.DeepEqual(unknown, actual[0].Data) {
+		t.Errorf("Deaggregate() want the data as is but %v.", actual)
+	}
+}
+
+// Deaggregate returns a record that is not aggregated as is.
+func Test_Deaggregate_NonAggregatedRecord(t *testing.T) {
+	actual, err := Deaggregate([]byte("NotAggregatedRecord"), "pk")
+	if err != nil {
+		t.Fatalf("Deaggregate() returned error %v", err)
+	}
+	expected := []Record{{PartitionKey: "pk", Data: []byte("NotAggregatedRecord")}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Deaggregate() want %v but %v.", expected, actual)
+	}
+}
+
+// Deaggregate fails on partition key indexes outside of the table.
+func Test_Deaggregate_InvalidPartitionKeyIndex(t *testing.T) {
+	target := createAggregatedRecord(&pb.AggregatedRecord{
+		Records: []*pb.Record{{PartitionKeyIndex: proto.Uint64(1111), Data: []byte("record")}},
+	})
+
+	_, err := Deaggregate(target, "pk")
+	if err == nil || err.Error() != "deaggregation: partition key index 1111 out of range" {
+		t.Errorf("Deaggregate() want out of range error but %v.", err)
+	}
+}
+
+// DeaggregateEntry keeps the explicit hash key of plain entries.
+func Test_DeaggregateEntry_NonAggregatedRecord(t *testing.T) {
+	actual, err := DeaggregateEntry(types.PutRecordsRequestEntry{
+		Data:            []byte("NotAggregatedRecord"),
+		PartitionKey:    aws.String("pk"),
+		ExplicitHashKey: aws.String("100"),
+	})
+	if err != nil {
+		t.Fatalf("DeaggregateEntry() returned error %v", err)
+	}
+	expected := []Record{{PartitionKey: "pk", ExplicitHashKey: "100", Data: []byte("NotAggregatedRecord")}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("DeaggregateEntry() want %v but %v.", expected, actual)
+	}
+}
+
+// DeaggregateRecords flattens the user records of all records.
+func Test_DeaggregateRecords(t *testing.T) {
+	aggregated := createAggregatedRecord(&pb.AggregatedRecord{
+		PartitionKeyTable: []string{"pk1"},
+		Records: []*pb.Record{
+			{PartitionKeyIndex: proto.Uint64(0), Data: []byte("record1")},
+			{PartitionKeyIndex: proto.Uint64(0), Data: []byte("record2")},
+		},
+	})
+
+	actual, err := DeaggregateRecords([]types.Record{
+		{Data: aggregated, PartitionKey: aws.String("pk1")},
+		{Data: []byte("record3"), PartitionKey: aws.String("pk2")},
+	})
+	if err != nil {
+		t.Fatalf("DeaggregateRecords() returned error %v", err)
+	}
+	expected := []Record{
+		{PartitionKey: "pk1", Data: []byte("record1")},
+		{PartitionKey: "pk1", Data: []byte("record2")},
+		{PartitionKey: "pk2", Data: []byte("record3")},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("DeaggregateRecords() want %v but %v.", expected, actual)
+	}
+}
+
+func createAggregatedRecord(aggregated *pb.AggregatedRecord) []byte {
+	data, err := proto.Marshal(aggregated)
+	if err != nil {
+		panic(err)
+	}
+	checkSum := md5.Sum(data)
+	target := append([]byte{}, magicNumber...)
+	target = append(target, data...)
+	return append(target, checkSum[:]...)
+}
+
 func createMinimumAggregateRecordMarshaledBytes() []byte {
 	targetRecord := &pb.Record{
 		PartitionKeyIndex: proto.Uint64(1111),
@@ -274,3 +413,31 @@ func createFullAggregateRecordMarshaledBytes() []byte {
 
 	return targetBytes
 }
+
+// SplitLengthPrefixed extracts the data of length prefixed records.
+func Test_SplitLengthPrefixed(t *testing.T) {
+	actual, err := SplitLengthPrefixed([]byte{0, 0, 0, 2, 'h', 'i', 0, 0, 0, 0, 0, 0, 0, 1, '!'})
+	if err != nil {
+		t.Fatalf("SplitLengthPrefixed() returned error %v", err)
+	}
+	expected := [][]byte{[]byte("hi"), {}, []byte("!")}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("SplitLengthPrefixed() want %q but %q.", expected, actual)
+	}
+
+	if _, err := SplitLengthPrefixed([]byte{0, 0, 0, 5, 'h'}); err == nil || err.Error() != "deaggregation: truncated record" {
+		t.Errorf("SplitLengthPrefixed() want truncated record error but %v.", err)
+	}
+	if _, err := SplitLengthPrefixed([]byte{0, 0}); err == nil || err.Error() != "deaggregation: truncated length prefix" {
+		t.Errorf("SplitLengthPrefixed() want truncated length prefix error but %v.", err)
+	}
+}
+
+// SplitNDJSON extracts the lines of newline delimited JSON.
+func Test_SplitNDJSON(t *testing.T) {
+	actual := SplitNDJSON([]byte("{\"a\":1}\n{\"b\":2}\n"))
+	expected := [][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("SplitNDJSON() want %q but %q.", expected, actual)
+	}
+}
@@ -0,0 +1,92 @@
+package producer
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// recentErrorsSize is the number of errors kept for DebugInfo
+const recentErrorsSize = 20
+
+// DebugInfo is a snapshot of the internals of a Producer for debugging, returned by
+// Producer.DebugInfo and rendered as JSON by DebugHandler and PublishExpvar.
+type DebugInfo struct {
+	Stats
+	// AggregatorBytes is the size of the user records in the aggregator of each shard,
+	// keyed like Stats.Aggregators
+	AggregatorBytes map[string]map[string]int
+	// RecentErrors are the last errors reported to NotifyFailures, whether or not it was
+	// called, oldest first
+	RecentErrors []DebugError
+}
+
+// DebugError is an error reported by the Producer
+type DebugError struct {
+	Time  time.Time
+	Error string
+}
+
+// recentErrors keeps the last errors reported by the Producer
+type recentErrors struct {
+	mu     sync.Mutex
+	errors []DebugError
+	// next is the index overwritten by the next error once errors is full
+	next int
+}
+
+func (r *recentErrors) add(now time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := DebugError{Time: now, Error: err.Error()}
+	if len(r.errors) < recentErrorsSize {
+		r.errors = append(r.errors, e)
+		return
+	}
+	r.errors[r.next] = e
+	r.next = (r.next + 1) % recentErrorsSize
+}
+
+// list returns the errors, oldest first
+func (r *recentErrors) list() []DebugError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(append([]DebugError{}, r.errors[r.next:]...), r.errors[:r.next]...)
+}
+
+// DebugInfo returns a snapshot of the internals of the producer. This method is
+// thread-safe.
+func (p *Producer) DebugInfo() DebugInfo {
+	info := DebugInfo{
+		Stats:           p.Stats(),
+		AggregatorBytes: make(map[string]map[string]int),
+		RecentErrors:    p.recentErrors.list(),
+	}
+	for stream, shardMap := range p.streamShardMaps() {
+		info.AggregatorBytes[stream] = shardMap.sizes()
+	}
+	return info
+}
+
+// DebugHandler returns an http.Handler that renders DebugInfo as JSON, e.g. to mount on
+// an internal debug server:
+//
+//	http.Handle("/debug/kinesis-producer", p.DebugHandler())
+func (p *Producer) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(p.DebugInfo()); err != nil {
+			p.Logger.Error("debug handler", err)
+		}
+	})
+}
+
+// PublishExpvar publishes DebugInfo as the expvar variable name, served on /debug/vars
+// by the expvar package. Like expvar.Publish, it panics if name is already published.
+func (p *Producer) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return p.DebugInfo() }))
+}
@@ -0,0 +1,53 @@
+package producer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentErrors(t *testing.T) {
+	var r recentErrors
+	now := time.Now()
+	for i := 0; i < recentErrorsSize+2; i++ {
+		r.add(now, fmt.Errorf("error %d", i))
+	}
+	list := r.list()
+	require.Len(t, list, recentErrorsSize)
+	require.Equal(t, "error 2", list[0].Error)
+	require.Equal(t, fmt.Sprintf("error %d", recentErrorsSize+1), list[recentErrorsSize-1].Error)
+}
+
+func TestDebugHandler(t *testing.T) {
+	p := New(&Config{
+		StreamName:    "foo",
+		FlushInterval: time.Hour,
+		Logger:        &NopLogger{},
+		Client:        &clientMock{incoming: make(map[int][]string)},
+	})
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.notify(errors.New("boom"))
+
+	w := httptest.NewRecorder()
+	p.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var info struct {
+		BacklogLength   int
+		Circuit         string
+		Aggregators     map[string]map[string]int
+		AggregatorBytes map[string]map[string]int
+		RecentErrors    []DebugError
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	require.Equal(t, "closed", info.Circuit)
+	require.Equal(t, map[string]map[string]int{"foo": {"": 1}}, info.Aggregators)
+	require.Equal(t, p.shardMap.Size(), info.AggregatorBytes["foo"][""])
+	require.Len(t, info.RecentErrors, 1)
+	require.Equal(t, "boom", info.RecentErrors[0].Error)
+}
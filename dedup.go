@@ -0,0 +1,103 @@
+package producer
+
+import (
+	"container/list"
+	"encoding/hex"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultDeduplicationSize is the default number of idempotency keys remembered
+const defaultDeduplicationSize = 100000
+
+// deduplicator remembers the idempotency keys of the records put within the window, see
+// Config.DeduplicationWindow
+type deduplicator struct {
+	now    func() time.Time
+	window time.Duration
+	size   int
+
+	mu sync.Mutex
+	// keys holds the dedupEntry of the remembered keys, oldest first
+	keys    *list.List
+	entries map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key   string
+	putAt time.Time
+}
+
+func newDeduplicator(config *Config) *deduplicator {
+	if config.DeduplicationWindow <= 0 {
+		return nil
+	}
+	return &deduplicator{
+		now:     config.Clock.Now,
+		window:  config.DeduplicationWindow,
+		size:    config.DeduplicationSize,
+		keys:    list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// add remembers key and returns its entry, or nil if it was put within the window
+func (d *deduplicator) add(key string) *dedupEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	for e := d.keys.Front(); e != nil && now.Sub(e.Value.(*dedupEntry).putAt) >= d.window; e = d.keys.Front() {
+		d.keys.Remove(e)
+		delete(d.entries, e.Value.(*dedupEntry).key)
+	}
+	if _, ok := d.entries[key]; ok {
+		return nil
+	}
+	entry := &dedupEntry{key, now}
+	d.entries[key] = d.keys.PushBack(entry)
+	if d.keys.Len() > d.size {
+		oldest := d.keys.Front()
+		d.keys.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupEntry).key)
+	}
+	return entry
+}
+
+// remove forgets the entry, so that a record that was not accepted or failed can be put
+// again. The key is kept if it was remembered again since.
+func (d *deduplicator) remove(entry *dedupEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[entry.key]; ok && e.Value == entry {
+		d.keys.Remove(e)
+		delete(d.entries, entry.key)
+	}
+}
+
+// contentIdempotencyKey is the default Config.IdempotencyKey, the hex encoded 128 bits
+// hash of the partition key and data of the record
+func contentIdempotencyKey(userRecord UserRecord) string {
+	h := fnv.New128a()
+	h.Write([]byte(userRecord.PartitionKey()))
+	h.Write([]byte{0})
+	h.Write(userRecord.Data())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyKey returns the key a user record is deduplicated with, or the empty string
+func (p *Producer) idempotencyKey(userRecord UserRecord, opts putOptions) string {
+	if opts.idempotencyKey != "" {
+		return opts.idempotencyKey
+	}
+	return p.IdempotencyKey(unwrapUserRecord(userRecord))
+}
+
+// duplicate suppresses a user record put again within the DeduplicationWindow
+func (p *Producer) duplicate(userRecord UserRecord, key string) {
+	err := &ErrDuplicateRecord{UserRecord: unwrapUserRecord(userRecord), IdempotencyKey: key}
+	resolveUserRecords([]UserRecord{userRecord}, PutResult{Err: err})
+	atomic.AddInt64(&p.pool.stats.duplicates, 1)
+	p.notify(err)
+}
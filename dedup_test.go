@@ -0,0 +1,136 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/deaggregation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicator(t *testing.T) {
+	now := time.Now()
+	d := newDeduplicator(&Config{
+		DeduplicationWindow: time.Second,
+		DeduplicationSize:   2,
+		Clock:               systemClock{},
+	})
+	d.now = func() time.Time { return now }
+
+	require.NotNil(t, d.add("foo"))
+	require.Nil(t, d.add("foo"))
+
+	// keys are forgotten after the window
+	now = now.Add(500 * time.Millisecond)
+	require.NotNil(t, d.add("bar"))
+	now = now.Add(500 * time.Millisecond)
+	require.NotNil(t, d.add("foo"))
+	require.Nil(t, d.add("bar"))
+
+	// or when more than DeduplicationSize keys are remembered
+	baz := d.add("baz")
+	require.NotNil(t, baz)
+	require.NotNil(t, d.add("bar"))
+
+	// or when removed
+	d.remove(baz)
+	baz2 := d.add("baz")
+	require.NotNil(t, baz2)
+
+	// a key remembered again is kept when the former entry is removed
+	d.remove(baz)
+	require.Nil(t, d.add("baz"))
+	d.remove(baz2)
+	require.NotNil(t, d.add("baz"))
+}
+
+func TestProducerDeduplication(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:          "foo",
+		MaxConnections:      1,
+		FlushInterval:       time.Hour,
+		DeduplicationWindow: time.Minute,
+		Logger:              &NopLogger{},
+		Client:              client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	// the same data with another partition key is not a duplicate
+	require.NoError(t, p.Put([]byte("hello"), "bar"))
+	require.NoError(t, p.Put([]byte("world"), "foo", WithIdempotencyKey("id-1")))
+
+	future, err := p.PutWithResult([]byte("again"), "foo", WithIdempotencyKey("id-1"))
+	require.NoError(t, err)
+	var duplicate *ErrDuplicateRecord
+	require.ErrorAs(t, future.Result().Err, &duplicate)
+	require.Equal(t, "id-1", duplicate.IdempotencyKey)
+	require.Equal(t, []byte("again"), duplicate.Data())
+	require.Equal(t, int64(2), p.Stats().Duplicates)
+	p.Stop()
+
+	require.Len(t, client.data, 1)
+	agg, err := deaggregation.Unmarshal(client.data[0])
+	require.NoError(t, err)
+	var data []string
+	for _, record := range agg.Records {
+		data = append(data, string(record.Data))
+	}
+	require.Equal(t, []string{"hello", "hello", "world"}, data)
+	var errs []error
+	for err := range failures {
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 2)
+	require.ErrorAs(t, errs[0], &duplicate)
+	require.Equal(t, []byte("hello"), duplicate.Data())
+	require.Equal(t, contentIdempotencyKey(NewDataRecord([]byte("hello"), "foo")), duplicate.IdempotencyKey)
+}
+
+func TestProducerDeduplicationFailure(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Error: &types.ResourceNotFoundException{}},
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{SequenceNumber: aws.String("1"), ShardId: aws.String("shardId-000000000001")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:          "foo",
+		MaxConnections:      1,
+		FlushInterval:       time.Hour,
+		DeduplicationWindow: time.Minute,
+		Logger:              &NopLogger{},
+		Client:              client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the key of a record that failed is forgotten so that it can be put again
+	future, err := p.PutWithResult([]byte("hello"), "foo", WithIdempotencyKey("id-1"))
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+	require.Error(t, future.Result().Err)
+
+	future, err = p.PutWithResult([]byte("hello"), "foo", WithIdempotencyKey("id-1"))
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+	require.NoError(t, future.Result().Err)
+	require.Zero(t, p.Stats().Duplicates)
+}
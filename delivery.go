@@ -0,0 +1,47 @@
+package producer
+
+// Delivery is sent to NotifyDeliveries once Kinesis acknowledged a user record put with
+// WithCorrelationID, so that the application can checkpoint the source of the record,
+// e.g. commit its Kafka offset.
+type Delivery struct {
+	// CorrelationID is the id given to WithCorrelationID
+	CorrelationID string
+	// ShardId of the shard the record was written to
+	ShardId string
+	// SequenceNumber assigned by Kinesis. Aggregated user records share the sequence
+	// number of the aggregated record they were sent in
+	SequenceNumber string
+}
+
+// NotifyDeliveries registers and returns a listener for the deliveries of the user records
+// put with WithCorrelationID. Records that failed are reported to NotifyFailures instead.
+// Like NotifyFailures, the channel must be drained or it blocks the delivery of further
+// records, and it is closed once the Producer is stopped.
+func (p *Producer) NotifyDeliveries() <-chan Delivery {
+	p.Lock()
+	defer p.Unlock()
+	if p.deliveries == nil {
+		p.deliveries = make(chan Delivery, p.BacklogCount)
+	}
+	return p.deliveries
+}
+
+// correlate tracks the user record to report its delivery with id
+func (p *Producer) correlate(userRecord UserRecord, id string) UserRecord {
+	tracked := track(userRecord)
+	tracked.report = func(result PutResult) {
+		if result.Err != nil {
+			return
+		}
+		p.RLock()
+		if p.deliveries != nil {
+			p.deliveries <- Delivery{
+				CorrelationID:  id,
+				ShardId:        result.ShardId,
+				SequenceNumber: result.SequenceNumber,
+			}
+		}
+		p.RUnlock()
+	}
+	return tracked
+}
@@ -0,0 +1,94 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyDeliveries(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(1),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+					{ShardId: aws.String("shardId-1"), SequenceNumber: aws.String("2")},
+					{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("boom")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		ErrorClassifier: func(err error) ErrorClass {
+			return ErrorFatal
+		},
+	})
+	deliveries := p.NotifyDeliveries()
+	failures := p.NotifyFailures()
+	go func() {
+		for range failures {
+		}
+	}()
+	p.Start()
+
+	require.NoError(t, p.Put([]byte("hello"), "a", WithoutAggregation(), WithCorrelationID("offset-1")))
+	// records without a correlation id are not reported
+	require.NoError(t, p.Put([]byte("world"), "b", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("failed"), "c", WithoutAggregation(), WithCorrelationID("offset-3")))
+	require.NoError(t, p.Flush(context.Background()))
+	p.Stop()
+
+	var got []Delivery
+	for d := range deliveries {
+		got = append(got, d)
+	}
+	require.Equal(t, []Delivery{{CorrelationID: "offset-1", ShardId: "shardId-0", SequenceNumber: "1"}}, got)
+}
+
+func TestNotifyDeliveriesSpill(t *testing.T) {
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+		for _, r := range input.Records {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0"), SequenceNumber: r.PartitionKey})
+		}
+		return out, nil
+	})
+	p := New(&Config{
+		StreamName:       "foo",
+		MaxConnections:   1,
+		MaxBufferedBytes: 10,
+		OverflowPolicy:   OverflowSpill,
+		SpillDir:         t.TempDir(),
+		FlushInterval:    time.Hour,
+		Logger:           &NopLogger{},
+		Client:           client,
+	})
+	deliveries := p.NotifyDeliveries()
+	p.Start()
+
+	p.Pause()
+	require.NoError(t, p.Put([]byte("hello"), "a", WithoutAggregation(), WithCorrelationID("offset-1")))
+	require.NoError(t, p.Put([]byte("world"), "b", WithoutAggregation(), WithCorrelationID("offset-2")))
+	require.Equal(t, 1, p.Stats().Spilled)
+	p.Resume()
+	p.Stop()
+
+	// the spilled record is reported once delivered
+	var got []string
+	for d := range deliveries {
+		got = append(got, d.CorrelationID)
+	}
+	require.ElementsMatch(t, []string{"offset-1", "offset-2"}, got)
+}
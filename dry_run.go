@@ -0,0 +1,148 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/deaggregation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	defaultDryRunDir = "kinesis-dry-run"
+	dryRunShardID    = "shardId-000000000000"
+)
+
+// DryRunConfig configures the FilePutter records are written to instead of Kinesis, see
+// Config.DryRun.
+type DryRunConfig struct {
+	// Dir is the directory of the files, created if needed. Each stream is written to a
+	// "<stream>.jsonl" file, where the characters of a stream ARN that are not valid in a
+	// file name are replaced with "_". Default to "kinesis-dry-run" in os.TempDir().
+	Dir string
+
+	// Deaggregate writes a line per user record instead of one per kinesis record.
+	// Default to false.
+	Deaggregate bool
+}
+
+func (c *DryRunConfig) defaults() {
+	if c.Dir == "" {
+		c.Dir = filepath.Join(os.TempDir(), defaultDryRunDir)
+	}
+}
+
+// FileRecord is a line of the files written by FilePutter, either a kinesis record or,
+// with DryRunConfig.Deaggregate, a user record
+type FileRecord struct {
+	Stream          string    `json:"stream"`
+	PartitionKey    string    `json:"partition_key"`
+	ExplicitHashKey string    `json:"explicit_hash_key,omitempty"`
+	Data            []byte    `json:"data"`
+	SequenceNumber  string    `json:"sequence_number"`
+	Time            time.Time `json:"time"`
+}
+
+// FilePutter implements Putter by appending the records of each PutRecords request to
+// local files as JSON lines instead of sending it to AWS, e.g. for staging, debugging
+// payloads or air-gapped tests. Every record succeeds.
+type FilePutter struct {
+	config DryRunConfig
+
+	mu  sync.Mutex
+	seq int
+}
+
+var _ Putter = (*FilePutter)(nil)
+
+// NewFilePutter creates a FilePutter writing to the files of config.Dir
+func NewFilePutter(config DryRunConfig) *FilePutter {
+	config.defaults()
+	return &FilePutter{config: config}
+}
+
+// PutRecords appends the records of input to the file of the stream
+func (p *FilePutter) PutRecords(_ context.Context, input *k.PutRecordsInput, _ ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	stream := aws.ToString(input.StreamName)
+	if stream == "" {
+		stream = aws.ToString(input.StreamARN)
+	}
+	now := time.Now().UTC()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var (
+		buf     bytes.Buffer
+		enc     = json.NewEncoder(&buf)
+		results = make([]types.PutRecordsResultEntry, len(input.Records))
+		seq     = p.seq
+	)
+	for i, entry := range input.Records {
+		seq++
+		record := FileRecord{
+			Stream:          stream,
+			PartitionKey:    aws.ToString(entry.PartitionKey),
+			ExplicitHashKey: aws.ToString(entry.ExplicitHashKey),
+			Data:            entry.Data,
+			SequenceNumber:  strconv.Itoa(seq),
+			Time:            now,
+		}
+		lines := []FileRecord{record}
+		if p.config.Deaggregate {
+			userRecords, err := deaggregation.DeaggregateEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			lines = lines[:0]
+			for _, r := range userRecords {
+				record.PartitionKey, record.ExplicitHashKey, record.Data = r.PartitionKey, r.ExplicitHashKey, r.Data
+				lines = append(lines, record)
+			}
+		}
+		for _, line := range lines {
+			if err := enc.Encode(line); err != nil {
+				return nil, err
+			}
+		}
+		results[i] = types.PutRecordsResultEntry{
+			ShardId:        aws.String(dryRunShardID),
+			SequenceNumber: aws.String(record.SequenceNumber),
+		}
+	}
+	if err := p.write(stream, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	p.seq = seq
+	return &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0), Records: results}, nil
+}
+
+func (p *FilePutter) write(stream string, b []byte) error {
+	if err := os.MkdirAll(p.config.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.Path(stream), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Path returns the path of the file the records of stream are written to
+func (p *FilePutter) Path(stream string) string {
+	return filepath.Join(p.config.Dir, dryRunFileReplacer.Replace(stream)+".jsonl")
+}
+
+var dryRunFileReplacer = strings.NewReplacer("/", "_", ":", "_", "\\", "_")
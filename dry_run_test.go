@@ -0,0 +1,81 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func readFileRecords(t *testing.T, path string) []FileRecord {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []FileRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r FileRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestFilePutter(t *testing.T) {
+	dir := t.TempDir()
+	p := NewFilePutter(DryRunConfig{Dir: filepath.Join(dir, "out")})
+	arn := "arn:aws:kinesis:us-east-1:123456789012:stream/foo"
+	for i := 0; i < 2; i++ {
+		out, err := p.PutRecords(context.Background(), &k.PutRecordsInput{
+			StreamARN: aws.String(arn),
+			Records: []types.PutRecordsRequestEntry{
+				{Data: []byte("hello"), PartitionKey: aws.String("a"), ExplicitHashKey: aws.String("7")},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, int32(0), aws.ToInt32(out.FailedRecordCount))
+		require.Len(t, out.Records, 1)
+	}
+
+	path := p.Path(arn)
+	require.Equal(t, filepath.Join(dir, "out", "arn_aws_kinesis_us-east-1_123456789012_stream_foo.jsonl"), path)
+	records := readFileRecords(t, path)
+	require.Len(t, records, 2)
+	require.Equal(t, arn, records[0].Stream)
+	require.Equal(t, "a", records[0].PartitionKey)
+	require.Equal(t, "7", records[0].ExplicitHashKey)
+	require.Equal(t, []byte("hello"), records[0].Data)
+	require.Equal(t, "1", records[0].SequenceNumber)
+	require.Equal(t, "2", records[1].SequenceNumber)
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	p := New(&Config{
+		StreamName:    "foo",
+		FlushInterval: time.Hour,
+		Logger:        &NopLogger{},
+		DryRun:        &DryRunConfig{Dir: dir, Deaggregate: true},
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "a"))
+	require.NoError(t, p.Put([]byte("world"), "b"))
+	p.Stop()
+
+	records := readFileRecords(t, filepath.Join(dir, "foo.jsonl"))
+	require.Len(t, records, 2)
+	require.Equal(t, []byte("hello"), records[0].Data)
+	require.Equal(t, "a", records[0].PartitionKey)
+	require.Equal(t, []byte("world"), records[1].Data)
+	require.Equal(t, "b", records[1].PartitionKey)
+	require.Equal(t, records[0].SequenceNumber, records[1].SequenceNumber)
+}
@@ -0,0 +1,42 @@
+package producer
+
+import "context"
+
+// Encryptor encrypts the data of user records on the client side, see the encryption
+// package.
+//
+// Encrypt is called synchronously by Put.
+type Encryptor interface {
+	Encrypt(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// encryptedRecord is a user record whose data was encrypted with Config.Encryptor
+type encryptedRecord struct {
+	UserRecord
+	data []byte
+}
+
+func (r *encryptedRecord) Data() []byte { return r.data }
+func (r *encryptedRecord) Size() int    { return len(r.data) }
+
+// encrypts reports whether the data of the user record must be encrypted. Chunks and
+// pointer records are created from records that went through encryption already.
+func (p *Producer) encrypts(userRecord UserRecord) bool {
+	if p.Encryptor == nil {
+		return false
+	}
+	switch userRecord.(type) {
+	case *encryptedRecord, *chunkRecord, *claimCheckRecord:
+		return false
+	}
+	return true
+}
+
+// encrypt returns the user record with encrypted data
+func (p *Producer) encrypt(userRecord UserRecord) (UserRecord, error) {
+	data, err := p.Encryptor.Encrypt(putContext(userRecord), userRecord.Data())
+	if err != nil {
+		return nil, &ErrEncryption{UserRecord: unwrapUserRecord(userRecord), Err: err}
+	}
+	return &encryptedRecord{UserRecord: userRecord, data: data}, nil
+}
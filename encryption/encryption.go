@@ -0,0 +1,244 @@
+// Package encryption encrypts user record data on the client side with data keys of a key
+// management service like AWS KMS, on top of the server-side encryption of the stream, and
+// decrypts it on the consumer side.
+//
+// An encrypted payload starts with a header:
+//
+//	magic   [4]byte 0x4B 0x50 0x58 0x31
+//	key id  uint16 big endian length, followed by the id of the master key
+//	wrapped uint16 big endian length, followed by the data key wrapped by the master key
+//	nonce   [12]byte
+//
+// followed by the data encrypted with AES-256-GCM. The header is authenticated with the
+// data.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// KeySize is the size of the data keys in bytes, for AES-256
+	KeySize = 32
+
+	nonceSize            = 12
+	defaultMaxKeyAge     = 5 * time.Minute
+	defaultMaxKeyUses    = 1 << 20
+	defaultMaxCachedKeys = 1000
+	maxHeaderFieldSize   = 1<<16 - 1
+	headerFixedSize      = 4 + 2 + 2 + nonceSize
+)
+
+var magicNumber = []byte{0x4B, 0x50, 0x58, 0x31}
+
+// KeyService generates and unwraps data keys, e.g. AWS KMS. Implementations must be safe
+// for concurrent use.
+type KeyService interface {
+	// GenerateDataKey returns a new data key of KeySize bytes, in plaintext and wrapped by
+	// the master key keyID, and the id of the master key to store in the header, e.g. its
+	// ARN
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext, wrapped []byte, id string, err error)
+	// Decrypt returns the plaintext of a data key wrapped by the master key keyID
+	Decrypt(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Encryptor encrypts payloads with data keys of the master key KeyID. A data key is
+// reused for MaxKeyAge and MaxKeyUses payloads, so that the KeyService is not called for
+// every record. It implements producer.Encryptor.
+type Encryptor struct {
+	Keys  KeyService
+	KeyID string
+	// MaxKeyAge is how long a data key is used. Default to 5m.
+	MaxKeyAge time.Duration
+	// MaxKeyUses is the number of payloads encrypted with a data key, well below the 2^32
+	// random nonces AES-GCM allows per key. Default to 1048576.
+	MaxKeyUses int
+
+	mu  sync.Mutex
+	key *dataKey
+}
+
+// dataKey is the data key in use by an Encryptor
+type dataKey struct {
+	aead    cipher.AEAD
+	header  []byte
+	created time.Time
+	uses    int
+}
+
+// NewEncryptor creates an Encryptor with data keys of the master key keyID
+func NewEncryptor(keys KeyService, keyID string) *Encryptor {
+	return &Encryptor{
+		Keys:       keys,
+		KeyID:      keyID,
+		MaxKeyAge:  defaultMaxKeyAge,
+		MaxKeyUses: defaultMaxKeyUses,
+	}
+}
+
+// Encrypt returns the encrypted payload of data
+func (e *Encryptor) Encrypt(ctx context.Context, data []byte) ([]byte, error) {
+	key, err := e.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(key.header), len(key.header)+nonceSize+len(data)+key.aead.Overhead())
+	copy(out, key.header)
+	nonce := out[len(out) : len(out)+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	out = out[:len(out)+nonceSize]
+	// the header, including the nonce, is authenticated
+	return key.aead.Seal(out, nonce, data, out), nil
+}
+
+// dataKey returns the data key to use, generating a new one if needed
+func (e *Encryptor) dataKey(ctx context.Context) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	maxAge, maxUses := e.MaxKeyAge, e.MaxKeyUses
+	if maxAge == 0 {
+		maxAge = defaultMaxKeyAge
+	}
+	if maxUses == 0 {
+		maxUses = defaultMaxKeyUses
+	}
+	if k := e.key; k != nil && k.uses < maxUses && now.Sub(k.created) < maxAge {
+		k.uses++
+		return k, nil
+	}
+	plaintext, wrapped, id, err := e.Keys.GenerateDataKey(ctx, e.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: generate data key: %w", err)
+	}
+	aead, err := newAEAD(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > maxHeaderFieldSize || len(wrapped) > maxHeaderFieldSize {
+		return nil, errors.New("encryption: key id or wrapped data key too long")
+	}
+	header := make([]byte, 0, headerFixedSize-nonceSize+len(id)+len(wrapped))
+	header = append(header, magicNumber...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(id)))
+	header = append(header, id...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	e.key = &dataKey{aead: aead, header: header, created: now, uses: 1}
+	return e.key, nil
+}
+
+// Decryptor decrypts payloads, caching the unwrapped data keys so that the KeyService is
+// only called once per data key.
+type Decryptor struct {
+	Keys KeyService
+	// MaxCachedKeys is the number of unwrapped data keys kept. Default to 1000.
+	MaxCachedKeys int
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD
+}
+
+// NewDecryptor creates a Decryptor unwrapping data keys with keys
+func NewDecryptor(keys KeyService) *Decryptor {
+	return &Decryptor{Keys: keys, MaxCachedKeys: defaultMaxCachedKeys}
+}
+
+// IsEncrypted reports whether data starts with the header of an encrypted payload
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magicNumber)
+}
+
+// Decrypt returns the data of an encrypted payload
+func (d *Decryptor) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("encryption: not an encrypted payload")
+	}
+	rest := data[len(magicNumber):]
+	id, rest, err := readField(rest)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, rest, err := readField(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < nonceSize {
+		return nil, errors.New("encryption: truncated header")
+	}
+	header := data[:len(data)-len(rest)+nonceSize]
+	nonce, ciphertext := rest[:nonceSize], rest[nonceSize:]
+	aead, err := d.aead(ctx, string(id), wrapped)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	return plaintext, nil
+}
+
+// aead returns the cipher of the wrapped data key, unwrapping it on first use
+func (d *Decryptor) aead(ctx context.Context, id string, wrapped []byte) (cipher.AEAD, error) {
+	cacheKey := id + "\x00" + string(wrapped)
+	d.mu.Lock()
+	aead, ok := d.keys[cacheKey]
+	d.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+	plaintext, err := d.Keys.Decrypt(ctx, id, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: decrypt data key: %w", err)
+	}
+	if aead, err = newAEAD(plaintext); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	maxKeys := d.MaxCachedKeys
+	if maxKeys == 0 {
+		maxKeys = defaultMaxCachedKeys
+	}
+	if d.keys == nil || len(d.keys) >= maxKeys {
+		// data keys rotate, so the old ones are rarely needed again
+		d.keys = make(map[string]cipher.AEAD)
+	}
+	d.keys[cacheKey] = aead
+	return aead, nil
+}
+
+// readField reads a field prefixed with its uint16 length
+func readField(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("encryption: truncated header")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, errors.New("encryption: truncated header")
+	}
+	return data[2 : 2+n], data[2+n:], nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption: data key of %d bytes, expected %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/achunariov/kinesis-producer/envelope"
+)
+
+// keyServiceMock wraps data keys by reversing them
+type keyServiceMock struct {
+	mu        sync.Mutex
+	generated int
+	decrypted int
+}
+
+func (m *keyServiceMock) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generated++
+	key := bytes.Repeat([]byte{byte(m.generated)}, KeySize)
+	key[0] = 0
+	return key, reverse(key), "arn:" + keyID, nil
+}
+
+func (m *keyServiceMock) Decrypt(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decrypted++
+	if keyID != "arn:alias/foo" {
+		return nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	return reverse(wrapped), nil
+}
+
+func reverse(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys := &keyServiceMock{}
+	e := NewEncryptor(keys, "alias/foo")
+	d := NewDecryptor(keys)
+
+	first, err := e.Encrypt(context.Background(), []byte("hello"))
+	require.NoError(t, err)
+	second, err := e.Encrypt(context.Background(), []byte("hello"))
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(first))
+	require.False(t, IsEncrypted([]byte("hello")))
+	// same data key, different nonces
+	require.NotEqual(t, first, second)
+	require.Equal(t, 1, keys.generated)
+
+	for _, payload := range [][]byte{first, second} {
+		out, err := d.Decrypt(context.Background(), payload)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello"), out)
+	}
+	require.Equal(t, 1, keys.decrypted)
+}
+
+func TestEncryptorRotatesKeys(t *testing.T) {
+	keys := &keyServiceMock{}
+	e := NewEncryptor(keys, "alias/foo")
+	e.MaxKeyUses = 2
+	for i := 0; i < 5; i++ {
+		_, err := e.Encrypt(context.Background(), []byte("hello"))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, keys.generated)
+
+	e.MaxKeyAge = time.Nanosecond
+	_, err := e.Encrypt(context.Background(), []byte("hello"))
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = e.Encrypt(context.Background(), []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, 5, keys.generated)
+}
+
+func TestDecryptTampered(t *testing.T) {
+	keys := &keyServiceMock{}
+	e := NewEncryptor(keys, "alias/foo")
+	d := NewDecryptor(keys)
+	payload, err := e.Encrypt(context.Background(), []byte("hello"))
+	require.NoError(t, err)
+
+	tampered := bytes.Clone(payload)
+	tampered[len(tampered)-1] ^= 1
+	_, err = d.Decrypt(context.Background(), tampered)
+	require.EqualError(t, err, "encryption: cipher: message authentication failed")
+
+	_, err = d.Decrypt(context.Background(), payload[:10])
+	require.EqualError(t, err, "encryption: truncated header")
+	_, err = d.Decrypt(context.Background(), []byte("hello"))
+	require.EqualError(t, err, "encryption: not an encrypted payload")
+}
+
+func TestEncryptKeyServiceError(t *testing.T) {
+	e := NewEncryptor(failingKeyService{}, "alias/foo")
+	_, err := e.Encrypt(context.Background(), []byte("hello"))
+	require.EqualError(t, err, "encryption: generate data key: access denied")
+}
+
+type failingKeyService struct{}
+
+func (failingKeyService) GenerateDataKey(context.Context, string) ([]byte, []byte, string, error) {
+	return nil, nil, "", errors.New("access denied")
+}
+
+func (failingKeyService) Decrypt(context.Context, string, []byte) ([]byte, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestIsEncryptedEnvelope(t *testing.T) {
+	// the header does not start like the other formats of the producer
+	require.False(t, bytes.HasPrefix(magicNumber, []byte(envelope.MagicNumber)))
+	for _, format := range []envelope.Format{envelope.JSON, envelope.Protobuf} {
+		require.False(t, IsEncrypted(append([]byte(envelope.MagicNumber), byte(format), 0, 0)))
+	}
+}
@@ -0,0 +1,87 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/compression"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptorMock "encrypts" data by prefixing it
+type encryptorMock struct {
+	err error
+}
+
+func (e encryptorMock) Encrypt(ctx context.Context, data []byte) ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return append([]byte("encrypted:"), data...), nil
+}
+
+func TestEncryption(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("2")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:           "foo",
+		Compression:          compression.Gzip,
+		CompressionThreshold: 100,
+		Encryptor:            encryptorMock{},
+		MaxConnections:       1,
+		FlushInterval:        time.Hour,
+		Logger:               &NopLogger{},
+		Client:               client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	data := bytes.Repeat([]byte("hello world "), 100)
+	future, err := p.PutWithResult(data, "foo", WithoutAggregation())
+	require.NoError(t, err)
+	require.NoError(t, p.Put([]byte("short"), "bar", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, PutResult{ShardId: "shardId-0", SequenceNumber: "1"}, future.Result())
+
+	require.Len(t, client.data, 2)
+	// data is compressed before it is encrypted
+	compressed, ok := bytes.CutPrefix(client.data[0], []byte("encrypted:"))
+	require.True(t, ok)
+	out, err := compression.Decompress(compressed)
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+	require.Equal(t, []byte("encrypted:short"), client.data[1])
+}
+
+func TestEncryptionError(t *testing.T) {
+	p := New(&Config{
+		StreamName: "foo",
+		Encryptor:  encryptorMock{err: errors.New("access denied")},
+		Logger:     &NopLogger{},
+		Client:     &clientMock{},
+	})
+	p.Start()
+	defer p.Stop()
+
+	err := p.Put([]byte("hello"), "foo")
+	var encryptionErr *ErrEncryption
+	require.ErrorAs(t, err, &encryptionErr)
+	require.Equal(t, []byte("hello"), encryptionErr.Data())
+	require.EqualError(t, err, "Unable to encrypt record: access denied")
+}
@@ -0,0 +1,75 @@
+package producer
+
+import (
+	"os"
+
+	"github.com/achunariov/kinesis-producer/envelope"
+)
+
+// EnvelopeConfig wraps the data of user records in an envelope.Envelope, see
+// Config.Envelope
+type EnvelopeConfig struct {
+	// Format of the envelopes. Default to envelope.JSON.
+	Format envelope.Format
+
+	// ProducerID identifies the producer in the envelopes. Default to the host name.
+	ProducerID string
+
+	// Headers are added to the envelope of every user record. Headers set with
+	// WithHeaders take precedence. Default to nil.
+	Headers map[string]string
+
+	// Checksum adds the checksum of the data of the user record to the envelope, e.g.
+	// envelope.CRC32C, which envelope.Decode verifies. Default to 0, no checksum.
+	Checksum envelope.ChecksumAlgorithm
+}
+
+func (c *EnvelopeConfig) defaults() {
+	if c.Format == 0 {
+		c.Format = envelope.JSON
+	}
+	if c.ProducerID == "" {
+		c.ProducerID, _ = os.Hostname()
+	}
+}
+
+// wrap returns the user record with its data wrapped in an envelope
+func (p *Producer) wrap(userRecord UserRecord, opts putOptions) (UserRecord, error) {
+	tracked, ok := userRecord.(*trackedRecord)
+	if ok {
+		userRecord = tracked.UserRecord
+	}
+	headers := opts.headers
+	if len(p.Envelope.Headers) > 0 {
+		headers = make(map[string]string, len(p.Envelope.Headers)+len(opts.headers))
+		for key, value := range p.Envelope.Headers {
+			headers[key] = value
+		}
+		for key, value := range opts.headers {
+			headers[key] = value
+		}
+	}
+	e := &envelope.Envelope{
+		Data:       userRecord.Data(),
+		Headers:    headers,
+		ProducerID: p.Envelope.ProducerID,
+		Timestamp:  p.Clock.Now(),
+	}
+	if p.Envelope.Checksum != 0 {
+		sum, err := envelope.Checksum(p.Envelope.Checksum, e.Data)
+		if err != nil {
+			return nil, &ErrEnvelope{UserRecord: userRecord, Err: err}
+		}
+		e.Checksum = sum
+	}
+	data, err := envelope.Encode(p.Envelope.Format, e)
+	if err != nil {
+		return nil, &ErrEnvelope{UserRecord: userRecord, Err: err}
+	}
+	userRecord = NewDataRecordWithExplicitHashKey(data, userRecord.PartitionKey(), userRecord.ExplicitHashKey())
+	if ok {
+		tracked.UserRecord = userRecord
+		return tracked, nil
+	}
+	return userRecord, nil
+}
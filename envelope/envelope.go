@@ -0,0 +1,279 @@
+// Package envelope wraps the data of user records with headers, the id of the producer
+// and the time they were put, and unwraps it on the consumer side.
+//
+// An envelope starts with a header:
+//
+//	magic  [3]byte 0x4B 0x50 0x45
+//	format byte    1 for JSON, 2 for protocol buffers
+//
+// followed by the Envelope encoded in the format. The JSON object has the fields data
+// (base64), headers, producer_id, timestamp (RFC 3339) and checksum. The protocol buffer
+// message is:
+//
+//	message Envelope {
+//	  optional bytes data = 1;
+//	  map<string, string> headers = 2;
+//	  optional string producer_id = 3;
+//	  optional int64 timestamp = 4; // unix nanoseconds
+//	  optional string checksum = 5;
+//	}
+//
+// The checksum of the data is the name of the algorithm and the hex encoded sum, e.g.
+// "crc32c:1a2b3c4d". Decode verifies it.
+package envelope
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// HeaderSize is the number of bytes added to each envelope before the encoded Envelope
+const HeaderSize = 4
+
+// MagicNumber starts every envelope, followed by the format
+const MagicNumber = "\x4B\x50\x45"
+
+var (
+	magicNumber = []byte(MagicNumber)
+	crcTable    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// ErrChecksumMismatch is returned by Decode if the data of the envelope does not match its
+// checksum
+var ErrChecksumMismatch = errors.New("envelope: checksum mismatch")
+
+// Format is the encoding of an Envelope
+type Format byte
+
+const (
+	// JSON encodes envelopes with encoding/json
+	JSON Format = 1
+	// Protobuf encodes envelopes in the protocol buffer wire format
+	Protobuf Format = 2
+)
+
+// ChecksumAlgorithm is the algorithm of the checksum of the data of an Envelope
+type ChecksumAlgorithm byte
+
+const (
+	// CRC32C checksums data with CRC-32 and the Castagnoli polynomial. It is cheap and
+	// detects accidental corruption
+	CRC32C ChecksumAlgorithm = 1
+	// SHA256 checksums data with SHA-256
+	SHA256 ChecksumAlgorithm = 2
+)
+
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case CRC32C:
+		return "crc32c"
+	case SHA256:
+		return "sha256"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(a))
+}
+
+// Checksum returns the checksum of data with algorithm, as stored in Envelope.Checksum
+func Checksum(algorithm ChecksumAlgorithm, data []byte) (string, error) {
+	switch algorithm {
+	case CRC32C:
+		return fmt.Sprintf("crc32c:%08x", crc32.Checksum(data, crcTable)), nil
+	case SHA256:
+		sum := sha256.Sum256(data)
+		return "sha256:" + hex.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("envelope: unknown checksum algorithm %d", algorithm)
+}
+
+// Envelope wraps the data of a user record with metadata
+type Envelope struct {
+	// Data is the data of the user record
+	Data []byte `json:"data"`
+	// Headers are arbitrary key-value pairs, e.g. a content type or a trace id
+	Headers map[string]string `json:"headers,omitempty"`
+	// ProducerID identifies the producer that put the record
+	ProducerID string `json:"producer_id,omitempty"`
+	// Timestamp is the time the record was put, according to the clock of the producer
+	Timestamp time.Time `json:"timestamp"`
+	// Checksum is the checksum of Data, see Checksum. Empty if the data is not checksummed
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Verify returns ErrChecksumMismatch if Data does not match Checksum. Envelopes without a
+// checksum are not verified.
+func (e *Envelope) Verify() error {
+	if e.Checksum == "" {
+		return nil
+	}
+	var algorithm ChecksumAlgorithm
+	switch name, _, _ := strings.Cut(e.Checksum, ":"); name {
+	case "crc32c":
+		algorithm = CRC32C
+	case "sha256":
+		algorithm = SHA256
+	default:
+		return fmt.Errorf("envelope: unknown checksum algorithm %q", name)
+	}
+	if sum, _ := Checksum(algorithm, e.Data); sum != e.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Encode encodes e in format and prepends the header
+func Encode(format Format, e *Envelope) ([]byte, error) {
+	out := make([]byte, 0, HeaderSize+len(e.Data)+64)
+	out = append(out, magicNumber...)
+	out = append(out, byte(format))
+	switch format {
+	case JSON:
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, fmt.Errorf("envelope: %w", err)
+		}
+		return append(out, data...), nil
+	case Protobuf:
+		return appendProto(out, e), nil
+	}
+	return nil, fmt.Errorf("envelope: unknown format %d", format)
+}
+
+// IsEnvelope reports whether data starts with the header of an envelope
+func IsEnvelope(data []byte) bool {
+	return len(data) >= HeaderSize && bytes.HasPrefix(data, magicNumber)
+}
+
+// Decode parses an envelope created by Encode
+func Decode(data []byte) (*Envelope, error) {
+	if !IsEnvelope(data) {
+		return nil, errors.New("envelope: not an envelope")
+	}
+	e := &Envelope{}
+	switch format := Format(data[len(magicNumber)]); format {
+	case JSON:
+		if err := json.Unmarshal(data[HeaderSize:], e); err != nil {
+			return nil, fmt.Errorf("envelope: %w", err)
+		}
+	case Protobuf:
+		if err := consumeProto(data[HeaderSize:], e); err != nil {
+			return nil, fmt.Errorf("envelope: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("envelope: unknown format %d", format)
+	}
+	if err := e.Verify(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func appendProto(b []byte, e *Envelope) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, e.Data)
+	// headers are sorted so that the same envelope is always encoded the same way
+	keys := make([]string, 0, len(e.Headers))
+	for key := range e.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, e.Headers[key])
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if e.ProducerID != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, e.ProducerID)
+	}
+	if !e.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.Timestamp.UnixNano()))
+	}
+	if e.Checksum != "" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, e.Checksum)
+	}
+	return b
+}
+
+func consumeProto(b []byte, e *Envelope) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var data []byte
+			data, n = protowire.ConsumeBytes(b)
+			e.Data = append([]byte{}, data...)
+		case num == 2 && typ == protowire.BytesType:
+			var entry []byte
+			entry, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				key, value, err := consumeHeader(entry)
+				if err != nil {
+					return err
+				}
+				if e.Headers == nil {
+					e.Headers = make(map[string]string)
+				}
+				e.Headers[key] = value
+			}
+		case num == 3 && typ == protowire.BytesType:
+			e.ProducerID, n = protowire.ConsumeString(b)
+		case num == 4 && typ == protowire.VarintType:
+			var nanos uint64
+			nanos, n = protowire.ConsumeVarint(b)
+			e.Timestamp = time.Unix(0, int64(nanos))
+		case num == 5 && typ == protowire.BytesType:
+			e.Checksum, n = protowire.ConsumeString(b)
+		default:
+			// fields added by newer versions are skipped
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeHeader(b []byte) (key, value string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			value, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return key, value, nil
+}
@@ -0,0 +1,90 @@
+package envelope
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	e := &Envelope{
+		Data:       []byte("hello"),
+		Headers:    map[string]string{"content-type": "text/plain", "trace-id": "abc"},
+		ProducerID: "host-1",
+		Timestamp:  time.Unix(1700000000, 123456789),
+	}
+	for name, format := range map[string]Format{"json": JSON, "protobuf": Protobuf} {
+		t.Run(name, func(t *testing.T) {
+			data, err := Encode(format, e)
+			require.NoError(t, err)
+			require.True(t, IsEnvelope(data))
+			require.Equal(t, byte(format), data[3])
+
+			out, err := Decode(data)
+			require.NoError(t, err)
+			require.Equal(t, e.Data, out.Data)
+			require.Equal(t, e.Headers, out.Headers)
+			require.Equal(t, e.ProducerID, out.ProducerID)
+			require.True(t, e.Timestamp.Equal(out.Timestamp))
+		})
+	}
+}
+
+func TestDecodeProtobufUnknownFields(t *testing.T) {
+	data, err := Encode(Protobuf, &Envelope{Data: []byte("hello")})
+	require.NoError(t, err)
+	data = protowire.AppendTag(data, 15, protowire.VarintType)
+	data = protowire.AppendVarint(data, 42)
+
+	out, err := Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), out.Data)
+	require.Nil(t, out.Headers)
+	require.True(t, out.Timestamp.IsZero())
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode([]byte("hello"))
+	require.EqualError(t, err, "envelope: not an envelope")
+
+	_, err = Decode([]byte{0x4B, 0x50, 0x45, 9, 1})
+	require.EqualError(t, err, "envelope: unknown format 9")
+
+	_, err = Decode([]byte{0x4B, 0x50, 0x45, byte(Protobuf), 0x0A, 0x05, 'h'})
+	require.Error(t, err)
+
+	_, err = Encode(9, &Envelope{})
+	require.EqualError(t, err, "envelope: unknown format 9")
+}
+
+func TestChecksum(t *testing.T) {
+	for _, algorithm := range []ChecksumAlgorithm{CRC32C, SHA256} {
+		for name, format := range map[string]Format{"json": JSON, "protobuf": Protobuf} {
+			t.Run(algorithm.String()+"/"+name, func(t *testing.T) {
+				sum, err := Checksum(algorithm, []byte("hello"))
+				require.NoError(t, err)
+				data, err := Encode(format, &Envelope{Data: []byte("hello"), Checksum: sum})
+				require.NoError(t, err)
+
+				out, err := Decode(data)
+				require.NoError(t, err)
+				require.Equal(t, sum, out.Checksum)
+
+				// the data changed after it was checksummed
+				corrupt, err := Encode(format, &Envelope{Data: []byte("hellO"), Checksum: sum})
+				require.NoError(t, err)
+				_, err = Decode(corrupt)
+				require.ErrorIs(t, err, ErrChecksumMismatch)
+			})
+		}
+	}
+
+	sum, err := Checksum(CRC32C, []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "crc32c:9a71bb4c", sum)
+	_, err = Checksum(9, []byte("hello"))
+	require.EqualError(t, err, "envelope: unknown checksum algorithm 9")
+	require.EqualError(t, (&Envelope{Checksum: "md4:00"}).Verify(), `envelope: unknown checksum algorithm "md4"`)
+}
@@ -0,0 +1,57 @@
+package producer
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/envelope"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Envelope: &EnvelopeConfig{
+			Format:     envelope.Protobuf,
+			ProducerID: "host-1",
+			Headers:    map[string]string{"env": "test", "type": "default"},
+		},
+	})
+	before := time.Now()
+	p.Start()
+	require.NoError(t, p.PutUserRecord(
+		NewDataRecordWithExplicitHashKey([]byte("hello"), "foo", big.NewInt(7)),
+		WithoutAggregation(),
+		WithHeaders(map[string]string{"type": "greeting"}),
+	))
+	p.Stop()
+
+	require.Len(t, client.data, 1)
+	require.Equal(t, []string{"7"}, client.hashKeys)
+	e, err := envelope.Decode(client.data[0])
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), e.Data)
+	require.Equal(t, map[string]string{"env": "test", "type": "greeting"}, e.Headers)
+	require.Equal(t, "host-1", e.ProducerID)
+	require.False(t, e.Timestamp.Before(before))
+}
+
+func TestEnvelopeDefaults(t *testing.T) {
+	config := &Config{Envelope: &EnvelopeConfig{}}
+	config.defaults()
+	require.Equal(t, envelope.JSON, config.Envelope.Format)
+	require.NotEmpty(t, config.Envelope.ProducerID)
+}
@@ -0,0 +1,90 @@
+package producer
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// ErrorClass is the category of a PutRecords error, which decides how it is handled
+type ErrorClass int
+
+const (
+	// ErrorFatal errors are not retried, the records are reported to NotifyFailures and
+	// the DeadLetter right away
+	ErrorFatal ErrorClass = iota
+	// ErrorRetryable errors are retried after a backoff, up to MaxRetries
+	ErrorRetryable
+	// ErrorThrottle errors are retried like ErrorRetryable ones, and counted as throttled
+	// records in the Metrics and Stats
+	ErrorThrottle
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorFatal:
+		return "fatal"
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorThrottle:
+		return "throttle"
+	}
+	return "unknown"
+}
+
+// ErrorClassifier returns the class of the error of a PutRecords request, or of a record
+// rejected in its response, in which case err is a *PutRecordsEntryError. Implementations
+// overriding a few errors should return DefaultErrorClassifier(err) for the others.
+type ErrorClassifier func(err error) ErrorClass
+
+// kmsThrottledErrorCode is the PutRecordsResultEntry error code for records rejected due to
+// exceeding the request rate of the KMS key of the stream
+const kmsThrottledErrorCode = "KMSThrottlingException"
+
+// fatalEntryErrorCodes are the error codes of records rejected because the stream or its
+// KMS key is unusable, which retries do not fix
+var fatalEntryErrorCodes = map[string]struct{}{
+	"KMSAccessDeniedException":  {},
+	"KMSDisabledException":      {},
+	"KMSInvalidStateException":  {},
+	"KMSNotFoundException":      {},
+	"KMSOptInRequired":          {},
+	"ResourceNotFoundException": {},
+}
+
+// DefaultErrorClassifier is the default Config.ErrorClassifier:
+//
+//   - ProvisionedThroughputExceededException and KMSThrottlingException are throttles
+//   - rejected records are retryable, unless their KMS key or stream is unusable
+//   - request timeouts and server side (5xx) errors are retryable
+//   - everything else is fatal
+func DefaultErrorClassifier(err error) ErrorClass {
+	var entry *PutRecordsEntryError
+	if errors.As(err, &entry) {
+		switch entry.Code {
+		case throttledErrorCode, kmsThrottledErrorCode:
+			return ErrorThrottle
+		}
+		if _, ok := fatalEntryErrorCodes[entry.Code]; ok {
+			return ErrorFatal
+		}
+		return ErrorRetryable
+	}
+	var throttled *types.ProvisionedThroughputExceededException
+	if errors.As(err, &throttled) {
+		return ErrorThrottle
+	}
+	var kmsThrottled *types.KMSThrottlingException
+	if errors.As(err, &kmsThrottled) {
+		return ErrorThrottle
+	}
+	var timeout *ErrRequestTimeout
+	if errors.As(err, &timeout) {
+		return ErrorRetryable
+	}
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) && status.HTTPStatusCode() >= 500 {
+		return ErrorRetryable
+	}
+	return ErrorFatal
+}
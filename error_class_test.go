@@ -0,0 +1,88 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected ErrorClass
+	}{
+		{&types.ProvisionedThroughputExceededException{}, ErrorThrottle},
+		{&types.KMSThrottlingException{}, ErrorThrottle},
+		{&ErrRequestTimeout{Err: context.DeadlineExceeded}, ErrorRetryable},
+		{&types.ResourceNotFoundException{}, ErrorFatal},
+		{errors.New("unknown"), ErrorFatal},
+		{&PutRecordsEntryError{Code: throttledErrorCode}, ErrorThrottle},
+		{&PutRecordsEntryError{Code: "InternalFailure"}, ErrorRetryable},
+		{&PutRecordsEntryError{Code: "KMSNotFoundException"}, ErrorFatal},
+		{&ErrMaxRetriesExceeded{Err: &PutRecordsEntryError{Code: throttledErrorCode}}, ErrorThrottle},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			require.Equal(t, tc.expected, DefaultErrorClassifier(tc.err))
+		})
+	}
+	require.Equal(t, "throttle", ErrorThrottle.String())
+}
+
+func TestErrorClassifier(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(2),
+				Records: []types.PutRecordsResultEntry{
+					{ErrorCode: aws.String("InternalFailure")},
+					{ErrorCode: aws.String("KMSNotFoundException")},
+					{SequenceNumber: aws.String("1"), ShardId: aws.String("shardId-000000000000")},
+				},
+			}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:         "foo",
+		MaxConnections:     1,
+		AggregateBatchSize: 1,
+		FlushInterval:      time.Hour,
+		Backoff:            &FixedBackoff{},
+		Logger:             &NopLogger{},
+		Client:             client,
+		ErrorClassifier: func(err error) ErrorClass {
+			if errorCode(err) == "InternalFailure" {
+				return ErrorThrottle
+			}
+			return DefaultErrorClassifier(err)
+		},
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	defer p.Stop()
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, p.Put([]byte("hello"), key))
+	}
+	require.NoError(t, p.Flush(context.Background()))
+
+	// the records are sent in a single request, in any order. The fatal record is not retried
+	failure := (<-failures).(*FailureRecord)
+	require.Equal(t, client.incoming[0][1], failure.PartitionKey)
+	require.Equal(t, "KMSNotFoundException", failure.ErrorCode)
+	require.Equal(t, ErrorFatal, failure.Class)
+	require.Equal(t, 1, failure.Attempts)
+	require.Equal(t, 2, client.calls)
+	require.Equal(t, client.incoming[0][:1], client.incoming[1])
+	stats := p.Stats()
+	require.Equal(t, int64(1), stats.Throttles)
+	require.Equal(t, int64(1), stats.Retries)
+	require.Equal(t, int64(1), stats.Drops)
+}
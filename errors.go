@@ -1,7 +1,12 @@
 package producer
 
 import (
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
 )
 
 type ErrStoppedProducer struct {
@@ -12,6 +17,76 @@ func (e *ErrStoppedProducer) Error() string {
 	return "Unable to Put record. Producer is already stopped"
 }
 
+// ErrClosedWriter is returned by Writer after Close
+type ErrClosedWriter struct{}
+
+func (e *ErrClosedWriter) Error() string {
+	return "Unable to Write. Writer is already closed"
+}
+
+// ErrCircuitOpen is returned by Put while the circuit breaker is open
+type ErrCircuitOpen struct {
+	UserRecord
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return "Unable to Put record. Circuit breaker is open"
+}
+
+// ErrBacklogFull is returned by TryPut, or Put with the OverflowError policy, when the
+// backlog is at capacity or MaxBufferedBytes is exceeded. It is also sent to
+// NotifyFailures for records dropped by the OverflowDropNewest and OverflowDropOldest
+// policies.
+type ErrBacklogFull struct {
+	UserRecord
+}
+
+func (e *ErrBacklogFull) Error() string {
+	return "Unable to Put record. Backlog is full"
+}
+
+// ErrRecordDropped is the result of the future of a user record put with PutWithResult
+// and dropped by a RecordInterceptor or the Sampling. Put returns nil for such records.
+type ErrRecordDropped struct {
+	UserRecord
+}
+
+func (e *ErrRecordDropped) Error() string {
+	return "Unable to Put record. Record was dropped by an interceptor or sampling"
+}
+
+// ErrThrottled is returned by TryPut, or Put with the OverflowError policy, while the
+// Rate of the Throttle is exceeded, see Producer.SetThrottle, or the Budget of Config.Cost
+// with Throttle.
+type ErrThrottled struct {
+	UserRecord
+}
+
+func (e *ErrThrottled) Error() string {
+	return "Unable to Put record. Producer is throttled"
+}
+
+// ErrOverBudget is returned by Put for a user record larger than the hourly Bytes of the
+// Budget of Config.Cost with Throttle, which would wait forever
+type ErrOverBudget struct {
+	UserRecord
+	Bytes int64
+}
+
+func (e *ErrOverBudget) Error() string {
+	return fmt.Sprintf("Unable to Put record. Record of %d bytes exceeds the budget of %d bytes per hour", e.Size()+len(e.PartitionKey()), e.Bytes)
+}
+
+// ErrQuotaExceeded is returned by Tenant.TryPut when the tenant is over its TenantQuota.
+type ErrQuotaExceeded struct {
+	UserRecord
+	Tenant string
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("Unable to Put record. Tenant %s exceeded its quota", e.Tenant)
+}
+
 type ErrIllegalPartitionKey struct {
 	UserRecord
 }
@@ -20,6 +95,215 @@ func (e *ErrIllegalPartitionKey) Error() string {
 	return fmt.Sprintf("Invalid parition key. Length must be at least 1 and at most 256: %s", e.PartitionKey())
 }
 
+type ErrIllegalExplicitHashKey struct {
+	UserRecord
+	// ExplicitHashKey is the invalid explicit hash key in decimal form
+	ExplicitHashKey string
+}
+
+func (e *ErrIllegalExplicitHashKey) Error() string {
+	return fmt.Sprintf("Invalid explicit hash key. Must be a decimal integer between 0 and 2^128-1: %s", e.ExplicitHashKey)
+}
+
+// ErrRecordCanceled is sent to NotifyFailures for a user record that was dropped because
+// the context it was put with was done before it was sent. Err is the context error.
+type ErrRecordCanceled struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrRecordCanceled) Error() string {
+	return fmt.Sprintf("Record canceled before it was sent: %v", e.Err)
+}
+
+func (e *ErrRecordCanceled) Unwrap() error {
+	return e.Err
+}
+
+// ErrIntercepted is returned by Put if a RecordInterceptor failed. UserRecord is the record
+// passed to the interceptor.
+type ErrIntercepted struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrIntercepted) Error() string {
+	return fmt.Sprintf("Record interceptor failed: %v", e.Err)
+}
+
+func (e *ErrIntercepted) Unwrap() error {
+	return e.Err
+}
+
+// ErrRouting is returned by PutData if Config.Router failed. UserRecord is the record of
+// the data passed to the router.
+type ErrRouting struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrRouting) Error() string {
+	return fmt.Sprintf("Record router failed: %v", e.Err)
+}
+
+func (e *ErrRouting) Unwrap() error {
+	return e.Err
+}
+
+// ErrEnvelope is returned by Put if the data of a user record could not be wrapped in an
+// envelope
+type ErrEnvelope struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrEnvelope) Error() string {
+	return fmt.Sprintf("Unable to wrap record in envelope: %v", e.Err)
+}
+
+func (e *ErrEnvelope) Unwrap() error {
+	return e.Err
+}
+
+// ErrChecksumMismatch is sent to NotifyFailures with Config.VerifyIntegrity for the records
+// whose data changed after they were put, e.g. because the application modified the buffer
+// passed to Put. It holds the first user record of the corrupt record.
+type ErrChecksumMismatch struct {
+	UserRecord
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return "Record data changed after it was put"
+}
+
+// ErrDuplicateRecord is sent to NotifyFailures for a user record that was not put because
+// a record with the same idempotency key was put within the DeduplicationWindow.
+type ErrDuplicateRecord struct {
+	UserRecord
+	IdempotencyKey string
+}
+
+func (e *ErrDuplicateRecord) Error() string {
+	return fmt.Sprintf("Duplicate record suppressed. Idempotency key put within the deduplication window: %s", e.IdempotencyKey)
+}
+
+// ErrRecordExpired is the error of the FailureRecord sent to NotifyFailures for user
+// records that were dropped because they were put longer than MaxRecordAge before being sent
+type ErrRecordExpired struct {
+	MaxRecordAge time.Duration
+}
+
+func (e *ErrRecordExpired) Error() string {
+	return fmt.Sprintf("Record older than %v dropped before it was sent", e.MaxRecordAge)
+}
+
+// ErrLargeRecordStore is returned by Put if the payload of a large record could not be
+// stored with the LargeRecordStore
+type ErrLargeRecordStore struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrLargeRecordStore) Error() string {
+	return fmt.Sprintf("Unable to store large record: %v", e.Err)
+}
+
+func (e *ErrLargeRecordStore) Unwrap() error {
+	return e.Err
+}
+
+// ErrWriteAheadLog is returned by Put if the user record could not be appended to the
+// WriteAheadLog
+type ErrWriteAheadLog struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrWriteAheadLog) Error() string {
+	return fmt.Sprintf("Unable to append record to write ahead log: %v", e.Err)
+}
+
+func (e *ErrWriteAheadLog) Unwrap() error {
+	return e.Err
+}
+
+// ErrSpill is returned by Put with the OverflowSpill policy if the user record could not
+// be written to disk
+type ErrSpill struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrSpill) Error() string {
+	return fmt.Sprintf("Unable to spill record: %v", e.Err)
+}
+
+func (e *ErrSpill) Unwrap() error {
+	return e.Err
+}
+
+// ErrCompression is returned by Put if the data of a user record could not be compressed
+type ErrCompression struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrCompression) Error() string {
+	return fmt.Sprintf("Unable to compress record: %v", e.Err)
+}
+
+func (e *ErrCompression) Unwrap() error {
+	return e.Err
+}
+
+// ErrEncryption is returned by Put if the data of a user record could not be encrypted
+type ErrEncryption struct {
+	UserRecord
+	Err error
+}
+
+func (e *ErrEncryption) Error() string {
+	return fmt.Sprintf("Unable to encrypt record: %v", e.Err)
+}
+
+func (e *ErrEncryption) Unwrap() error {
+	return e.Err
+}
+
+// ErrMarshal is returned by TypedProducer.Put if a value could not be marshaled
+type ErrMarshal struct {
+	Err error
+}
+
+func (e *ErrMarshal) Error() string {
+	return fmt.Sprintf("Unable to marshal record: %v", e.Err)
+}
+
+func (e *ErrMarshal) Unwrap() error {
+	return e.Err
+}
+
+// ErrJSONSizeExceeded is returned by PutJSON if the JSON encoding of the value is larger
+// than Config.MaxJSONSize
+type ErrJSONSizeExceeded struct {
+	Size    int
+	MaxSize int
+}
+
+func (e *ErrJSONSizeExceeded) Error() string {
+	return fmt.Sprintf("JSON encoding of %d bytes exceeds the maximum of %d bytes", e.Size, e.MaxSize)
+}
+
+// ErrInvalidJSON is returned by Put if the data of a user record to aggregate is not valid
+// JSON with the NDJSON AggregationFormat
+type ErrInvalidJSON struct {
+	UserRecord
+}
+
+func (e *ErrInvalidJSON) Error() string {
+	return "Invalid JSON record. NDJSON aggregation requires JSON documents"
+}
+
 type ErrRecordSizeExceeded struct {
 	UserRecord
 }
@@ -31,6 +315,16 @@ func (e *ErrRecordSizeExceeded) Error() string {
 // Failure record type for failures from Kinesis PutRecords request
 type FailureRecord struct {
 	Err error
+	// ErrorCode is the AWS error code of Err, e.g. ResourceNotFoundException. Will be the
+	// empty string if Err did not come from AWS
+	ErrorCode string
+	// Class is the class of Err given by Config.ErrorClassifier, e.g. ErrorThrottle for
+	// records still throttled after MaxRetries retries
+	Class ErrorClass
+	// Attempts is the number of times the PutRecords request was sent before failing
+	Attempts int
+	// StreamName is the name or ARN of the stream the records were put to
+	StreamName string
 	// The PartitionKey that was used in the kinesis.PutRecordsRequestEntry
 	PartitionKey string
 	// The ExplicitHashKey that was used in the kinesis.PutRecordsRequestEntry. Will be the
@@ -38,12 +332,89 @@ type FailureRecord struct {
 	ExplicitHashKey string
 	// UserRecords that were contained in the failed aggregated record request
 	UserRecords []UserRecord
+	// History are the failed attempts to put the record, oldest first, up to the last 32.
+	// Empty if the record failed before it was sent
+	History []Attempt
+}
+
+func newFailureRecord(record *AggregatedRecordRequest, err error, attempts int) *FailureRecord {
+	failure := &FailureRecord{
+		Err:          err,
+		ErrorCode:    errorCode(err),
+		Attempts:     attempts,
+		StreamName:   record.stream,
+		PartitionKey: aws.ToString(record.Entry.PartitionKey),
+		UserRecords:  unwrapUserRecords(record.UserRecords),
+		History:      append([]Attempt(nil), record.attempts...),
+	}
+	if record.Entry.ExplicitHashKey != nil {
+		failure.ExplicitHashKey = *record.Entry.ExplicitHashKey
+	}
+	return failure
 }
 
 func (e *FailureRecord) Error() string {
 	return e.Err.Error()
 }
 
+func (e *FailureRecord) Unwrap() error {
+	return e.Err
+}
+
+// PutRecordsEntryError is the error of a single record rejected in a PutRecords response.
+// It implements smithy.APIError.
+type PutRecordsEntryError struct {
+	Code    string
+	Message string
+}
+
+func (e *PutRecordsEntryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *PutRecordsEntryError) ErrorCode() string             { return e.Code }
+func (e *PutRecordsEntryError) ErrorMessage() string          { return e.Message }
+func (e *PutRecordsEntryError) ErrorFault() smithy.ErrorFault { return smithy.FaultUnknown }
+
+// ErrRequestTimeout is the error of a PutRecords request that did not complete within
+// Config.RequestTimeout. Err is the error returned by the client.
+type ErrRequestTimeout struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *ErrRequestTimeout) Error() string {
+	return fmt.Sprintf("PutRecords request timed out after %s: %v", e.Timeout, e.Err)
+}
+
+func (e *ErrRequestTimeout) Unwrap() error {
+	return e.Err
+}
+
+// ErrMaxRetriesExceeded is the error of records that were still failing after
+// Config.MaxRetries retries. Err is the last error.
+type ErrMaxRetriesExceeded struct {
+	Retries int
+	Err     error
+}
+
+func (e *ErrMaxRetriesExceeded) Error() string {
+	return fmt.Sprintf("Max retries exceeded (%d): %v", e.Retries, e.Err)
+}
+
+func (e *ErrMaxRetriesExceeded) Unwrap() error {
+	return e.Err
+}
+
+// errorCode returns the AWS error code of err or the empty string
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
 type DrainError struct {
 	Err error
 	// UserRecords in the buffer when drain attempt was made
@@ -54,6 +425,33 @@ func (e *DrainError) Error() string {
 	return e.Err.Error()
 }
 
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
+// isRejected reports whether err means that the put user record was not accepted. A
+// DrainError fails the records aggregated before the put one, which was still accepted.
+func isRejected(err error) bool {
+	_, drained := err.(*DrainError)
+	return err != nil && !drained
+}
+
+// ShutdownError is returned by Producer.Shutdown when the context expires before all
+// records could be delivered
+type ShutdownError struct {
+	Err error
+	// UserRecords that were not delivered before the deadline
+	UserRecords []UserRecord
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("Unable to deliver %d records before shutdown: %v", len(e.UserRecords), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
 type ShardBucketError struct {
 	UserRecord
 }
@@ -12,15 +12,19 @@ import (
 	"github.com/google/uuid"
 )
 
-func ExampleSimple() {
-	logger := &StdLogger{log.New(os.Stdout, "", log.LstdFlags)}
+func Example() {
+	logger := &StdLogger{Logger: log.New(os.Stdout, "", log.LstdFlags)}
 	client := kinesis.NewFromConfig(*aws.NewConfig())
-	pr := New(&Config{
-		StreamName:   "test",
-		BacklogCount: 2000,
-		Client:       client,
-		Logger:       logger,
-	})
+	pr, err := NewProducer(
+		WithStreamName("test"),
+		WithBacklogCount(2000),
+		WithClient(client),
+		WithLogger(logger),
+	)
+	if err != nil {
+		logger.Error("invalid producer configuration", err)
+		return
+	}
 
 	pr.Start()
 
@@ -47,7 +51,7 @@ func ExampleSimple() {
 }
 
 func ExampleShardMap() {
-	logger := &StdLogger{log.New(os.Stdout, "", log.LstdFlags)}
+	logger := &StdLogger{Logger: log.New(os.Stdout, "", log.LstdFlags)}
 	client := kinesis.NewFromConfig(*aws.NewConfig())
 	pr := New(&Config{
 		StreamName:           "test",
@@ -112,7 +116,7 @@ func newMyExampleUserRecord(key, val string) (*myExampleUserRecord, error) {
 }
 
 func ExampleUserRecord() {
-	logger := &StdLogger{log.New(os.Stdout, "", log.LstdFlags)}
+	logger := &StdLogger{Logger: log.New(os.Stdout, "", log.LstdFlags)}
 	client := kinesis.NewFromConfig(*aws.NewConfig())
 	pr := New(&Config{
 		StreamName:           "test",
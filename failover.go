@@ -0,0 +1,210 @@
+package producer
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailoverRegion        = "standby"
+	defaultFailoverErrorRate     = 0.5
+	defaultFailoverDuration      = 30 * time.Second
+	defaultFailoverProbeInterval = 30 * time.Second
+)
+
+// FailoverConfig configures the failover of the producer to a standby stream, e.g. in
+// another region, when the primary stream keeps failing, see Config.Failover. A PutRecords
+// request fails like for the circuit breaker, if it returns an error or every record of it
+// is rejected.
+type FailoverConfig struct {
+	// Client puts records to the standby stream, e.g. a kinesis.Client of another region.
+	// It is wrapped with Config.Middlewares. Required.
+	Client Putter
+
+	// Region names the standby in logs and events. Default to "standby".
+	Region string
+
+	// StreamName is the name or ARN of the standby stream. Default to the empty string,
+	// records are put to a stream of the same name as the one they were put to. The ARN of
+	// the primary stream names another region or account, so only its stream name is sent
+	// to the standby.
+	StreamName string
+
+	// ErrorRate fails over when the fraction of failed requests to the primary stream
+	// within Duration exceeds it. Between 0 and 1. Default to 0.5.
+	ErrorRate float64
+
+	// Duration is the period the error rate is computed over. Default to 30s.
+	Duration time.Duration
+
+	// ProbeInterval is the interval between two probes of the primary stream once failed
+	// over. A probe sends the next request to the primary stream, and fails back if it
+	// succeeds. Default to 30s.
+	ProbeInterval time.Duration
+
+	// OnFailover is called on failover and failback. It is called from the worker sending
+	// the request and must not block. Default to nil.
+	OnFailover func(FailoverEvent)
+}
+
+func (c *FailoverConfig) defaults() {
+	if c.Region == "" {
+		c.Region = defaultFailoverRegion
+	}
+	if c.ErrorRate == 0 {
+		c.ErrorRate = defaultFailoverErrorRate
+	}
+	if c.Duration <= 0 {
+		c.Duration = defaultFailoverDuration
+	}
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = defaultFailoverProbeInterval
+	}
+}
+
+// FailoverEvent describes a failover to the standby stream, or a failback to the primary
+// stream
+type FailoverEvent struct {
+	// Failback is true when requests are sent to the primary stream again
+	Failback bool
+	// Region of the standby
+	Region string
+	// ErrorRate of the primary stream that triggered the failover. Zero on failback
+	ErrorRate float64
+	Time      time.Time
+}
+
+// failover tracks the outcome of the requests to the primary stream and routes requests
+// to the standby once it failed over. A nil failover never fails over.
+type failover struct {
+	*FailoverConfig
+	// client is FailoverConfig.Client wrapped with Config.Middlewares
+	client Putter
+	logger Logger
+	clock  Clock
+
+	mu     sync.Mutex
+	active bool
+	// requests and failures to the primary stream in the current window
+	windowStart time.Time
+	requests    int
+	failures    int
+	// probeAt is the time of the next probe once failed over
+	probeAt time.Time
+	// probing is set while a probe is in flight
+	probing bool
+}
+
+func newFailover(config *Config) *failover {
+	if config.Failover == nil {
+		return nil
+	}
+	return &failover{
+		FailoverConfig: config.Failover,
+		client:         chain(config.Failover.Client, config.Middlewares),
+		logger:         config.Logger,
+		clock:          config.Clock,
+	}
+}
+
+// stream returns the standby stream of the primary stream
+func (f *failover) stream(primary string) string {
+	if f.StreamName != "" {
+		return f.StreamName
+	}
+	if isStreamARN(primary) {
+		// arn:aws:kinesis:region:account:stream/name
+		if i := strings.LastIndex(primary, "stream/"); i >= 0 {
+			return primary[i+len("stream/"):]
+		}
+	}
+	return primary
+}
+
+// isActive reports whether requests are sent to the standby
+func (f *failover) isActive() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+// route reports whether the next request must be sent to the standby, or whether it is
+// sent to the primary stream as a probe
+func (f *failover) route() (standby, probe bool) {
+	if f == nil {
+		return false, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.active {
+		return false, false
+	}
+	if !f.probing && !f.clock.Now().Before(f.probeAt) {
+		f.probing = true
+		return false, true
+	}
+	return true, false
+}
+
+// record records the outcome of a request to the primary stream
+func (f *failover) record(probe, failed bool) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	now := f.clock.Now()
+	if probe {
+		f.probing = false
+		if failed {
+			f.probeAt = now.Add(f.ProbeInterval)
+			f.mu.Unlock()
+			return
+		}
+		f.active = false
+		f.windowStart, f.requests, f.failures = now, 0, 0
+		f.mu.Unlock()
+		f.notify(FailoverEvent{Failback: true, Region: f.Region, Time: now})
+		return
+	}
+	if f.active {
+		// outcome of a request sent before the failover
+		f.mu.Unlock()
+		return
+	}
+	if f.windowStart.IsZero() {
+		f.windowStart = now
+	}
+	f.requests++
+	if failed {
+		f.failures++
+	}
+	if now.Sub(f.windowStart) < f.Duration {
+		f.mu.Unlock()
+		return
+	}
+	rate := float64(f.failures) / float64(f.requests)
+	f.windowStart, f.requests, f.failures = now, 0, 0
+	if rate <= f.ErrorRate {
+		f.mu.Unlock()
+		return
+	}
+	f.active = true
+	f.probeAt = now.Add(f.ProbeInterval)
+	f.mu.Unlock()
+	f.notify(FailoverEvent{Region: f.Region, ErrorRate: rate, Time: now})
+}
+
+func (f *failover) notify(event FailoverEvent) {
+	if event.Failback {
+		f.logger.Warn("failback", LogValue{"region", event.Region})
+	} else {
+		f.logger.Warn("failover", LogValue{"region", event.Region}, LogValue{"error_rate", event.ErrorRate})
+	}
+	if f.OnFailover != nil {
+		f.OnFailover(event)
+	}
+}
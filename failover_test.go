@@ -0,0 +1,137 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover(t *testing.T) {
+	var events []FailoverEvent
+	config := &Config{
+		Clock:  systemClock{},
+		Logger: &NopLogger{},
+		Failover: &FailoverConfig{
+			Client:        PutterFunc(nil),
+			Duration:      time.Millisecond,
+			ProbeInterval: time.Millisecond,
+			OnFailover:    func(e FailoverEvent) { events = append(events, e) },
+		},
+	}
+	config.Failover.defaults()
+	f := newFailover(config)
+
+	// 1 failure out of 2 requests does not exceed the error rate
+	f.record(false, true)
+	time.Sleep(time.Millisecond)
+	f.record(false, false)
+	require.False(t, f.isActive())
+
+	f.record(false, true)
+	time.Sleep(time.Millisecond)
+	f.record(false, true)
+	require.True(t, f.isActive())
+	require.Len(t, events, 1)
+	require.Equal(t, "standby", events[0].Region)
+	require.Equal(t, 1.0, events[0].ErrorRate)
+	standby, probe := f.route()
+	require.True(t, standby)
+	require.False(t, probe)
+
+	// a single probe at a time
+	time.Sleep(time.Millisecond)
+	standby, probe = f.route()
+	require.False(t, standby)
+	require.True(t, probe)
+	standby, _ = f.route()
+	require.True(t, standby)
+
+	// a failed probe postpones the failback
+	f.record(true, true)
+	require.True(t, f.isActive())
+	time.Sleep(time.Millisecond)
+	_, probe = f.route()
+	require.True(t, probe)
+	f.record(true, false)
+	require.False(t, f.isActive())
+	require.Len(t, events, 2)
+	require.True(t, events[1].Failback)
+	standby, probe = f.route()
+	require.False(t, standby)
+	require.False(t, probe)
+}
+
+func TestNilFailover(t *testing.T) {
+	var f *failover
+	f.record(false, true)
+	require.False(t, f.isActive())
+	standby, probe := f.route()
+	require.False(t, standby)
+	require.False(t, probe)
+}
+
+func TestFailoverStream(t *testing.T) {
+	f := &failover{FailoverConfig: &FailoverConfig{}}
+	require.Equal(t, "foo", f.stream("foo"))
+	require.Equal(t, "foo", f.stream("arn:aws:kinesis:us-east-1:123456789012:stream/foo"))
+	f.StreamName = "arn:aws:kinesis:eu-west-1:123456789012:stream/bar"
+	require.Equal(t, f.StreamName, f.stream("arn:aws:kinesis:us-east-1:123456789012:stream/foo"))
+}
+
+func TestProducerFailover(t *testing.T) {
+	primary := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		return nil, &types.ProvisionedThroughputExceededException{Message: aws.String("unavailable")}
+	})
+	var (
+		mu      sync.Mutex
+		streams []string
+		data    []string
+	)
+	standby := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		streams = append(streams, aws.ToString(input.StreamName))
+		out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+		for _, r := range input.Records {
+			data = append(data, string(r.Data))
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0")})
+		}
+		return out, nil
+	})
+	var events []FailoverEvent
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         primary,
+		Backoff:        &FixedBackoff{},
+		Failover: &FailoverConfig{
+			Client:        standby,
+			Region:        "eu-west-1",
+			StreamName:    "foo-standby",
+			Duration:      time.Nanosecond,
+			ProbeInterval: time.Hour,
+			OnFailover:    func(e FailoverEvent) { events = append(events, e) },
+		},
+	})
+	p.Start()
+	res, err := p.PutWithResult([]byte("hello"), "foo", WithoutAggregation())
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+	<-res.Done()
+	require.NoError(t, res.Result().Err)
+	require.True(t, p.Stats().FailedOver)
+	p.Stop()
+
+	require.Equal(t, []string{"foo-standby"}, streams)
+	require.Equal(t, []string{"hello"}, data)
+	require.Len(t, events, 1)
+	require.Equal(t, "eu-west-1", events[0].Region)
+}
@@ -0,0 +1,61 @@
+package producer
+
+import "time"
+
+const (
+	defaultMinFlushInterval = 100 * time.Millisecond
+	defaultMaxFlushInterval = 30 * time.Second
+)
+
+// adaptiveInterval adjusts the flush interval to the rate of puts. The interval aims at
+// flushing about BatchCount user records at a time: it shortens under high throughput to
+// bound latency and lengthens when idle to aggregate more records per request.
+type adaptiveInterval struct {
+	min, max time.Duration
+	// target number of user records per flush
+	target   int
+	current  time.Duration
+	lastPuts int64
+	lastTime time.Time
+}
+
+func newAdaptiveInterval(config *Config, now time.Time) *adaptiveInterval {
+	return &adaptiveInterval{
+		min:      config.MinFlushInterval,
+		max:      config.MaxFlushInterval,
+		target:   config.BatchCount,
+		current:  clampDuration(config.FlushInterval, config.MinFlushInterval, config.MaxFlushInterval),
+		lastTime: now,
+	}
+}
+
+// next returns the interval until the next flush given the total number of puts. The
+// interval moves halfway towards the ideal interval for the rate since the last call, to
+// smooth out bursts.
+func (a *adaptiveInterval) next(puts int64, now time.Time) time.Duration {
+	elapsed := now.Sub(a.lastTime)
+	count := puts - a.lastPuts
+	a.lastPuts, a.lastTime = puts, now
+	if elapsed <= 0 {
+		return a.current
+	}
+
+	ideal := a.max
+	if count > 0 {
+		rate := float64(count) / elapsed.Seconds()
+		ideal = time.Duration(float64(a.target) / rate * float64(time.Second))
+	}
+	ideal = clampDuration(ideal, a.min, a.max)
+	a.current = clampDuration((a.current+ideal)/2, a.min, a.max)
+	return a.current
+}
+
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,41 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	now := time.Now()
+	a := newAdaptiveInterval(&Config{
+		FlushInterval:    time.Second,
+		MinFlushInterval: 100 * time.Millisecond,
+		MaxFlushInterval: 8 * time.Second,
+		BatchCount:       500,
+	}, now)
+
+	// idle producers move towards the maximum
+	now = now.Add(time.Second)
+	require.Equal(t, 4500*time.Millisecond, a.next(0, now))
+	now = now.Add(4500 * time.Millisecond)
+	require.Equal(t, 6250*time.Millisecond, a.next(0, now))
+
+	// 500 records/s flush every second
+	var puts int64
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		puts += 500
+		a.next(puts, now)
+	}
+	require.InDelta(t, float64(time.Second), float64(a.current), float64(10*time.Millisecond))
+
+	// hot producers move towards the minimum
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		puts += 100000
+		a.next(puts, now)
+	}
+	require.InDelta(t, float64(100*time.Millisecond), float64(a.current), float64(time.Millisecond))
+}
@@ -0,0 +1,100 @@
+package producer
+
+import (
+	"container/list"
+	"math/big"
+	"sync"
+)
+
+// defaultHashCacheSize is the number of partition keys whose MD5 hash key is cached by
+// default
+const defaultHashCacheSize = 4096
+
+// Hasher maps the partition keys of user records without an explicit hash key to the hash
+// key that decides their shard. The hash key must be between 0 and 2^128 - 1 and must not
+// be modified by the caller.
+//
+// Kinesis hashes partition keys with MD5. Records mapped with any other Hasher are sent
+// with the hash key as their explicit hash key, so that Kinesis and the KCL agree on
+// their shard.
+type Hasher interface {
+	HashKey(partitionKey string) *big.Int
+}
+
+// The HasherFunc type is an adapter to allow the use of ordinary functions as Hasher.
+type HasherFunc func(partitionKey string) *big.Int
+
+// HashKey calls f(partitionKey).
+func (f HasherFunc) HashKey(partitionKey string) *big.Int { return f(partitionKey) }
+
+// MD5Hasher hashes partition keys with MD5, as Kinesis does.
+type MD5Hasher struct{}
+
+// HashKey returns the MD5 hash of the partition key as a 128-bit integer.
+func (MD5Hasher) HashKey(partitionKey string) *big.Int { return hashKey(partitionKey) }
+
+// NewCachedHasher returns a Hasher that caches the hash keys of the size most recently
+// used partition keys computed by hasher. It is safe for concurrent use.
+func NewCachedHasher(hasher Hasher, size int) Hasher {
+	return &cachedHasher{
+		hasher:  hasher,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+type cachedHasher struct {
+	hasher Hasher
+	size   int
+
+	mu sync.Mutex
+	// lru holds the cached hashEntry, most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type hashEntry struct {
+	partitionKey string
+	hashKey      *big.Int
+}
+
+func (h *cachedHasher) HashKey(partitionKey string) *big.Int {
+	h.mu.Lock()
+	if e, ok := h.entries[partitionKey]; ok {
+		h.lru.MoveToFront(e)
+		hk := e.Value.(*hashEntry).hashKey
+		h.mu.Unlock()
+		return hk
+	}
+	h.mu.Unlock()
+
+	// hash outside the lock. Concurrent misses of the same key add it once
+	hk := h.hasher.HashKey(partitionKey)
+	if h.size <= 0 {
+		return hk
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.entries[partitionKey]; !ok {
+		h.entries[partitionKey] = h.lru.PushFront(&hashEntry{partitionKey, hk})
+		if h.lru.Len() > h.size {
+			oldest := h.lru.Back()
+			h.lru.Remove(oldest)
+			delete(h.entries, oldest.Value.(*hashEntry).partitionKey)
+		}
+	}
+	return hk
+}
+
+// isMD5Hasher reports whether hasher maps partition keys like Kinesis does, so that
+// records do not need an explicit hash key. A nil Hasher hashes with MD5.
+func isMD5Hasher(hasher Hasher) bool {
+	switch h := hasher.(type) {
+	case nil, MD5Hasher, *MD5Hasher:
+		return true
+	case *cachedHasher:
+		return isMD5Hasher(h.hasher)
+	}
+	return false
+}
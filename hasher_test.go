@@ -0,0 +1,93 @@
+package producer
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/deaggregation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedHasher(t *testing.T) {
+	calls := map[string]int{}
+	hasher := NewCachedHasher(HasherFunc(func(pk string) *big.Int {
+		calls[pk]++
+		return hashKey(pk)
+	}), 2)
+
+	require.Equal(t, hashKey("foo"), hasher.HashKey("foo"))
+	require.Equal(t, hashKey("foo"), hasher.HashKey("foo"))
+	require.Equal(t, hashKey("bar"), hasher.HashKey("bar"))
+	require.Equal(t, map[string]int{"foo": 1, "bar": 1}, calls)
+
+	// foo was used more recently than bar, which is evicted
+	hasher.HashKey("foo")
+	hasher.HashKey("baz")
+	hasher.HashKey("foo")
+	hasher.HashKey("bar")
+	require.Equal(t, map[string]int{"foo": 1, "bar": 2, "baz": 1}, calls)
+}
+
+func TestIsMD5Hasher(t *testing.T) {
+	custom := HasherFunc(func(string) *big.Int { return big.NewInt(0) })
+	require.True(t, isMD5Hasher(nil))
+	require.True(t, isMD5Hasher(MD5Hasher{}))
+	require.True(t, isMD5Hasher(NewCachedHasher(MD5Hasher{}, 1)))
+	require.False(t, isMD5Hasher(custom))
+	require.False(t, isMD5Hasher(NewCachedHasher(custom, 1)))
+}
+
+func TestShardMapHasher(t *testing.T) {
+	shards, _, _ := StaticGetShardsFunc(2)(nil)
+	last := *shards[1].HashKeyRange.EndingHashKey
+	shardMap := NewShardMap(shards, maxAggregationCount)
+	shardMap.setHasher(HasherFunc(func(string) *big.Int {
+		hk, _ := new(big.Int).SetString(last, 10)
+		return hk
+	}))
+
+	// records are mapped to the last shard whatever their partition key
+	for _, pk := range []string{"foo", "bar", "baz"} {
+		drained, err := shardMap.Put(newTestUserRecord(pk, "", []byte("hello")))
+		require.NoError(t, err)
+		require.Nil(t, drained)
+	}
+	require.Equal(t, map[string]int{aws.ToString(shards[0].ShardId): 0, aws.ToString(shards[1].ShardId): 3}, shardMap.Counts())
+
+	drained, errs := shardMap.Drain()
+	require.Empty(t, errs)
+	require.Len(t, drained, 1)
+	agg, err := deaggregation.Unmarshal(drained[0].Entry.Data)
+	require.NoError(t, err)
+	// the hash key is sent as the explicit hash key of the user records
+	require.Equal(t, []string{last}, agg.ExplicitHashKeyTable)
+	for _, record := range agg.Records {
+		require.Equal(t, uint64(0), record.GetExplicitHashKeyIndex())
+	}
+}
+
+func TestProducerHasher(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Hasher:         HasherFunc(func(string) *big.Int { return big.NewInt(42) }),
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.PutWithExplicitHashKey([]byte("hello"), "bar", "7"))
+	p.Stop()
+
+	require.ElementsMatch(t, []string{"42", "7"}, client.hashKeys)
+}
@@ -0,0 +1,122 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// healthMaxConsecutiveFailures is the number of failed requests in a row after which
+	// the producer is unhealthy
+	healthMaxConsecutiveFailures = 5
+	// healthMaxSaturation is the fraction of the backlog or MaxBufferedBytes in use above
+	// which the producer is unhealthy
+	healthMaxSaturation = 0.9
+	// healthMaxShardMapAge is the number of ShardRefreshIntervals without a successful
+	// refresh after which the shard map is stale
+	healthMaxShardMapAge = 3
+)
+
+// HealthReport is the state of the checks of Producer.Health. Problems lists the checks
+// that failed; the other fields let callers apply their own thresholds.
+type HealthReport struct {
+	// Healthy reports whether every check passed
+	Healthy bool
+	// Problems describes the failed checks
+	Problems []string
+	// Stopped reports whether the producer was stopped
+	Stopped bool
+	// Circuit is the state of the circuit breaker. Always closed if disabled
+	Circuit CircuitState
+	// ConsecutiveFailures is the number of PutRecords requests in a row that returned an
+	// error or had all their records rejected, whether or not the circuit breaker is
+	// enabled
+	ConsecutiveFailures int
+	// BacklogSaturation is the fraction of the backlog, or of MaxBufferedBytes if it is
+	// set and fuller, in use. Not checked once the producer is stopped
+	BacklogSaturation float64
+	// ShardMapAge is the time since the shards of the streams were last refreshed
+	// successfully. Zero without ShardRefreshInterval
+	ShardMapAge time.Duration
+}
+
+// ErrUnhealthy is returned by Producer.Healthy when one of the checks of Producer.Health
+// failed
+type ErrUnhealthy struct {
+	Problems []string
+}
+
+func (e *ErrUnhealthy) Error() string {
+	return "Producer is unhealthy: " + strings.Join(e.Problems, ", ")
+}
+
+// Health checks the producer is stopped, its circuit breaker is open, its last requests
+// failed, its backlog or MaxBufferedBytes is more than 90% full, or its shard map was not
+// refreshed for 3 ShardRefreshIntervals. This method is thread-safe.
+func (p *Producer) Health() HealthReport {
+	report := HealthReport{
+		Circuit:             p.pool.breaker.currentState(),
+		ConsecutiveFailures: int(atomic.LoadInt64(&p.pool.stats.consecutiveFailures)),
+	}
+	select {
+	case <-p.stopped:
+		report.Stopped = true
+		report.Problems = append(report.Problems, "producer is stopped")
+	default:
+	}
+	if report.Circuit == CircuitOpen {
+		report.Problems = append(report.Problems, "circuit breaker is open")
+	}
+	if report.ConsecutiveFailures >= healthMaxConsecutiveFailures {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d requests failed in a row", report.ConsecutiveFailures))
+	}
+	if c := p.backlog.cap(); c > 0 {
+		report.BacklogSaturation = float64(p.backlog.len()) / float64(c)
+	}
+	if p.MaxBufferedBytes > 0 {
+		report.BacklogSaturation = max(report.BacklogSaturation, float64(p.buffered.size())/float64(p.MaxBufferedBytes))
+	}
+	// Stop holds the backlog while the producer shuts down
+	if report.BacklogSaturation > healthMaxSaturation && !report.Stopped {
+		report.Problems = append(report.Problems, fmt.Sprintf("backlog is %.0f%% full", 100*report.BacklogSaturation))
+	}
+	if p.ShardRefreshInterval > 0 {
+		refreshed := time.Unix(0, atomic.LoadInt64(&p.pool.stats.shardsRefreshed))
+		report.ShardMapAge = p.Clock.Now().Sub(refreshed)
+		if report.ShardMapAge > healthMaxShardMapAge*p.ShardRefreshInterval {
+			report.Problems = append(report.Problems, fmt.Sprintf("shard map not refreshed for %s", report.ShardMapAge.Round(time.Second)))
+		}
+	}
+	report.Healthy = len(report.Problems) == 0
+	return report
+}
+
+// Healthy returns an *ErrUnhealthy listing the problems found by Health, or nil if there is
+// none. This method is thread-safe.
+func (p *Producer) Healthy() error {
+	if report := p.Health(); !report.Healthy {
+		return &ErrUnhealthy{Problems: report.Problems}
+	}
+	return nil
+}
+
+// HealthHandler returns an http.Handler that renders Health as JSON, with a 503 status
+// code if the producer is unhealthy, e.g. for Kubernetes readiness probes:
+//
+//	http.Handle("/readyz", p.HealthHandler())
+func (p *Producer) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := p.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			p.Logger.Error("health handler", err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package producer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	// the producer is not started and every record bypasses aggregation so the first Put
+	// fills the backlog
+	p := New(&Config{
+		StreamName:           "foo",
+		BacklogCount:         1,
+		AggregateBatchSize:   1,
+		ShardRefreshInterval: time.Minute,
+		Logger:               &NopLogger{},
+		Client:               &clientMock{incoming: make(map[int][]string)},
+	})
+	require.NoError(t, p.Healthy())
+	report := p.Health()
+	require.True(t, report.Healthy)
+	require.Equal(t, CircuitClosed, report.Circuit)
+	require.Less(t, report.ShardMapAge, time.Minute)
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	atomic.StoreInt64(&p.pool.stats.consecutiveFailures, 5)
+	atomic.StoreInt64(&p.pool.stats.shardsRefreshed, time.Now().Add(-time.Hour).UnixNano())
+	report = p.Health()
+	require.False(t, report.Healthy)
+	require.Equal(t, 1.0, report.BacklogSaturation)
+	require.Equal(t, 5, report.ConsecutiveFailures)
+	require.GreaterOrEqual(t, report.ShardMapAge, time.Hour)
+	require.Equal(t, []string{
+		"5 requests failed in a row",
+		"backlog is 100% full",
+		"shard map not refreshed for 1h0m0s",
+	}, report.Problems)
+
+	err := p.Healthy()
+	require.IsType(t, &ErrUnhealthy{}, err)
+	require.Equal(t, report.Problems, err.(*ErrUnhealthy).Problems)
+}
+
+func TestHealthStopped(t *testing.T) {
+	p := New(&Config{
+		StreamName: "foo",
+		Logger:     &NopLogger{},
+		Client:     &clientMock{incoming: make(map[int][]string)},
+	})
+	p.Start()
+	p.Stop()
+	report := p.Health()
+	require.True(t, report.Stopped)
+	require.Equal(t, []string{"producer is stopped"}, report.Problems)
+}
+
+func TestHealthHandler(t *testing.T) {
+	p := New(&Config{
+		StreamName: "foo",
+		Logger:     &NopLogger{},
+		Client:     &clientMock{incoming: make(map[int][]string)},
+	})
+	rec := httptest.NewRecorder()
+	p.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	atomic.StoreInt64(&p.pool.stats.consecutiveFailures, 10)
+	rec = httptest.NewRecorder()
+	p.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Equal(t, false, report["Healthy"])
+	require.Equal(t, "closed", report["Circuit"])
+	require.Equal(t, 10.0, report["ConsecutiveFailures"])
+}
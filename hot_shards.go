@@ -0,0 +1,91 @@
+package producer
+
+import "time"
+
+// hotShardInterval is the period the utilization of the shards is measured over, see
+// Config.HotShardThreshold
+const hotShardInterval = 10 * time.Second
+
+// HotShard describes a shard whose utilization exceeded Config.HotShardThreshold
+type HotShard struct {
+	Stream  string
+	ShardID string
+	// Utilization is the fraction of the per shard limits of 1000 records/s and 1MiB/s
+	// used by the producer over the last interval
+	Utilization float64
+	// Throttles is the number of kinesis records the shard throttled over the last
+	// interval
+	Throttles int64
+}
+
+// hotShards reports the shards whose utilization exceeds the threshold
+type hotShards struct {
+	threshold  float64
+	stats      *stats
+	logger     Logger
+	onHotShard func(HotShard)
+	// counters of each stream and shard at the last step
+	last       map[string]StreamStats
+	measuredAt time.Time
+}
+
+func newHotShards(config *Config, stats *stats) *hotShards {
+	if config.HotShardThreshold <= 0 {
+		return nil
+	}
+	return &hotShards{
+		threshold:  config.HotShardThreshold,
+		stats:      stats,
+		logger:     config.Logger,
+		onHotShard: config.OnHotShard,
+		measuredAt: config.Clock.Now(),
+	}
+}
+
+// step measures the utilization of the shards since the last step and reports the hot ones
+func (h *hotShards) step(now time.Time) []HotShard {
+	streams := h.stats.streamStats()
+	elapsed := now.Sub(h.measuredAt)
+	last := h.last
+	h.last, h.measuredAt = streams, now
+	if elapsed <= 0 {
+		return nil
+	}
+
+	var hot []HotShard
+	for stream, stats := range streams {
+		for shardID, shard := range stats.Shards {
+			if shardID == "" {
+				// throttled records of streams without shards
+				continue
+			}
+			prev := last[stream].Shards[shardID]
+			u := usage{
+				records:   shard.Records - prev.Records,
+				bytes:     shard.Bytes - prev.Bytes,
+				throttles: shard.Throttles - prev.Throttles,
+				elapsed:   elapsed,
+			}
+			if utilization := u.utilization(1); utilization > h.threshold {
+				hot = append(hot, HotShard{
+					Stream:      stream,
+					ShardID:     shardID,
+					Utilization: utilization,
+					Throttles:   u.throttles,
+				})
+			}
+		}
+	}
+	for _, shard := range hot {
+		h.logger.Warn("hot shard",
+			LogValue{"stream", shard.Stream},
+			LogValue{"shard_id", shard.ShardID},
+			LogValue{"utilization", shard.Utilization},
+			LogValue{"throttles", shard.Throttles},
+		)
+		if h.onHotShard != nil {
+			h.onHotShard(shard)
+		}
+	}
+	return hot
+}
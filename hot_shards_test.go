@@ -0,0 +1,58 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotShards(t *testing.T) {
+	var reported []HotShard
+	s := &stats{}
+	config := &Config{
+		Logger:            &NopLogger{},
+		Clock:             systemClock{},
+		HotShardThreshold: 0.5,
+		OnHotShard:        func(shard HotShard) { reported = append(reported, shard) },
+	}
+	h := newHotShards(config, s)
+	now := h.measuredAt
+
+	// shard 1 used at 60% of its records limit, shard 2 at 10% of its bytes limit
+	s.sent("foo", map[string]*shardCounters{
+		"shardId-1": {records: 600 * 10},
+		"shardId-2": {records: 1, bytes: shardBytesPerSecond},
+		"":          {throttles: 1000},
+	}, time.Millisecond)
+	now = now.Add(10 * time.Second)
+	hot := h.step(now)
+	require.Equal(t, []HotShard{{Stream: "foo", ShardID: "shardId-1", Utilization: 0.6}}, hot)
+	require.Equal(t, hot, reported)
+
+	// the utilization is measured since the last step
+	s.sent("foo", map[string]*shardCounters{"shardId-1": {records: 100, throttles: 5}}, time.Millisecond)
+	now = now.Add(10 * time.Second)
+	require.Empty(t, h.step(now))
+
+	require.Nil(t, newHotShards(&Config{}, s))
+}
+
+func TestStreamStats(t *testing.T) {
+	s := &stats{}
+	s.sent("foo", map[string]*shardCounters{
+		"shardId-1": {records: 2, bytes: 20},
+		"shardId-2": {throttles: 1},
+	}, time.Second)
+	s.sent("foo", map[string]*shardCounters{"shardId-1": {records: 1, bytes: 10}}, 3*time.Second)
+
+	require.Equal(t, map[string]StreamStats{
+		"foo": {
+			ShardStats: ShardStats{Records: 3, Bytes: 30, Throttles: 1, Requests: 2, Latency: 2 * time.Second},
+			Shards: map[string]ShardStats{
+				"shardId-1": {Records: 3, Bytes: 30, Requests: 2, Latency: 2 * time.Second},
+				"shardId-2": {Throttles: 1, Requests: 1, Latency: time.Second},
+			},
+		},
+	}, s.streamStats())
+}
@@ -0,0 +1,69 @@
+package producer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash/crc32"
+
+	"github.com/achunariov/kinesis-producer/wire"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns the CRC-32C of data, see Config.VerifyIntegrity
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+// verifyChecksums returns an ErrChecksumMismatch if the data of a user record changed
+// since it was put in the aggregator
+func (a *Aggregator) verifyChecksums() error {
+	if !a.verify {
+		return nil
+	}
+	for i, userRecord := range a.buf {
+		if checksum(userRecord.Data()) != a.checksums[i] {
+			return &ErrChecksumMismatch{UserRecord: unwrapUserRecord(userRecord)}
+		}
+	}
+	return nil
+}
+
+// corrupt reports whether the data of the record changed since it was built. Aggregated
+// records are verified against their MD5 trailer, plain records against their checksum.
+func (r *AggregatedRecordRequest) corrupt() bool {
+	data := r.Entry.Data
+	if r.plain {
+		return checksum(data) != r.checksum
+	}
+	if wire.IsFramed(data) {
+		frame, err := wire.Decode(data)
+		if err != nil {
+			return true
+		}
+		data = frame.Payload
+	}
+	if !bytes.HasPrefix(data, magicNumber) || len(data) < len(magicNumber)+md5.Size {
+		// not built by an Aggregator
+		return false
+	}
+	sum := md5.Sum(data[len(magicNumber) : len(data)-md5.Size])
+	return !bytes.Equal(sum[:], data[len(data)-md5.Size:])
+}
+
+// intact fails the records whose data changed since they were built, with
+// Config.VerifyIntegrity, and returns the records left to send
+func (wp *WorkerPool) intact(records []*AggregatedRecordRequest, attempts int) []*AggregatedRecordRequest {
+	if !wp.VerifyIntegrity {
+		return records
+	}
+	out := records[:0]
+	for _, record := range records {
+		if record.corrupt() {
+			wp.fail(record, &ErrChecksumMismatch{UserRecord: unwrapUserRecord(record.UserRecords[0])}, attempts)
+			continue
+		}
+		out = append(out, record)
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/achunariov/kinesis-producer/envelope"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyIntegrity(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:      "foo",
+		MaxConnections:  1,
+		FlushInterval:   time.Hour,
+		Logger:          &NopLogger{},
+		Client:          client,
+		VerifyIntegrity: true,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	defer p.Stop()
+
+	// the application reuses the buffers after Put
+	aggregated, plain := []byte("hello"), []byte("world")
+	aggregatedFuture, err := p.PutWithResult(aggregated, "foo")
+	require.NoError(t, err)
+	plainFuture, err := p.PutWithResult(plain, "bar", WithoutAggregation())
+	require.NoError(t, err)
+	copy(aggregated, "HELLO")
+	copy(plain, "WORLD")
+	require.NoError(t, p.Flush(context.Background()))
+
+	var mismatch *ErrChecksumMismatch
+	require.ErrorAs(t, aggregatedFuture.Result().Err, &mismatch)
+	require.Equal(t, "foo", mismatch.PartitionKey())
+	require.ErrorAs(t, plainFuture.Result().Err, &mismatch)
+	require.Equal(t, "bar", mismatch.PartitionKey())
+	for i := 0; i < 2; i++ {
+		require.ErrorAs(t, <-failures, &mismatch)
+	}
+	// corrupt records are not sent
+	require.Empty(t, client.data)
+}
+
+func TestAggregatedRecordCorrupt(t *testing.T) {
+	a := NewAggregator(nil)
+	a.Put(NewDataRecord([]byte("hello"), "foo"))
+	a.Put(NewDataRecord([]byte("world"), "bar"))
+	record, err := a.Drain()
+	require.NoError(t, err)
+	require.False(t, record.corrupt())
+
+	record.Entry.Data[len(magicNumber)+2] ^= 1
+	require.True(t, record.corrupt())
+
+	// records that were not built by an Aggregator are not verified
+	require.False(t, NewAggregatedRecordRequest([]byte("hello"), aws.String("foo"), nil, nil).corrupt())
+}
+
+func TestEnvelopeChecksum(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Envelope:       &EnvelopeConfig{Checksum: envelope.SHA256},
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Len(t, client.data, 1)
+	e, err := envelope.Decode(client.data[0])
+	require.NoError(t, err)
+	require.Equal(t, "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", e.Checksum)
+
+	c := &Config{StreamName: "foo", Client: &clientMock{}, Envelope: &EnvelopeConfig{Checksum: 9}}
+	c.defaults()
+	require.EqualError(t, c.validate(), "kinesis: unknown Envelope.Checksum")
+}
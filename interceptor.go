@@ -0,0 +1,39 @@
+package producer
+
+// RecordInterceptor transforms a user record at Put time, e.g. to enrich it, redact fields
+// or wrap it in an envelope, see Config.Interceptors. It returns the record to put, which
+// may be userRecord itself, or nil to drop the record. A returned error fails the Put. It
+// must be safe for concurrent use.
+type RecordInterceptor func(userRecord UserRecord) (UserRecord, error)
+
+// intercept applies Config.Interceptors to userRecord. It returns nil if an interceptor
+// dropped the record, resolving the future of a record put with PutWithResult with an
+// *ErrRecordDropped.
+func (p *Producer) intercept(userRecord UserRecord) (UserRecord, error) {
+	if len(p.Interceptors) == 0 {
+		return userRecord, nil
+	}
+	// records put with PutWithResult or PutWithContext keep their future and context
+	tracked, ok := userRecord.(*trackedRecord)
+	if ok {
+		userRecord = tracked.UserRecord
+	}
+	for _, interceptor := range p.Interceptors {
+		intercepted, err := interceptor(userRecord)
+		if err != nil {
+			return nil, &ErrIntercepted{UserRecord: userRecord, Err: err}
+		}
+		if intercepted == nil {
+			if ok {
+				resolveUserRecords([]UserRecord{tracked}, PutResult{Err: &ErrRecordDropped{unwrapUserRecord(userRecord)}})
+			}
+			return nil, nil
+		}
+		userRecord = intercepted
+	}
+	if ok {
+		tracked.UserRecord = userRecord
+		return tracked, nil
+	}
+	return userRecord, nil
+}
@@ -0,0 +1,86 @@
+package producer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptors(t *testing.T) {
+	errSecret := errors.New("secret")
+	var streams []string
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("2")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		StreamRouter: func(userRecord UserRecord) string {
+			// records are routed after they were intercepted
+			streams = append(streams, string(userRecord.Data()))
+			return ""
+		},
+		Interceptors: []RecordInterceptor{
+			func(userRecord UserRecord) (UserRecord, error) {
+				switch {
+				case bytes.Equal(userRecord.Data(), []byte("drop")):
+					return nil, nil
+				case bytes.Equal(userRecord.Data(), []byte("secret")):
+					return nil, errSecret
+				}
+				return userRecord, nil
+			},
+			func(userRecord UserRecord) (UserRecord, error) {
+				data := append([]byte("v1:"), userRecord.Data()...)
+				return NewDataRecord(data, userRecord.PartitionKey()), nil
+			},
+		},
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("drop"), "foo"))
+
+	err := p.Put([]byte("secret"), "foo")
+	var intercepted *ErrIntercepted
+	require.ErrorAs(t, err, &intercepted)
+	require.ErrorIs(t, err, errSecret)
+	require.Equal(t, []byte("secret"), intercepted.Data())
+
+	future, err := p.PutWithResult([]byte("drop"), "foo")
+	require.NoError(t, err)
+	select {
+	case <-future.Done():
+		var dropped *ErrRecordDropped
+		require.ErrorAs(t, future.Result().Err, &dropped)
+		require.Equal(t, []byte("drop"), dropped.Data())
+	default:
+		t.Fatal("future of a dropped record must be resolved")
+	}
+
+	future, err = p.PutWithResult([]byte("world"), "bar", WithoutAggregation())
+	require.NoError(t, err)
+	p.Stop()
+
+	require.NoError(t, future.Result().Err)
+	require.Equal(t, []string{"v1:hello", "v1:world"}, streams)
+	require.ElementsMatch(t, [][]byte{[]byte("v1:hello"), []byte("v1:world")}, client.data)
+	require.ElementsMatch(t, []string{"foo", "bar"}, client.incoming[0])
+}
@@ -0,0 +1,74 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// maxPooledJSONBuffer is the capacity above which the buffer of a jsonEncoder is not
+// returned to the pool, so that a rare large value does not pin its memory
+const maxPooledJSONBuffer = 64 << 10
+
+// jsonEncoder is a json.Encoder writing to a buffer reused across values
+type jsonEncoder struct {
+	buf jsonBuffer
+	enc *json.Encoder
+}
+
+// jsonBuffer is a bytes.Buffer failing writes that would grow the encoding past maxSize
+type jsonBuffer struct {
+	bytes.Buffer
+	maxSize int
+}
+
+func (b *jsonBuffer) Write(p []byte) (int, error) {
+	// json.Encoder writes each value at once, terminated by a newline
+	if size := b.Len() + len(p) - 1; b.maxSize > 0 && size > b.maxSize {
+		return 0, &ErrJSONSizeExceeded{Size: size, MaxSize: b.maxSize}
+	}
+	return b.Buffer.Write(p)
+}
+
+var jsonEncoderPool = sync.Pool{New: func() any {
+	e := new(jsonEncoder)
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}}
+
+// marshalJSON encodes v like json.Marshal with a pooled encoder. If maxSize is positive,
+// encodings larger than maxSize fail with *ErrJSONSizeExceeded before they are copied.
+func marshalJSON(v any, maxSize int) ([]byte, error) {
+	e := jsonEncoderPool.Get().(*jsonEncoder)
+	e.buf.maxSize = maxSize
+	if err := e.enc.Encode(v); err != nil {
+		// a json.Encoder keeps failing after a failed write, it is not reused
+		return nil, err
+	}
+	defer func() {
+		if e.buf.Cap() <= maxPooledJSONBuffer {
+			e.buf.Reset()
+			jsonEncoderPool.Put(e)
+		}
+	}()
+	// the record owns a copy of the exact size, without the trailing newline
+	b := e.buf.Bytes()
+	return append(make([]byte, 0, len(b)-1), b[:len(b)-1]...), nil
+}
+
+// PutJSON encodes v as JSON and puts it like PutWithContext, without the intermediate
+// copies of json.Marshal: encoders and their buffers are pooled and the record data is
+// allocated at its final size. Returns *ErrMarshal if v could not be encoded, or
+// *ErrJSONSizeExceeded if the encoding exceeds Config.MaxJSONSize. This method is
+// thread-safe.
+func (p *Producer) PutJSON(ctx context.Context, v any, partitionKey string, opts ...PutOption) error {
+	data, err := marshalJSON(v, p.MaxJSONSize)
+	if err != nil {
+		if e, ok := err.(*ErrJSONSizeExceeded); ok {
+			return e
+		}
+		return &ErrMarshal{Err: err}
+	}
+	return p.PutWithContext(ctx, data, partitionKey, opts...)
+}
@@ -0,0 +1,71 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	v := map[string]any{"id": 1, "html": "<b>"}
+	expected, err := json.Marshal(v)
+	require.NoError(t, err)
+	data, err := marshalJSON(v, 0)
+	require.NoError(t, err)
+	// encoded like json.Marshal, HTML escaped and without a trailing newline
+	require.Equal(t, expected, data)
+	require.Equal(t, len(data), cap(data))
+
+	// the data is not overwritten by the next value of the pooled encoder
+	_, err = marshalJSON("other", 0)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+
+	data, err = marshalJSON("hello", 7)
+	require.NoError(t, err)
+	require.Equal(t, `"hello"`, string(data))
+	_, err = marshalJSON("hello!", 7)
+	require.Equal(t, &ErrJSONSizeExceeded{Size: 8, MaxSize: 7}, err)
+
+	_, err = marshalJSON(make(chan int), 0)
+	require.Error(t, err)
+}
+
+func TestPutJSON(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		MaxJSONSize:    32,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	ctx := context.Background()
+	require.NoError(t, p.PutJSON(ctx, typedEvent{ID: "1", Name: "hello"}, "1", WithoutAggregation()))
+	err := p.PutJSON(ctx, typedEvent{ID: "2", Name: strings.Repeat("a", 32)}, "2")
+	require.IsType(t, &ErrJSONSizeExceeded{}, err)
+	require.IsType(t, &ErrMarshal{}, p.PutJSON(ctx, make(chan int), "3"))
+	require.NoError(t, p.Flush(ctx))
+
+	require.Equal(t, []string{"1"}, client.incoming[0])
+	require.Equal(t, [][]byte{[]byte(`{"id":"1","name":"hello"}`)}, client.data)
+
+	c := &Config{StreamName: "foo", Client: &clientMock{}, MaxJSONSize: -1}
+	c.defaults()
+	require.EqualError(t, c.validate(), "kinesis: MaxJSONSize must not be negative")
+}
@@ -0,0 +1,58 @@
+package producer
+
+import (
+	"context"
+
+	"github.com/achunariov/kinesis-producer/claimcheck"
+)
+
+// LargeRecordStore stores the payload of user records larger than
+// Config.LargeRecordThreshold outside of Kinesis. A pointer record with the returned
+// claim check is put in place of the user record, see the claimcheck package.
+//
+// Store is called synchronously by Put.
+type LargeRecordStore interface {
+	Store(ctx context.Context, data []byte) (*claimcheck.ClaimCheck, error)
+}
+
+// claimCheckRecord is the pointer record put in place of a user record that was offloaded
+// to the LargeRecordStore. It uses the partition and explicit hash key of the user record.
+type claimCheckRecord struct {
+	UserRecord
+	data []byte
+}
+
+func (r *claimCheckRecord) Data() []byte { return r.data }
+func (r *claimCheckRecord) Size() int    { return len(r.data) }
+
+// offloads reports whether the user record must be offloaded to the LargeRecordStore.
+// Records whose data looks like a pointer record are offloaded whatever their size, so
+// that consumers do not mistake them for one.
+func (p *Producer) offloads(userRecord UserRecord, recordSize int) bool {
+	if p.LargeRecordStore == nil || len(userRecord.PartitionKey()) > 256 {
+		return false
+	}
+	if recordSize <= p.LargeRecordThreshold && !claimcheck.IsClaimCheck(userRecord.Data()) {
+		return false
+	}
+	// pointer records are never offloaded, even with a threshold smaller than a claim check
+	_, ok := userRecord.(*claimCheckRecord)
+	return !ok
+}
+
+// offload stores the payload of the user record and returns the pointer record to put
+func (p *Producer) offload(userRecord UserRecord) (UserRecord, error) {
+	ctx := context.Background()
+	if r, ok := userRecord.(*trackedRecord); ok {
+		ctx = r.ctx
+	}
+	c, err := p.LargeRecordStore.Store(ctx, userRecord.Data())
+	if err != nil {
+		return nil, &ErrLargeRecordStore{UserRecord: unwrapUserRecord(userRecord), Err: err}
+	}
+	data, err := claimcheck.Encode(c)
+	if err != nil {
+		return nil, &ErrLargeRecordStore{UserRecord: unwrapUserRecord(userRecord), Err: err}
+	}
+	return &claimCheckRecord{UserRecord: userRecord, data: data}, nil
+}
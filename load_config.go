@@ -0,0 +1,198 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables read by LoadConfig
+const envPrefix = "KINESIS_PRODUCER_"
+
+var (
+	overflowPolicies = map[string]OverflowPolicy{
+		"block":       OverflowBlock,
+		"drop_newest": OverflowDropNewest,
+		"drop_oldest": OverflowDropOldest,
+		"error":       OverflowError,
+		"spill":       OverflowSpill,
+	}
+	backends = map[string]Backend{
+		"kinesis":  BackendKinesis,
+		"firehose": BackendFirehose,
+	}
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// LoadConfig reads a Config from the JSON or YAML file at path, depending on its
+// extension, and then from the environment, and validates it. All the problems found by
+// Config.Validate are returned at once. The settings that are not
+// functions or interfaces can be loaded: strings, numbers, booleans, durations like "5s",
+// OverflowPolicy ("block", "drop_newest", "drop_oldest", "error" or "spill") and Backend
+// ("kinesis" or "firehose"). They are the fields of Config in snake case in the file, e.g.
+// batch_size, and in upper snake case prefixed with KINESIS_PRODUCER_ in the environment,
+// e.g. KINESIS_PRODUCER_BATCH_SIZE, which takes precedence. An empty path only reads the
+// environment.
+//
+// The Client, or PutterFactory, and the other settings that can not be loaded are set on
+// the returned Config before it is passed to New.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	// the Client is set by the application afterwards
+	check := *c
+	if check.Client == nil && check.PutterFactory == nil && check.DryRun == nil {
+		check.Client = PutterFunc(nil)
+	}
+	if errs := check.Validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+// loadFile sets the settings of the JSON or YAML file at path
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("kinesis: %w", err)
+	}
+	settings := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		// numbers are kept as written so that large integers are not rounded
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&settings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("kinesis: unknown config file extension %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("kinesis: %s: %w", path, err)
+	}
+	fields := loadableFields()
+	for key, value := range settings {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("kinesis: %s: unknown setting %q", path, key)
+		}
+		switch value.(type) {
+		case string, bool, int, float64, json.Number:
+		default:
+			return fmt.Errorf("kinesis: %s: invalid %s: %v", path, key, value)
+		}
+		if err := setField(c, field, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("kinesis: %s: invalid %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// loadEnv sets the settings of the environment variables returned by lookup
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	for key, field := range loadableFields() {
+		name := envPrefix + strings.ToUpper(key)
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(c, field, value); err != nil {
+			return fmt.Errorf("kinesis: invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// loadableFields returns the fields of Config that can be loaded, keyed by their snake
+// case name
+func loadableFields() map[string]reflect.StructField {
+	t := reflect.TypeOf(Config{})
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch field.Type.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+			fields[snakeCase(field.Name)] = field
+		}
+	}
+	return fields
+}
+
+// setField parses value into the field of c
+func setField(c *Config, field reflect.StructField, value string) error {
+	v := reflect.ValueOf(c).Elem().FieldByIndex(field.Index)
+	switch {
+	case field.Type == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case field.Type == reflect.TypeOf(OverflowPolicy(0)):
+		policy, ok := overflowPolicies[value]
+		if !ok {
+			return fmt.Errorf("unknown overflow policy %q", value)
+		}
+		v.SetInt(int64(policy))
+	case field.Type == reflect.TypeOf(Backend(0)):
+		backend, ok := backends[value]
+		if !ok {
+			return fmt.Errorf("unknown backend %q", value)
+		}
+		v.SetInt(int64(backend))
+	case field.Type.Kind() == reflect.String:
+		v.SetString(value)
+	case field.Type.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case field.Type.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	}
+	return nil
+}
+
+// snakeCase converts a field name to snake case, keeping acronyms together, e.g.
+// StreamARN to stream_arn
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
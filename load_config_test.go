@@ -0,0 +1,85 @@
+package producer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"StreamName":            "stream_name",
+		"StreamARN":             "stream_arn",
+		"AggregatePayloadUnits": "aggregate_payload_units",
+		"MaxRecordAge":          "max_record_age",
+	} {
+		require.Equal(t, want, snakeCase(name))
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "producer.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+stream_name: events
+batch_size: 1048576
+flush_interval: 250ms
+overflow_policy: drop_oldest
+hot_shard_threshold: 0.8
+ordered_delivery: true
+`), 0o644))
+
+	t.Setenv("KINESIS_PRODUCER_BATCH_COUNT", "100")
+	t.Setenv("KINESIS_PRODUCER_FLUSH_INTERVAL", "1s")
+	c, err := LoadConfig(yamlPath)
+	require.NoError(t, err)
+	require.Equal(t, "events", c.StreamName)
+	require.Equal(t, 1048576, c.BatchSize)
+	require.Equal(t, 100, c.BatchCount)
+	// the environment takes precedence over the file
+	require.Equal(t, time.Second, c.FlushInterval)
+	require.Equal(t, OverflowDropOldest, c.OverflowPolicy)
+	require.Equal(t, 0.8, c.HotShardThreshold)
+	require.True(t, c.OrderedDelivery)
+	// the defaults are applied by New
+	require.Nil(t, c.Client)
+	require.Zero(t, c.MaxConnections)
+
+	jsonPath := filepath.Join(dir, "producer.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"stream_arn": "arn:aws:kinesis:eu-west-1:123456789012:stream/events", "max_buffered_bytes": 9007199254740993}`), 0o644))
+	c, err = LoadConfig(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, "arn:aws:kinesis:eu-west-1:123456789012:stream/events", c.StreamARN)
+	require.Equal(t, 9007199254740993, c.MaxBufferedBytes)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		return path
+	}
+	for _, test := range []struct {
+		path string
+		err  string
+	}{
+		{write("unknown.json", `{"stream_name": "events", "batch_sise": 10}`), `unknown setting "batch_sise"`},
+		{write("duration.json", `{"stream_name": "events", "flush_interval": 5}`), "invalid flush_interval"},
+		{write("policy.yaml", "stream_name: events\noverflow_policy: drop"), `unknown overflow policy "drop"`},
+		{write("nested.yaml", "stream_name: events\nbatch_size: [1]"), "invalid batch_size"},
+		{write("config.toml", ""), `unknown config file extension ".toml"`},
+		// cross-field checks of the Config
+		{write("flush.yaml", "stream_name: events\nadaptive_flush: true\nmin_flush_interval: 1m\nmax_flush_interval: 1s"), "kinesis: MinFlushInterval must be between 0 and MaxFlushInterval"},
+	} {
+		_, err := LoadConfig(test.path)
+		require.ErrorContains(t, err, test.err, test.path)
+	}
+
+	t.Setenv("KINESIS_PRODUCER_MAX_CONNECTIONS", "many")
+	_, err := LoadConfig("")
+	require.ErrorContains(t, err, "invalid KINESIS_PRODUCER_MAX_CONNECTIONS")
+}
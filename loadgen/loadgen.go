@@ -0,0 +1,268 @@
+// Package loadgen drives a producer at a configurable record size, partition key
+// cardinality and rate, and reports the throughput, aggregation ratio, buffering latency
+// and allocations it achieved. Use it to tune BatchSize, AggregateBatchSize and
+// FlushInterval empirically, against a real stream or a producertest.Putter.
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+const (
+	defaultRecordSize     = 512
+	defaultKeyCardinality = 1000
+	defaultDuration       = 10 * time.Second
+	defaultConcurrency    = 1
+	// sampleSize is the number of buffering latencies kept to compute the percentiles
+	sampleSize = 100000
+)
+
+// Config configures the load
+type Config struct {
+	// RecordSize is the size of the data of each user record in bytes. Default to 512.
+	RecordSize int
+
+	// KeyCardinality is the number of distinct partition keys, picked at random for each
+	// record. Default to 1000.
+	KeyCardinality int
+
+	// Rate is the number of user records put per second. Default to 0, records are put as
+	// fast as the producer accepts them.
+	Rate int
+
+	// Records is the number of user records to put. Default to 0, records are put for
+	// Duration.
+	Records int
+
+	// Duration is how long records are put, unless Records is set. Default to 10s.
+	Duration time.Duration
+
+	// Concurrency is the number of goroutines putting records. Default to 1.
+	Concurrency int
+}
+
+func (c *Config) defaults() {
+	if c.RecordSize == 0 {
+		c.RecordSize = defaultRecordSize
+	}
+	if c.KeyCardinality == 0 {
+		c.KeyCardinality = defaultKeyCardinality
+	}
+	if c.Records == 0 && c.Duration == 0 {
+		c.Duration = defaultDuration
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = defaultConcurrency
+	}
+}
+
+// Report is the outcome of a Run
+type Report struct {
+	// Records is the number of user records put, and Bytes the size of their data
+	Records int64
+	Bytes   int64
+	// Errors is the number of user records Put returned an error for
+	Errors int64
+	// Dropped is the number of user records that failed permanently
+	Dropped int64
+	// KinesisRecords is the number of kinesis records sent, and Requests the number of
+	// PutRecords requests they were sent with
+	KinesisRecords int64
+	Requests       int64
+	// Elapsed is the time between the first Put and the end of the final flush
+	Elapsed time.Duration
+	// Throughput is the number of user records put per second, and ByteThroughput their
+	// bytes per second
+	Throughput     float64
+	ByteThroughput float64
+	// AggregationRatio is the mean number of user records per kinesis record
+	AggregationRatio float64
+	// FlushLatencyP50 and FlushLatencyP99 are percentiles of the time between the Put of a
+	// user record and the first PutRecords request it is sent with
+	FlushLatencyP50 time.Duration
+	FlushLatencyP99 time.Duration
+	// AllocsPerRecord and AllocBytesPerRecord are the heap allocations of the process
+	// during the run divided by Records
+	AllocsPerRecord     float64
+	AllocBytesPerRecord float64
+}
+
+// String formats the report on a single line
+func (r *Report) String() string {
+	return fmt.Sprintf("records=%d errors=%d dropped=%d elapsed=%s throughput=%.0f/s %.0fB/s requests=%d aggregation=%.1f flush_p50=%s flush_p99=%s allocs=%.1f/record %.0fB/record",
+		r.Records, r.Errors, r.Dropped, r.Elapsed.Round(time.Millisecond), r.Throughput, r.ByteThroughput,
+		r.Requests, r.AggregationRatio, r.FlushLatencyP50, r.FlushLatencyP99,
+		r.AllocsPerRecord, r.AllocBytesPerRecord)
+}
+
+// Run creates a producer with opts, puts records to it as configured until they are all
+// put or ctx is done, flushes and stops it, and returns the Report. The producer Metrics
+// are replaced by the ones measuring the run.
+func Run(ctx context.Context, config Config, opts ...producer.Option) (*Report, error) {
+	config.defaults()
+	m := newMetrics()
+	p, err := producer.NewProducer(append(opts, producer.WithMetrics(m))...)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, config.KeyCardinality)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	data := make([]byte, config.RecordSize)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	var (
+		before, after runtime.MemStats
+		next, errs    int64
+		wg            sync.WaitGroup
+	)
+	runtime.ReadMemStats(&before)
+	p.Start()
+	start := time.Now()
+	wg.Add(config.Concurrency)
+	for w := 0; w < config.Concurrency; w++ {
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for ctx.Err() == nil {
+				i := atomic.AddInt64(&next, 1)
+				if config.Records > 0 && i > int64(config.Records) {
+					return
+				}
+				if config.Rate > 0 && !sleepUntil(ctx, start.Add(time.Duration(i-1)*time.Second/time.Duration(config.Rate))) {
+					return
+				}
+				if err := p.Put(data, keys[rnd.Intn(len(keys))]); err != nil {
+					atomic.AddInt64(&errs, 1)
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+	// the final flush is not bounded by the duration of the run
+	p.Flush(context.Background())
+	elapsed := time.Since(start)
+	p.Stop()
+	runtime.ReadMemStats(&after)
+
+	report := m.report()
+	report.Errors = errs
+	report.Elapsed = elapsed
+	if secs := elapsed.Seconds(); secs > 0 {
+		report.Throughput = float64(report.Records) / secs
+		report.ByteThroughput = float64(report.Bytes) / secs
+	}
+	if report.Records > 0 {
+		report.AllocsPerRecord = float64(after.Mallocs-before.Mallocs) / float64(report.Records)
+		report.AllocBytesPerRecord = float64(after.TotalAlloc-before.TotalAlloc) / float64(report.Records)
+	}
+	return report, nil
+}
+
+// sleepUntil sleeps until t and reports whether ctx is still not done
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// metrics measure a run. It implements producer.BufferingMetrics to sample the buffering
+// latencies
+type metrics struct {
+	producer.NopMetrics
+
+	records, bytes, dropped  int64
+	kinesisRecords, requests int64
+	aggregatedRecords        int64
+
+	mu sync.Mutex
+	// latencies is a reservoir sample of the buffering latencies
+	latencies []time.Duration
+	seen      int64
+	rand      *rand.Rand
+}
+
+var _ producer.BufferingMetrics = (*metrics)(nil)
+
+func newMetrics() *metrics {
+	return &metrics{rand: rand.New(rand.NewSource(1))}
+}
+
+func (m *metrics) UserRecordsPut(count int, bytes int) {
+	atomic.AddInt64(&m.records, int64(count))
+	atomic.AddInt64(&m.bytes, int64(bytes))
+}
+
+func (m *metrics) RequestSent(kinesisRecords, userRecords, bytes int, latency time.Duration) {
+	atomic.AddInt64(&m.requests, 1)
+	atomic.AddInt64(&m.kinesisRecords, int64(kinesisRecords))
+	atomic.AddInt64(&m.aggregatedRecords, int64(userRecords))
+}
+
+func (m *metrics) RecordsDropped(count int) {
+	atomic.AddInt64(&m.dropped, int64(count))
+}
+
+func (m *metrics) UserRecordBuffered(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seen++
+	if len(m.latencies) < sampleSize {
+		m.latencies = append(m.latencies, d)
+	} else if i := m.rand.Int63n(m.seen); i < sampleSize {
+		m.latencies[i] = d
+	}
+}
+
+func (m *metrics) report() *Report {
+	r := &Report{
+		Records:        atomic.LoadInt64(&m.records),
+		Bytes:          atomic.LoadInt64(&m.bytes),
+		Dropped:        atomic.LoadInt64(&m.dropped),
+		KinesisRecords: atomic.LoadInt64(&m.kinesisRecords),
+		Requests:       atomic.LoadInt64(&m.requests),
+	}
+	if r.KinesisRecords > 0 {
+		r.AggregationRatio = float64(atomic.LoadInt64(&m.aggregatedRecords)) / float64(r.KinesisRecords)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.Slice(m.latencies, func(i, j int) bool { return m.latencies[i] < m.latencies[j] })
+	r.FlushLatencyP50 = percentile(m.latencies, 0.50)
+	r.FlushLatencyP99 = percentile(m.latencies, 0.99)
+	return r
+}
+
+// percentile returns the p-th percentile of the sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
@@ -0,0 +1,61 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	producer "github.com/achunariov/kinesis-producer"
+	"github.com/achunariov/kinesis-producer/producertest"
+)
+
+func TestRun(t *testing.T) {
+	putter := producertest.NewPutter()
+	report, err := Run(context.Background(), Config{
+		RecordSize:     100,
+		KeyCardinality: 10,
+		Records:        1000,
+		Concurrency:    4,
+	},
+		producer.WithStreamName("test"),
+		producer.WithClient(putter),
+		producer.WithLogger(&producer.NopLogger{}),
+		producer.WithFlushInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), report.Records)
+	require.Zero(t, report.Errors)
+	require.Zero(t, report.Dropped)
+	require.Len(t, putter.Delivered(), 1000)
+	require.Greater(t, report.AggregationRatio, 1.0)
+	require.Greater(t, report.Throughput, 0.0)
+	require.GreaterOrEqual(t, report.FlushLatencyP99, report.FlushLatencyP50)
+	require.NotEmpty(t, report.String())
+}
+
+func TestRunRate(t *testing.T) {
+	report, err := Run(context.Background(), Config{
+		RecordSize: 10,
+		Rate:       100,
+		Duration:   200 * time.Millisecond,
+	},
+		producer.WithStreamName("test"),
+		producer.WithClient(producertest.NewPutter()),
+		producer.WithLogger(&producer.NopLogger{}),
+	)
+	require.NoError(t, err)
+	// 20 records are due within the duration
+	require.InDelta(t, 20, report.Records, 3)
+}
+
+func TestPercentile(t *testing.T) {
+	require.Zero(t, percentile(nil, 0.99))
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	require.Equal(t, 50*time.Millisecond, percentile(sorted, 0.50))
+	require.Equal(t, 99*time.Millisecond, percentile(sorted, 0.99))
+}
@@ -0,0 +1,39 @@
+package producer
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+)
+
+const (
+	localRegion    = "us-east-1"
+	localAccessKey = "local"
+)
+
+// NewLocalClient returns a kinesis.Client for a local emulator like LocalStack or
+// kinesalite listening at endpoint, e.g. "http://localhost:4566". Requests are signed with
+// dummy credentials for the us-east-1 region, which the emulators accept. The client
+// also implements ShardLister, StreamCreator and StreamScaler.
+func NewLocalClient(endpoint string) *k.Client {
+	return k.New(k.Options{
+		Region:           localRegion,
+		EndpointResolver: k.EndpointResolverFromURL(endpoint),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     localAccessKey,
+				SecretAccessKey: localAccessKey,
+				Source:          "NewLocalClient",
+			}, nil
+		}),
+	})
+}
+
+// NewLocalProducer creates a Producer putting records to stream on a local emulator
+// listening at endpoint, using NewLocalClient. opts are applied after the stream and the
+// client, so they can override them or add e.g. WithCreateStream with a NewLocalClient to
+// create the stream first.
+func NewLocalProducer(endpoint, stream string, opts ...Option) (*Producer, error) {
+	return NewProducer(append([]Option{WithStreamName(stream), WithClient(NewLocalClient(endpoint))}, opts...)...)
+}
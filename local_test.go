@@ -0,0 +1,44 @@
+package producer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLocalProducer(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		targets  []string
+		auth     string
+		requests []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		auth = r.Header.Get("Authorization")
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"FailedRecordCount":0,"Records":[{"SequenceNumber":"1","ShardId":"shardId-000000000000"}]}`))
+	}))
+	defer server.Close()
+
+	p, err := NewLocalProducer(server.URL, "foo", WithLogger(&NopLogger{}))
+	require.NoError(t, err)
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	require.Equal(t, []string{"Kinesis_20131202.PutRecords"}, targets)
+	require.True(t, strings.Contains(auth, "Credential=local/"), auth)
+	require.True(t, strings.Contains(auth, "/us-east-1/kinesis/"), auth)
+	require.Equal(t, "foo", requests[0]["StreamName"])
+}
@@ -1,17 +1,39 @@
 package producer
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"strings"
 )
 
-// Logger represents a simple interface used by kinesis-producer to handle logging
+// Logger represents a leveled, structured logging interface used by kinesis-producer.
+// Log lines about PutRecords requests include the stream, size and attempt as values.
+// Debug and Warn were added after Info and Error, loggers written before must add them.
 type Logger interface {
+	Debug(msg string, values ...LogValue)
 	Info(msg string, values ...LogValue)
+	Warn(msg string, values ...LogValue)
 	Error(msg string, err error, values ...LogValue)
 }
 
+// LevelLogger is implemented by Loggers that tell whether a level is logged, so that the
+// Producer does not build the values of lines that would be dropped, e.g. the result of
+// every record at debug level. Loggers without it are assumed to log every level.
+type LevelLogger interface {
+	Logger
+	Enabled(level slog.Level) bool
+}
+
+// logEnabled reports whether logger logs the level
+func logEnabled(logger Logger, level slog.Level) bool {
+	if l, ok := logger.(LevelLogger); ok {
+		return l.Enabled(level)
+	}
+	return true
+}
+
 // LogValue represents a key:value pair used by the Logger interface
 type LogValue struct {
 	Name  string
@@ -25,16 +47,50 @@ func (v LogValue) String() string {
 // StdLogger implements the Logger interface using standard library loggers
 type StdLogger struct {
 	Logger *log.Logger
+	// Level is the minimum level printed. Default to slog.LevelInfo
+	Level slog.Level
+	// level overrides Level for the default logger of the Producer, so that Verbose can be
+	// changed at runtime
+	level *slog.LevelVar
+}
+
+// Debug prints log message
+func (l *StdLogger) Debug(msg string, values ...LogValue) {
+	l.print(slog.LevelDebug, msg, nil, values)
 }
 
 // Info prints log message
 func (l *StdLogger) Info(msg string, values ...LogValue) {
-	l.Logger.Print(msg, l.valuesToString(values...))
+	l.print(slog.LevelInfo, msg, nil, values)
+}
+
+// Warn prints log message
+func (l *StdLogger) Warn(msg string, values ...LogValue) {
+	l.print(slog.LevelWarn, msg, nil, values)
 }
 
 // Error prints log message
 func (l *StdLogger) Error(msg string, err error, values ...LogValue) {
-	l.Logger.Print(msg, l.valuesToString(values...), err)
+	l.print(slog.LevelError, msg, err, values)
+}
+
+// Enabled reports whether the level is printed
+func (l *StdLogger) Enabled(level slog.Level) bool {
+	min := l.Level
+	if l.level != nil {
+		min = l.level.Level()
+	}
+	return level >= min
+}
+
+func (l *StdLogger) print(level slog.Level, msg string, err error, values []LogValue) {
+	if !l.Enabled(level) {
+		return
+	}
+	if err != nil {
+		values = append(values, LogValue{"error", err})
+	}
+	l.Logger.Print(level, " ", msg, l.valuesToString(values...))
 }
 
 func (l *StdLogger) valuesToString(values ...LogValue) string {
@@ -45,7 +101,61 @@ func (l *StdLogger) valuesToString(values ...LogValue) string {
 	return strings.Join(parts, ", ")
 }
 
+// SlogLogger implements the Logger interface using a log/slog Logger. LogValues are
+// logged as attributes and errors with the "error" key.
+type SlogLogger struct {
+	Logger *slog.Logger
+}
+
+// NewSlogLogger creates a SlogLogger writing to handler
+func NewSlogLogger(handler slog.Handler) *SlogLogger {
+	return &SlogLogger{Logger: slog.New(handler)}
+}
+
+// Debug logs a message
+func (l *SlogLogger) Debug(msg string, values ...LogValue) {
+	l.log(slog.LevelDebug, msg, nil, values)
+}
+
+// Info logs a message
+func (l *SlogLogger) Info(msg string, values ...LogValue) {
+	l.log(slog.LevelInfo, msg, nil, values)
+}
+
+// Warn logs a message
+func (l *SlogLogger) Warn(msg string, values ...LogValue) {
+	l.log(slog.LevelWarn, msg, nil, values)
+}
+
+// Error logs an error
+func (l *SlogLogger) Error(msg string, err error, values ...LogValue) {
+	l.log(slog.LevelError, msg, err, values)
+}
+
+// Enabled reports whether the slog Logger handles the level
+func (l *SlogLogger) Enabled(level slog.Level) bool {
+	return l.Logger.Enabled(context.Background(), level)
+}
+
+func (l *SlogLogger) log(level slog.Level, msg string, err error, values []LogValue) {
+	ctx := context.Background()
+	if !l.Logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(values)+1)
+	for _, v := range values {
+		attrs = append(attrs, slog.Any(v.Name, v.Value))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	l.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
 type NopLogger struct{}
 
+func (_ *NopLogger) Debug(msg string, values ...LogValue)            {}
 func (_ *NopLogger) Info(msg string, values ...LogValue)             {}
+func (_ *NopLogger) Warn(msg string, values ...LogValue)             {}
 func (_ *NopLogger) Error(msg string, err error, values ...LogValue) {}
+func (_ *NopLogger) Enabled(level slog.Level) bool                   { return false }
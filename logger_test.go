@@ -0,0 +1,119 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStdLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &StdLogger{Logger: log.New(&buf, "", 0)}
+
+	logger.Debug("debug", LogValue{"stream", "foo"})
+	logger.Info("info", LogValue{"stream", "foo"})
+	logger.Error("error", errors.New("boom"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, []string{"INFO info stream=foo", "ERROR error error=boom"}, lines)
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	logger.Info("info", LogValue{"stream", "foo"})
+	logger.Warn("put failures", LogValue{"stream", "foo"}, LogValue{"attempt", 2})
+	logger.Error("send", errors.New("boom"), LogValue{"size", 10})
+
+	var got []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]interface{}
+		require.NoError(t, dec.Decode(&line))
+		delete(line, "time")
+		got = append(got, line)
+	}
+	require.Equal(t, []map[string]interface{}{
+		{"level": "WARN", "msg": "put failures", "stream": "foo", "attempt": float64(2)},
+		{"level": "ERROR", "msg": "send", "size": float64(10), "error": "boom"},
+	}, got)
+}
+
+// debugLogger records the debug messages, if debug is enabled
+type debugLogger struct {
+	NopLogger
+	debug bool
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *debugLogger) Debug(msg string, values ...LogValue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func (l *debugLogger) Enabled(level slog.Level) bool {
+	return l.debug || level > slog.LevelDebug
+}
+
+func TestLogEnabled(t *testing.T) {
+	require.False(t, logEnabled(&StdLogger{}, slog.LevelDebug))
+	require.True(t, logEnabled(&StdLogger{Level: slog.LevelDebug}, slog.LevelDebug))
+	require.False(t, logEnabled(NewSlogLogger(slog.NewJSONHandler(&bytes.Buffer{}, nil)), slog.LevelDebug))
+	require.True(t, logEnabled(NewSlogLogger(slog.NewJSONHandler(&bytes.Buffer{}, nil)), slog.LevelInfo))
+	require.False(t, logEnabled(&NopLogger{}, slog.LevelError))
+	// loggers that do not tell log every level
+	require.True(t, logEnabled(struct{ Logger }{&NopLogger{}}, slog.LevelDebug))
+
+	for _, debug := range []bool{false, true} {
+		client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+			out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+			for range input.Records {
+				out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")})
+			}
+			return out, nil
+		})
+		logger := &debugLogger{debug: debug}
+		p := New(&Config{
+			StreamName:     "foo",
+			MaxConnections: 1,
+			FlushInterval:  time.Hour,
+			Logger:         logger,
+			Client:         client,
+		})
+		p.Start()
+		require.NoError(t, p.Put([]byte("hello"), "a", WithoutAggregation()))
+		require.NoError(t, p.Put([]byte("world"), "b", WithoutAggregation()))
+		require.NoError(t, p.Flush(context.Background()))
+		p.Stop()
+
+		logger.mu.Lock()
+		var results []string
+		for _, msg := range logger.messages {
+			if strings.HasPrefix(msg, "Result[") {
+				results = append(results, msg)
+			}
+		}
+		logger.mu.Unlock()
+		if debug {
+			require.Equal(t, []string{"Result[0]", "Result[1]"}, results)
+		} else {
+			require.Empty(t, results)
+		}
+	}
+}
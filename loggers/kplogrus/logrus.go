@@ -1,6 +1,8 @@
 package kplogrus
 
 import (
+	"log/slog"
+
 	producer "github.com/achunariov/kinesis-producer"
 	"github.com/sirupsen/logrus"
 )
@@ -10,16 +12,39 @@ type Logger struct {
 	Logger *logrus.Logger
 }
 
+// Debug logs a message
+func (l *Logger) Debug(msg string, args ...producer.LogValue) {
+	l.Logger.WithFields(l.valuesToFields(args...)).Debug(msg)
+}
+
 // Info logs a message
 func (l *Logger) Info(msg string, args ...producer.LogValue) {
 	l.Logger.WithFields(l.valuesToFields(args...)).Info(msg)
 }
 
+// Warn logs a message
+func (l *Logger) Warn(msg string, args ...producer.LogValue) {
+	l.Logger.WithFields(l.valuesToFields(args...)).Warn(msg)
+}
+
 // Error logs an error
 func (l *Logger) Error(msg string, err error, args ...producer.LogValue) {
 	l.Logger.WithError(err).WithFields(l.valuesToFields(args...)).Error(msg)
 }
 
+// Enabled reports whether the logrus Logger logs the level
+func (l *Logger) Enabled(level slog.Level) bool {
+	switch {
+	case level < slog.LevelInfo:
+		return l.Logger.IsLevelEnabled(logrus.DebugLevel)
+	case level < slog.LevelWarn:
+		return l.Logger.IsLevelEnabled(logrus.InfoLevel)
+	case level < slog.LevelError:
+		return l.Logger.IsLevelEnabled(logrus.WarnLevel)
+	}
+	return l.Logger.IsLevelEnabled(logrus.ErrorLevel)
+}
+
 func (l *Logger) valuesToFields(values ...producer.LogValue) logrus.Fields {
 	fields := logrus.Fields{}
 	for _, v := range values {
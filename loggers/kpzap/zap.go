@@ -1,7 +1,10 @@
 package kpzap
 
 import (
+	"log/slog"
+
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	producer "github.com/achunariov/kinesis-producer"
 )
@@ -11,16 +14,39 @@ type Logger struct {
 	Logger *zap.Logger
 }
 
+// Debug logs a message
+func (l *Logger) Debug(msg string, values ...producer.LogValue) {
+	l.Logger.Debug(msg, l.valuesToFields(values)...)
+}
+
 // Info logs a message
 func (l *Logger) Info(msg string, values ...producer.LogValue) {
 	l.Logger.Info(msg, l.valuesToFields(values)...)
 }
 
+// Warn logs a message
+func (l *Logger) Warn(msg string, values ...producer.LogValue) {
+	l.Logger.Warn(msg, l.valuesToFields(values)...)
+}
+
 // Error logs an error
 func (l *Logger) Error(msg string, err error, values ...producer.LogValue) {
 	fields := l.valuesToFields(values)
 	fields = append(fields, zap.Error(err))
-	l.Logger.Info(msg, fields...)
+	l.Logger.Error(msg, fields...)
+}
+
+// Enabled reports whether the zap Logger logs the level
+func (l *Logger) Enabled(level slog.Level) bool {
+	switch {
+	case level < slog.LevelInfo:
+		return l.Logger.Core().Enabled(zapcore.DebugLevel)
+	case level < slog.LevelWarn:
+		return l.Logger.Core().Enabled(zapcore.InfoLevel)
+	case level < slog.LevelError:
+		return l.Logger.Core().Enabled(zapcore.WarnLevel)
+	}
+	return l.Logger.Core().Enabled(zapcore.ErrorLevel)
 }
 
 func (l *Logger) valuesToFields(values []producer.LogValue) []zap.Field {
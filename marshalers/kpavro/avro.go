@@ -0,0 +1,89 @@
+// Package kpavro encodes the values of a producer.TypedProducer with an Avro schema, in
+// the binary or the single-object encoding.
+package kpavro
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/hamba/avro/v2"
+)
+
+// singleObjectMagic starts the data of Avro single-object encoded values
+var singleObjectMagic = [2]byte{0xC3, 0x01}
+
+// singleObjectHeaderSize is the size of the magic and the schema fingerprint
+const singleObjectHeaderSize = len(singleObjectMagic) + 8
+
+// ErrNotSingleObject is returned by ParseSingleObject for data that is not single-object
+// encoded
+var ErrNotSingleObject = errors.New("kpavro: data is not an Avro single object")
+
+// Marshaler implements a producer.Marshaler encoding values in the Avro binary format
+type Marshaler[T any] struct {
+	Schema avro.Schema
+}
+
+// New returns a Marshaler for the Avro schema in JSON
+func New[T any](schema string) (*Marshaler[T], error) {
+	s, err := avro.Parse(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &Marshaler[T]{Schema: s}, nil
+}
+
+// Marshal encodes v with the schema
+func (m *Marshaler[T]) Marshal(v T) ([]byte, error) {
+	return avro.Marshal(m.Schema, v)
+}
+
+// SingleObjectMarshaler implements a producer.Marshaler encoding values in the Avro
+// single-object encoding: the binary encoding prefixed with the CRC-64-AVRO fingerprint of
+// the schema, rather than the schema itself as in object container files, so that
+// consumers can look up the writer schema of each record in a registry.
+type SingleObjectMarshaler[T any] struct {
+	Schema avro.Schema
+	// header is the magic and the little-endian fingerprint of Schema
+	header [singleObjectHeaderSize]byte
+}
+
+// NewSingleObject returns a SingleObjectMarshaler for the Avro schema in JSON
+func NewSingleObject[T any](schema string) (*SingleObjectMarshaler[T], error) {
+	s, err := avro.Parse(schema)
+	if err != nil {
+		return nil, err
+	}
+	m := &SingleObjectMarshaler[T]{Schema: s}
+	fingerprint, err := s.FingerprintUsing(avro.CRC64Avro)
+	if err != nil {
+		return nil, err
+	}
+	copy(m.header[:], singleObjectMagic[:])
+	// the fingerprint is big-endian, the single-object encoding little-endian
+	binary.LittleEndian.PutUint64(m.header[len(singleObjectMagic):], binary.BigEndian.Uint64(fingerprint))
+	return m, nil
+}
+
+// Fingerprint returns the CRC-64-AVRO fingerprint of the schema
+func (m *SingleObjectMarshaler[T]) Fingerprint() uint64 {
+	return binary.LittleEndian.Uint64(m.header[len(singleObjectMagic):])
+}
+
+// Marshal encodes v with the schema, prefixed with the fingerprint of the schema
+func (m *SingleObjectMarshaler[T]) Marshal(v T) ([]byte, error) {
+	data, err := avro.Marshal(m.Schema, v)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]byte, 0, singleObjectHeaderSize+len(data)), m.header[:]...), data...), nil
+}
+
+// ParseSingleObject returns the schema fingerprint and the binary encoded value of single
+// object encoded data. Returns ErrNotSingleObject if data is not single-object encoded.
+func ParseSingleObject(data []byte) (fingerprint uint64, value []byte, err error) {
+	if len(data) < singleObjectHeaderSize || data[0] != singleObjectMagic[0] || data[1] != singleObjectMagic[1] {
+		return 0, nil, ErrNotSingleObject
+	}
+	return binary.LittleEndian.Uint64(data[len(singleObjectMagic):]), data[singleObjectHeaderSize:], nil
+}
@@ -0,0 +1,84 @@
+package kpavro
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/hamba/avro/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// schema is in the Parsing Canonical Form, which the fingerprint is computed from
+const schema = `{"name":"test.Event","type":"record","fields":[{"name":"id","type":"long"},{"name":"name","type":"string"}]}`
+
+type event struct {
+	ID   int64  `avro:"id"`
+	Name string `avro:"name"`
+}
+
+// crc64Avro is the CRC-64-AVRO fingerprint as given by the Avro specification
+func crc64Avro(data []byte) uint64 {
+	const empty = 0xc15d213aa4d7a795
+	var table [256]uint64
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (empty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	fp := uint64(empty)
+	for _, b := range data {
+		fp = (fp >> 8) ^ table[(fp^uint64(b))&0xff]
+	}
+	return fp
+}
+
+func TestMarshaler(t *testing.T) {
+	m, err := New[event](schema)
+	require.NoError(t, err)
+	data, err := m.Marshal(event{ID: 1, Name: "foo"})
+	require.NoError(t, err)
+	// the zig-zag encoded long, then the length prefixed string
+	require.Equal(t, []byte{0x02, 0x06, 'f', 'o', 'o'}, data)
+
+	_, err = New[event](`{"type":"unknown"}`)
+	require.Error(t, err)
+}
+
+func TestSingleObjectMarshaler(t *testing.T) {
+	m, err := NewSingleObject[event](schema)
+	require.NoError(t, err)
+	require.Equal(t, crc64Avro([]byte(schema)), m.Fingerprint())
+
+	data, err := m.Marshal(event{ID: 1, Name: "foo"})
+	require.NoError(t, err)
+	// the magic, then the fingerprint in little-endian
+	require.Equal(t, []byte{0xC3, 0x01}, data[:2])
+	require.Equal(t, m.Fingerprint(), binary.LittleEndian.Uint64(data[2:10]))
+
+	fingerprint, value, err := ParseSingleObject(data)
+	require.NoError(t, err)
+	require.Equal(t, m.Fingerprint(), fingerprint)
+	var got event
+	require.NoError(t, avro.Unmarshal(m.Schema, value, &got))
+	require.Equal(t, event{ID: 1, Name: "foo"}, got)
+}
+
+func TestParseSingleObject(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{0xC3, 0x01, 0, 0, 0, 0, 0, 0, 0},
+		{0xC3, 0x02, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0x02, 0x06, 'f', 'o', 'o', 0, 0, 0, 0, 0},
+	} {
+		_, _, err := ParseSingleObject(data)
+		require.ErrorIs(t, err, ErrNotSingleObject)
+	}
+
+	// a value may be empty, e.g. of the null schema
+	fingerprint, value, err := ParseSingleObject([]byte{0xC3, 0x01, 1, 2, 3, 4, 5, 6, 7, 8})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x0807060504030201), fingerprint)
+	require.Empty(t, value)
+}
@@ -0,0 +1,97 @@
+package producer
+
+import "time"
+
+// Metrics is the interface used by the Producer to report its internal metrics. Methods
+// are called concurrently from the Put path and the flusher and must be thread-safe.
+type Metrics interface {
+	// UserRecordsPut is called when user records are accepted by Put with the number of
+	// records and their size in bytes
+	UserRecordsPut(count int, bytes int)
+	// RequestSent is called after each successful PutRecords request with the number of
+	// kinesis records sent, the number of user records aggregated into them, the bytes sent
+	// and the request latency
+	RequestSent(kinesisRecords int, userRecords int, bytes int, latency time.Duration)
+	// RecordsRetried is called with the number of kinesis records that will be retried
+	RecordsRetried(count int)
+	// RecordsThrottled is called with the number of kinesis records that were rejected due
+	// to exceeding the shard throughput
+	RecordsThrottled(count int)
+	// RecordsDropped is called with the number of user records that failed permanently
+	RecordsDropped(count int)
+	// BacklogDepth is called periodically with the number of Puts waiting in the backlog
+	BacklogDepth(depth int)
+}
+
+// RecordErrorMetrics is implemented by Metrics that also count the kinesis records that
+// failed by error code, e.g. ProvisionedThroughputExceededException or InternalFailure.
+// The Producer checks for it with a type assertion.
+type RecordErrorMetrics interface {
+	// RecordsFailed is called with the number of kinesis records of a PutRecords request
+	// that were rejected with the error code, or that failed with the request
+	RecordsFailed(code string, count int)
+}
+
+// ShardMetrics is implemented by Metrics that also break the kinesis records down by
+// stream and shard, so that hot shards are visible. Every shard adds a series, which may be
+// too many for streams with a lot of shards. The Producer checks for it with a type
+// assertion.
+type ShardMetrics interface {
+	// ShardRecordsSent is called after each PutRecords request that delivered kinesis
+	// records to the shard with their number, their size in bytes and the request latency
+	ShardRecordsSent(stream, shardID string, records int, bytes int, latency time.Duration)
+	// ShardRecordsThrottled is called with the number of kinesis records of a PutRecords
+	// request that were rejected due to exceeding the throughput of the shard. The shard id
+	// is empty if the shards of the stream are unknown
+	ShardRecordsThrottled(stream, shardID string, count int)
+}
+
+// BufferingMetrics is implemented by Metrics that also measure how long user records are
+// buffered before they are sent, like the BufferingTime metric of the KPL. The Producer
+// checks for it with a type assertion, and then records the time each user record is put.
+type BufferingMetrics interface {
+	// UserRecordBuffered is called for each user record with the time between its Put and
+	// the first PutRecords request it is sent with
+	UserRecordBuffered(d time.Duration)
+}
+
+// StageMetrics is implemented by Metrics that also measure how long user records wait in
+// each stage of buffering, see Config.AggregationMaxDelay and Config.RequestMaxDelay. The
+// Producer checks for it with a type assertion, and then records the time each user record
+// is put.
+type StageMetrics interface {
+	// AggregationDelay is called for each kinesis record added to the worker pool with the
+	// time since the Put of its oldest user record
+	AggregationDelay(d time.Duration)
+	// RequestDelay is called for each batch of kinesis records turned into a PutRecords
+	// request with the time since its oldest kinesis record was added to the worker pool
+	RequestDelay(d time.Duration)
+}
+
+// MirrorMetrics is implemented by Metrics that also record the requests to the mirror, see
+// Config.Mirror. The Producer checks for it with a type assertion.
+type MirrorMetrics interface {
+	// MirrorRequestSent is called after each PutRecords request to the mirror of region
+	// with the number of kinesis records delivered and failed, and the request latency.
+	// All the records failed if the request failed
+	MirrorRequestSent(region string, delivered, failed int, latency time.Duration)
+	// MirrorRecordsDropped is called with the number of kinesis records that were not
+	// mirrored, because the backlog was full or their retries were exhausted
+	MirrorRecordsDropped(region string, count int)
+	// MirrorLag is called for each batch delivered to the mirror with the time since its
+	// first request to the primary stream
+	MirrorLag(region string, lag time.Duration)
+}
+
+// NopMetrics implements the Metrics interface by discarding all metrics. It can be
+// embedded by partial Metrics implementations. It implements none of the optional
+// interfaces, such as ShardMetrics, so that the Producer does not compute metrics for
+// nothing; embedders implement the ones they need.
+type NopMetrics struct{}
+
+func (_ *NopMetrics) UserRecordsPut(count int, bytes int)                                       {}
+func (_ *NopMetrics) RequestSent(kinesisRecords, userRecords, bytes int, latency time.Duration) {}
+func (_ *NopMetrics) RecordsRetried(count int)                                                  {}
+func (_ *NopMetrics) RecordsThrottled(count int)                                                {}
+func (_ *NopMetrics) RecordsDropped(count int)                                                  {}
+func (_ *NopMetrics) BacklogDepth(depth int)                                                    {}
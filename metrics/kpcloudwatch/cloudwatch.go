@@ -0,0 +1,335 @@
+// Package kpcloudwatch publishes the metrics of a producer to CloudWatch with the metric
+// names and dimensions of the Java KPL, so that dashboards and alarms built for the KPL
+// keep working.
+package kpcloudwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+const (
+	// DefaultNamespace is the namespace the KPL publishes to by default
+	DefaultNamespace = "KinesisProducerLibrary"
+	// defaultInterval is the upload interval of the KPL
+	defaultInterval = time.Minute
+	// maxDatums is the maximum number of metric datums in a PutMetricData request
+	maxDatums = 1000
+)
+
+// Granularity is the level of the dimensions metrics are published with. Every metric is
+// also published at the coarser levels, as the KPL does.
+type Granularity int
+
+const (
+	// GranularityGlobal publishes metrics without StreamName dimension
+	GranularityGlobal Granularity = iota
+	// GranularityStream also publishes metrics with the StreamName dimension
+	GranularityStream
+	// GranularityShard also publishes the metrics of shards with the StreamName and
+	// ShardId dimensions
+	GranularityShard
+)
+
+// Client is the interface that wraps the cloudwatch.Client PutMetricData method.
+type Client interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// Config configures Metrics.
+type Config struct {
+	// Client calls PutMetricData. Required.
+	Client Client
+
+	// Namespace of the metrics. Default to KinesisProducerLibrary.
+	Namespace string
+
+	// StreamName is the value of the StreamName dimension of the metrics that are not
+	// reported per stream, the metrics of shards have the stream of the shard. Required
+	// with GranularityStream and GranularityShard.
+	StreamName string
+
+	// Granularity of the dimensions. Default to GranularityGlobal.
+	Granularity Granularity
+
+	// Interval between two uploads. Default to 1m.
+	Interval time.Duration
+
+	// Logger logs failed uploads. Default to producer.NopLogger.
+	Logger producer.Logger
+}
+
+// Metrics implements producer.Metrics by aggregating the metrics into statistic sets that
+// are uploaded to CloudWatch every Interval. The metrics are named after the KPL metrics:
+//
+//   - UserRecordsReceived and UserRecordsDataPut: user records accepted by Put
+//   - UserRecordsPut: user records sent with PutRecords requests
+//   - UserRecordsPending: Puts waiting in the backlog
+//   - KinesisRecordsPut and KinesisRecordsDataPut: kinesis records delivered
+//   - ErrorsByCode, with the ErrorCode dimension, and AllErrors: kinesis records failed
+//   - RetriesPerRecord: kinesis records retried
+//   - BufferingTime: time between the Put of a user record and its first request
+//   - RequestTime: latency of PutRecords requests
+//   - UserRecordsPerKinesisRecord, KinesisRecordsPerPutRecordsRequest and
+//     UserRecordsPerPutRecordsRequest: aggregation and batching ratios
+//
+// Call Close to upload the last metrics.
+type Metrics struct {
+	config Config
+
+	mu   sync.Mutex
+	sets map[metricKey]*types.StatisticSet
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	_ producer.Metrics            = (*Metrics)(nil)
+	_ producer.RecordErrorMetrics = (*Metrics)(nil)
+	_ producer.ShardMetrics       = (*Metrics)(nil)
+	_ producer.BufferingMetrics   = (*Metrics)(nil)
+)
+
+// metricKey identifies a statistic set by metric name and dimensions
+type metricKey struct {
+	name       string
+	unit       types.StandardUnit
+	streamName string
+	shardID    string
+	errorCode  string
+}
+
+// New creates Metrics and starts uploading them every config.Interval.
+func New(config Config) (*Metrics, error) {
+	if config.Client == nil {
+		return nil, errors.New("kpcloudwatch: Client must be set")
+	}
+	if config.Granularity > GranularityGlobal && config.StreamName == "" {
+		return nil, errors.New("kpcloudwatch: StreamName must be set with stream or shard granularity")
+	}
+	if config.Namespace == "" {
+		config.Namespace = DefaultNamespace
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	if config.Logger == nil {
+		config.Logger = &producer.NopLogger{}
+	}
+	m := &Metrics{
+		config: config,
+		sets:   make(map[metricKey]*types.StatisticSet),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go m.loop()
+	return m, nil
+}
+
+func (m *Metrics) loop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Upload(context.Background()); err != nil {
+				m.config.Logger.Error("kpcloudwatch: upload", err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic uploads and uploads the metrics aggregated since the last one.
+// Close the producer first.
+func (m *Metrics) Close(ctx context.Context) error {
+	close(m.stop)
+	<-m.done
+	return m.Upload(ctx)
+}
+
+// Upload uploads the metrics aggregated since the last upload. The metrics that could not
+// be uploaded are kept for the next upload.
+func (m *Metrics) Upload(ctx context.Context) error {
+	m.mu.Lock()
+	sets := m.sets
+	m.sets = make(map[metricKey]*types.StatisticSet)
+	m.mu.Unlock()
+	if len(sets) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	keys := make([]metricKey, 0, len(sets))
+	data := make([]types.MetricDatum, 0, len(sets))
+	for key, set := range sets {
+		keys = append(keys, key)
+		data = append(data, types.MetricDatum{
+			MetricName:      aws.String(key.name),
+			Unit:            key.unit,
+			Dimensions:      key.dimensions(),
+			StatisticValues: set,
+			Timestamp:       aws.Time(now),
+		})
+	}
+	for start := 0; start < len(data); start += maxDatums {
+		end := min(start+maxDatums, len(data))
+		_, err := m.config.Client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(m.config.Namespace),
+			MetricData: data[start:end],
+		})
+		if err != nil {
+			m.restore(keys[start:], sets)
+			return fmt.Errorf("kpcloudwatch: put metric data: %w", err)
+		}
+	}
+	return nil
+}
+
+// restore merges the statistic sets of keys that were not uploaded into the sets
+// aggregated since
+func (m *Metrics) restore(keys []metricKey, sets map[metricKey]*types.StatisticSet) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		set := sets[key]
+		current, ok := m.sets[key]
+		if !ok {
+			m.sets[key] = set
+			continue
+		}
+		*current.SampleCount += *set.SampleCount
+		*current.Sum += *set.Sum
+		*current.Minimum = math.Min(*current.Minimum, *set.Minimum)
+		*current.Maximum = math.Max(*current.Maximum, *set.Maximum)
+	}
+}
+
+func (k metricKey) dimensions() []types.Dimension {
+	var dimensions []types.Dimension
+	if k.streamName != "" {
+		dimensions = append(dimensions, types.Dimension{Name: aws.String("StreamName"), Value: aws.String(k.streamName)})
+	}
+	if k.shardID != "" {
+		dimensions = append(dimensions, types.Dimension{Name: aws.String("ShardId"), Value: aws.String(k.shardID)})
+	}
+	if k.errorCode != "" {
+		dimensions = append(dimensions, types.Dimension{Name: aws.String("ErrorCode"), Value: aws.String(k.errorCode)})
+	}
+	return dimensions
+}
+
+// observe adds a sample of the metric at each level up to the granularity. The stream of
+// key defaults to Config.StreamName, and its shard id is only used with GranularityShard.
+func (m *Metrics) observe(key metricKey, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	streamName, shardID := key.streamName, key.shardID
+	if streamName == "" {
+		streamName = m.config.StreamName
+	}
+	key.streamName, key.shardID = "", ""
+	m.add(key, value)
+	if m.config.Granularity >= GranularityStream {
+		key.streamName = streamName
+		m.add(key, value)
+		if m.config.Granularity >= GranularityShard && shardID != "" {
+			key.shardID = shardID
+			m.add(key, value)
+		}
+	}
+}
+
+func (m *Metrics) add(key metricKey, value float64) {
+	set, ok := m.sets[key]
+	if !ok {
+		m.sets[key] = &types.StatisticSet{
+			SampleCount: aws.Float64(1),
+			Sum:         aws.Float64(value),
+			Minimum:     aws.Float64(value),
+			Maximum:     aws.Float64(value),
+		}
+		return
+	}
+	*set.SampleCount++
+	*set.Sum += value
+	*set.Minimum = math.Min(*set.Minimum, value)
+	*set.Maximum = math.Max(*set.Maximum, value)
+}
+
+// countKey returns the key of a metric counted without unit conversion
+func countKey(name string) metricKey {
+	return metricKey{name: name, unit: types.StandardUnitCount}
+}
+
+// UserRecordsPut implements producer.Metrics
+func (m *Metrics) UserRecordsPut(count int, bytes int) {
+	m.observe(countKey("UserRecordsReceived"), float64(count))
+	m.observe(metricKey{name: "UserRecordsDataPut", unit: types.StandardUnitBytes}, float64(bytes))
+}
+
+// RequestSent implements producer.Metrics
+func (m *Metrics) RequestSent(kinesisRecords int, userRecords int, bytes int, latency time.Duration) {
+	m.observe(countKey("UserRecordsPut"), float64(userRecords))
+	m.observe(countKey("KinesisRecordsPerPutRecordsRequest"), float64(kinesisRecords))
+	m.observe(countKey("UserRecordsPerPutRecordsRequest"), float64(userRecords))
+	if kinesisRecords > 0 {
+		m.observe(countKey("UserRecordsPerKinesisRecord"), float64(userRecords)/float64(kinesisRecords))
+	}
+	m.observe(metricKey{name: "RequestTime", unit: types.StandardUnitMilliseconds}, milliseconds(latency))
+}
+
+// ShardRecordsSent implements producer.ShardMetrics
+func (m *Metrics) ShardRecordsSent(stream, shardID string, records int, bytes int, latency time.Duration) {
+	m.observe(metricKey{name: "KinesisRecordsPut", unit: types.StandardUnitCount, streamName: stream, shardID: shardID}, float64(records))
+	m.observe(metricKey{name: "KinesisRecordsDataPut", unit: types.StandardUnitBytes, streamName: stream, shardID: shardID}, float64(bytes))
+}
+
+// ShardRecordsThrottled implements producer.ShardMetrics. Throttled records are counted
+// by RecordsFailed.
+func (m *Metrics) ShardRecordsThrottled(stream, shardID string, count int) {}
+
+// RecordsFailed implements producer.RecordErrorMetrics
+func (m *Metrics) RecordsFailed(code string, count int) {
+	m.observe(metricKey{name: "ErrorsByCode", unit: types.StandardUnitCount, errorCode: code}, float64(count))
+	m.observe(countKey("AllErrors"), float64(count))
+}
+
+// RecordsRetried implements producer.Metrics
+func (m *Metrics) RecordsRetried(count int) {
+	m.observe(countKey("RetriesPerRecord"), float64(count))
+}
+
+// RecordsThrottled implements producer.Metrics. Throttled records are counted by
+// RecordsFailed.
+func (m *Metrics) RecordsThrottled(count int) {}
+
+// RecordsDropped implements producer.Metrics. The KPL has no matching metric.
+func (m *Metrics) RecordsDropped(count int) {}
+
+// BacklogDepth implements producer.Metrics
+func (m *Metrics) BacklogDepth(depth int) {
+	m.observe(countKey("UserRecordsPending"), float64(depth))
+}
+
+// UserRecordBuffered implements producer.BufferingMetrics
+func (m *Metrics) UserRecordBuffered(d time.Duration) {
+	m.observe(metricKey{name: "BufferingTime", unit: types.StandardUnitMilliseconds}, milliseconds(d))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
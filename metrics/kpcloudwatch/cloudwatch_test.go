@@ -0,0 +1,182 @@
+package kpcloudwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/stretchr/testify/require"
+)
+
+type clientMock struct {
+	mu       sync.Mutex
+	inputs   []*cloudwatch.PutMetricDataInput
+	failures int
+}
+
+func (c *clientMock) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return nil, errors.New("throttling")
+	}
+	c.inputs = append(c.inputs, params)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// datums returns the uploaded datums by metric name and dimensions
+func (c *clientMock) datums() map[string]types.MetricDatum {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	datums := make(map[string]types.MetricDatum)
+	for _, input := range c.inputs {
+		for _, datum := range input.MetricData {
+			name := []string{aws.ToString(datum.MetricName)}
+			for _, dimension := range datum.Dimensions {
+				name = append(name, aws.ToString(dimension.Name)+"="+aws.ToString(dimension.Value))
+			}
+			datums[strings.Join(name, ",")] = datum
+		}
+	}
+	return datums
+}
+
+func newMetrics(t *testing.T, client Client, config Config) *Metrics {
+	config.Client = client
+	config.Interval = time.Hour
+	m, err := New(config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		m.Close(context.Background())
+	})
+	return m
+}
+
+func requireSet(t *testing.T, datum types.MetricDatum, count, sum, minimum, maximum float64) {
+	t.Helper()
+	require.Equal(t, &types.StatisticSet{
+		SampleCount: aws.Float64(count),
+		Sum:         aws.Float64(sum),
+		Minimum:     aws.Float64(minimum),
+		Maximum:     aws.Float64(maximum),
+	}, datum.StatisticValues)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	require.EqualError(t, err, "kpcloudwatch: Client must be set")
+	_, err = New(Config{Client: &clientMock{}, Granularity: GranularityStream})
+	require.EqualError(t, err, "kpcloudwatch: StreamName must be set with stream or shard granularity")
+}
+
+func TestAggregation(t *testing.T) {
+	client := &clientMock{}
+	m := newMetrics(t, client, Config{})
+
+	m.UserRecordsPut(1, 100)
+	m.UserRecordsPut(3, 50)
+	m.RequestSent(2, 4, 150, 20*time.Millisecond)
+	require.NoError(t, m.Upload(context.Background()))
+
+	require.Len(t, client.inputs, 1)
+	require.Equal(t, DefaultNamespace, aws.ToString(client.inputs[0].Namespace))
+	datums := client.datums()
+	requireSet(t, datums["UserRecordsReceived"], 2, 4, 1, 3)
+	requireSet(t, datums["UserRecordsDataPut"], 2, 150, 50, 100)
+	require.Equal(t, types.StandardUnitBytes, datums["UserRecordsDataPut"].Unit)
+	requireSet(t, datums["UserRecordsPerKinesisRecord"], 1, 2, 2, 2)
+	requireSet(t, datums["RequestTime"], 1, 20, 20, 20)
+	require.Equal(t, types.StandardUnitMilliseconds, datums["RequestTime"].Unit)
+
+	// the sets are reset after an upload
+	require.NoError(t, m.Upload(context.Background()))
+	require.Len(t, client.inputs, 1)
+}
+
+func TestDimensions(t *testing.T) {
+	for name, tc := range map[string]struct {
+		granularity Granularity
+		keys        []string
+	}{
+		"global": {
+			granularity: GranularityGlobal,
+			keys:        []string{"AllErrors", "ErrorsByCode,ErrorCode=Throttled", "KinesisRecordsPut", "UserRecordsReceived"},
+		},
+		"stream": {
+			granularity: GranularityStream,
+			keys: []string{
+				"AllErrors", "AllErrors,StreamName=foo",
+				"ErrorsByCode,ErrorCode=Throttled", "ErrorsByCode,StreamName=foo,ErrorCode=Throttled",
+				"KinesisRecordsPut", "KinesisRecordsPut,StreamName=bar",
+				"UserRecordsReceived", "UserRecordsReceived,StreamName=foo",
+			},
+		},
+		"shard": {
+			granularity: GranularityShard,
+			keys: []string{
+				"AllErrors", "AllErrors,StreamName=foo",
+				"ErrorsByCode,ErrorCode=Throttled", "ErrorsByCode,StreamName=foo,ErrorCode=Throttled",
+				"KinesisRecordsPut", "KinesisRecordsPut,StreamName=bar", "KinesisRecordsPut,StreamName=bar,ShardId=shardId-1",
+				"UserRecordsReceived", "UserRecordsReceived,StreamName=foo",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &clientMock{}
+			m := newMetrics(t, client, Config{StreamName: "foo", Granularity: tc.granularity})
+
+			m.UserRecordsPut(1, 10)
+			// shards are reported with the stream they belong to
+			m.ShardRecordsSent("bar", "shardId-1", 2, 20, time.Millisecond)
+			m.RecordsFailed("Throttled", 1)
+			require.NoError(t, m.Upload(context.Background()))
+
+			var keys []string
+			for key := range client.datums() {
+				if !strings.HasPrefix(key, "UserRecordsDataPut") && !strings.HasPrefix(key, "KinesisRecordsDataPut") {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			require.Equal(t, tc.keys, keys)
+		})
+	}
+}
+
+func TestUploadSplit(t *testing.T) {
+	client := &clientMock{}
+	m := newMetrics(t, client, Config{})
+
+	// one datum per error code and AllErrors
+	for i := 0; i < 1500; i++ {
+		m.RecordsFailed(fmt.Sprintf("Error%d", i), 1)
+	}
+	require.NoError(t, m.Upload(context.Background()))
+	require.Len(t, client.inputs, 2)
+	require.Len(t, client.inputs[0].MetricData, maxDatums)
+	require.Len(t, client.inputs[1].MetricData, 501)
+	require.Len(t, client.datums(), 1501)
+}
+
+func TestUploadFailure(t *testing.T) {
+	client := &clientMock{failures: 1}
+	m := newMetrics(t, client, Config{})
+
+	m.RecordsRetried(2)
+	require.Error(t, m.Upload(context.Background()))
+
+	// the metrics of the failed upload are merged with the metrics observed since
+	m.RecordsRetried(4)
+	require.NoError(t, m.Upload(context.Background()))
+	require.Len(t, client.inputs, 1)
+	requireSet(t, client.datums()["RetriesPerRecord"], 2, 6, 2, 4)
+}
@@ -0,0 +1,236 @@
+package kpprometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+// Metrics implements producer.Metrics and prometheus.Collector for kinesis-producer
+type Metrics struct {
+	userRecordsPut    prometheus.Counter
+	userBytesPut      prometheus.Counter
+	kinesisRecordsPut prometheus.Counter
+	bytesSent         prometheus.Counter
+	aggregationRatio  prometheus.Histogram
+	putRecordsLatency prometheus.Histogram
+	retries           prometheus.Counter
+	throttles         prometheus.Counter
+	dropped           prometheus.Counter
+	recordErrors      *prometheus.CounterVec
+	shardRecords      *prometheus.CounterVec
+	shardBytes        *prometheus.CounterVec
+	shardThrottles    *prometheus.CounterVec
+	shardLatency      *prometheus.HistogramVec
+	backlogDepth      prometheus.Gauge
+	mirrorRecords     *prometheus.CounterVec
+	mirrorFailures    *prometheus.CounterVec
+	mirrorDropped     *prometheus.CounterVec
+	mirrorLatency     *prometheus.HistogramVec
+	mirrorLag         *prometheus.GaugeVec
+}
+
+var (
+	_ producer.Metrics            = (*Metrics)(nil)
+	_ producer.RecordErrorMetrics = (*Metrics)(nil)
+	_ producer.ShardMetrics       = (*Metrics)(nil)
+	_ producer.MirrorMetrics      = (*Metrics)(nil)
+)
+
+// New creates Metrics using the given namespace and constant labels. The result must be
+// registered with a prometheus.Registerer to be exported.
+func New(namespace string, labels prometheus.Labels) *Metrics {
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{
+			Namespace:   namespace,
+			Subsystem:   "kinesis_producer",
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}
+	}
+	histogramOpts := func(name, help string, buckets []float64) prometheus.HistogramOpts {
+		o := opts(name, help)
+		return prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        o.Name,
+			Help:        o.Help,
+			ConstLabels: o.ConstLabels,
+			Buckets:     buckets,
+		}
+	}
+	return &Metrics{
+		userRecordsPut:    prometheus.NewCounter(prometheus.CounterOpts(opts("user_records_put_total", "Number of user records accepted by Put."))),
+		userBytesPut:      prometheus.NewCounter(prometheus.CounterOpts(opts("user_bytes_put_total", "Number of user record bytes accepted by Put, including partition keys."))),
+		kinesisRecordsPut: prometheus.NewCounter(prometheus.CounterOpts(opts("kinesis_records_put_total", "Number of kinesis records sent with PutRecords."))),
+		bytesSent:         prometheus.NewCounter(prometheus.CounterOpts(opts("bytes_sent_total", "Number of bytes sent with PutRecords."))),
+		aggregationRatio: prometheus.NewHistogram(histogramOpts(
+			"aggregation_ratio",
+			"Number of user records per kinesis record in each PutRecords request.",
+			prometheus.ExponentialBuckets(1, 2, 12),
+		)),
+		putRecordsLatency: prometheus.NewHistogram(histogramOpts(
+			"put_records_latency_seconds",
+			"Latency of PutRecords requests.",
+			prometheus.DefBuckets,
+		)),
+		retries:   prometheus.NewCounter(prometheus.CounterOpts(opts("retries_total", "Number of kinesis records retried."))),
+		throttles: prometheus.NewCounter(prometheus.CounterOpts(opts("throttles_total", "Number of kinesis records throttled."))),
+		dropped:   prometheus.NewCounter(prometheus.CounterOpts(opts("dropped_records_total", "Number of user records that failed permanently."))),
+		recordErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("record_errors_total", "Number of kinesis records that failed, by error code.")),
+			[]string{"code"},
+		),
+		shardRecords: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("shard_records_total", "Number of kinesis records delivered, by stream and shard.")),
+			[]string{"stream", "shard"},
+		),
+		shardBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("shard_bytes_total", "Number of bytes delivered, by stream and shard.")),
+			[]string{"stream", "shard"},
+		),
+		shardThrottles: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("shard_throttles_total", "Number of kinesis records throttled, by stream and shard.")),
+			[]string{"stream", "shard"},
+		),
+		shardLatency: prometheus.NewHistogramVec(histogramOpts(
+			"shard_latency_seconds",
+			"Latency of PutRecords requests that delivered records, by stream and shard.",
+			prometheus.DefBuckets,
+		), []string{"stream", "shard"}),
+		backlogDepth: prometheus.NewGauge(prometheus.GaugeOpts(opts("backlog_depth", "Number of Puts waiting in the backlog."))),
+		mirrorRecords: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("mirror_records_total", "Number of kinesis records delivered to the mirror, by region.")),
+			[]string{"region"},
+		),
+		mirrorFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("mirror_failures_total", "Number of kinesis records that failed to be delivered to the mirror, by region.")),
+			[]string{"region"},
+		),
+		mirrorDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("mirror_dropped_records_total", "Number of kinesis records that were not mirrored, by region.")),
+			[]string{"region"},
+		),
+		mirrorLatency: prometheus.NewHistogramVec(histogramOpts(
+			"mirror_latency_seconds",
+			"Latency of PutRecords requests to the mirror, by region.",
+			prometheus.DefBuckets,
+		), []string{"region"}),
+		mirrorLag: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts(opts("mirror_lag_seconds", "Time between the first request of the last mirrored batch and its delivery to the mirror, by region.")),
+			[]string{"region"},
+		),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.userRecordsPut,
+		m.userBytesPut,
+		m.kinesisRecordsPut,
+		m.bytesSent,
+		m.aggregationRatio,
+		m.putRecordsLatency,
+		m.retries,
+		m.throttles,
+		m.dropped,
+		m.recordErrors,
+		m.shardRecords,
+		m.shardBytes,
+		m.shardThrottles,
+		m.shardLatency,
+		m.backlogDepth,
+		m.mirrorRecords,
+		m.mirrorFailures,
+		m.mirrorDropped,
+		m.mirrorLatency,
+		m.mirrorLag,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// UserRecordsPut implements producer.Metrics
+func (m *Metrics) UserRecordsPut(count int, bytes int) {
+	m.userRecordsPut.Add(float64(count))
+	m.userBytesPut.Add(float64(bytes))
+}
+
+// RequestSent implements producer.Metrics
+func (m *Metrics) RequestSent(kinesisRecords int, userRecords int, bytes int, latency time.Duration) {
+	m.kinesisRecordsPut.Add(float64(kinesisRecords))
+	m.bytesSent.Add(float64(bytes))
+	if kinesisRecords > 0 {
+		m.aggregationRatio.Observe(float64(userRecords) / float64(kinesisRecords))
+	}
+	m.putRecordsLatency.Observe(latency.Seconds())
+}
+
+// RecordsRetried implements producer.Metrics
+func (m *Metrics) RecordsRetried(count int) {
+	m.retries.Add(float64(count))
+}
+
+// RecordsThrottled implements producer.Metrics
+func (m *Metrics) RecordsThrottled(count int) {
+	m.throttles.Add(float64(count))
+}
+
+// RecordsDropped implements producer.Metrics
+func (m *Metrics) RecordsDropped(count int) {
+	m.dropped.Add(float64(count))
+}
+
+// RecordsFailed implements producer.RecordErrorMetrics
+func (m *Metrics) RecordsFailed(code string, count int) {
+	m.recordErrors.WithLabelValues(code).Add(float64(count))
+}
+
+// ShardRecordsSent implements producer.ShardMetrics
+func (m *Metrics) ShardRecordsSent(stream, shardID string, records int, bytes int, latency time.Duration) {
+	m.shardRecords.WithLabelValues(stream, shardID).Add(float64(records))
+	m.shardBytes.WithLabelValues(stream, shardID).Add(float64(bytes))
+	m.shardLatency.WithLabelValues(stream, shardID).Observe(latency.Seconds())
+}
+
+// ShardRecordsThrottled implements producer.ShardMetrics
+func (m *Metrics) ShardRecordsThrottled(stream, shardID string, count int) {
+	m.shardThrottles.WithLabelValues(stream, shardID).Add(float64(count))
+}
+
+// BacklogDepth implements producer.Metrics
+func (m *Metrics) BacklogDepth(depth int) {
+	m.backlogDepth.Set(float64(depth))
+}
+
+// MirrorRequestSent implements producer.MirrorMetrics
+func (m *Metrics) MirrorRequestSent(region string, delivered, failed int, latency time.Duration) {
+	m.mirrorRecords.WithLabelValues(region).Add(float64(delivered))
+	m.mirrorFailures.WithLabelValues(region).Add(float64(failed))
+	m.mirrorLatency.WithLabelValues(region).Observe(latency.Seconds())
+}
+
+// MirrorRecordsDropped implements producer.MirrorMetrics
+func (m *Metrics) MirrorRecordsDropped(region string, count int) {
+	m.mirrorDropped.WithLabelValues(region).Add(float64(count))
+}
+
+// MirrorLag implements producer.MirrorMetrics
+func (m *Metrics) MirrorLag(region string, lag time.Duration) {
+	m.mirrorLag.WithLabelValues(region).Set(lag.Seconds())
+}
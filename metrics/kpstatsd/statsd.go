@@ -0,0 +1,271 @@
+// Package kpstatsd sends the metrics of a producer to StatsD, with DogStatsD tags for the
+// stream, shard and error code.
+package kpstatsd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+const (
+	defaultAddr          = "127.0.0.1:8125"
+	defaultPrefix        = "kinesis_producer."
+	defaultFlushInterval = time.Second
+	// defaultMaxPacketSize keeps packets within the MTU of most networks
+	defaultMaxPacketSize = 1432
+)
+
+// Config configures Metrics.
+type Config struct {
+	// Addr is the UDP address of the StatsD server. Default to 127.0.0.1:8125.
+	Addr string
+
+	// Prefix is prepended to the metric names. Default to "kinesis_producer.".
+	Prefix string
+
+	// Tags are added to every metric, e.g. "env:prod". Default to nil.
+	Tags []string
+
+	// DisableTags sends plain StatsD metrics without tags. The metrics of shards and error
+	// codes are not sent then. Default to false.
+	DisableTags bool
+
+	// FlushInterval is the longest time metrics are buffered before they are sent.
+	// Default to 1s.
+	FlushInterval time.Duration
+
+	// SampleRate is the fraction of the histogram and timing samples that are sent, e.g.
+	// the BufferingTime of each user record. Counters and gauges are not sampled. Between
+	// 0 and 1. Default to 1.
+	SampleRate float64
+
+	// MaxPacketSize is the largest UDP packet sent. Default to 1432 bytes.
+	MaxPacketSize int
+
+	// Logger logs failed writes. Default to producer.NopLogger.
+	Logger producer.Logger
+}
+
+// Metrics implements producer.Metrics by sending the metrics to StatsD over UDP. Lines
+// are buffered and sent when a packet is full or every FlushInterval. Call Close to send
+// the last metrics.
+type Metrics struct {
+	config Config
+	conn   net.Conn
+	tags   string
+
+	mu  sync.Mutex
+	buf []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	_ producer.Metrics            = (*Metrics)(nil)
+	_ producer.RecordErrorMetrics = (*Metrics)(nil)
+	_ producer.ShardMetrics       = (*Metrics)(nil)
+	_ producer.BufferingMetrics   = (*Metrics)(nil)
+)
+
+// New creates Metrics sending to config.Addr.
+func New(config Config) (*Metrics, error) {
+	if config.Addr == "" {
+		config.Addr = defaultAddr
+	}
+	if config.Prefix == "" {
+		config.Prefix = defaultPrefix
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = 1
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = defaultMaxPacketSize
+	}
+	if config.Logger == nil {
+		config.Logger = &producer.NopLogger{}
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, errors.New("kpstatsd: SampleRate must be between 0 and 1")
+	}
+	conn, err := net.Dial("udp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("kpstatsd: dial %s: %w", config.Addr, err)
+	}
+	m := &Metrics{
+		config: config,
+		conn:   conn,
+		buf:    make([]byte, 0, config.MaxPacketSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if !config.DisableTags {
+		m.tags = strings.Join(config.Tags, ",")
+	}
+	go m.loop()
+	return m, nil
+}
+
+func (m *Metrics) loop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			m.flush()
+			m.mu.Unlock()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close sends the buffered metrics and closes the connection. Close the producer first.
+func (m *Metrics) Close() error {
+	close(m.stop)
+	<-m.done
+	m.mu.Lock()
+	m.flush()
+	m.mu.Unlock()
+	return m.conn.Close()
+}
+
+// flush sends the buffered lines. Callers must hold the lock.
+func (m *Metrics) flush() {
+	if len(m.buf) == 0 {
+		return
+	}
+	if _, err := m.conn.Write(m.buf); err != nil {
+		m.config.Logger.Error("kpstatsd: write", err)
+	}
+	m.buf = m.buf[:0]
+}
+
+// send buffers a metric line, e.g. "kinesis_producer.retries:1|c|#stream:foo". tags are
+// added to Config.Tags.
+func (m *Metrics) send(name, value, kind string, rate float64, tags ...string) {
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+	line := make([]byte, 0, 64)
+	line = append(line, m.config.Prefix...)
+	line = append(line, name...)
+	line = append(line, ':')
+	line = append(line, value...)
+	line = append(line, '|')
+	line = append(line, kind...)
+	if rate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, rate, 'f', -1, 64)
+	}
+	if !m.config.DisableTags && (m.tags != "" || len(tags) > 0) {
+		line = append(line, "|#"...)
+		line = append(line, m.tags...)
+		for i, tag := range tags {
+			if i > 0 || m.tags != "" {
+				line = append(line, ',')
+			}
+			line = append(line, tag...)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.buf) > 0 && len(m.buf)+1+len(line) > m.config.MaxPacketSize {
+		m.flush()
+	}
+	if len(m.buf) > 0 {
+		m.buf = append(m.buf, '\n')
+	}
+	m.buf = append(m.buf, line...)
+}
+
+func (m *Metrics) count(name string, value int, tags ...string) {
+	m.send(name, strconv.Itoa(value), "c", 1, tags...)
+}
+
+func (m *Metrics) timing(name string, d time.Duration, tags ...string) {
+	ms := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	m.send(name, ms, "ms", m.config.SampleRate, tags...)
+}
+
+// UserRecordsPut implements producer.Metrics
+func (m *Metrics) UserRecordsPut(count int, bytes int) {
+	m.count("user_records_put", count)
+	m.count("user_bytes_put", bytes)
+}
+
+// RequestSent implements producer.Metrics
+func (m *Metrics) RequestSent(kinesisRecords int, userRecords int, bytes int, latency time.Duration) {
+	m.count("kinesis_records_put", kinesisRecords)
+	m.count("bytes_sent", bytes)
+	if kinesisRecords > 0 {
+		ratio := strconv.FormatFloat(float64(userRecords)/float64(kinesisRecords), 'f', -1, 64)
+		m.send("aggregation_ratio", ratio, "h", m.config.SampleRate)
+	}
+	m.timing("request_latency", latency)
+}
+
+// RecordsRetried implements producer.Metrics
+func (m *Metrics) RecordsRetried(count int) {
+	m.count("retries", count)
+}
+
+// RecordsThrottled implements producer.Metrics
+func (m *Metrics) RecordsThrottled(count int) {
+	m.count("throttles", count)
+}
+
+// RecordsDropped implements producer.Metrics
+func (m *Metrics) RecordsDropped(count int) {
+	m.count("dropped_records", count)
+}
+
+// RecordsFailed implements producer.RecordErrorMetrics
+func (m *Metrics) RecordsFailed(code string, count int) {
+	if m.config.DisableTags {
+		return
+	}
+	m.count("record_errors", count, "error_code:"+code)
+}
+
+// ShardRecordsSent implements producer.ShardMetrics
+func (m *Metrics) ShardRecordsSent(stream, shardID string, records int, bytes int, latency time.Duration) {
+	if m.config.DisableTags {
+		return
+	}
+	m.count("shard_records", records, "stream:"+stream, "shard:"+shardID)
+	m.count("shard_bytes", bytes, "stream:"+stream, "shard:"+shardID)
+	m.timing("shard_latency", latency, "stream:"+stream, "shard:"+shardID)
+}
+
+// ShardRecordsThrottled implements producer.ShardMetrics
+func (m *Metrics) ShardRecordsThrottled(stream, shardID string, count int) {
+	if m.config.DisableTags {
+		return
+	}
+	m.count("shard_throttles", count, "stream:"+stream, "shard:"+shardID)
+}
+
+// BacklogDepth implements producer.Metrics
+func (m *Metrics) BacklogDepth(depth int) {
+	m.send("backlog_depth", strconv.Itoa(depth), "g", 1)
+}
+
+// UserRecordBuffered implements producer.BufferingMetrics
+func (m *Metrics) UserRecordBuffered(d time.Duration) {
+	m.timing("buffering_time", d)
+}
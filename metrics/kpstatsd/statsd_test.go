@@ -0,0 +1,130 @@
+package kpstatsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a local StatsD server and returns its address and the packets received
+func listen(t *testing.T) (string, <-chan string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	packets := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				close(packets)
+				return
+			}
+			packets <- string(buf[:n])
+		}
+	}()
+	return conn.LocalAddr().String(), packets
+}
+
+// receive returns the next packet
+func receive(t *testing.T, packets <-chan string) string {
+	select {
+	case packet := <-packets:
+		return packet
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet received")
+		return ""
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	addr, packets := listen(t)
+	m, err := New(Config{Addr: addr, Tags: []string{"env:prod"}, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	m.UserRecordsPut(2, 10)
+	m.RequestSent(1, 2, 12, 1500*time.Microsecond)
+	m.RecordsFailed("InternalFailure", 1)
+	m.ShardRecordsThrottled("foo", "shardId-0", 3)
+	m.BacklogDepth(5)
+	require.NoError(t, m.Close())
+
+	require.Equal(t, strings.Join([]string{
+		"kinesis_producer.user_records_put:2|c|#env:prod",
+		"kinesis_producer.user_bytes_put:10|c|#env:prod",
+		"kinesis_producer.kinesis_records_put:1|c|#env:prod",
+		"kinesis_producer.bytes_sent:12|c|#env:prod",
+		"kinesis_producer.aggregation_ratio:2|h|#env:prod",
+		"kinesis_producer.request_latency:1.5|ms|#env:prod",
+		"kinesis_producer.record_errors:1|c|#env:prod,error_code:InternalFailure",
+		"kinesis_producer.shard_throttles:3|c|#env:prod,stream:foo,shard:shardId-0",
+		"kinesis_producer.backlog_depth:5|g|#env:prod",
+	}, "\n"), receive(t, packets))
+}
+
+func TestMetricsDisableTags(t *testing.T) {
+	addr, packets := listen(t)
+	m, err := New(Config{Addr: addr, Prefix: "kpl.", Tags: []string{"env:prod"}, DisableTags: true, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	// the metrics of shards and error codes are not sent without tags
+	m.RecordsRetried(1)
+	m.RecordsFailed("InternalFailure", 1)
+	m.ShardRecordsSent("foo", "shardId-0", 1, 10, time.Millisecond)
+	m.RecordsDropped(2)
+	require.NoError(t, m.Close())
+
+	require.Equal(t, "kpl.retries:1|c\nkpl.dropped_records:2|c", receive(t, packets))
+}
+
+func TestMetricsSampleRate(t *testing.T) {
+	addr, packets := listen(t)
+	m, err := New(Config{Addr: addr, SampleRate: 0.5, FlushInterval: time.Hour, MaxPacketSize: 65000})
+	require.NoError(t, err)
+
+	// timings are sampled, counters are not
+	for i := 0; i < 1000; i++ {
+		m.UserRecordBuffered(time.Millisecond)
+	}
+	m.RecordsThrottled(1)
+	require.NoError(t, m.Close())
+
+	lines := strings.Split(receive(t, packets), "\n")
+	require.Equal(t, "kinesis_producer.throttles:1|c", lines[len(lines)-1])
+	require.InDelta(t, 500, len(lines)-1, 100)
+	require.Equal(t, "kinesis_producer.buffering_time:1|ms|@0.5", lines[0])
+
+	_, err = New(Config{Addr: addr, SampleRate: 2})
+	require.EqualError(t, err, "kpstatsd: SampleRate must be between 0 and 1")
+}
+
+func TestMetricsMaxPacketSize(t *testing.T) {
+	addr, packets := listen(t)
+	m, err := New(Config{Addr: addr, MaxPacketSize: 100, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	// each line is 36 bytes, so that two fit in a packet with the newline but not three
+	for i := 0; i < 5; i++ {
+		m.RecordsDropped(1)
+	}
+	require.NoError(t, m.Close())
+
+	line := "kinesis_producer.dropped_records:1|c"
+	require.Equal(t, line+"\n"+line, receive(t, packets))
+	require.Equal(t, line+"\n"+line, receive(t, packets))
+	require.Equal(t, line, receive(t, packets))
+}
+
+func TestMetricsFlushInterval(t *testing.T) {
+	addr, packets := listen(t)
+	m, err := New(Config{Addr: addr, FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer m.Close()
+
+	// buffered lines are sent without waiting for Close
+	m.BacklogDepth(1)
+	require.Equal(t, "kinesis_producer.backlog_depth:1|g", receive(t, packets))
+}
@@ -0,0 +1,76 @@
+package producer
+
+import (
+	"context"
+	"time"
+
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+)
+
+// The PutterFunc type is an adapter to allow the use of ordinary functions as Putter.
+type PutterFunc func(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error)
+
+// PutRecords calls f(ctx, params, optFns...).
+func (f PutterFunc) PutRecords(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	return f(ctx, params, optFns...)
+}
+
+// Middleware wraps the Putter PutRecords requests are sent with, e.g. for custom logging,
+// auditing or failure injection, see Config.Middlewares. It is called for every attempt,
+// including retries, and must be safe for concurrent use.
+type Middleware func(next Putter) Putter
+
+// PutRecordsCall is a PutRecords request passed to the after hook of Hook.
+type PutRecordsCall struct {
+	Input *k.PutRecordsInput
+	// Output is nil if Err is set
+	Output  *k.PutRecordsOutput
+	Err     error
+	Latency time.Duration
+	// Metadata identifies the response, from Output or Err
+	Metadata ResponseMetadata
+}
+
+// Hook returns a Middleware that calls before ahead of each PutRecords request and after
+// once it returned. Either may be nil.
+func Hook(before func(ctx context.Context, input *k.PutRecordsInput), after func(ctx context.Context, call PutRecordsCall)) Middleware {
+	return func(next Putter) Putter {
+		return PutterFunc(func(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+			if before != nil {
+				before(ctx, params)
+			}
+			start := time.Now()
+			out, err := next.PutRecords(ctx, params, optFns...)
+			if after != nil {
+				after(ctx, PutRecordsCall{
+					Input:    params,
+					Output:   out,
+					Err:      err,
+					Latency:  time.Since(start),
+					Metadata: responseMetadata(out, err),
+				})
+			}
+			return out, err
+		})
+	}
+}
+
+// RequestOptions returns a Middleware that appends the options returned by optFns to each
+// PutRecords request, e.g. to raise the API call timeout of large requests or to sign
+// requests with the values of ctx. optFns may return nil.
+func RequestOptions(optFns func(ctx context.Context, input *k.PutRecordsInput) []func(*k.Options)) Middleware {
+	return func(next Putter) Putter {
+		return PutterFunc(func(ctx context.Context, params *k.PutRecordsInput, opts ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+			// opts may share its backing array with Config.ClientOptions
+			return next.PutRecords(ctx, params, append(opts[:len(opts):len(opts)], optFns(ctx, params)...)...)
+		})
+	}
+}
+
+// chain wraps client with middlewares, the first one being the outermost
+func chain(client Putter, middlewares []Middleware) Putter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		client = middlewares[i](client)
+	}
+	return client
+}
@@ -0,0 +1,107 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewares(t *testing.T) {
+	var (
+		order []string
+		calls []PutRecordsCall
+	)
+	trace := func(name string) Middleware {
+		return func(next Putter) Putter {
+			return PutterFunc(func(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+				order = append(order, name)
+				return next.PutRecords(ctx, params, optFns...)
+			})
+		}
+	}
+	// the first attempt fails before reaching the client
+	injected := false
+	chaos := func(next Putter) Putter {
+		return PutterFunc(func(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+			if !injected {
+				injected = true
+				return nil, &types.ProvisionedThroughputExceededException{}
+			}
+			return next.PutRecords(ctx, params, optFns...)
+		})
+	}
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		Backoff:        &FixedBackoff{},
+		Logger:         &NopLogger{},
+		Client:         client,
+		Middlewares: []Middleware{
+			Hook(nil, func(ctx context.Context, call PutRecordsCall) { calls = append(calls, call) }),
+			trace("outer"),
+			trace("inner"),
+			chaos,
+		},
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	require.Equal(t, []string{"outer", "inner", "outer", "inner"}, order)
+	require.Len(t, calls, 2)
+	require.True(t, errors.As(calls[0].Err, new(*types.ProvisionedThroughputExceededException)))
+	require.Nil(t, calls[0].Output)
+	require.NoError(t, calls[1].Err)
+	require.Equal(t, "foo", aws.ToString(calls[1].Input.StreamName))
+	require.Len(t, calls[1].Input.Records, 1)
+	require.GreaterOrEqual(t, calls[1].Latency, time.Duration(0))
+	require.Equal(t, 1, client.calls)
+}
+
+func TestClientOptions(t *testing.T) {
+	var regions []string
+	client := PutterFunc(func(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		var o k.Options
+		for _, fn := range optFns {
+			fn(&o)
+		}
+		regions = append(regions, o.Region)
+		return &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}, nil
+	})
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		ClientOptions:  []func(*k.Options){func(o *k.Options) { o.Region = "eu-west-1" }},
+		Middlewares: []Middleware{
+			RequestOptions(func(ctx context.Context, input *k.PutRecordsInput) []func(*k.Options) {
+				if len(input.Records) < 2 {
+					return nil
+				}
+				return []func(*k.Options){func(o *k.Options) { o.Region = o.Region + "-batch" }}
+			}),
+		},
+	})
+	p.Start()
+	defer p.Stop()
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, []string{"eu-west-1", "eu-west-1-batch"}, regions)
+}
@@ -0,0 +1,308 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	defaultMirrorRegion         = "mirror"
+	defaultMirrorBacklogCount   = 100
+	defaultMirrorMaxConnections = 4
+	defaultMirrorMaxRetries     = 10
+)
+
+// MirrorConfig configures the mirroring of every batch to a secondary stream, e.g. in
+// another region for active-active disaster recovery, see Config.Mirror.
+type MirrorConfig struct {
+	// Client puts the batches to the mirror, e.g. a kinesis.Client of another region.
+	// Middlewares do not apply to it. Required.
+	Client Putter
+
+	// Region names the mirror in Stats, logs and metrics. Default to "mirror".
+	Region string
+
+	// StreamName is the name or ARN of the mirror stream. Default to the empty string,
+	// batches are put to a stream of the same name as the one they were put to.
+	StreamName string
+
+	// BacklogCount is the maximum number of batches waiting to be mirrored. Batches are
+	// dropped once it is full, so that the mirror never slows down the primary stream.
+	// Default to 100.
+	BacklogCount int
+
+	// MaxConnections is the number of requests in flight to the mirror. Default to 4.
+	MaxConnections int
+
+	// MaxRetries is the maximum number of times the records of a batch are retried after a
+	// failed request, whatever the error, before they are dropped. Default to 10.
+	MaxRetries int
+
+	// Backoff determines the delay between retries. Default to Config.Backoff.
+	Backoff Backoff
+}
+
+func (c *MirrorConfig) defaults(config *Config) {
+	if c.Region == "" {
+		c.Region = defaultMirrorRegion
+	}
+	if c.BacklogCount == 0 {
+		c.BacklogCount = defaultMirrorBacklogCount
+	}
+	if c.MaxConnections == 0 {
+		c.MaxConnections = defaultMirrorMaxConnections
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMirrorMaxRetries
+	}
+	if c.Backoff == nil {
+		c.Backoff = config.Backoff
+	}
+}
+
+// MirrorStats are the counters of the mirror, see Stats.Mirror. Records are counted as
+// kinesis records.
+type MirrorStats struct {
+	Region string
+	// Healthy reports whether the last request to the mirror delivered records
+	Healthy bool
+	// Pending is the number of batches waiting to be mirrored
+	Pending int
+	// Records is the number of records delivered to the mirror
+	Records int64
+	// Retries is the number of records retried
+	Retries int64
+	// Drops is the number of records that were not mirrored, because the backlog was full
+	// or their retries were exhausted
+	Drops int64
+	// Lag is the time between the first request of the last mirrored batch to the primary
+	// stream and its delivery to the mirror
+	Lag time.Duration
+}
+
+// mirrorBatch is a batch of kinesis records to put to the mirror
+type mirrorBatch struct {
+	stream  string
+	records []types.PutRecordsRequestEntry
+	// putAt is the time of the first request of the batch to the primary stream
+	putAt   time.Time
+	attempt int
+	delay   time.Duration
+}
+
+// mirror puts every batch sent to the primary stream to the mirror stream
+type mirror struct {
+	*MirrorConfig
+	clock  Clock
+	logger Logger
+	// metrics are the Config.Metrics if they implement MirrorMetrics, or nil
+	metrics MirrorMetrics
+	// ctx is used for PutRecords requests and is canceled if Shutdown times out
+	ctx     context.Context
+	cancel  context.CancelFunc
+	batches chan *mirrorBatch
+	wg      sync.WaitGroup
+
+	records, retries, drops int64
+	// lag of the last mirrored batch in nanoseconds
+	lag int64
+	// healthy is 1 while the last request delivered records
+	healthy int32
+}
+
+func newMirror(config *Config) *mirror {
+	if config.Mirror == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &mirror{
+		MirrorConfig: config.Mirror,
+		clock:        config.Clock,
+		logger:       config.Logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		batches:      make(chan *mirrorBatch, config.Mirror.BacklogCount),
+		healthy:      1,
+	}
+	m.metrics, _ = config.Metrics.(MirrorMetrics)
+	return m
+}
+
+func (m *mirror) start() {
+	m.wg.Add(m.MaxConnections)
+	for i := 0; i < m.MaxConnections; i++ {
+		go m.loop()
+	}
+}
+
+// add queues a batch sent to stream for the first time. The batch is dropped if the
+// backlog is full
+func (m *mirror) add(stream string, records []types.PutRecordsRequestEntry, putAt time.Time) {
+	batch := &mirrorBatch{
+		stream:  stream,
+		records: append([]types.PutRecordsRequestEntry(nil), records...),
+		putAt:   putAt,
+	}
+	select {
+	case m.batches <- batch:
+	default:
+		m.logger.Warn("mirror backlog full",
+			LogValue{"region", m.Region},
+			LogValue{"stream", stream},
+			LogValue{"records", len(records)},
+		)
+		m.drop(len(records))
+	}
+}
+
+// close waits for the pending batches to be mirrored. If ctx is done first, the requests
+// in flight are canceled and the batches left are dropped.
+func (m *mirror) close(ctx context.Context) {
+	close(m.batches)
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.cancel()
+		<-done
+	}
+	m.cancel()
+}
+
+func (m *mirror) loop() {
+	defer m.wg.Done()
+	for batch := range m.batches {
+		for batch != nil {
+			batch = m.send(batch)
+		}
+	}
+}
+
+// send puts the batch to the mirror and returns the batch to retry, if any
+func (m *mirror) send(batch *mirrorBatch) *mirrorBatch {
+	if m.ctx.Err() != nil {
+		m.drop(len(batch.records))
+		return nil
+	}
+	stream := m.StreamName
+	if stream == "" {
+		stream = batch.stream
+	}
+	input := &k.PutRecordsInput{Records: batch.records}
+	if isStreamARN(stream) {
+		input.StreamARN = &stream
+	} else {
+		input.StreamName = &stream
+	}
+	count := len(batch.records)
+	start := m.clock.Now()
+	out, err := m.Client.PutRecords(m.ctx, input)
+	now := m.clock.Now()
+	if err != nil {
+		m.setHealthy(false)
+		if m.metrics != nil {
+			m.metrics.MirrorRequestSent(m.Region, 0, count, now.Sub(start))
+		}
+		if m.ctx.Err() != nil {
+			m.drop(count)
+			return nil
+		}
+		return m.retry(batch, stream, err)
+	}
+
+	failed := int(aws.ToInt32(out.FailedRecordCount))
+	delivered := count - failed
+	m.setHealthy(delivered > 0)
+	atomic.AddInt64(&m.records, int64(delivered))
+	if delivered > 0 {
+		atomic.StoreInt64(&m.lag, int64(now.Sub(batch.putAt)))
+	}
+	if m.metrics != nil {
+		m.metrics.MirrorRequestSent(m.Region, delivered, failed, now.Sub(start))
+		if delivered > 0 {
+			m.metrics.MirrorLag(m.Region, now.Sub(batch.putAt))
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	records := make([]types.PutRecordsRequestEntry, 0, failed)
+	for i, r := range out.Records {
+		if r.ErrorCode != nil && i < count {
+			records = append(records, batch.records[i])
+			err = &PutRecordsEntryError{Code: *r.ErrorCode, Message: aws.ToString(r.ErrorMessage)}
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	batch.records = records
+	return m.retry(batch, stream, err)
+}
+
+// retry sleeps for the backoff before the batch that failed with err is retried. Returns
+// nil if the batch was dropped instead
+func (m *mirror) retry(batch *mirrorBatch, stream string, err error) *mirrorBatch {
+	values := []LogValue{
+		{"region", m.Region},
+		{"stream", stream},
+		{"records", len(batch.records)},
+		{"attempt", batch.attempt},
+	}
+	if batch.attempt >= m.MaxRetries {
+		m.logger.Error("mirror", &ErrMaxRetriesExceeded{Retries: batch.attempt, Err: err}, values...)
+		m.drop(len(batch.records))
+		return nil
+	}
+	batch.attempt++
+	batch.delay = m.Backoff.Duration(batch.attempt, batch.delay)
+	m.logger.Warn("mirror failures", append(values, LogValue{"error", err}, LogValue{"backoff", batch.delay.String()})...)
+	atomic.AddInt64(&m.retries, int64(len(batch.records)))
+
+	timer := m.clock.NewTimer(batch.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return batch
+	case <-m.ctx.Done():
+		m.drop(len(batch.records))
+		return nil
+	}
+}
+
+func (m *mirror) drop(count int) {
+	atomic.AddInt64(&m.drops, int64(count))
+	if m.metrics != nil {
+		m.metrics.MirrorRecordsDropped(m.Region, count)
+	}
+}
+
+func (m *mirror) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&m.healthy, v)
+}
+
+func (m *mirror) stats() *MirrorStats {
+	return &MirrorStats{
+		Region:  m.Region,
+		Healthy: atomic.LoadInt32(&m.healthy) == 1,
+		Pending: len(m.batches),
+		Records: atomic.LoadInt64(&m.records),
+		Retries: atomic.LoadInt64(&m.retries),
+		Drops:   atomic.LoadInt64(&m.drops),
+		Lag:     time.Duration(atomic.LoadInt64(&m.lag)),
+	}
+}
@@ -0,0 +1,111 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		calls   int
+		streams []string
+		data    []string
+	)
+	mirrorClient := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		switch calls {
+		case 1:
+			return nil, errors.New("connection refused")
+		case 2:
+			// the second record is throttled
+			return &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(1),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0")},
+					{ErrorCode: aws.String(throttledErrorCode)},
+				},
+			}, nil
+		}
+		streams = append(streams, aws.ToString(input.StreamName))
+		for _, r := range input.Records {
+			data = append(data, string(r.Data))
+		}
+		return &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}, nil
+	})
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Mirror: &MirrorConfig{
+			Client:         mirrorClient,
+			Region:         "eu-west-1",
+			StreamName:     "foo-mirror",
+			MaxConnections: 1,
+			Backoff:        &FixedBackoff{},
+		},
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar", WithoutAggregation()))
+	p.Stop()
+
+	require.Len(t, client.data, 2)
+	require.Equal(t, []string{"foo-mirror"}, streams)
+	require.Len(t, data, 1)
+	stats := p.Stats().Mirror
+	require.NotNil(t, stats)
+	require.Equal(t, "eu-west-1", stats.Region)
+	require.True(t, stats.Healthy)
+	require.Equal(t, int64(2), stats.Records)
+	require.Equal(t, int64(3), stats.Retries)
+	require.Zero(t, stats.Drops)
+	require.Zero(t, stats.Pending)
+}
+
+func TestMirrorBacklogFull(t *testing.T) {
+	config := &Config{
+		Clock:  systemClock{},
+		Logger: &NopLogger{},
+		Mirror: &MirrorConfig{
+			Client:       PutterFunc(nil),
+			BacklogCount: 1,
+		},
+	}
+	config.Mirror.defaults(config)
+	m := newMirror(config)
+	entries := []types.PutRecordsRequestEntry{{Data: []byte("hello")}, {Data: []byte("world")}}
+
+	// the mirror is not started, so the second batch does not fit in the backlog
+	m.add("foo", entries, time.Now())
+	m.add("foo", entries, time.Now())
+	stats := m.stats()
+	require.Equal(t, 1, stats.Pending)
+	require.Equal(t, int64(2), stats.Drops)
+
+	// batches left when Shutdown times out are dropped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.cancel()
+	m.start()
+	m.close(ctx)
+	require.Equal(t, int64(4), m.stats().Drops)
+}
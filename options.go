@@ -0,0 +1,487 @@
+package producer
+
+import (
+	"time"
+
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/achunariov/kinesis-producer/compression"
+)
+
+// Option configures a Producer created with NewProducer. Options set the Config field of
+// the same name; see Config for defaults and limits.
+type Option func(*Config)
+
+// WithStreamName sets the Kinesis stream to put records to. Required.
+func WithStreamName(streamName string) Option {
+	return func(c *Config) { c.StreamName = streamName }
+}
+
+// WithStreamARN sets the ARN of the Kinesis stream to put records to, instead of
+// WithStreamName.
+func WithStreamARN(streamARN string) Option {
+	return func(c *Config) { c.StreamARN = streamARN }
+}
+
+// WithStreamRouter sets the StreamRouter selecting the stream of each record.
+func WithStreamRouter(router StreamRouter) Option {
+	return func(c *Config) { c.StreamRouter = router }
+}
+
+// WithRouter sets the Router selecting the partition key and stream of the records put
+// with PutData.
+func WithRouter(router Router) Option {
+	return func(c *Config) { c.Router = router }
+}
+
+// WithInterceptors appends interceptors transforming each user record at Put time.
+func WithInterceptors(interceptors ...RecordInterceptor) Option {
+	return func(c *Config) { c.Interceptors = append(c.Interceptors, interceptors...) }
+}
+
+// WithDeduplication suppresses user records put within window of a record with the same
+// idempotency key.
+func WithDeduplication(window time.Duration) Option {
+	return func(c *Config) { c.DeduplicationWindow = window }
+}
+
+// WithEnvelope wraps the data of each user record in an envelope.
+func WithEnvelope(config EnvelopeConfig) Option {
+	return func(c *Config) { c.Envelope = &config }
+}
+
+// WithStreamShards sets the function returning the shards of streams other than the
+// default stream.
+func WithStreamShards(getStreamShards GetStreamShardsFunc) Option {
+	return func(c *Config) { c.GetStreamShards = getStreamShards }
+}
+
+// WithBackend sets the service records are put to.
+func WithBackend(backend Backend) Option {
+	return func(c *Config) { c.Backend = backend }
+}
+
+// WithClient sets the Putter used to send PutRecords requests. Required.
+func WithClient(client Putter) Option {
+	return func(c *Config) { c.Client = client }
+}
+
+// WithDryRun writes the records to local files instead of sending them to AWS.
+func WithDryRun(config DryRunConfig) Option {
+	return func(c *Config) { c.DryRun = &config }
+}
+
+// WithPutterFactory builds the Putter with factory instead of Client, and rebuilds it every
+// interval. A zero interval never rebuilds it.
+func WithPutterFactory(factory PutterFactory, interval time.Duration) Option {
+	return func(c *Config) {
+		c.PutterFactory = factory
+		c.PutterRefreshInterval = interval
+	}
+}
+
+// WithMiddlewares appends middlewares wrapping the Client for every PutRecords request.
+func WithMiddlewares(middlewares ...Middleware) Option {
+	return func(c *Config) { c.Middlewares = append(c.Middlewares, middlewares...) }
+}
+
+// WithWatchdog logs, and optionally recycles, PutRecords requests that stall.
+func WithWatchdog(config WatchdogConfig) Option {
+	return func(c *Config) { c.Watchdog = &config }
+}
+
+// WithClientOptions appends options passed to every PutRecords request of the Client.
+func WithClientOptions(optFns ...func(*k.Options)) Option {
+	return func(c *Config) { c.ClientOptions = append(c.ClientOptions, optFns...) }
+}
+
+// WithMirror also puts every batch to a secondary stream, e.g. in another region.
+func WithMirror(config MirrorConfig) Option {
+	return func(c *Config) { c.Mirror = &config }
+}
+
+// WithFailover sends the requests to a standby stream while the primary stream fails.
+func WithFailover(config FailoverConfig) Option {
+	return func(c *Config) { c.Failover = &config }
+}
+
+// WithFlushInterval sets the regular interval for flushing the buffer.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *Config) { c.FlushInterval = interval }
+}
+
+// WithAggregationMaxDelay sets how long a user record may wait inside an aggregated record.
+func WithAggregationMaxDelay(d time.Duration) Option {
+	return func(c *Config) { c.AggregationMaxDelay = d }
+}
+
+// WithRequestMaxDelay sets how long a drained record may wait for a fuller PutRecords
+// request.
+func WithRequestMaxDelay(d time.Duration) Option {
+	return func(c *Config) { c.RequestMaxDelay = d }
+}
+
+// WithAdaptiveFlush adjusts the flush interval to the rate of puts between min and max.
+func WithAdaptiveFlush(min, max time.Duration) Option {
+	return func(c *Config) {
+		c.AdaptiveFlush = true
+		c.MinFlushInterval = min
+		c.MaxFlushInterval = max
+	}
+}
+
+// WithShards sets the function used to populate the shard map and the interval at which
+// it is refreshed. A refresh interval of 0 disables refreshing.
+func WithShards(getShards GetShardsFunc, refreshInterval time.Duration) Option {
+	return func(c *Config) {
+		c.GetShards = getShards
+		c.ShardRefreshInterval = refreshInterval
+	}
+}
+
+// WithShardChangeCallback sets the function called when a shard refresh finds that the
+// shards of a stream changed.
+func WithShardChangeCallback(fn func(ShardChange)) Option {
+	return func(c *Config) { c.OnShardChange = fn }
+}
+
+// WithHasher sets the Hasher that maps partition keys to shards, e.g.
+// NewCachedHasher(hasher, size) to cache an expensive Hasher.
+func WithHasher(hasher Hasher) Option {
+	return func(c *Config) { c.Hasher = hasher }
+}
+
+// WithAutoPartitionKey generates a random partition key for records put with an empty
+// one.
+func WithAutoPartitionKey() Option {
+	return func(c *Config) { c.AutoPartitionKey = true }
+}
+
+// WithStickyPartitionKey generates partition keys for records put with an empty one that
+// are shared until they fill an aggregated record or interval passes.
+func WithStickyPartitionKey(interval time.Duration) Option {
+	return func(c *Config) {
+		c.AutoPartitionKey = true
+		c.StickyPartitionInterval = interval
+	}
+}
+
+// WithBatchCount sets the maximum number of records in a PutRecords request.
+func WithBatchCount(count int) Option {
+	return func(c *Config) { c.BatchCount = count }
+}
+
+// WithBatchSize sets the maximum number of bytes in a PutRecords request.
+func WithBatchSize(size int) Option {
+	return func(c *Config) { c.BatchSize = size }
+}
+
+// WithAggregateBatchCount sets the maximum number of user records in an aggregated record.
+func WithAggregateBatchCount(count int) Option {
+	return func(c *Config) { c.AggregateBatchCount = count }
+}
+
+// WithAggregateBatchSize sets the maximum number of bytes in an aggregated record.
+func WithAggregateBatchSize(size int) Option {
+	return func(c *Config) { c.AggregateBatchSize = size }
+}
+
+// WithAggregatePayloadUnits drains aggregated records before they exceed units PUT payload
+// units of 25KiB.
+func WithAggregatePayloadUnits(units int) Option {
+	return func(c *Config) { c.AggregatePayloadUnits = units }
+}
+
+// WithBacklogCount sets the number of Puts that can be buffered before Put blocks.
+func WithBacklogCount(count int) Option {
+	return func(c *Config) { c.BacklogCount = count }
+}
+
+// WithMaxBufferedBytes limits the total size of the user records held by the producer
+// until they are delivered or fail, before Put applies the overflow policy.
+func WithMaxBufferedBytes(n int) Option {
+	return func(c *Config) { c.MaxBufferedBytes = n }
+}
+
+// WithMaxChunkSize enables splitting user records larger than size into chunks.
+func WithMaxChunkSize(size int) Option {
+	return func(c *Config) { c.MaxChunkSize = size }
+}
+
+// WithCompression compresses the data of user records of at least threshold bytes with
+// codec.
+func WithCompression(codec compression.Codec, threshold int) Option {
+	return func(c *Config) {
+		c.Compression = codec
+		c.CompressionThreshold = threshold
+	}
+}
+
+// WithEncryptor encrypts the data of user records with encryptor.
+func WithEncryptor(encryptor Encryptor) Option {
+	return func(c *Config) { c.Encryptor = encryptor }
+}
+
+// WithLargeRecordStore offloads user records larger than threshold bytes to store. A
+// threshold of 0 uses the maximum record size.
+func WithLargeRecordStore(store LargeRecordStore, threshold int) Option {
+	return func(c *Config) {
+		c.LargeRecordStore = store
+		c.LargeRecordThreshold = threshold
+	}
+}
+
+// WithWriteAheadLog stores the user records accepted by Put in log until they are
+// delivered, and puts the records left in log on Start.
+func WithWriteAheadLog(log WriteAheadLog) Option {
+	return func(c *Config) { c.WriteAheadLog = log }
+}
+
+// WithIntegrityChecks verifies that the data of records did not change between Put and
+// the PutRecords request, see Config.VerifyIntegrity.
+func WithIntegrityChecks() Option {
+	return func(c *Config) { c.VerifyIntegrity = true }
+}
+
+// WithWireVersion sends aggregated records in version of the wire package format.
+func WithWireVersion(version int) Option {
+	return func(c *Config) { c.WireVersion = version }
+}
+
+// WithMaxJSONSize rejects the values put with PutJSON whose JSON encoding is larger than
+// size bytes, see Config.MaxJSONSize.
+func WithMaxJSONSize(size int) Option {
+	return func(c *Config) { c.MaxJSONSize = size }
+}
+
+// WithAggregationDisabled sends each user record as its own PutRecords entry, see
+// Config.DisableAggregation.
+func WithAggregationDisabled() Option {
+	return func(c *Config) { c.DisableAggregation = true }
+}
+
+// WithAggregationFormat packs user records into aggregated records with format instead of
+// the KPL format.
+func WithAggregationFormat(format AggregationFormat) Option {
+	return func(c *Config) { c.AggregationFormat = format }
+}
+
+// WithThrottleHook sets the hook feeding external signals into the producer and the
+// interval it is called at.
+func WithThrottleHook(hook ThrottleHook, interval time.Duration) Option {
+	return func(c *Config) {
+		c.ThrottleHook = hook
+		c.ThrottleInterval = interval
+	}
+}
+
+// WithBarriers enables Producer.Barrier.
+func WithBarriers() Option {
+	return func(c *Config) { c.Barriers = true }
+}
+
+// WithOrderedDelivery preserves the order of records put to the same shard.
+func WithOrderedDelivery() Option {
+	return func(c *Config) { c.OrderedDelivery = true }
+}
+
+// WithShardConcurrency sends the records of each shard in their own requests, with at
+// most n requests in flight per shard.
+func WithShardConcurrency(n int) Option {
+	return func(c *Config) { c.ShardConcurrency = n }
+}
+
+// WithRequestTimeout sets the deadline of each PutRecords request.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.RequestTimeout = timeout }
+}
+
+// WithCircuitBreaker enables the circuit breaker.
+func WithCircuitBreaker(config CircuitBreakerConfig) Option {
+	return func(c *Config) { c.CircuitBreaker = &config }
+}
+
+// WithCreateStream creates the stream if it does not exist and waits until it is active
+// in NewProducer.
+func WithCreateStream(config CreateStreamConfig) Option {
+	return func(c *Config) { c.CreateStream = &config }
+}
+
+// WithAutoTune tunes the producer to the capacity mode and shards of each stream described
+// with client.
+func WithAutoTune(client StreamDescriber) Option {
+	return func(c *Config) { c.AutoTune = client }
+}
+
+// WithAutoScaling scales the shards of the stream to the throughput of the producer.
+func WithAutoScaling(config AutoScalingConfig) Option {
+	return func(c *Config) { c.AutoScaling = &config }
+}
+
+// WithSampling samples and filters the user records at Put time, see Config.Sampling.
+func WithSampling(config SamplingConfig) Option {
+	return func(c *Config) { c.Sampling = &config }
+}
+
+// WithCost estimates the costs of the records delivered, and limits the records put per
+// hour if config has a Budget, see Config.Cost.
+func WithCost(config CostConfig) Option {
+	return func(c *Config) { c.Cost = &config }
+}
+
+// WithConnectionScaling scales the connections of the producer to its load.
+func WithConnectionScaling(config ConnectionScalingConfig) Option {
+	return func(c *Config) { c.ConnectionScaling = &config }
+}
+
+// WithMaxRetries sets the maximum number of retries before records are failed.
+func WithMaxRetries(n int) Option {
+	return func(c *Config) { c.MaxRetries = n }
+}
+
+// WithMaxRecordAge drops the user records that were put longer than age before they are
+// sent.
+func WithMaxRecordAge(age time.Duration) Option {
+	return func(c *Config) { c.MaxRecordAge = age }
+}
+
+// WithDeadLetter sets the DeadLetter receiving permanently failed records.
+func WithDeadLetter(deadLetter DeadLetter) Option {
+	return func(c *Config) { c.DeadLetter = deadLetter }
+}
+
+// WithOverflowPolicy sets the behavior of Put when the backlog is full.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(c *Config) { c.OverflowPolicy = policy }
+}
+
+// WithSpillDir spills records to temporary files in dir when the backlog is full, see
+// OverflowSpill.
+func WithSpillDir(dir string) Option {
+	return func(c *Config) {
+		c.OverflowPolicy = OverflowSpill
+		c.SpillDir = dir
+	}
+}
+
+// WithMaxConnections sets the number of concurrent PutRecords requests.
+func WithMaxConnections(connections int) Option {
+	return func(c *Config) { c.MaxConnections = connections }
+}
+
+// WithBackoff sets the delay policy between retries.
+func WithBackoff(backoff Backoff) Option {
+	return func(c *Config) { c.Backoff = backoff }
+}
+
+// WithErrorClassifier sets which errors are retried, throttles or fatal.
+func WithErrorClassifier(classifier ErrorClassifier) Option {
+	return func(c *Config) { c.ErrorClassifier = classifier }
+}
+
+// WithRateLimit enables pacing of requests to stay below the per shard limits, keeping
+// headroom percent of the limits in reserve.
+func WithRateLimit(headroom int) Option {
+	return func(c *Config) {
+		c.DisableRateLimit = false
+		c.RateLimitHeadroom = headroom
+	}
+}
+
+// WithAdaptiveRateLimit slows down the rate limit of throttled shards.
+func WithAdaptiveRateLimit() Option {
+	return func(c *Config) { c.AdaptiveRateLimit = true }
+}
+
+// WithoutRateLimit disables pacing of requests to stay below the per shard limits.
+func WithoutRateLimit() Option {
+	return func(c *Config) { c.DisableRateLimit = true }
+}
+
+// WithLogger sets the logger used by the Producer.
+func WithLogger(logger Logger) Option {
+	return func(c *Config) { c.Logger = logger }
+}
+
+// WithVerbose enables verbose logging.
+//
+// Deprecated: see Config.Verbose.
+func WithVerbose(verbose bool) Option {
+	return func(c *Config) { c.Verbose = verbose }
+}
+
+// WithMetrics sets the Metrics implementation receiving the Producer's metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Config) { c.Metrics = metrics }
+}
+
+// WithHotShardThreshold sets the utilization above which a shard is reported as hot to
+// fn, which may be nil to only log a warning.
+func WithHotShardThreshold(threshold float64, fn func(HotShard)) Option {
+	return func(c *Config) {
+		c.HotShardThreshold = threshold
+		c.OnHotShard = fn
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry TracerProvider used to create spans.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *Config) { c.TracerProvider = provider }
+}
+
+// WithClock sets the Clock of the producer.
+func WithClock(clock Clock) Option {
+	return func(c *Config) { c.Clock = clock }
+}
+
+// PutOption configures a single Put call
+type PutOption func(*putOptions)
+
+type putOptions struct {
+	policy OverflowPolicy
+	// disableAggregation puts the user record as a plain kinesis record
+	disableAggregation bool
+	// priority sends the user record ahead of other records
+	priority bool
+	// idempotencyKey deduplicates the user record instead of Config.IdempotencyKey
+	idempotencyKey string
+	// headers are added to the envelope of the user record
+	headers map[string]string
+	// correlationID is reported to NotifyDeliveries once the user record is delivered
+	correlationID string
+	// reservation holds the backlog slots and buffered bytes reserved by PutBatch. Nil
+	// for a single Put
+	reservation *reservation
+}
+
+// WithoutAggregation puts the user record as a plain kinesis record in the PutRecords
+// request instead of aggregating it, for consumers that can not deaggregate records.
+func WithoutAggregation() PutOption {
+	return func(o *putOptions) { o.disableAggregation = true }
+}
+
+// WithPriority sends the user record as a plain kinesis record in the next PutRecords
+// request, ahead of the aggregated records and without waiting for the flush interval.
+// Ignored with OrderedDelivery.
+func WithPriority() PutOption {
+	return func(o *putOptions) { o.priority = true }
+}
+
+// WithIdempotencyKey sets the key the user record is deduplicated with when
+// DeduplicationWindow is set, instead of the key returned by Config.IdempotencyKey.
+func WithIdempotencyKey(key string) PutOption {
+	return func(o *putOptions) { o.idempotencyKey = key }
+}
+
+// WithHeaders adds headers to the envelope of the user record when Config.Envelope is
+// set. They take precedence over the headers of the EnvelopeConfig.
+func WithHeaders(headers map[string]string) PutOption {
+	return func(o *putOptions) { o.headers = headers }
+}
+
+// WithCorrelationID reports the delivery of the user record to NotifyDeliveries with id,
+// e.g. the offset of the record in its upstream source.
+func WithCorrelationID(id string) PutOption {
+	return func(o *putOptions) { o.correlationID = id }
+}
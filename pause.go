@@ -0,0 +1,84 @@
+package producer
+
+import "sync/atomic"
+
+// Pause stops flushing records until Resume is called, e.g. during a deployment cutover or
+// a maintenance window of consumers. Puts are still accepted and records are held in
+// memory; no record is dropped. Records held while paused count towards
+// MaxBufferedBytes, so once it is exceeded Puts block or overflow according to the
+// OverflowPolicy. Without MaxBufferedBytes, the held records are only bounded by memory.
+// Requests in flight are completed. Flush blocks until the Producer is resumed or its
+// context is done.
+func (p *Producer) Pause() {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	p.userPaused = true
+	p.applyPause()
+}
+
+// Resume sends the records held since Pause and resumes flushing, unless a Throttle still
+// pauses the Producer
+func (p *Producer) Resume() {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	p.userPaused = false
+	p.applyPause()
+}
+
+// throttlePause pauses or resumes the Producer on behalf of a Throttle. A Producer paused
+// with Pause stays paused.
+func (p *Producer) throttlePause(paused bool) {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	p.throttlePaused = paused
+	p.applyPause()
+}
+
+// unpause resumes the Producer whoever paused it, e.g. to deliver the held records on
+// Shutdown
+func (p *Producer) unpause() {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	p.userPaused, p.throttlePaused = false, false
+	p.applyPause()
+}
+
+// applyPause holds the records while the Producer is paused by Pause or a Throttle, and
+// sends them once neither does. Callers must hold pauseMu.
+func (p *Producer) applyPause() {
+	paused := p.userPaused || p.throttlePaused
+	if paused == p.paused {
+		return
+	}
+	p.paused = paused
+	if paused {
+		p.pool.hold(true)
+		p.Logger.Info("paused")
+		return
+	}
+	p.pool.hold(false)
+	// the Puts blocked on MaxBufferedBytes ask again for the flush skipped while paused
+	p.buffered.wake()
+	p.Logger.Info("resumed")
+}
+
+// Paused reports whether the Producer is paused
+func (p *Producer) Paused() bool {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	return p.paused
+}
+
+// hold stops or restarts sending requests. Records are still accepted and batched
+func (wp *WorkerPool) hold(held bool) {
+	var v int32
+	if held {
+		v = 1
+	}
+	atomic.StoreInt32(&wp.held, v)
+	// wake up the loop to pick up the change
+	select {
+	case wp.wake <- struct{}{}:
+	default:
+	}
+}
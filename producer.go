@@ -7,47 +7,420 @@
 package producer
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/achunariov/kinesis-producer/chunking"
 )
 
+// staleShardsInterval is the minimum interval between the shard refreshes of records
+// written to another shard than the one they were aggregated for
+const staleShardsInterval = time.Second
+
 type Producer struct {
 	sync.RWMutex
 	*Config
 
+	// shardMap of StreamName or StreamARN
 	shardMap *ShardMap
 
-	// semaphore controling size of Put backlog before blocking
-	backlog semaphore
+	// shard maps of every stream records have been put to, including the default stream
+	streams   map[string]*ShardMap
+	streamsMu sync.RWMutex
+	// fetching holds the streams whose shards are being fetched, see streamShardMap. The
+	// channel is closed once done. Guarded by streamsMu
+	fetching map[string]chan struct{}
+
+	// orderMu serializes adding records to the worker pool with OrderedDelivery
+	orderMu sync.Mutex
+
+	// batchMu serializes the reservations of PutBatch, so that two batches waiting for
+	// the backlog can not hold part of it each
+	batchMu sync.Mutex
+
+	// backlog limits the Puts in progress and queues their drained records
+	backlog *backlog
+
+	// bytes of the user records held from Put until they are delivered or failed, limited
+	// by MaxBufferedBytes
+	buffered *byteSemaphore
+
+	// signals the main loop to flush the aggregators because MaxBufferedBytes is exceeded
+	pressure chan struct{}
+
+	// signals the main loop to refresh the shards because Kinesis wrote a record to
+	// another shard than the one it was aggregated for
+	stale chan struct{}
+
+	// freed is signaled when the backlog frees up, see Ready
+	freed broadcast
+
+	// hookDone is closed once the ThrottleHook is no longer polled. Nil without a
+	// ThrottleHook
+	hookDone chan struct{}
+
+	// throttle is the Throttle applied with SetThrottle or the ThrottleHook
+	throttle throttle
+
+	// barrier counts the records accepted between calls to Barrier. Nil unless
+	// Config.Barriers is set
+	barrier *barrier
+
+	// drainRate estimates the throughput, see Backlog
+	drainRate drainRate
 
 	pool *WorkerPool
 
+	// scaler scales the default stream. Nil unless AutoScaling is set
+	scaler *autoScaler
+
+	// connections scales MaxConnections. Nil unless ConnectionScaling is set
+	connections *connectionScaler
+
+	// recentErrors are the last errors notified, see DebugInfo
+	recentErrors recentErrors
+
+	// hot reports hot shards. Nil unless HotShardThreshold is set
+	hot *hotShards
+
+	// sticky shares partition keys between keyless records. Nil unless
+	// StickyPartitionInterval is set
+	sticky *stickyPartitioner
+
+	// dedup suppresses duplicate records. Nil unless DeduplicationWindow is set
+	dedup *deduplicator
+	// sampler drops records at Put time. Nil unless Sampling is set
+	sampler *sampler
+	// budget counts the records put against the Cost.Budget. Nil unless it is set
+	budget *budget
+
+	// capacities of the streams by name. Empty unless AutoTune is set. Guarded by
+	// streamsMu
+	capacities map[string]*capacity
+	// tuneConnections is set if AutoTune tunes MaxConnections, which was not set
+	tuneConnections bool
+	// tunedConnections is the MaxConnections last set by AutoTune
+	tunedConnections int
+
+	tracer trace.Tracer
+
 	// stopped signals that the producer is no longer accepting Puts
 	stopped chan struct{}
 
 	// signal for the main loop that stop has been called and it should drain the backlog
 	done chan struct{}
 
+	// requests for the main loop to flush all records and wait for delivery
+	flushes chan flushRequest
+
+	// configuration updates applied by the main loop. configMu serializes UpdateConfig
+	updates  chan configRequest
+	configMu sync.Mutex
+	// started is set by Start, before which UpdateConfig applies the updates itself.
+	// Guarded by configMu
+	started bool
+	// snapshot is a copy of the configuration published by the main loop whenever it
+	// changes the settings, for UpdateConfig. It is never modified
+	snapshot atomic.Pointer[Config]
+
+	// paused is set while the Producer is paused by Pause, userPaused, or by a Throttle,
+	// throttlePaused
+	paused         bool
+	userPaused     bool
+	throttlePaused bool
+	pauseMu        sync.Mutex
+
+	// spill holds the records spilled to disk by the OverflowSpill policy. Nil with other
+	// policies. spilled signals that records were spilled
+	spill   *spillQueue
+	spilled chan struct{}
+
 	failures chan error
+	// deliveries receives the deliveries of records put with WithCorrelationID. Nil until
+	// NotifyDeliveries is called
+	deliveries chan Delivery
 }
 
+// New creates a Producer from config. New panics if the configuration is invalid, the
+// stream can not be described, see AutoTune, or the initial call to Config.GetShards
+// fails; use NewProducer to get an error instead. Unlike NewProducer, New does not
+// require a Client, PutterFactory or DryRun, as before NewProducer was added, but the
+// Producer can not send records without one. New does not create the stream, which
+// may take minutes and fail on AWS errors, and panics if CreateStream is set; use
+// NewProducer instead.
 func New(config *Config) *Producer {
+	if config.CreateStream != nil {
+		panic(errors.New("kinesis: CreateStream requires NewProducer"))
+	}
+	p, err := newProducer(config)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// NewProducer creates a Producer configured with the given options. An error is returned
+// if the resulting configuration is invalid, the PutterFactory fails, the stream can not be
+// created or described, or the initial call to GetShards fails.
+func NewProducer(opts ...Option) (*Producer, error) {
+	config := &Config{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return newProducer(config)
+}
+
+func newProducer(config *Config) (*Producer, error) {
+	// AutoTune does not override a MaxConnections set explicitly
+	tuneConnections := config.AutoTune != nil && config.MaxConnections == 0
 	config.defaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.DryRun != nil {
+		config.Client = NewFilePutter(*config.DryRun)
+	} else if config.PutterFactory != nil {
+		client, err := newRefreshingPutter(context.Background(), config)
+		if err != nil {
+			return nil, err
+		}
+		config.Client = client
+	}
+	if config.CreateStream != nil && config.DryRun == nil {
+		if err := EnsureStream(context.Background(), config.StreamName, *config.CreateStream); err != nil {
+			return nil, err
+		}
+	}
+	capacities := make(map[string]*capacity)
+	if config.AutoTune != nil {
+		capacity, err := config.describeCapacity(config.defaultStream())
+		if err != nil {
+			return nil, err
+		}
+		capacities[config.defaultStream()] = capacity
+		if tuneConnections {
+			config.MaxConnections = capacity.maxConnections()
+		}
+	}
 	p := &Producer{
-		Config:  config,
-		backlog: make(chan struct{}, config.BacklogCount),
-		pool:    NewWorkerPool(config),
-		stopped: make(chan struct{}),
-		done:    make(chan struct{}),
+		Config:    config,
+		backlog:   newBacklog(config.BacklogCount),
+		buffered:  newByteSemaphore(config.MaxBufferedBytes),
+		pressure:  make(chan struct{}, 1),
+		stale:     make(chan struct{}, 1),
+		drainRate: drainRate{at: config.Clock.Now()},
+		pool:      NewWorkerPool(config),
+		tracer:    config.TracerProvider.Tracer(tracerName),
+		stopped:   make(chan struct{}),
+		done:      make(chan struct{}),
+		flushes:   make(chan flushRequest),
+		updates:   make(chan configRequest),
+		streams:   make(map[string]*ShardMap),
+		fetching:  make(map[string]chan struct{}),
 	}
+	p.capacities = capacities
+	p.tuneConnections = tuneConnections
+	p.tunedConnections = config.MaxConnections
+	p.snapshot.Store(config.clone())
+	p.scaler = newAutoScaler(config, p.pool.stats)
+	p.connections = newConnectionScaler(config, p.pool.stats, p.backlog.len)
+	p.sticky = newStickyPartitioner(config)
+	p.dedup = newDeduplicator(config)
+	if config.ThrottleHook != nil {
+		p.hookDone = make(chan struct{})
+	}
+	p.sampler = newSampler(config, p.pool.stats)
+	p.budget = newBudget(config)
+	p.hot = newHotShards(config, p.pool.stats)
+	p.barrier = newBarrier(config)
 	shards, _, err := p.GetShards(nil)
 	if err != nil {
 		// TODO: maybe just log and continue or fallback to default? if ShardRefreshInterval
 		// 			 is set, it may succeed a later time
-		panic(err)
+		return nil, err
 	}
-	p.shardMap = NewShardMap(shards, p.AggregateBatchCount)
-	return p
+	if p.OverflowPolicy == OverflowSpill {
+		if p.spill, err = newSpillQueue(p.SpillDir); err != nil {
+			return nil, err
+		}
+		p.spilled = make(chan struct{}, 1)
+	}
+	p.shardMap = p.addStream(p.defaultStream(), shards)
+	atomic.StoreInt64(&p.pool.stats.flushInterval, int64(p.FlushInterval))
+	atomic.StoreInt64(&p.pool.stats.shardsRefreshed, p.Clock.Now().UnixNano())
+	p.pool.shardKey = p.shardKey
+	p.pool.shardID = p.shardID
+	p.pool.resolved = p.resolved
+	p.pool.stale = p.shardsStale
+	if p.AutoTune != nil {
+		p.logCapacity(p.defaultStream())
+	}
+	return p, nil
+}
+
+// shardKey returns the key of the shard entry is written to. All entries of a stream
+// without shards share the same key.
+func (p *Producer) shardKey(stream string, entry types.PutRecordsRequestEntry) string {
+	p.streamsMu.RLock()
+	shardMap := p.streams[stream]
+	p.streamsMu.RUnlock()
+	if shardMap != nil {
+		if key, ok := shardMap.ShardKey(entry); ok {
+			return stream + "/" + key
+		}
+	}
+	return stream
+}
+
+// shardID returns the id of the shard entry is written to. Empty if the shards of stream
+// are unknown.
+func (p *Producer) shardID(stream string, entry types.PutRecordsRequestEntry) string {
+	p.streamsMu.RLock()
+	shardMap := p.streams[stream]
+	p.streamsMu.RUnlock()
+	if shardMap != nil {
+		if shard, ok := shardMap.shard(entry); ok {
+			return aws.ToString(shard.ShardId)
+		}
+	}
+	return ""
+}
+
+// shardsStale asks the main loop to refresh the shards, as a record of stream was written
+// to another shard than its shard map predicted.
+func (p *Producer) shardsStale(stream string) {
+	select {
+	case p.stale <- struct{}{}:
+	default:
+	}
+}
+
+// addStream creates the shard map and rate limiter of stream. Callers must hold the
+// streams lock, unless the producer has not been started yet.
+func (p *Producer) addStream(stream string, shards []types.Shard) *ShardMap {
+	shardMap := NewShardMap(shards, p.AggregateBatchCount)
+	shardMap.setHasher(p.Hasher)
+	shardMap.setVerifyIntegrity(p.VerifyIntegrity)
+	shardMap.setWireVersion(byte(p.WireVersion))
+	shardMap.setAggregationFormat(p.AggregationFormat)
+	p.streams[stream] = shardMap
+	p.tuneStream(stream, shardMap)
+	return shardMap
+}
+
+// newRateLimiter creates the rate limiter of the shards of shardMap
+func (p *Producer) newRateLimiter(shardMap *ShardMap) *RateLimiter {
+	limiter := NewRateLimiter(shardMap, p.RateLimitHeadroom)
+	limiter.now = p.Clock.Now
+	limiter.adaptive = p.AdaptiveRateLimit
+	return limiter
+}
+
+// streamShardMap returns the shard map of stream. The shard map of a stream is created
+// when the first record is put to it. Its shards are fetched once, without holding
+// streamsMu, so that the Puts to other streams are not held back.
+func (p *Producer) streamShardMap(stream string) (*ShardMap, error) {
+	p.streamsMu.RLock()
+	shardMap, ok := p.streams[stream]
+	p.streamsMu.RUnlock()
+	if ok {
+		return shardMap, nil
+	}
+
+	for {
+		p.streamsMu.Lock()
+		if shardMap, ok := p.streams[stream]; ok {
+			p.streamsMu.Unlock()
+			return shardMap, nil
+		}
+		if fetched, ok := p.fetching[stream]; ok {
+			p.streamsMu.Unlock()
+			// fetch again if it failed
+			<-fetched
+			continue
+		}
+		fetched := make(chan struct{})
+		p.fetching[stream] = fetched
+		p.streamsMu.Unlock()
+
+		shards, _, err := p.getShards(stream, nil)
+		var capacity *capacity
+		if err == nil && p.AutoTune != nil {
+			// the stream is tuned on the next shard refresh instead
+			var describeErr error
+			if capacity, describeErr = p.describeCapacity(stream); describeErr != nil {
+				p.Logger.Error("auto tune", describeErr, LogValue{"stream", stream})
+			}
+		}
+
+		p.streamsMu.Lock()
+		delete(p.fetching, stream)
+		close(fetched)
+		if err != nil {
+			p.streamsMu.Unlock()
+			return nil, err
+		}
+		if capacity != nil {
+			p.capacities[stream] = capacity
+		}
+		shardMap := p.addStream(stream, shards)
+		p.streamsMu.Unlock()
+		if capacity != nil {
+			p.logCapacity(stream)
+		}
+		return shardMap, nil
+	}
+}
+
+// streamShardMaps returns a copy of the shard maps of all streams
+func (p *Producer) streamShardMaps() map[string]*ShardMap {
+	p.streamsMu.RLock()
+	defer p.streamsMu.RUnlock()
+	streams := make(map[string]*ShardMap, len(p.streams))
+	for stream, shardMap := range p.streams {
+		streams[stream] = shardMap
+	}
+	return streams
+}
+
+// getShards calls the GetShardsFunc of stream
+func (p *Producer) getShards(stream string, old []types.Shard) ([]types.Shard, bool, error) {
+	if stream == p.defaultStream() {
+		return p.GetShards(old)
+	}
+	if p.GetStreamShards == nil {
+		return nil, false, nil
+	}
+	return p.GetStreamShards(stream, old)
+}
+
+// route returns the stream of a user record
+func (p *Producer) route(userRecord UserRecord) string {
+	if p.StreamRouter != nil {
+		if stream := p.StreamRouter(unwrapUserRecord(userRecord)); stream != "" {
+			return stream
+		}
+	}
+	return p.defaultStream()
 }
 
 // Put `data` using `partitionKey` asynchronously. This method is thread-safe.
@@ -57,66 +430,515 @@ func New(config *Config) *Producer {
 // When unrecoverable error has detected(e.g: trying to put to in a stream that
 // doesn't exist), the message will returned by the Producer.
 // Add a listener with `Producer.NotifyFailures` to handle undeliverable messages.
-func (p *Producer) Put(data []byte, partitionKey string) error {
-	return p.PutUserRecord(NewDataRecord(data, partitionKey))
+// opts configure this call only, e.g. WithoutAggregation.
+func (p *Producer) Put(data []byte, partitionKey string, opts ...PutOption) error {
+	return p.PutUserRecord(p.newDataRecord(data, partitionKey), opts...)
+}
+
+// PutWithPriority puts `data` using `partitionKey` asynchronously ahead of the other
+// records, see WithPriority. This method is thread-safe.
+func (p *Producer) PutWithPriority(data []byte, partitionKey string, opts ...PutOption) error {
+	return p.PutUserRecord(p.newDataRecord(data, partitionKey), append(opts, WithPriority())...)
+}
+
+// PutWithExplicitHashKey puts `data` using `partitionKey` asynchronously, mapping it to a
+// shard with `explicitHashKey` instead of the MD5 hash of the partition key.
+// `explicitHashKey` is a decimal integer between 0 and 2^128-1. This method is thread-safe.
+func (p *Producer) PutWithExplicitHashKey(data []byte, partitionKey, explicitHashKey string) error {
+	hk, ok := new(big.Int).SetString(explicitHashKey, 10)
+	if !ok {
+		return &ErrIllegalExplicitHashKey{
+			UserRecord:      NewDataRecord(data, partitionKey),
+			ExplicitHashKey: explicitHashKey,
+		}
+	}
+	record := p.newDataRecord(data, partitionKey)
+	record.explicitHashKey = hk
+	return p.PutUserRecord(record)
 }
 
-func (p *Producer) PutUserRecord(userRecord UserRecord) error {
+// PutWithResult puts `data` using `partitionKey` asynchronously and returns a PutFuture
+// that is resolved once the record has been delivered or has failed permanently.
+// This method is thread-safe.
+func (p *Producer) PutWithResult(data []byte, partitionKey string, opts ...PutOption) (*PutFuture, error) {
+	return p.PutUserRecordWithResult(p.newDataRecord(data, partitionKey), opts...)
+}
+
+// PutUserRecordWithResult is the same as PutWithResult but accepts a UserRecord.
+// Failure notifications will still be sent for the user record in addition to
+// resolving the returned PutFuture.
+func (p *Producer) PutUserRecordWithResult(userRecord UserRecord, opts ...PutOption) (*PutFuture, error) {
+	future := newPutFuture()
+	tracked := track(userRecord)
+	tracked.future = future
+	err := p.PutUserRecord(tracked, opts...)
+	if isRejected(err) {
+		// the record was never accepted
+		return nil, err
+	}
+	return future, err
+}
+
+// PutWithContext is the same as Put but associates ctx with the record. If ctx is done
+// before the record is sent, the record is dropped and an *ErrRecordCanceled is sent to
+// NotifyFailures. Use context.WithoutCancel for records that must outlive ctx. The trace
+// span in ctx is linked to the spans of the PutRecords request the record is sent with.
+//
+// ctx is also passed on to the PutRecords request: its values if every record of the
+// request was put with the same context, and its cancellation and deadline once the
+// contexts of every record of the request are done, in which case the records are dropped
+// rather than retried.
+func (p *Producer) PutWithContext(ctx context.Context, data []byte, partitionKey string, opts ...PutOption) error {
+	return p.PutUserRecordWithContext(ctx, p.newDataRecord(data, partitionKey), opts...)
+}
+
+// PutUserRecordWithContext is the same as PutWithContext but accepts a UserRecord.
+func (p *Producer) PutUserRecordWithContext(ctx context.Context, userRecord UserRecord, opts ...PutOption) error {
+	if err := ctx.Err(); err != nil {
+		return &ErrRecordCanceled{UserRecord: userRecord, Err: err}
+	}
+
+	tracked := track(userRecord)
+	tracked.ctx = ctx
+	return p.PutUserRecord(tracked, opts...)
+}
+
+// newDataRecord creates the DataRecord put by Put and its variants. An empty partition key
+// is replaced by a random one if Config.AutoPartitionKey is set.
+func (p *Producer) newDataRecord(data []byte, partitionKey string) *DataRecord {
+	if partitionKey == "" && p.AutoPartitionKey && p.Backend != BackendFirehose {
+		if p.sticky != nil {
+			partitionKey = p.sticky.partitionKey(len(data))
+		} else {
+			partitionKey = randomPartitionKey()
+		}
+	}
+	return NewDataRecord(data, partitionKey)
+}
+
+// randomPartitionKey returns 128 random bits as a hex string. math/rand is enough to
+// spread records evenly across shards and is cheaper than a UUID.
+func randomPartitionKey() string {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], rand.Uint64())
+	binary.LittleEndian.PutUint64(b[8:], rand.Uint64())
+	return hex.EncodeToString(b[:])
+}
+
+// PutUserRecord puts a UserRecord asynchronously. See Put.
+func (p *Producer) PutUserRecord(userRecord UserRecord, opts ...PutOption) error {
+	return p.putUserRecord("", userRecord, p.putOptions(p.OverflowPolicy, opts))
+}
+
+// PutToStream is the same as Put but puts the record to stream instead of the stream
+// selected by Config.StreamRouter. An empty stream puts to Config.StreamName, or
+// Config.StreamARN. A stream starting with "arn:" is put to by ARN.
+func (p *Producer) PutToStream(stream string, data []byte, partitionKey string, opts ...PutOption) error {
+	return p.PutUserRecordToStream(stream, p.newDataRecord(data, partitionKey), opts...)
+}
+
+// PutUserRecordToStream is the same as PutToStream but accepts a UserRecord.
+func (p *Producer) PutUserRecordToStream(stream string, userRecord UserRecord, opts ...PutOption) error {
+	if stream == "" {
+		stream = p.defaultStream()
+	}
+	return p.putUserRecord(stream, userRecord, p.putOptions(p.OverflowPolicy, opts))
+}
+
+// TryPut is the same as Put but never blocks. If the backlog is full, *ErrBacklogFull is
+// returned regardless of the configured OverflowPolicy.
+func (p *Producer) TryPut(data []byte, partitionKey string, opts ...PutOption) error {
+	return p.TryPutUserRecord(p.newDataRecord(data, partitionKey), opts...)
+}
+
+// TryPutUserRecord is the same as TryPut but accepts a UserRecord.
+func (p *Producer) TryPutUserRecord(userRecord UserRecord, opts ...PutOption) error {
+	return p.putUserRecord("", userRecord, p.putOptions(OverflowError, opts))
+}
+
+// putUserRecord deduplicates, intercepts and samples a user record put by the application
+// before putting it to stream, or to the stream selected by the StreamRouter if empty
+func (p *Producer) putUserRecord(stream string, userRecord UserRecord, opts putOptions) (err error) {
+	ctx, span := p.tracer.Start(putContext(userRecord), "kinesis-producer.Put", trace.WithAttributes(
+		userRecordSizeKey.Int(userRecord.Size()),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	if span.SpanContext().IsValid() {
+		// the span of the PutRecords request links to the span of the Put
+		tracked := track(userRecord)
+		tracked.ctx = ctx
+		userRecord = tracked
+	}
+	if err := p.throttled(userRecord, opts.policy); err != nil {
+		return err
+	}
+	if p.dedup != nil {
+		if key := p.idempotencyKey(userRecord, opts); key != "" {
+			entry := p.dedup.add(key)
+			if entry == nil {
+				p.duplicate(userRecord, key)
+				return nil
+			}
+			// records that were not accepted or failed can be put again
+			tracked := track(userRecord)
+			tracked.forget = func() { p.dedup.remove(entry) }
+			userRecord = tracked
+			defer func() {
+				if isRejected(err) {
+					p.dedup.remove(entry)
+				}
+			}()
+		}
+	}
+	if opts.correlationID != "" {
+		userRecord = p.correlate(userRecord, opts.correlationID)
+	}
+	userRecord, err = p.intercept(userRecord)
+	if userRecord == nil {
+		return err
+	}
+	if userRecord = p.sample(userRecord); userRecord == nil {
+		return nil
+	}
+	if p.Envelope != nil {
+		if userRecord, err = p.wrap(userRecord, opts); err != nil {
+			return err
+		}
+	}
+	if stream == "" {
+		stream = p.route(userRecord)
+	}
+	span.SetAttributes(streamNameKey.String(stream))
+	return p.put(stream, userRecord, opts)
+}
+
+// putOptions applies opts to the default options of a put with the overflow policy
+func (p *Producer) putOptions(policy OverflowPolicy, opts []PutOption) putOptions {
+	o := putOptions{policy: policy}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// acquire buffered bytes and a backlog slot for the user record according to the
+// overflow policy. Returns false without an error if the record was dropped.
+func (p *Producer) acquire(userRecord UserRecord, size int, policy OverflowPolicy) (bool, error) {
+	if ok, err := p.acquireBytes(userRecord, size, policy); !ok {
+		return false, err
+	}
+	ok, err := p.acquireBacklog(userRecord, policy)
+	if !ok {
+		p.buffered.release(size)
+	}
+	return ok, err
+}
+
+// acquireBytes reserves size bytes of MaxBufferedBytes for the user record
+func (p *Producer) acquireBytes(userRecord UserRecord, size int, policy OverflowPolicy) (bool, error) {
+	ok, released := p.buffered.tryAcquire(size)
+	if ok {
+		return true, nil
+	}
+
+	switch policy {
+	case OverflowError:
+		return false, &ErrBacklogFull{unwrapUserRecord(userRecord)}
+	case OverflowDropNewest:
+		p.reject(userRecord)
+		return false, nil
+	}
+
+	for !ok {
+		// the oldest queued record frees up its bytes once dropped
+		if policy == OverflowDropOldest {
+			if record := p.backlog.evict(); record != nil {
+				p.evicted(record)
+				p.backlog.release(1)
+				p.freed.signal()
+				ok, released = p.buffered.tryAcquire(size)
+				continue
+			}
+		}
+		// ask the main loop to flush the aggregators to free up the buffered bytes
+		select {
+		case p.pressure <- struct{}{}:
+		default:
+		}
+		select {
+		case <-p.stopped:
+			return false, &ErrStoppedProducer{unwrapUserRecord(userRecord)}
+		case <-released:
+		}
+		ok, released = p.buffered.tryAcquire(size)
+	}
+	return true, nil
+}
+
+// acquireBacklog acquires a backlog slot for the user record
+func (p *Producer) acquireBacklog(userRecord UserRecord, policy OverflowPolicy) (bool, error) {
 	select {
 	case <-p.stopped:
-		return userRecord.(*ErrStoppedProducer)
-	// same as p.backlog.acquire() but using channel primative for select case
-	case p.backlog <- struct{}{}:
+		return false, &ErrStoppedProducer{unwrapUserRecord(userRecord)}
+	default:
+	}
+	if p.backlog.tryAcquire() {
+		return true, nil
 	}
 
-	var release = true
+	switch policy {
+	case OverflowError:
+		return false, &ErrBacklogFull{unwrapUserRecord(userRecord)}
+	case OverflowDropNewest:
+		p.reject(userRecord)
+		return false, nil
+	case OverflowDropOldest:
+		// the oldest record waiting to be added to the worker pool is dropped and hands its
+		// slot over
+		if record := p.backlog.evict(); record != nil {
+			p.evicted(record)
+			return true, nil
+		}
+	}
+
+	if !p.backlog.acquire(p.stopped) {
+		return false, &ErrStoppedProducer{unwrapUserRecord(userRecord)}
+	}
+	return true, nil
+}
+
+// evicted drops a queued record evicted by OverflowDropOldest
+func (p *Producer) evicted(record *AggregatedRecordRequest) {
+	p.drop(record, &ErrBacklogFull{})
+	p.buffered.release(bufferedSize(record.UserRecords))
+}
+
+// handOff adds the records queued in the backlog to the worker pool, until the backlog
+// is closed
+func (p *Producer) handOff() {
+	for {
+		records := p.backlog.take()
+		if records == nil {
+			return
+		}
+		p.pool.AddBatch(records)
+		p.backlog.hand(len(records))
+		p.freed.signal()
+		// the Puts blocked on MaxBufferedBytes ask again for a flush, which may have
+		// missed the records
+		p.buffered.wake()
+	}
+}
+
+// put runs the user record through the stages of a put: the circuit breaker, the
+// write-ahead log, the barrier, spilling, transformation, offloading or chunking, buffering,
+// validation and aggregation. The stages are implemented by their features.
+func (p *Producer) put(stream string, userRecord UserRecord, opts putOptions) (err error) {
+	// the chunks of a record are put once it was let through
+	if _, chunk := userRecord.(*chunkRecord); !chunk && p.pool.breaker.isOpen() {
+		return &ErrCircuitOpen{unwrapUserRecord(userRecord)}
+	}
+
+	if userRecord, err = p.logRecord(stream, userRecord); err != nil {
+		return err
+	}
+	userRecord = p.stamp(userRecord)
+	var entered, logged *trackedRecord
+	userRecord, entered = p.enterBarrier(userRecord)
 	defer func() {
-		if release {
-			p.backlog.release()
+		if isRejected(err) {
+			p.unwind(entered, logged)
 		}
 	}()
+	if p.spills(userRecord, userRecord.Size()+len(userRecord.PartitionKey()), opts.policy) {
+		return p.spillRecord(stream, userRecord)
+	}
+	if opts.policy == OverflowSpill {
+		opts.policy = OverflowBlock
+	}
+	if r, ok := userRecord.(*trackedRecord); ok && r.ack != nil {
+		// a spilled record is logged until it is put again
+		logged = r
+	}
 
-	partitionKey := userRecord.PartitionKey()
-	partitionKeySize := len(partitionKey)
-	if partitionKeySize < 1 || partitionKeySize > 256 {
-		return userRecord.(*ErrIllegalPartitionKey)
+	if userRecord, err = p.transform(userRecord); err != nil {
+		return err
 	}
 
 	// Kinesis counts partition key size towards size limits
-	recordSize := userRecord.Size() + partitionKeySize
-	if recordSize > maxRecordSize {
-		return userRecord.(*ErrRecordSizeExceeded)
+	recordSize := userRecord.Size() + len(userRecord.PartitionKey())
+
+	if p.offloads(userRecord, recordSize) {
+		pointer, err := p.offload(userRecord)
+		if err != nil {
+			return err
+		}
+		return p.put(stream, pointer, opts)
 	}
 
-	var (
-		record *AggregatedRecordRequest
-		err    error
-	)
-	// if the record size is bigger than aggregation size
-	// handle it as a simple kinesis record
-	// TODO: this logic is not enforced when doing reaggreation after shard refresh
-	if recordSize > p.AggregateBatchSize {
-		record = NewAggregatedRecordRequest(userRecord.Data(), &partitionKey, nil, []UserRecord{userRecord})
-	} else {
-		record, err = p.shardMap.Put(userRecord)
+	if p.MaxChunkSize > 0 && recordSize > p.MaxChunkSize && len(userRecord.PartitionKey()) <= 256 {
+		return p.putChunks(stream, userRecord, opts)
 	}
 
-	if record != nil {
-		// if we are going to send a record over the records channel
-		// we hold the semaphore until that record has been sent
-		// this way we can rely on p.backlog.wait() to mean all waiting puts complete and
-		// future puts are blocked
+	if ok, err := p.acquireReserved(opts.reservation, userRecord, recordSize, opts.policy); !ok {
+		return err
+	}
+
+	var release, releaseBytes = true, true
+	defer func() {
+		if release {
+			p.backlog.release(1)
+			p.freed.signal()
+		}
+		if releaseBytes {
+			p.buffered.release(recordSize)
+		}
+	}()
+
+	if err := p.checkRecord(userRecord, recordSize); err != nil {
+		return err
+	}
+
+	if p.OrderedDelivery {
+		// records must be added to the worker pool in the order they are put
+		p.orderMu.Lock()
+		defer p.orderMu.Unlock()
+	}
+
+	record, err := p.aggregate(stream, userRecord, recordSize, opts)
+	if isRejected(err) {
+		return err
+	}
+	// the bytes are released once the user record is delivered or failed
+	releaseBytes = false
+
+	p.Metrics.UserRecordsPut(1, recordSize)
+	atomic.AddInt64(&p.pool.stats.puts, 1)
+
+	if record != nil && p.OrderedDelivery {
+		p.pool.Add(record)
+		p.buffered.wake()
+	} else if record != nil {
+		// the record holds the backlog slot until it is added to the worker pool, this way
+		// we can rely on p.backlog.wait() to mean all waiting puts complete and future puts
+		// are blocked
 		release = false
-		go func() {
-			p.pool.Add(record)
-			p.backlog.release()
-		}()
+		p.backlog.push(record)
 	}
 
 	return err
 }
 
+// unwind undoes the stages of a user record that was not accepted: it is neither replayed
+// from the write-ahead log nor waited for by a barrier. Nil records skipped the stage.
+func (p *Producer) unwind(entered, logged *trackedRecord) {
+	if logged != nil {
+		logged.ack()
+	}
+	if entered != nil {
+		p.barrier.leave(entered)
+	}
+}
+
+// transform compresses and encrypts the data of the user record, if enabled
+func (p *Producer) transform(userRecord UserRecord) (UserRecord, error) {
+	var err error
+	if p.compresses(userRecord) {
+		if userRecord, err = p.compress(userRecord); err != nil {
+			return nil, err
+		}
+	}
+	if p.encrypts(userRecord) {
+		if userRecord, err = p.encrypt(userRecord); err != nil {
+			return nil, err
+		}
+	}
+	return userRecord, nil
+}
+
+// checkRecord validates the partition key, explicit hash key and size of the user record
+func (p *Producer) checkRecord(userRecord UserRecord, recordSize int) error {
+	// Firehose does not use partition keys
+	partitionKeySize := len(userRecord.PartitionKey())
+	if partitionKeySize > 256 || (partitionKeySize < 1 && p.Backend != BackendFirehose) {
+		return &ErrIllegalPartitionKey{unwrapUserRecord(userRecord)}
+	}
+
+	explicitHashKey := userRecord.ExplicitHashKey()
+	if explicitHashKey != nil && !validHashKey(explicitHashKey) {
+		return &ErrIllegalExplicitHashKey{
+			UserRecord:      unwrapUserRecord(userRecord),
+			ExplicitHashKey: explicitHashKey.String(),
+		}
+	}
+
+	if recordSize > p.recordSizeLimit() {
+		return &ErrRecordSizeExceeded{unwrapUserRecord(userRecord)}
+	}
+	return nil
+}
+
+// aggregate adds the user record to the aggregator of its shard of stream, or makes a
+// plain kinesis record of it. Returns the record to send, if the user record was plain or
+// filled up an aggregated record. A DrainError fails the records aggregated before the
+// user record, which is accepted; other errors reject it.
+func (p *Producer) aggregate(stream string, userRecord UserRecord, recordSize int, opts putOptions) (*AggregatedRecordRequest, error) {
+	// if the record size is bigger than aggregation size
+	// handle it as a simple kinesis record
+	// Firehose does not deaggregate records
+	// priority records skip the aggregators so that they are sent right away
+	priority := opts.priority && !p.OrderedDelivery
+	if recordSize > p.AggregateBatchSize || p.Backend == BackendFirehose || opts.disableAggregation || priority || p.DisableAggregation || p.AggregationFormat == PassThrough {
+		partitionKey := userRecord.PartitionKey()
+		explicitHashKey := userRecord.ExplicitHashKey()
+		var ehk *string
+		if explicitHashKey == nil && !isMD5Hasher(p.Hasher) && p.Backend != BackendFirehose {
+			// Kinesis would hash the partition key with MD5
+			explicitHashKey = p.Hasher.HashKey(partitionKey)
+		}
+		if explicitHashKey != nil {
+			hk := explicitHashKey.String()
+			ehk = &hk
+		}
+		record := NewAggregatedRecordRequest(userRecord.Data(), &partitionKey, ehk, []UserRecord{userRecord})
+		record.stream = stream
+		record.priority = priority
+		record.plain = true
+		if p.VerifyIntegrity {
+			record.checksum = checksum(record.Entry.Data)
+		}
+		return record, nil
+	}
+
+	// an invalid document would fail the whole aggregated record
+	if p.AggregationFormat == NDJSON && !json.Valid(userRecord.Data()) {
+		return nil, &ErrInvalidJSON{unwrapUserRecord(userRecord)}
+	}
+	shardMap, err := p.streamShardMap(stream)
+	if err != nil {
+		return nil, err
+	}
+	record, err := shardMap.Put(userRecord)
+	if _, ok := err.(*ShardBucketError); ok {
+		return nil, err
+	}
+	p.failDrained(stream, err)
+	if record != nil {
+		record.stream = stream
+	}
+	return record, err
+}
+
 func (p *Producer) Start() {
+	p.configMu.Lock()
+	p.started = true
+	p.configMu.Unlock()
 	poolErrs := p.pool.Errors()
 	// listen for errors from the worker pool p.notify() will send on the failures
 	// channel if p.NotifyFailures() has been called
@@ -131,22 +953,137 @@ func (p *Producer) Start() {
 			close(p.failures)
 			p.failures = nil
 		}
+		if p.deliveries != nil {
+			close(p.deliveries)
+			p.deliveries = nil
+		}
 		p.Unlock()
 	}()
 	p.pool.Start()
+	go p.handOff()
 	go p.loop()
+	if p.ThrottleHook != nil {
+		go p.pollThrottleHook()
+	}
+	if p.spill != nil {
+		go p.drainSpill()
+	}
+	if p.WriteAheadLog != nil {
+		// records left in the log by a previous run are put before any new records
+		p.replay()
+	}
 }
 
+// Stop stops accepting Puts and blocks until all buffered records have been delivered
+// or have failed.
 func (p *Producer) Stop() {
+	p.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting Puts, flushes all aggregated and backlogged records and waits
+// for in flight requests to complete. If ctx is done before delivery completes, in flight
+// requests and retries are canceled and a *ShutdownError holding the undelivered user
+// records is returned. Canceled requests may still have been accepted by Kinesis.
+func (p *Producer) Shutdown(ctx context.Context) error {
+	// records held by Pause or a Throttle are delivered
+	p.unpause()
+	if p.spill != nil {
+		// put back the spilled records before Puts are stopped
+		p.spill.wait(ctx)
+	}
 	// signal to stop any future Puts
 	close(p.stopped)
+	if p.hookDone != nil {
+		// the ThrottleHook may have paused the producer again meanwhile
+		<-p.hookDone
+		p.unpause()
+	}
 	// signal to main loop to begin cleanup process
 	p.done <- struct{}{}
-	// wait for the worker pool to complete
-	p.pool.Wait()
+	// wait for the worker pool to complete, aborting it if ctx is done first
+	waitc := make(chan struct{})
+	go func() {
+		p.pool.Wait()
+		close(waitc)
+	}()
+	select {
+	case <-waitc:
+	case <-ctx.Done():
+		p.pool.Abort()
+		<-waitc
+	}
+	if p.pool.mirror != nil {
+		p.pool.mirror.close(ctx)
+	}
 	// send another signal to main loop to exit
 	p.done <- struct{}{}
 	<-p.done
+
+	abandoned := p.pool.Abandoned()
+	var spilled []UserRecord
+	if p.spill != nil {
+		spilled = p.spill.close()
+	}
+	if len(abandoned) == 0 && len(spilled) == 0 {
+		return nil
+	}
+	shutdownErr := &ShutdownError{Err: ctx.Err()}
+	for _, record := range abandoned {
+		resolveUserRecords(record.UserRecords, PutResult{Err: shutdownErr})
+		p.buffered.release(bufferedSize(record.UserRecords))
+		shutdownErr.UserRecords = append(shutdownErr.UserRecords, unwrapUserRecords(record.UserRecords)...)
+		failure := newFailureRecord(record, shutdownErr, len(record.attempts))
+		failure.Class = p.ErrorClassifier(shutdownErr)
+		p.sendDeadLetter(failure)
+	}
+	resolveUserRecords(spilled, PutResult{Err: shutdownErr})
+	shutdownErr.UserRecords = append(shutdownErr.UserRecords, unwrapUserRecords(spilled)...)
+	if len(spilled) > 0 {
+		p.sendDeadLetter(&FailureRecord{
+			Err:         shutdownErr,
+			Class:       p.ErrorClassifier(shutdownErr),
+			UserRecords: unwrapUserRecords(spilled),
+		})
+	}
+	p.Metrics.RecordsDropped(len(shutdownErr.UserRecords))
+	atomic.AddInt64(&p.pool.stats.drops, int64(len(shutdownErr.UserRecords)))
+	return shutdownErr
+}
+
+type flushRequest struct {
+	// idle receives the channel closed once the worker pool is idle, see WorkerPool.Idle
+	idle chan (<-chan struct{})
+}
+
+// Flush drains all aggregated and backlogged records and blocks until every resulting
+// PutRecords request has completed, including retries, or ctx is done. Puts block while
+// Flush is in progress. Records that fail permanently are reported as usual with
+// NotifyFailures; Flush only returns an error if ctx is done or the Producer is stopped.
+func (p *Producer) Flush(ctx context.Context) error {
+	// block puts so no new records are added while waiting for the pool
+	p.backlog.wait()
+	defer func() {
+		p.backlog.open()
+		p.freed.signal()
+	}()
+	req := flushRequest{idle: make(chan (<-chan struct{}), 1)}
+	select {
+	case p.flushes <- req:
+	case <-p.stopped:
+		return &ErrStoppedProducer{}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// the main loop goes on while waiting, e.g. to refresh the shards
+	idle := <-req.idle
+	select {
+	case <-idle:
+	case <-p.pool.done:
+		// the pool completed every request on Shutdown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
 }
 
 // NotifyFailures registers and return listener to handle undeliverable messages.
@@ -164,53 +1101,162 @@ func (p *Producer) NotifyFailures() <-chan error {
 func (p *Producer) loop() {
 	var (
 		stop       chan struct{}
-		done       chan struct{}    = p.done
-		flushTick  *time.Ticker     = time.NewTicker(p.FlushInterval)
-		flushTickC <-chan time.Time = flushTick.C
-		shardTick  *time.Ticker
+		done       chan struct{}      = p.done
+		updates    chan configRequest = p.updates
+		flushes    chan flushRequest  = p.flushes
+		flushTick  Ticker             = p.Clock.NewTicker(p.FlushInterval)
+		flushTickC <-chan time.Time   = flushTick.C()
+		shardTick  Ticker
 		shardTickC <-chan time.Time
+		scaleTick  Ticker
+		scaleTickC <-chan time.Time
+		hotTick    Ticker
+		hotTickC   <-chan time.Time
+		connTick   Ticker
+		connTickC  <-chan time.Time
+		adaptive   *adaptiveInterval
 	)
 
+	if p.AdaptiveFlush {
+		adaptive = newAdaptiveInterval(p.Config, p.Clock.Now())
+		flushTick.Reset(adaptive.current)
+		atomic.StoreInt64(&p.pool.stats.flushInterval, int64(adaptive.current))
+	}
+
 	if p.ShardRefreshInterval != 0 {
-		shardTick = time.NewTicker(p.ShardRefreshInterval)
-		shardTickC = shardTick.C
+		shardTick = p.Clock.NewTicker(p.ShardRefreshInterval)
+		shardTickC = shardTick.C()
 		defer shardTick.Stop()
 	}
 
+	if p.scaler != nil {
+		scaleTick = p.Clock.NewTicker(p.AutoScaling.Interval)
+		scaleTickC = scaleTick.C()
+		defer scaleTick.Stop()
+	}
+
+	if p.hot != nil {
+		hotTick = p.Clock.NewTicker(hotShardInterval)
+		hotTickC = hotTick.C()
+		defer hotTick.Stop()
+	}
+
+	if p.connections != nil {
+		connTick = p.Clock.NewTicker(p.ConnectionScaling.Interval)
+		connTickC = connTick.C()
+		defer connTick.Stop()
+	}
+
 	defer flushTick.Stop()
 	defer close(p.done)
 
-	flush := func() {
+	// flush drains the aggregators into the pool, and sends the batches of the pool if send
+	// is set. With RequestMaxDelay, the batches of the flush interval are sent by the pool
+	flush := func(send bool) {
+		_, span := p.tracer.Start(context.Background(), "kinesis-producer.Flush")
+		if p.OrderedDelivery {
+			p.orderMu.Lock()
+			defer p.orderMu.Unlock()
+		}
 		records := p.drain()
 		for _, record := range records {
 			p.pool.Add(record)
 		}
-		p.pool.Flush()
+		if send {
+			p.pool.Flush()
+		}
+		p.pool.stats.flushed(p.Clock.Now())
+		span.SetAttributes(
+			streamNameKey.String(p.defaultStream()),
+			recordCountKey.Int(len(records)),
+		)
+		span.End()
 	}
 
 	for {
 		select {
-		case <-flushTickC:
-			flush()
+		case now := <-flushTickC:
+			p.Metrics.BacklogDepth(p.backlog.len())
+			if !p.Paused() {
+				flush(p.RequestMaxDelay == 0)
+			}
+			if adaptive != nil {
+				interval := adaptive.next(atomic.LoadInt64(&p.pool.stats.puts), now)
+				flushTick.Reset(interval)
+				atomic.StoreInt64(&p.pool.stats.flushInterval, int64(interval))
+			}
+		case <-p.pressure:
+			if !p.Paused() {
+				flush(true)
+			}
+		case request := <-updates:
+			update := request.merge(settingsOf(p.Config))
+			p.applyUpdate(update)
+			if adaptive != nil {
+				adaptive.target = update.batchCount
+			} else {
+				flushTick.Reset(update.flushInterval)
+				atomic.StoreInt64(&p.pool.stats.flushInterval, int64(update.flushInterval))
+			}
+			close(request.done)
+		case req := <-flushes:
+			// Flush blocked the puts and waits for the pool to be idle
+			flush(true)
+			req.idle <- p.pool.Idle()
 		case <-shardTickC:
+			// the worker pool can not be reconfigured once it is closing
+			if p.AutoTune != nil && done != nil {
+				p.retune()
+			}
 			err := p.updateShards(done == nil)
 			if err != nil {
 				p.Logger.Error("UpdateShards error", err)
 				p.notify(err)
 			}
+		case <-p.stale:
+			// a split or merge is not noticed before the next refresh, which may be never
+			refreshed := atomic.LoadInt64(&p.pool.stats.shardsRefreshed)
+			if p.Clock.Now().Sub(time.Unix(0, refreshed)) < staleShardsInterval {
+				continue
+			}
+			if err := p.updateShards(done == nil); err != nil {
+				p.Logger.Error("UpdateShards error", err)
+				p.notify(err)
+			}
+		case now := <-scaleTickC:
+			// once a reshard completed, the records are aggregated for the new shards
+			if p.scaler.step(now) {
+				if err := p.updateShards(done == nil); err != nil {
+					p.Logger.Error("UpdateShards error", err)
+					p.notify(err)
+				}
+			}
+		case now := <-hotTickC:
+			p.hot.step(now)
+		case <-connTickC:
+			p.scaleConnections()
 		case <-done:
 			// after waiting for the pool to finish, Stop() will send another signal to the done
 			// channel, the second time signaling its safe to end this go routine
 			stop, done = done, nil
+			// the worker pool is closing and can no longer be reconfigured
+			updates = nil
+			// nor flushed, Flush returns as the Producer is stopped
+			flushes = nil
 			// once we are done we no longer need flush tick as we are already
 			// flushing the backlog
 			flushTickC = nil
+			// the stream is not scaled while shutting down
+			scaleTickC = nil
+			// the worker pool can not be reconfigured once it is closing
+			connTickC = nil
 			// block any more puts from happening
-			p.backlog.wait(p.BacklogCount)
+			p.backlog.wait()
 			// backlog is flushed and no more records are incomming
 			// flush any remaining records in the aggregator
-			flush()
+			flush(true)
 			// with puts blocked and flush complete, we can close input channel safely
+			p.backlog.close()
 			p.pool.Close()
 		case <-stop:
 			return
@@ -219,50 +1265,232 @@ func (p *Producer) loop() {
 }
 
 func (p *Producer) updateShards(done bool) error {
-	old := p.shardMap.Shards()
-	shards, updated, err := p.GetShards(old)
-	if err != nil {
-		return err
+	var (
+		streams  = p.streamShardMaps()
+		updates  = make(map[string][]types.Shard)
+		firstErr error
+	)
+	for stream, shardMap := range streams {
+		shards, updated, err := p.getShards(stream, shardMap.Shards())
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if updated {
+			updates[stream] = shards
+		}
 	}
-	if !updated {
-		return nil
+	if firstErr == nil {
+		atomic.StoreInt64(&p.pool.stats.shardsRefreshed, p.Clock.Now().UnixNano())
+	}
+	if len(updates) == 0 {
+		return firstErr
 	}
 
 	if !done {
 		// if done signal has not been received yet, flush all backlogged puts into the worker
 		// pool and block additional puts
-		p.backlog.wait(p.BacklogCount)
+		p.backlog.wait()
 	}
 
 	// pause and drain the worker pool
 	pending := p.pool.Pause()
+	pendingByStream := make(map[string][]*AggregatedRecordRequest)
+	for _, record := range pending {
+		pendingByStream[record.stream] = append(pendingByStream[record.stream], record)
+	}
 
-	// update the shards and reaggregate pending records
-	records, err := p.shardMap.UpdateShards(shards, pending)
+	// update the shards and reaggregate pending records of each updated stream
+	var (
+		records []*AggregatedRecordRequest
+		changes []ShardChange
+	)
+	for stream, shards := range updates {
+		old := streams[stream].Shards()
+		updated, err := streams[stream].UpdateShards(shards, pendingByStream[stream])
+		delete(pendingByStream, stream)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			changes = append(changes, ShardChange{Stream: stream, Old: old, New: shards})
+			if limiter := p.pool.limiter(stream); limiter != nil {
+				limiter.Reset()
+			}
+		}
+		for _, record := range updated {
+			record.stream = stream
+		}
+		records = append(records, updated...)
+	}
+	// pending records of streams that were not updated are sent as they are
+	for _, rs := range pendingByStream {
+		records = append(records, rs...)
+	}
 
 	// resume the worker pool
 	p.pool.Resume(records)
 
 	if !done {
 		// if done signal has not been received yet, re-open the backlog to accept more Puts
-		p.backlog.open(p.BacklogCount)
+		p.backlog.open()
+		p.freed.signal()
 	}
 
-	return err
+	for _, change := range changes {
+		p.Logger.Info("shards updated",
+			LogValue{"stream", change.Stream},
+			LogValue{"old_shards", len(change.Old)},
+			LogValue{"new_shards", len(change.New)},
+		)
+		if p.OnShardChange != nil {
+			p.OnShardChange(change)
+		}
+	}
+
+	return firstErr
 }
 
 func (p *Producer) drain() []*AggregatedRecordRequest {
-	if p.shardMap.Size() == 0 {
-		return nil
-	}
-	records, errs := p.shardMap.Drain()
-	if len(errs) > 0 {
-		p.notify(errs...)
+	var records []*AggregatedRecordRequest
+	for stream, shardMap := range p.streamShardMaps() {
+		if shardMap.Size() == 0 {
+			continue
+		}
+		drained, errs := shardMap.Drain()
+		for _, err := range errs {
+			p.failDrained(stream, err)
+		}
+		if len(errs) > 0 {
+			p.notify(errs...)
+		}
+		for _, record := range drained {
+			record.stream = stream
+		}
+		records = append(records, drained...)
 	}
+	// the largest records are pushed first so that the smaller ones fill up the batches
+	sort.SliceStable(records, func(i, j int) bool {
+		return len(records[i].Entry.Data) > len(records[j].Entry.Data)
+	})
 	return records
 }
 
+// putChunks splits the user record into chunks of at most MaxChunkSize bytes and puts
+// each chunk. The backlog slots and buffered bytes of all the chunks are reserved at once
+// as by PutBatch, so that either every chunk is put or none is, and consumers are not
+// left with chunks that will never be reassembled.
+func (p *Producer) putChunks(stream string, userRecord UserRecord, opts putOptions) error {
+	chunks, err := chunking.Split(uuid.New(), userRecord.Data(), p.MaxChunkSize-len(userRecord.PartitionKey()))
+	if err != nil {
+		return err
+	}
+	if len(chunks) > p.backlog.cap() {
+		return fmt.Errorf("kinesis: record of %d chunks exceeds BacklogCount %d", len(chunks), p.backlog.cap())
+	}
+	group := &chunkGroup{userRecord: userRecord, remaining: int32(len(chunks))}
+	records := make([]UserRecord, len(chunks))
+	for i, data := range chunks {
+		records[i] = &chunkRecord{UserRecord: userRecord, data: data, group: group}
+	}
+
+	// the record is dropped once rather than each of its chunks
+	policy := opts.policy
+	if policy == OverflowDropNewest {
+		policy = OverflowError
+	}
+	res, err := p.reserve(records, policy)
+	if res == nil {
+		if opts.policy == OverflowDropNewest {
+			p.reject(userRecord)
+			return nil
+		}
+		return err
+	}
+	defer p.releaseReservation(res)
+	opts.reservation = res
+
+	// a DrainError fails other records, the chunk was accepted
+	var drainErr error
+	for _, record := range records {
+		if err := p.put(stream, record, opts); isRejected(err) {
+			return err
+		} else if err != nil {
+			drainErr = err
+		}
+	}
+	return drainErr
+}
+
+// reject drops a user record that was not accepted because the backlog is full
+func (p *Producer) reject(userRecord UserRecord) {
+	err := &ErrBacklogFull{unwrapUserRecord(userRecord)}
+	resolveUserRecords([]UserRecord{userRecord}, PutResult{Err: err})
+	p.Metrics.RecordsDropped(1)
+	atomic.AddInt64(&p.pool.stats.drops, 1)
+	p.sendDeadLetter(&FailureRecord{
+		Err:          err,
+		Class:        p.ErrorClassifier(err),
+		PartitionKey: userRecord.PartitionKey(),
+		UserRecords:  []UserRecord{err.UserRecord},
+	})
+	p.notify(err)
+}
+
+// drop fails the user records of an aggregated record that will not be sent
+func (p *Producer) drop(record *AggregatedRecordRequest, err error) {
+	resolveUserRecords(record.UserRecords, PutResult{Err: err})
+	p.Metrics.RecordsDropped(len(record.UserRecords))
+	atomic.AddInt64(&p.pool.stats.drops, int64(len(record.UserRecords)))
+	failure := newFailureRecord(record, err, 0)
+	failure.Class = p.ErrorClassifier(err)
+	p.sendDeadLetter(failure)
+	p.notify(failure)
+}
+
+// failDrained resolves and counts the user records of a DrainError of stream as failed
+func (p *Producer) failDrained(stream string, err error) {
+	drainErr, ok := err.(*DrainError)
+	if !ok {
+		return
+	}
+	resolveUserRecords(drainErr.UserRecords, PutResult{Err: drainErr})
+	p.buffered.release(bufferedSize(drainErr.UserRecords))
+	drainErr.UserRecords = unwrapUserRecords(drainErr.UserRecords)
+	p.Metrics.RecordsDropped(len(drainErr.UserRecords))
+	atomic.AddInt64(&p.pool.stats.drops, int64(len(drainErr.UserRecords)))
+	p.sendDeadLetter(&FailureRecord{
+		Err:         drainErr,
+		ErrorCode:   errorCode(drainErr),
+		Class:       p.ErrorClassifier(drainErr),
+		StreamName:  stream,
+		UserRecords: drainErr.UserRecords,
+	})
+}
+
+// resolved releases the buffered bytes of the user records delivered or failed by the
+// worker pool
+func (p *Producer) resolved(userRecords []UserRecord) {
+	p.buffered.release(bufferedSize(userRecords))
+}
+
+// bufferedSize returns the number of bytes user records count towards MaxBufferedBytes
+func bufferedSize(userRecords []UserRecord) int {
+	var size int
+	for _, userRecord := range userRecords {
+		size += userRecord.Size() + len(userRecord.PartitionKey())
+	}
+	return size
+}
+
 func (p *Producer) notify(errs ...error) {
+	now := p.Clock.Now()
+	for _, err := range errs {
+		p.recentErrors.add(now, err)
+	}
 	p.RLock()
 	if p.failures != nil {
 		for _, err := range errs {
@@ -1,252 +1,2238 @@
 package producer
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"math/big"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/achunariov/kinesis-producer/chunking"
+	"github.com/achunariov/kinesis-producer/claimcheck"
+	"github.com/achunariov/kinesis-producer/compression"
+	"github.com/achunariov/kinesis-producer/deaggregation"
+	"github.com/achunariov/kinesis-producer/wal"
 )
 
-type responseMock struct {
-	Response *k.PutRecordsOutput
-	Error    error
+type responseMock struct {
+	Response *k.PutRecordsOutput
+	Error    error
+	// Hang blocks the request until its context is done
+	Hang bool
+}
+
+type clientMock struct {
+	calls     int
+	responses []responseMock
+	incoming  map[int][]string
+	streams   []string
+	arns      []string
+	data      [][]byte
+	hashKeys  []string
+}
+
+func (c *clientMock) PutRecords(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	res := c.responses[c.calls]
+	for _, r := range input.Records {
+		c.incoming[c.calls] = append(c.incoming[c.calls], *r.PartitionKey)
+		c.data = append(c.data, r.Data)
+		c.hashKeys = append(c.hashKeys, aws.ToString(r.ExplicitHashKey))
+	}
+	c.streams = append(c.streams, aws.ToString(input.StreamName))
+	c.arns = append(c.arns, aws.ToString(input.StreamARN))
+	c.calls++
+	if res.Hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if out := res.Response; out != nil && len(out.Records) == 0 && aws.ToInt32(out.FailedRecordCount) == 0 {
+		// like Kinesis, return an entry for each record delivered
+		filled := *out
+		for range input.Records {
+			filled.Records = append(filled.Records, types.PutRecordsResultEntry{
+				ShardId:        aws.String("shardId-000000000000"),
+				SequenceNumber: aws.String(strconv.Itoa(c.calls)),
+			})
+		}
+		return &filled, nil
+	}
+	return res.Response, nil
+}
+
+type testCase struct {
+	// configuration
+	name    string      // test name
+	config  *Config     // test config
+	records []string    // all outgoing records(partition keys and data too)
+	putter  *clientMock // mocked client
+
+	// expectations
+	outgoing map[int][]string // [call number][partition keys]
+}
+
+func genBulk(n int, s string) (ret []string) {
+	for i := 0; i < n; i++ {
+		ret = append(ret, s)
+	}
+	return
+}
+
+var testCases = []testCase{
+	{
+		"one record with batch count 1",
+		&Config{BatchCount: 1},
+		[]string{"hello"},
+		&clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+					},
+				},
+			}},
+		map[int][]string{
+			0: []string{"hello"},
+		},
+	},
+	{
+		"two records with batch count 1",
+		&Config{BatchCount: 1, AggregateBatchCount: 1},
+		[]string{"hello", "world"},
+		&clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+					},
+				},
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+					},
+				},
+			}},
+		map[int][]string{
+			0: []string{"hello"},
+			1: []string{"world"},
+		},
+	},
+	{
+		"two records with batch count 2, simulating retries",
+		&Config{BatchCount: 2, AggregateBatchCount: 1},
+		[]string{"hello", "world"},
+		&clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(1),
+						Records: []types.PutRecordsResultEntry{
+							{SequenceNumber: aws.String("3"), ShardId: aws.String("1")},
+							{ErrorCode: aws.String("400")},
+						},
+					},
+				},
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+					},
+				},
+			}},
+		map[int][]string{
+			0: []string{"hello", "world"},
+			1: []string{"world"},
+		},
+	},
+	{
+		"2 bulks of 10 records",
+		&Config{BatchCount: 10, AggregateBatchCount: 1, BacklogCount: 1},
+		genBulk(20, "foo"),
+		&clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+					},
+				},
+				{
+					Error: nil,
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+					},
+				},
+			}},
+		map[int][]string{
+			0: genBulk(10, "foo"),
+			1: genBulk(10, "foo"),
+		},
+	},
+}
+
+func TestProducer(t *testing.T) {
+	for _, test := range testCases {
+		test.config.StreamName = test.name
+		test.config.MaxConnections = 1
+		test.config.Client = test.putter
+		p := New(test.config)
+		failures := p.NotifyFailures()
+		var ewg sync.WaitGroup
+		ewg.Add(1)
+		go func() {
+			defer ewg.Done()
+			for err := range failures {
+				t.Error(err)
+			}
+		}()
+		p.Start()
+		var wg sync.WaitGroup
+		wg.Add(len(test.records))
+		for _, r := range test.records {
+			go func(s string) {
+				err := p.Put([]byte(s), s)
+				if err != nil {
+					t.Error(err)
+				}
+				wg.Done()
+			}(r)
+		}
+		wg.Wait()
+		p.Stop()
+		ewg.Wait()
+		for k, v := range test.putter.incoming {
+			if len(v) != len(test.outgoing[k]) {
+				t.Errorf("failed test: %s\n\texcpeted:%v\n\tactual:  %v", test.name,
+					test.outgoing, test.putter.incoming)
+			}
+		}
+	}
+}
+
+func TestNewProducer(t *testing.T) {
+	client := &clientMock{incoming: make(map[int][]string)}
+	testCases := []struct {
+		name          string
+		opts          []Option
+		expectedError string
+	}{
+		{
+			name: "creates producer",
+			opts: []Option{WithStreamName("foo"), WithClient(client), WithShards(StaticGetShardsFunc(2), 0)},
+		},
+		{
+			name:          "returns error for missing stream name",
+			opts:          []Option{WithClient(client)},
+			expectedError: "kinesis: StreamName length must be at least 1",
+		},
+		{
+			name:          "returns error for missing client",
+			opts:          []Option{WithStreamName("foo")},
+			expectedError: "kinesis: Client, PutterFactory or DryRun must be set",
+		},
+		{
+			name:          "returns error for invalid batch count",
+			opts:          []Option{WithStreamName("foo"), WithClient(client), WithBatchCount(501)},
+			expectedError: "kinesis: BatchCount exceeds 500",
+		},
+		{
+			name:          "returns error for sticky partition key without AutoPartitionKey",
+			opts:          []Option{WithStreamName("foo"), WithClient(client), func(c *Config) { c.StickyPartitionInterval = time.Second }},
+			expectedError: "kinesis: StickyPartitionInterval requires AutoPartitionKey",
+		},
+		{
+			name:          "returns error for negative deduplication window",
+			opts:          []Option{WithStreamName("foo"), WithClient(client), WithDeduplication(-time.Second)},
+			expectedError: "kinesis: DeduplicationWindow must not be negative",
+		},
+		{
+			name:          "returns error for invalid max connections",
+			opts:          []Option{WithStreamName("foo"), WithClient(client), WithMaxConnections(257)},
+			expectedError: "kinesis: MaxConnections must be between 1 and 256",
+		},
+		{
+			name: "returns error for firehose batch size",
+			opts: []Option{
+				WithStreamName("foo"),
+				WithClient(client),
+				WithBackend(BackendFirehose),
+				WithBatchSize(5 << 20),
+			},
+			expectedError: "kinesis: BatchSize exceeds 4MiB",
+		},
+		{
+			name: "returns error for circuit breaker without threshold",
+			opts: []Option{
+				WithStreamName("foo"),
+				WithClient(client),
+				WithCircuitBreaker(CircuitBreakerConfig{CoolDown: time.Second}),
+			},
+			expectedError: "kinesis: CircuitBreaker requires ConsecutiveFailures or ErrorRate",
+		},
+		{
+			name: "returns error for shard concurrency with ordered delivery",
+			opts: []Option{
+				WithStreamName("foo"),
+				WithClient(client),
+				WithOrderedDelivery(),
+				WithShardConcurrency(1),
+			},
+			expectedError: "kinesis: ShardConcurrency can not be used with OrderedDelivery",
+		},
+		{
+			name: "returns error for auto scaling below MinShards",
+			opts: []Option{
+				WithStreamName("foo"),
+				WithClient(client),
+				WithAutoScaling(AutoScalingConfig{Client: &mockStreamScaler{}, MinShards: 2, MaxShards: 1}),
+			},
+			expectedError: "kinesis: AutoScaling.MaxShards must be at least MinShards and MinShards at least 1",
+		},
+		{
+			name: "returns error for stream name and ARN",
+			opts: []Option{
+				WithStreamName("foo"),
+				WithStreamARN("arn:aws:kinesis:us-east-1:123456789012:stream/foo"),
+				WithClient(client),
+			},
+			expectedError: "kinesis: only one of StreamName and StreamARN can be set",
+		},
+		{
+			name: "returns error from GetShards",
+			opts: []Option{
+				WithStreamName("foo"),
+				WithClient(client),
+				WithShards(func(_ []types.Shard) ([]types.Shard, bool, error) {
+					return nil, false, errors.New("getShards error")
+				}, 0),
+			},
+			expectedError: "getShards error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewProducer(append(tc.opts, WithLogger(&NopLogger{}))...)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				require.Nil(t, p)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, p)
+		})
+	}
+}
+
+func TestNewPanicsOnInvalidConfig(t *testing.T) {
+	require.Panics(t, func() {
+		New(&Config{Client: &clientMock{}, Logger: &NopLogger{}})
+	})
+	// a Client is not required, as before NewProducer was added
+	require.NotPanics(t, func() {
+		New(&Config{StreamName: "foo", Logger: &NopLogger{}})
+	})
+}
+
+func TestNotify(t *testing.T) {
+	kError := errors.New("ResourceNotFoundException: Stream foo under account X not found")
+	p := New(&Config{
+		StreamName:          "foo",
+		MaxConnections:      1,
+		BatchCount:          1,
+		AggregateBatchCount: 10,
+		Client: &clientMock{
+			incoming:  make(map[int][]string),
+			responses: []responseMock{{Error: kError}},
+		},
+	})
+	p.Start()
+	records := genBulk(10, "bar")
+	failures := p.NotifyFailures()
+	failed := 0
+	done := make(chan struct{})
+	// timeout test after 10 seconds
+	timeout := time.After(10 * time.Second)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case failure, ok := <-failures:
+				if !ok {
+					// expect producer close the failures channel
+					close(done)
+					return
+				}
+				failed += len(failure.(*FailureRecord).UserRecords)
+			case <-timeout:
+				return
+			}
+		}
+	}()
+	for _, r := range records {
+		p.Put([]byte(r), r)
+	}
+	p.Stop()
+	wg.Wait()
+
+	if failed != len(records) {
+		t.Errorf("failed test: NotifyFailure\n\texcpeted:%v\n\tactual:%v", failed, len(records))
+	}
+
+	select {
+	case <-done:
+	default:
+		t.Error("failed test: NotifyFailure\n\texpect failures channel to be closed")
+	}
+}
+
+func TestRetryThrottledRequest(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Error: &types.ProvisionedThroughputExceededException{}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		Backoff:        &FixedBackoff{},
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	for err := range failures {
+		t.Error(err)
+	}
+	require.Equal(t, 2, client.calls)
+}
+
+func TestPutWithResult(t *testing.T) {
+	kError := errors.New("ResourceNotFoundException: Stream foo under account X not found")
+	testCases := []struct {
+		name     string
+		response responseMock
+		expected PutResult
+	}{
+		{
+			name: "resolves with shard id and sequence number",
+			response: responseMock{
+				Response: &k.PutRecordsOutput{
+					FailedRecordCount: aws.Int32(0),
+					Records: []types.PutRecordsResultEntry{
+						{SequenceNumber: aws.String("3"), ShardId: aws.String("shardId-000000000001")},
+					},
+				},
+			},
+			expected: PutResult{SequenceNumber: "3", ShardId: "shardId-000000000001"},
+		},
+		{
+			name:     "resolves with request error",
+			response: responseMock{Error: kError},
+			expected: PutResult{Err: kError},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New(&Config{
+				StreamName:     "foo",
+				MaxConnections: 1,
+				Logger:         &NopLogger{},
+				Client: &clientMock{
+					incoming:  make(map[int][]string),
+					responses: []responseMock{tc.response},
+				},
+			})
+			p.Start()
+
+			future, err := p.PutWithResult([]byte("hello"), "foo")
+			require.NoError(t, err)
+			p.Stop()
+
+			select {
+			case <-future.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatal("expected future to be resolved")
+			}
+			require.Equal(t, tc.expected, future.Result())
+		})
+	}
+}
+
+func TestPutWithResultIllegalPartitionKey(t *testing.T) {
+	p := New(&Config{
+		StreamName: "foo",
+		Logger:     &NopLogger{},
+		Client:     &clientMock{incoming: make(map[int][]string)},
+	})
+	future, err := p.PutWithResult([]byte("hello"), "")
+	require.Nil(t, future)
+	require.IsType(t, &ErrIllegalPartitionKey{}, err)
+}
+
+func TestNotifyFailureRecord(t *testing.T) {
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		Logger:         &NopLogger{},
+		Client: &clientMock{
+			incoming:  make(map[int][]string),
+			responses: []responseMock{{Error: &types.ResourceNotFoundException{}}},
+		},
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	record := newTestUserRecord("foo", "", []byte("hello"))
+	require.NoError(t, p.PutUserRecord(record))
+	p.Stop()
+
+	var got []*FailureRecord
+	for err := range failures {
+		got = append(got, err.(*FailureRecord))
+	}
+	require.Len(t, got, 1)
+	require.Equal(t, "ResourceNotFoundException", got[0].ErrorCode)
+	require.Equal(t, 1, got[0].Attempts)
+	require.Equal(t, []UserRecord{record}, got[0].UserRecords)
+}
+
+type throttledClientMock struct{}
+
+func (c *throttledClientMock) PutRecords(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	return nil, &types.ProvisionedThroughputExceededException{}
+}
+
+type deadLetterMock struct {
+	sync.Mutex
+	failures []*FailureRecord
+	// canceled counts the failures sent with a done context
+	canceled int
+}
+
+func (d *deadLetterMock) Send(ctx context.Context, failure *FailureRecord) error {
+	d.Lock()
+	d.failures = append(d.failures, failure)
+	if ctx.Err() != nil {
+		d.canceled++
+	}
+	d.Unlock()
+	return nil
+}
+
+func TestMaxRetriesDeadLetter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		responses []responseMock
+		code      string
+	}{
+		{
+			name: "request error",
+			responses: []responseMock{
+				{Error: &types.ProvisionedThroughputExceededException{}},
+				{Error: &types.ProvisionedThroughputExceededException{}},
+				{Error: &types.ProvisionedThroughputExceededException{}},
+			},
+			code: "ProvisionedThroughputExceededException",
+		},
+		{
+			name: "record errors",
+			responses: []responseMock{
+				{Response: internalErrorResponse()},
+				{Response: internalErrorResponse()},
+				{Response: internalErrorResponse()},
+			},
+			code: "InternalFailure",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &clientMock{incoming: make(map[int][]string), responses: tc.responses}
+			deadLetter := &deadLetterMock{}
+			p := New(&Config{
+				StreamName:     "foo",
+				MaxConnections: 1,
+				MaxRetries:     2,
+				DeadLetter:     deadLetter,
+				Backoff:        &FixedBackoff{},
+				Logger:         &NopLogger{},
+				Client:         client,
+			})
+			failures := p.NotifyFailures()
+			p.Start()
+			require.NoError(t, p.Put([]byte("hello"), "foo"))
+			p.Stop()
+
+			var got []*FailureRecord
+			for err := range failures {
+				got = append(got, err.(*FailureRecord))
+			}
+			require.Equal(t, 3, client.calls)
+			require.Len(t, got, 1)
+			require.Equal(t, got, deadLetter.failures)
+			require.IsType(t, &ErrMaxRetriesExceeded{}, got[0].Err)
+			require.Equal(t, tc.code, got[0].ErrorCode)
+			require.Equal(t, 3, got[0].Attempts)
+		})
+	}
+}
+
+func TestDeadLetterDropped(t *testing.T) {
+	t.Run("Overflow", func(t *testing.T) {
+		deadLetter := &deadLetterMock{}
+		p := New(&Config{
+			StreamName:         "foo",
+			BacklogCount:       1,
+			AggregateBatchSize: 1,
+			OverflowPolicy:     OverflowDropNewest,
+			DeadLetter:         deadLetter,
+			Logger:             &NopLogger{},
+			Client:             &clientMock{incoming: make(map[int][]string)},
+		})
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		require.NoError(t, p.Put([]byte("world"), "bar"))
+		require.Len(t, deadLetter.failures, 1)
+		require.IsType(t, &ErrBacklogFull{}, deadLetter.failures[0].Err)
+		require.Equal(t, "bar", deadLetter.failures[0].PartitionKey)
+		require.Equal(t, []byte("world"), deadLetter.failures[0].UserRecords[0].Data())
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		deadLetter := &deadLetterMock{}
+		p := New(&Config{
+			StreamName:     "foo",
+			MaxConnections: 1,
+			DeadLetter:     deadLetter,
+			Logger:         &NopLogger{},
+			Client: &clientMock{
+				incoming:  make(map[int][]string),
+				responses: []responseMock{{Hang: true}},
+			},
+		})
+		p.Start()
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+
+		// the abandoned records are sent with a context of their own
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.IsType(t, &ShutdownError{}, p.Shutdown(ctx))
+		require.Len(t, deadLetter.failures, 1)
+		require.IsType(t, &ShutdownError{}, deadLetter.failures[0].Err)
+		require.Equal(t, "foo", deadLetter.failures[0].StreamName)
+		require.Zero(t, deadLetter.canceled)
+	})
+}
+
+func TestMaxRecordAge(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records:           []types.PutRecordsResultEntry{{ShardId: aws.String("shardId-0")}},
+			}},
+		},
+	}
+	deadLetter := &deadLetterMock{}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		MaxRecordAge:   50 * time.Millisecond,
+		FlushInterval:  time.Hour,
+		DeadLetter:     deadLetter,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	defer p.Stop()
+
+	// both records are aggregated together, only the stale one is dropped
+	stale, err := p.PutWithResult([]byte("hello"), "foo")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	fresh, err := p.PutWithResult([]byte("world"), "bar")
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+
+	<-stale.Done()
+	require.IsType(t, &ErrRecordExpired{}, stale.Result().Err)
+	<-fresh.Done()
+	require.NoError(t, fresh.Result().Err)
+	require.Equal(t, 1, client.calls)
+	require.Equal(t, []string{"bar"}, client.incoming[0])
+
+	failure := (<-failures).(*FailureRecord)
+	require.IsType(t, &ErrRecordExpired{}, failure.Err)
+	require.Equal(t, []byte("hello"), failure.UserRecords[0].Data())
+	require.Len(t, deadLetter.failures, 1)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	client := &clientMock{
+		incoming:  make(map[int][]string),
+		responses: []responseMock{{Hang: true}, {Hang: true}},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		MaxRetries:     1,
+		RequestTimeout: 10 * time.Millisecond,
+		Backoff:        &FixedBackoff{},
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	var got []*FailureRecord
+	for err := range failures {
+		got = append(got, err.(*FailureRecord))
+	}
+	require.Equal(t, 2, client.calls)
+	require.Len(t, got, 1)
+	require.IsType(t, &ErrMaxRetriesExceeded{}, got[0].Err)
+	var timeout *ErrRequestTimeout
+	require.ErrorAs(t, got[0], &timeout)
+	require.Equal(t, 10*time.Millisecond, timeout.Timeout)
+	require.ErrorIs(t, got[0], context.DeadlineExceeded)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Error: &types.ProvisionedThroughputExceededException{}},
+			{Error: &types.ProvisionedThroughputExceededException{}},
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+				},
+			}},
+		},
+	}
+	type change struct{ from, to CircuitState }
+	changes := make(chan change, 3)
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Backoff:        &FixedBackoff{},
+		CircuitBreaker: &CircuitBreakerConfig{
+			ConsecutiveFailures: 2,
+			CoolDown:            50 * time.Millisecond,
+			OnStateChange: func(from, to CircuitState) {
+				changes <- change{from, to}
+			},
+		},
+		Logger: &NopLogger{},
+		Client: client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	future, err := p.PutWithResult([]byte("hello"), "foo")
+	require.NoError(t, err)
+	go p.Flush(context.Background())
+
+	require.Equal(t, change{CircuitClosed, CircuitOpen}, <-changes)
+	err = p.Put([]byte("world"), "bar")
+	require.IsType(t, &ErrCircuitOpen{}, err)
+	require.Equal(t, "bar", err.(*ErrCircuitOpen).PartitionKey())
+
+	require.Equal(t, PutResult{ShardId: "shardId-0", SequenceNumber: "1"}, future.Result())
+	require.Equal(t, change{CircuitOpen, CircuitHalfOpen}, <-changes)
+	require.Equal(t, change{CircuitHalfOpen, CircuitClosed}, <-changes)
+	require.Equal(t, 3, client.calls)
+	require.Equal(t, CircuitClosed, p.Stats().Circuit)
+}
+
+func internalErrorResponse() *k.PutRecordsOutput {
+	return &k.PutRecordsOutput{
+		FailedRecordCount: aws.Int32(1),
+		Records: []types.PutRecordsResultEntry{
+			{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("Internal service failure.")},
+		},
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		Backoff:        &FixedBackoff{Delay: time.Minute},
+		Logger:         &NopLogger{},
+		Client:         &throttledClientMock{},
+	})
+	p.Start()
+	future, err := p.PutWithResult([]byte("hello"), "foo")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = p.Shutdown(ctx)
+
+	shutdownErr, ok := err.(*ShutdownError)
+	require.True(t, ok, "expected *ShutdownError, got %v", err)
+	require.Equal(t, context.DeadlineExceeded, shutdownErr.Err)
+	require.Len(t, shutdownErr.UserRecords, 1)
+	require.Equal(t, []byte("hello"), shutdownErr.UserRecords[0].Data())
+	require.Equal(t, shutdownErr, future.Result().Err)
+}
+
+func TestPutWithExplicitHashKey(t *testing.T) {
+	testCases := []struct {
+		name            string
+		explicitHashKey string
+		expectedError   bool
+	}{
+		{name: "accepts valid key", explicitHashKey: "170141183460469231731687303715884105728"},
+		{name: "accepts max key", explicitHashKey: maxHashKeyRange},
+		{name: "rejects non decimal key", explicitHashKey: "abc", expectedError: true},
+		{name: "rejects negative key", explicitHashKey: "-1", expectedError: true},
+		{name: "rejects key out of range", explicitHashKey: "340282366920938463463374607431768211456", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New(&Config{
+				StreamName: "foo",
+				Logger:     &NopLogger{},
+				GetShards:  StaticGetShardsFunc(2),
+				Client:     &clientMock{incoming: make(map[int][]string)},
+			})
+			err := p.PutWithExplicitHashKey([]byte("hello"), "foo", tc.explicitHashKey)
+			if tc.expectedError {
+				require.IsType(t, &ErrIllegalExplicitHashKey{}, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOnShardChange(t *testing.T) {
+	old, _, _ := StaticGetShardsFunc(1)(nil)
+	split, _, _ := StaticGetShardsFunc(2)(nil)
+	changes := make(chan ShardChange, 1)
+	p := New(&Config{
+		StreamName:           "foo",
+		GetShards:            mockGetShards(old, split, true, nil),
+		ShardRefreshInterval: 10 * time.Millisecond,
+		OnShardChange:        func(change ShardChange) { changes <- change },
+		Logger:               &NopLogger{},
+		Client:               &clientMock{incoming: make(map[int][]string)},
+	})
+	p.Start()
+	defer p.Stop()
+
+	select {
+	case change := <-changes:
+		require.Equal(t, ShardChange{Stream: "foo", Old: old, New: split}, change)
+	case <-time.After(time.Second):
+		t.Fatal("shard change was not reported")
+	}
+	require.Equal(t, split, p.shardMap.Shards())
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected shard change: %+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStaleShards(t *testing.T) {
+	old, _, _ := StaticGetShardsFunc(1)(nil)
+	old[0].ShardId = aws.String("shardId-000000000000")
+	split, _, _ := StaticGetShardsFunc(2)(nil)
+	split[0].ShardId = aws.String("shardId-000000000001")
+	split[1].ShardId = aws.String("shardId-000000000002")
+	changes := make(chan ShardChange, 1)
+	p := New(&Config{
+		StreamName:    "foo",
+		GetShards:     mockGetShards(old, split, true, nil),
+		OnShardChange: func(change ShardChange) { changes <- change },
+		Logger:        &NopLogger{},
+		Client: &clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{Response: &k.PutRecordsOutput{
+					FailedRecordCount: aws.Int32(0),
+					Records: []types.PutRecordsResultEntry{
+						{ShardId: aws.String("shardId-000000000001"), SequenceNumber: aws.String("1")},
+					},
+				}},
+			},
+		},
+	})
+	// the shards were refreshed long enough ago
+	atomic.StoreInt64(&p.pool.stats.shardsRefreshed, 0)
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Flush(context.Background()))
+	select {
+	case change := <-changes:
+		require.Equal(t, ShardChange{Stream: "foo", Old: old, New: split}, change)
+	case <-time.After(time.Second):
+		t.Fatal("stale shards were not refreshed")
+	}
+	require.Equal(t, split, p.shardMap.Shards())
+}
+
+func TestAutoPartitionKey(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:       "foo",
+		MaxConnections:   1,
+		FlushInterval:    time.Hour,
+		AutoPartitionKey: true,
+		Logger:           &NopLogger{},
+		Client:           client,
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("hello"), "", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("hello"), "bar", WithoutAggregation()))
+	p.Stop()
+
+	keys := client.incoming[0]
+	require.Len(t, keys, 3)
+	require.Contains(t, keys, "bar")
+	generated := make(map[string]bool)
+	for _, key := range keys {
+		if key != "bar" {
+			require.Regexp(t, "^[0-9a-f]{32}$", key)
+			generated[key] = true
+		}
+	}
+	require.Len(t, generated, 2)
+
+	p = New(&Config{StreamName: "foo", Logger: &NopLogger{}, Client: &clientMock{}})
+	require.IsType(t, &ErrIllegalPartitionKey{}, p.Put([]byte("hello"), ""))
+}
+
+func TestFlush(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 1, client.calls)
+	require.Equal(t, []string{"foo"}, client.incoming[0])
+}
+
+func TestPutWithContextCanceled(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	defer p.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.PutWithContext(ctx, []byte("hello"), "foo")
+	require.IsType(t, &ErrRecordCanceled{}, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	require.NoError(t, p.PutWithContext(ctx, []byte("hello"), "foo"))
+	require.NoError(t, p.PutWithContext(context.Background(), []byte("world"), "bar"))
+	require.NoError(t, p.PutWithContext(ctx, []byte("hello"), "baz", WithoutAggregation()))
+	cancel()
+
+	flushed := make(chan error)
+	go func() {
+		flushed <- p.Flush(context.Background())
+	}()
+	var canceled []string
+	for i := 0; i < 2; i++ {
+		err := <-failures
+		var canceledErr *ErrRecordCanceled
+		require.ErrorAs(t, err, &canceledErr)
+		require.ErrorIs(t, err, context.Canceled)
+		canceled = append(canceled, canceledErr.PartitionKey())
+	}
+	require.NoError(t, <-flushed)
+	require.ElementsMatch(t, []string{"foo", "baz"}, canceled)
+	require.Equal(t, 1, client.calls)
+	require.Equal(t, []string{"bar"}, client.incoming[0])
+
+	records, err := deaggregation.Deaggregate(client.data[0], "bar")
+	require.NoError(t, err)
+	require.Equal(t, []deaggregation.Record{{PartitionKey: "bar", Data: []byte("world")}}, records)
+	require.Equal(t, int64(2), p.Stats().Drops)
+}
+
+func TestFlushContextDone(t *testing.T) {
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Backoff:        &FixedBackoff{Delay: 10 * time.Millisecond},
+		Logger:         &NopLogger{},
+		Client:         &throttledClientMock{},
+	})
+	p.Start()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, p.Flush(ctx))
+
+	// puts are accepted again after Flush returns
+	require.NoError(t, p.Put([]byte("world"), "foo"))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p.Shutdown(ctx)
+	require.IsType(t, &ErrStoppedProducer{}, p.Flush(context.Background()))
+}
+
+func TestFlushConcurrent(t *testing.T) {
+	reply := make(chan struct{})
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		<-reply
+		out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+		for range input.Records {
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")})
+		}
+		return out, nil
+	})
+	p := New(&Config{
+		StreamName:    "foo",
+		FlushInterval: time.Hour,
+		Logger:        &NopLogger{},
+		Client:        client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	flushed := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { flushed <- p.Flush(context.Background()) }()
+	}
+	// the main loop is not held back while the flushes wait for the request
+	require.Eventually(t, func() bool { return p.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, p.UpdateConfig(func(c *Config) { c.BatchCount = 100 }))
+	// puts are blocked until both flushes return
+	require.IsType(t, &ErrBacklogFull{}, p.TryPut([]byte("world"), "foo"))
+	close(reply)
+	require.NoError(t, <-flushed)
+	require.NoError(t, <-flushed)
+	require.NoError(t, p.TryPut([]byte("world"), "foo"))
+}
+
+func TestPutToStream(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	var getStreamShards []string
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		StreamRouter: func(userRecord UserRecord) string {
+			if strings.HasPrefix(userRecord.PartitionKey(), "bar") {
+				return "bar"
+			}
+			return ""
+		},
+		GetStreamShards: func(stream string, old []types.Shard) ([]types.Shard, bool, error) {
+			getStreamShards = append(getStreamShards, stream)
+			return nil, false, nil
+		},
+		Logger: &NopLogger{},
+		Client: client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo-1"))
+	require.NoError(t, p.Put([]byte("hello"), "bar-1"))
+	require.NoError(t, p.PutToStream("baz", []byte("hello"), "baz-1"))
+	require.NoError(t, p.PutToStream("bar", []byte("hello"), "bar-2"))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, 3, client.calls)
+	require.ElementsMatch(t, []string{"foo", "bar", "baz"}, client.streams)
+	require.ElementsMatch(t, []string{"bar", "baz"}, getStreamShards)
+	for i, stream := range client.streams {
+		switch stream {
+		case "foo":
+			require.Equal(t, []string{"foo-1"}, client.incoming[i])
+		case "bar":
+			// both bar records are aggregated into a single kinesis record
+			require.Len(t, client.incoming[i], 1)
+		case "baz":
+			require.Equal(t, []string{"baz-1"}, client.incoming[i])
+		}
+	}
+}
+
+func TestPutToStreamFetchingShards(t *testing.T) {
+	var (
+		fetches int32
+		fetched = make(chan struct{})
+	)
+	p := New(&Config{
+		StreamName:    "foo",
+		FlushInterval: time.Hour,
+		GetStreamShards: func(stream string, old []types.Shard) ([]types.Shard, bool, error) {
+			atomic.AddInt32(&fetches, 1)
+			<-fetched
+			return nil, false, nil
+		},
+		Logger: &NopLogger{},
+		Client: &clientMock{incoming: make(map[int][]string)},
+	})
+
+	// the first Puts to a stream wait for its shards, without holding back other streams
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, p.PutToStream("bar", []byte("hello"), "bar"))
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, p.PutToStream("foo", []byte("hello"), "foo"))
+	close(fetched)
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	require.Equal(t, 3, p.Stats().Aggregators["bar"][""])
+}
+
+func TestFirehoseBackend(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		Backend:        BackendFirehose,
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	require.Equal(t, firehoseMaxRequestSize, p.BatchSize)
+	p.Start()
+	defer p.Stop()
+
+	// records are sent without partition keys or aggregation
+	require.NoError(t, p.Put([]byte("hello"), ""))
+	require.NoError(t, p.Put([]byte("world"), ""))
+	require.IsType(t, &ErrRecordSizeExceeded{}, p.Put(make([]byte, firehoseMaxRecordSize+1), ""))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 1, client.calls)
+	require.Equal(t, []string{"", ""}, client.incoming[0])
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Error: &types.ProvisionedThroughputExceededException{}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:         "foo",
+		OrderedDelivery:    true,
+		MaxConnections:     4,
+		FlushInterval:      time.Hour,
+		AggregateBatchSize: 1,
+		Backoff:            &FixedBackoff{Delay: 10 * time.Millisecond},
+		Logger:             &NopLogger{},
+		Client:             client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// every record bypasses aggregation, so each request may only contain one of them
+	// and the retry of the first request is sent before the others
+	require.NoError(t, p.Put([]byte("hello"), "a"))
+	require.NoError(t, p.Put([]byte("hello"), "b"))
+	require.NoError(t, p.Put([]byte("hello"), "c"))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, 4, client.calls)
+	require.Equal(t, map[int][]string{
+		0: {"a"},
+		1: {"a"},
+		2: {"b"},
+		3: {"c"},
+	}, client.incoming)
+}
+
+func TestShardConcurrency(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:       "foo",
+		GetShards:        StaticGetShardsFunc(2),
+		ShardConcurrency: 1,
+		MaxConnections:   1,
+		FlushInterval:    time.Hour,
+		Logger:           &NopLogger{},
+		Client:           client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the records of each shard are sent in their own request
+	require.NoError(t, p.PutWithExplicitHashKey([]byte("hello"), "a", "0"))
+	require.NoError(t, p.PutWithExplicitHashKey([]byte("hello"), "b", maxHashKeyRange))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, 2, client.calls)
+	require.Len(t, client.incoming[0], 1)
+	require.Len(t, client.incoming[1], 1)
+	require.ElementsMatch(t, []string{"a", "b"}, append(client.incoming[0], client.incoming[1]...))
+}
+
+func TestBatchPacking(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		GetShards:      StaticGetShardsFunc(4),
+		BatchSize:      1100,
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// one aggregated record per shard. Batched in order, the first record would be sent on
+	// its own
+	quarter := new(big.Int).Lsh(big.NewInt(1), 126)
+	for i, size := range []int{600, 600, 300, 300} {
+		hashKey := new(big.Int).Mul(quarter, big.NewInt(int64(i))).String()
+		data := make([]byte, size)
+		require.NoError(t, p.PutWithExplicitHashKey(data, fmt.Sprint(i), hashKey))
+	}
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, 2, client.calls)
+	require.Len(t, client.incoming[0], 2)
+	require.Len(t, client.incoming[1], 2)
+}
+
+func TestPutWithPriority(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the priority record is sent without waiting for the flush interval
+	require.NoError(t, p.Put([]byte("hello"), "bulk"))
+	require.NoError(t, p.PutWithPriority([]byte("alert"), "urgent"))
+	require.Eventually(t, func() bool {
+		return p.Stats().Requests == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, 2, client.calls)
+	require.Equal(t, map[int][]string{
+		0: {"urgent"},
+		1: {"bulk"},
+	}, client.incoming)
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("2")},
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("3")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:         "foo",
+		MaxChunkSize:       1024,
+		MaxConnections:     1,
+		FlushInterval:      time.Hour,
+		AggregateBatchSize: 1,
+		Logger:             &NopLogger{},
+		Client:             client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	future, err := p.PutWithResult(data, "foo")
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, PutResult{ShardId: "shardId-0", SequenceNumber: "3"}, future.Result())
+
+	require.Len(t, client.data, 3)
+	d := chunking.NewDechunker()
+	for i, chunk := range client.data {
+		require.LessOrEqual(t, len(chunk)+len("foo"), 1024)
+		out, complete, err := d.Add(chunk)
+		require.NoError(t, err)
+		require.Equal(t, i == 2, complete)
+		if complete {
+			require.Equal(t, data, out)
+		}
+	}
+}
+
+type largeRecordStoreMock struct {
+	sync.Mutex
+	data [][]byte
+	err  error
+}
+
+func (s *largeRecordStoreMock) Store(_ context.Context, data []byte) (*claimcheck.ClaimCheck, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.data = append(s.data, data)
+	return claimcheck.New("bucket", fmt.Sprintf("key-%d", len(s.data)), data), nil
+}
+
+func TestLargeRecordStore(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+				},
+			}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	store := &largeRecordStoreMock{}
+	p := New(&Config{
+		StreamName:           "foo",
+		LargeRecordStore:     store,
+		LargeRecordThreshold: 1024,
+		MaxConnections:       1,
+		FlushInterval:        time.Hour,
+		AggregateBatchSize:   1,
+		Logger:               &NopLogger{},
+		Client:               client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	large := bytes.Repeat([]byte("a"), 2*1024*1024)
+	future, err := p.PutWithResult(large, "foo")
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, PutResult{ShardId: "shardId-0", SequenceNumber: "1"}, future.Result())
+
+	require.Equal(t, [][]byte{large}, store.data)
+	require.Len(t, client.data, 1)
+	c, err := claimcheck.Decode(client.data[0])
+	require.NoError(t, err)
+	require.Equal(t, "key-1", c.Key)
+	require.NoError(t, c.Verify(large))
+
+	// a small record that looks like a pointer record is offloaded too
+	lookalike := []byte("KPCC{}")
+	require.NoError(t, p.Put(lookalike, "foo", WithoutAggregation()))
+	require.Equal(t, [][]byte{large, lookalike}, store.data)
+
+	store.err = errors.New("boom")
+	err = p.Put(large, "foo")
+	require.IsType(t, &ErrLargeRecordStore{}, err)
+	require.Equal(t, "foo", err.(*ErrLargeRecordStore).PartitionKey())
+	require.ErrorIs(t, err, store.err)
+}
+
+func TestWriteAheadLog(t *testing.T) {
+	dir := t.TempDir()
+	log, err := wal.Open(dir, nil)
+	require.NoError(t, err)
+	// records left by a previous run
+	_, err = log.Append(encodeLogRecord("foo", NewDataRecord([]byte("hello"), "foo")))
+	require.NoError(t, err)
+	_, err = log.Append(encodeLogRecord("foo", NewDataRecordWithExplicitHashKey([]byte("world"), "bar", big.NewInt(1))))
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	log, err = wal.Open(dir, nil)
+	require.NoError(t, err)
+	defer log.Close()
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+				},
+			}},
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("2")},
+				},
+			}},
+			{Error: &types.ResourceNotFoundException{}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		WriteAheadLog:  log,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.Equal(t, 2, log.Pending())
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 1, client.calls)
+	records, err := deaggregation.Deaggregate(client.data[0], "foo")
+	require.NoError(t, err)
+	require.Equal(t, []deaggregation.Record{
+		{PartitionKey: "foo", Data: []byte("hello")},
+		{PartitionKey: "bar", ExplicitHashKey: "1", Data: []byte("world")},
+	}, records)
+	require.Equal(t, 0, log.Pending())
+
+	// new records are acknowledged once delivered
+	require.NoError(t, p.Put([]byte("foo"), "foo"))
+	require.Equal(t, 1, log.Pending())
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 0, log.Pending())
+
+	// records that are not accepted are acknowledged
+	require.IsType(t, &ErrIllegalPartitionKey{}, p.Put([]byte("foo"), ""))
+	require.Equal(t, 0, log.Pending())
+
+	// records that failed are kept to be put again
+	require.NoError(t, p.Put([]byte("bar"), "foo"))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 1, log.Pending())
+}
+
+func TestStats(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Put([]byte("world"), "bar"))
+	stats := p.Stats()
+	require.Equal(t, int64(2), stats.Puts)
+	require.Equal(t, 16, stats.BufferedBytes)
+	require.Equal(t, map[string]map[string]int{"foo": {"": 2}}, stats.Aggregators)
+	require.True(t, stats.LastFlush.IsZero())
+
+	require.NoError(t, p.Flush(context.Background()))
+	stats = p.Stats()
+	latency := stats.Streams["foo"].Latency
+	sent := ShardStats{Records: 1, Bytes: 55, Requests: 1, Latency: latency}
+	require.Equal(t, Stats{
+		Aggregators:   map[string]map[string]int{"foo": {"": 0}},
+		Puts:          2,
+		Requests:      1,
+		PayloadUnits:  1,
+		Flushes:       1,
+		FlushInterval: time.Hour,
+		Connections:   1,
+		LastFlush:     stats.LastFlush,
+		Streams: map[string]StreamStats{
+			"foo": {ShardStats: sent, Shards: map[string]ShardStats{"shardId-0": sent}},
+		},
+	}, stats)
+	require.False(t, stats.LastFlush.IsZero())
+}
+
+func TestWithoutAggregation(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("2")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar"))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, map[int][]string{0: {"foo", "bar"}}, client.incoming)
+	require.Equal(t, []byte("hello"), client.data[0])
+	require.Equal(t, magicNumber, client.data[1][:len(magicNumber)])
+}
+
+func TestCompression(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{
+				FailedRecordCount: aws.Int32(0),
+				Records: []types.PutRecordsResultEntry{
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")},
+					{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("2")},
+				},
+			}},
+		},
+	}
+	p := New(&Config{
+		StreamName:           "foo",
+		Compression:          compression.Gzip,
+		CompressionThreshold: 100,
+		MaxConnections:       1,
+		FlushInterval:        time.Hour,
+		Logger:               &NopLogger{},
+		Client:               client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	data := bytes.Repeat([]byte("hello world "), 100)
+	future, err := p.PutWithResult(data, "foo", WithoutAggregation())
+	require.NoError(t, err)
+	require.NoError(t, p.Put([]byte("short"), "bar"))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, PutResult{ShardId: "shardId-0", SequenceNumber: "1"}, future.Result())
+
+	require.Len(t, client.data, 2)
+	require.True(t, compression.IsCompressed(client.data[0]))
+	out, err := compression.Decompress(client.data[0])
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+	// below the threshold
+	require.True(t, bytes.Contains(client.data[1], []byte("short")))
+}
+
+func TestCompressionStored(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:           "foo",
+		Compression:          compression.Gzip,
+		CompressionThreshold: 100,
+		MaxConnections:       1,
+		FlushInterval:        time.Hour,
+		Logger:               &NopLogger{},
+		Client:               client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// raw data that looks like a gzip payload is not decompressed by consumers
+	data := []byte{0x4B, 0x50, 0x5A, 1, 'h', 'i'}
+	require.NoError(t, p.Put(data, "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("short"), "bar", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Len(t, client.data, 2)
+	out, err := compression.Decompress(client.data[0])
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+	require.Equal(t, []byte("short"), client.data[1])
+}
+
+func TestStreamARN(t *testing.T) {
+	const arn = "arn:aws:kinesis:us-east-1:123456789012:stream/foo"
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamARN:      arn,
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Flush(context.Background()))
+	require.NoError(t, p.PutToStream("bar", []byte("hello"), "bar"))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, []string{"", "bar"}, client.streams)
+	require.Equal(t, []string{arn, ""}, client.arns)
+}
+
+func TestUpdateConfig(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+
+	// the update is applied right away before Start
+	require.NoError(t, p.UpdateConfig(func(c *Config) {
+		c.FlushInterval = 2 * time.Hour
+	}))
+	require.Equal(t, 2*time.Hour, p.Stats().FlushInterval)
+	p.Start()
+
+	require.EqualError(t, p.UpdateConfig(func(c *Config) {
+		c.BatchCount = 1000
+	}), "kinesis: BatchCount exceeds 500")
+
+	// only the safe parameters are changed
+	require.NoError(t, p.UpdateConfig(func(c *Config) {
+		c.StreamName = "bar"
+		c.BatchCount = 1
+		c.MaxConnections = 2
+		c.FlushInterval = time.Minute
+	}))
+	require.Equal(t, "foo", p.StreamName)
+	require.Equal(t, time.Minute, p.Stats().FlushInterval)
+
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar", WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 2, client.calls)
+	require.ElementsMatch(t, []string{"foo", "bar"}, append(client.incoming[0], client.incoming[1]...))
+
+	p.Stop()
+	require.IsType(t, &ErrStoppedProducer{}, p.UpdateConfig(func(c *Config) {}))
+}
+
+func TestUpdateConfigMaxConnections(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inflight []int
+		n        int
+		release  = make(chan struct{})
+	)
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		mu.Lock()
+		n++
+		inflight = append(inflight, n)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		n--
+		mu.Unlock()
+		return &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}, nil
+	})
+	p := New(&Config{
+		StreamName:     "foo",
+		BatchCount:     1,
+		MaxConnections: 2,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar", WithoutAggregation()))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return n == 2
+	}, time.Second, time.Millisecond)
+
+	// the connections are lowered without waiting for the requests in flight
+	require.NoError(t, p.UpdateConfig(func(c *Config) {
+		c.MaxConnections = 1
+	}))
+	require.Equal(t, 1, p.Stats().Connections)
+
+	// no request is sent above the new limit
+	require.NoError(t, p.Put([]byte("again"), "baz", WithoutAggregation()))
+	close(release)
+	require.NoError(t, p.Flush(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []int{1, 2, 1}, inflight)
+}
+
+func TestPauseResume(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:       "foo",
+		MaxConnections:   1,
+		MaxBufferedBytes: 16,
+		FlushInterval:    time.Hour,
+		Logger:           &NopLogger{},
+		Client:           client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	p.Pause()
+	require.True(t, p.Stats().Paused)
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar", WithoutAggregation()))
+	// records held while paused count towards MaxBufferedBytes
+	require.IsType(t, &ErrBacklogFull{}, p.TryPut([]byte("hello"), "baz", WithoutAggregation()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, p.Flush(ctx))
+	require.Equal(t, 0, client.calls)
+	require.Equal(t, 16, p.Stats().BufferedBytes)
+
+	p.Resume()
+	require.False(t, p.Paused())
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, 1, client.calls)
+	require.ElementsMatch(t, []string{"foo", "bar"}, client.incoming[0])
+	require.Equal(t, 0, p.Stats().BufferedBytes)
+}
+
+func TestPutAfterStop(t *testing.T) {
+	p := New(&Config{
+		StreamName: "foo",
+		Logger:     &NopLogger{},
+		Client:     &clientMock{incoming: make(map[int][]string)},
+	})
+	p.Start()
+	p.Stop()
+	err := p.Put([]byte("hello"), "foo")
+	require.IsType(t, &ErrStoppedProducer{}, err)
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	newProducer := func(policy OverflowPolicy) *Producer {
+		// the producer is not started and every record bypasses aggregation so the first
+		// Put fills the backlog
+		return New(&Config{
+			StreamName:         "foo",
+			BacklogCount:       1,
+			AggregateBatchSize: 1,
+			OverflowPolicy:     policy,
+			Logger:             &NopLogger{},
+			Client:             &clientMock{incoming: make(map[int][]string)},
+		})
+	}
+
+	t.Run("TryPut", func(t *testing.T) {
+		p := newProducer(OverflowBlock)
+		require.NoError(t, p.TryPut([]byte("hello"), "foo"))
+		err := p.TryPut([]byte("world"), "bar")
+		require.IsType(t, &ErrBacklogFull{}, err)
+		require.Equal(t, "bar", err.(*ErrBacklogFull).PartitionKey())
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		p := newProducer(OverflowError)
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		require.IsType(t, &ErrBacklogFull{}, p.Put([]byte("world"), "bar"))
+	})
+
+	t.Run("DropNewest", func(t *testing.T) {
+		p := newProducer(OverflowDropNewest)
+		failures := p.NotifyFailures()
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		future, err := p.PutWithResult([]byte("world"), "bar")
+		require.NoError(t, err)
+		require.IsType(t, &ErrBacklogFull{}, future.Result().Err)
+
+		failure := <-failures
+		require.IsType(t, &ErrBacklogFull{}, failure)
+		require.Equal(t, "bar", failure.(*ErrBacklogFull).PartitionKey())
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		p := newProducer(OverflowDropOldest)
+		failures := p.NotifyFailures()
+		future, err := p.PutWithResult([]byte("hello"), "foo")
+		require.NoError(t, err)
+		require.NoError(t, p.Put([]byte("world"), "bar"))
+		require.IsType(t, &ErrBacklogFull{}, future.Result().Err)
+
+		failure := <-failures
+		require.IsType(t, &FailureRecord{}, failure)
+		require.IsType(t, &ErrBacklogFull{}, failure.(*FailureRecord).Err)
+		require.Equal(t, "foo", failure.(*FailureRecord).PartitionKey)
+	})
+}
+
+func TestOverflowSpill(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	dir := t.TempDir()
+	p := New(&Config{
+		StreamName:       "foo",
+		MaxConnections:   1,
+		MaxBufferedBytes: 10,
+		OverflowPolicy:   OverflowSpill,
+		SpillDir:         dir,
+		FlushInterval:    time.Hour,
+		Logger:           &NopLogger{},
+		Client:           client,
+	})
+	p.Start()
+
+	// records are held in memory while paused, so that the following ones are spilled
+	p.Pause()
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("world"), "bar", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("again"), "baz", WithoutAggregation()))
+	require.Equal(t, 2, p.Stats().Spilled)
+	require.Equal(t, 8, p.Stats().BufferedBytes)
+
+	p.Resume()
+	p.Stop()
+	var keys []string
+	for i := 0; i < client.calls; i++ {
+		keys = append(keys, client.incoming[i]...)
+	}
+	require.ElementsMatch(t, []string{"foo", "bar", "baz"}, keys)
+	require.Equal(t, 0, p.Stats().Spilled)
+
+	// the temporary files are removed
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestMaxBufferedBytes(t *testing.T) {
+	t.Run("TryPut", func(t *testing.T) {
+		p := New(&Config{
+			StreamName:       "foo",
+			MaxBufferedBytes: 10,
+			Logger:           &NopLogger{},
+			Client:           &clientMock{incoming: make(map[int][]string)},
+		})
+		// a single record larger than the limit is accepted when nothing is buffered
+		require.NoError(t, p.TryPut([]byte("hello world"), "foo"))
+		require.Equal(t, 14, p.buffered.size())
+		require.IsType(t, &ErrBacklogFull{}, p.TryPut([]byte("hello"), "bar"))
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		client := &clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+				{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			},
+		}
+		p := New(&Config{
+			StreamName:       "foo",
+			MaxBufferedBytes: 10,
+			MaxConnections:   1,
+			FlushInterval:    time.Hour,
+			Logger:           &NopLogger{},
+			Client:           client,
+		})
+		p.Start()
+		defer p.Stop()
+
+		// the second put blocks until the first record is flushed out of the aggregator
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		require.NoError(t, p.Put([]byte("world"), "bar"))
+		require.NoError(t, p.Flush(context.Background()))
+		require.Equal(t, 0, p.buffered.size())
+		require.Equal(t, 2, client.calls)
+	})
+
+	t.Run("InFlight", func(t *testing.T) {
+		sent, reply := make(chan struct{}, 1), make(chan struct{})
+		client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+			select {
+			case sent <- struct{}{}:
+			default:
+			}
+			<-reply
+			out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+			for range input.Records {
+				out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0"), SequenceNumber: aws.String("1")})
+			}
+			return out, nil
+		})
+		p := New(&Config{
+			StreamName:       "foo",
+			MaxBufferedBytes: 10,
+			FlushInterval:    time.Hour,
+			Logger:           &NopLogger{},
+			Client:           client,
+		})
+		p.Start()
+		defer p.Stop()
+
+		// the bytes of a record are held until it is delivered, not only until it is sent
+		require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+		flushed := make(chan error, 1)
+		go func() { flushed <- p.Flush(context.Background()) }()
+		<-sent
+		require.Equal(t, 8, p.Stats().BufferedBytes)
+		require.IsType(t, &ErrBacklogFull{}, p.TryPut([]byte("world"), "bar"))
+		close(reply)
+		require.NoError(t, <-flushed)
+		require.Equal(t, 0, p.Stats().BufferedBytes)
+		require.NoError(t, p.TryPut([]byte("world"), "bar"))
+	})
+
+	t.Run("UpdateShards", func(t *testing.T) {
+		old, _, _ := StaticGetShardsFunc(1)(nil)
+		split, _, _ := StaticGetShardsFunc(2)(nil)
+		var splitting int32
+		changes := make(chan ShardChange, 1)
+		p := New(&Config{
+			StreamName:       "foo",
+			MaxBufferedBytes: 10,
+			FlushInterval:    time.Hour,
+			GetShards: func(current []types.Shard) ([]types.Shard, bool, error) {
+				if current == nil {
+					return old, true, nil
+				}
+				if atomic.CompareAndSwapInt32(&splitting, 1, 2) {
+					return split, true, nil
+				}
+				return nil, false, nil
+			},
+			ShardRefreshInterval: 10 * time.Millisecond,
+			OnShardChange:        func(change ShardChange) { changes <- change },
+			Logger:               &NopLogger{},
+			Client: &clientMock{
+				incoming:  make(map[int][]string),
+				responses: []responseMock{{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}}},
+			},
+		})
+		p.Start()
+		defer p.Stop()
+
+		// the record held by the paused worker pool is aggregated again for the new shards,
+		// and keeps its bytes
+		p.Pause()
+		require.NoError(t, p.Put([]byte("hello"), "foo"))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.Equal(t, context.DeadlineExceeded, p.Flush(ctx))
+		atomic.StoreInt32(&splitting, 1)
+		<-changes
+		require.Equal(t, 8, p.Stats().BufferedBytes)
+		p.Resume()
+	})
 }
 
-type clientMock struct {
-	calls     int
-	responses []responseMock
-	incoming  map[int][]string
+type metricsMock struct {
+	NopMetrics
+	sync.Mutex
+	userRecordsPut int
+	kinesisRecords int
+	retried        int
+	throttled      int
+	dropped        int
+	failed         map[string]int
+	// records sent and throttled by shard id
+	shardRecords   map[string]int
+	shardThrottles map[string]int
+	// user records reported as buffered
+	buffered int
 }
 
-func (c *clientMock) PutRecords(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
-	res := c.responses[c.calls]
-	for _, r := range input.Records {
-		c.incoming[c.calls] = append(c.incoming[c.calls], *r.PartitionKey)
+func (m *metricsMock) UserRecordsPut(count int, bytes int) {
+	m.Lock()
+	m.userRecordsPut += count
+	m.Unlock()
+}
+
+func (m *metricsMock) RequestSent(kinesisRecords, userRecords, bytes int, latency time.Duration) {
+	m.Lock()
+	m.kinesisRecords += kinesisRecords
+	m.Unlock()
+}
+
+func (m *metricsMock) RecordsRetried(count int) {
+	m.Lock()
+	m.retried += count
+	m.Unlock()
+}
+
+func (m *metricsMock) RecordsThrottled(count int) {
+	m.Lock()
+	m.throttled += count
+	m.Unlock()
+}
+
+func (m *metricsMock) ShardRecordsSent(stream, shardID string, records, bytes int, latency time.Duration) {
+	m.Lock()
+	if m.shardRecords == nil {
+		m.shardRecords = make(map[string]int)
 	}
-	c.calls++
-	if res.Error != nil {
-		return nil, res.Error
+	m.shardRecords[shardID] += records
+	m.Unlock()
+}
+
+func (m *metricsMock) ShardRecordsThrottled(stream, shardID string, count int) {
+	m.Lock()
+	if m.shardThrottles == nil {
+		m.shardThrottles = make(map[string]int)
 	}
-	return res.Response, nil
+	m.shardThrottles[shardID] += count
+	m.Unlock()
 }
 
-type testCase struct {
-	// configuration
-	name    string      // test name
-	config  *Config     // test config
-	records []string    // all outgoing records(partition keys and data too)
-	putter  *clientMock // mocked client
+func (m *metricsMock) UserRecordBuffered(d time.Duration) {
+	m.Lock()
+	m.buffered++
+	m.Unlock()
+}
 
-	// expectations
-	outgoing map[int][]string // [call number][partition keys]
+func (m *metricsMock) RecordsDropped(count int) {
+	m.Lock()
+	m.dropped += count
+	m.Unlock()
 }
 
-func genBulk(n int, s string) (ret []string) {
-	for i := 0; i < n; i++ {
-		ret = append(ret, s)
+func (m *metricsMock) RecordsFailed(code string, count int) {
+	m.Lock()
+	if m.failed == nil {
+		m.failed = make(map[string]int)
 	}
-	return
+	m.failed[code] += count
+	m.Unlock()
 }
 
-var testCases = []testCase{
-	{
-		"one record with batch count 1",
-		&Config{BatchCount: 1},
-		[]string{"hello"},
-		&clientMock{
-			incoming: make(map[int][]string),
-			responses: []responseMock{
-				{
-					Error: nil,
-					Response: &k.PutRecordsOutput{
-						FailedRecordCount: aws.Int32(0),
-					},
-				},
-			}},
-		map[int][]string{
-			0: []string{"hello"},
-		},
-	},
-	{
-		"two records with batch count 1",
-		&Config{BatchCount: 1, AggregateBatchCount: 1},
-		[]string{"hello", "world"},
-		&clientMock{
-			incoming: make(map[int][]string),
-			responses: []responseMock{
-				{
-					Error: nil,
-					Response: &k.PutRecordsOutput{
-						FailedRecordCount: aws.Int32(0),
-					},
-				},
-				{
-					Error: nil,
-					Response: &k.PutRecordsOutput{
-						FailedRecordCount: aws.Int32(0),
-					},
-				},
-			}},
-		map[int][]string{
-			0: []string{"hello"},
-			1: []string{"world"},
-		},
-	},
-	{
-		"two records with batch count 2, simulating retries",
-		&Config{BatchCount: 2, AggregateBatchCount: 1},
-		[]string{"hello", "world"},
-		&clientMock{
+func TestNopMetrics(t *testing.T) {
+	var metrics Metrics = &NopMetrics{}
+	for _, implemented := range []bool{
+		implements[RecordErrorMetrics](metrics),
+		implements[ShardMetrics](metrics),
+		implements[BufferingMetrics](metrics),
+		implements[StageMetrics](metrics),
+		implements[MirrorMetrics](metrics),
+	} {
+		require.False(t, implemented)
+	}
+}
+
+func implements[T any](metrics Metrics) bool {
+	_, ok := metrics.(T)
+	return ok
+}
+
+func TestIsRejected(t *testing.T) {
+	require.False(t, isRejected(nil))
+	require.False(t, isRejected(&DrainError{Err: errors.New("drain")}))
+	require.True(t, isRejected(&ErrIllegalPartitionKey{}))
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &metricsMock{}
+	p := New(&Config{
+		StreamName:          "foo",
+		MaxConnections:      1,
+		BatchCount:          2,
+		AggregateBatchCount: 1,
+		Backoff:             &FixedBackoff{},
+		Logger:              &NopLogger{},
+		Metrics:             metrics,
+		Client: &clientMock{
 			incoming: make(map[int][]string),
 			responses: []responseMock{
 				{
-					Error: nil,
 					Response: &k.PutRecordsOutput{
 						FailedRecordCount: aws.Int32(1),
 						Records: []types.PutRecordsResultEntry{
 							{SequenceNumber: aws.String("3"), ShardId: aws.String("1")},
-							{ErrorCode: aws.String("400")},
+							{ErrorCode: aws.String(throttledErrorCode)},
 						},
 					},
 				},
-				{
-					Error: nil,
-					Response: &k.PutRecordsOutput{
-						FailedRecordCount: aws.Int32(0),
-					},
-				},
-			}},
-		map[int][]string{
-			0: []string{"hello", "world"},
-			1: []string{"world"},
-		},
-	},
-	{
-		"2 bulks of 10 records",
-		&Config{BatchCount: 10, AggregateBatchCount: 1, BacklogCount: 1},
-		genBulk(20, "foo"),
-		&clientMock{
-			incoming: make(map[int][]string),
-			responses: []responseMock{
-				{
-					Error: nil,
-					Response: &k.PutRecordsOutput{
-						FailedRecordCount: aws.Int32(0),
-					},
-				},
-				{
-					Error: nil,
-					Response: &k.PutRecordsOutput{
-						FailedRecordCount: aws.Int32(0),
-					},
-				},
-			}},
-		map[int][]string{
-			0: genBulk(10, "foo"),
-			1: genBulk(10, "foo"),
-		},
-	},
-}
-
-func TestProducer(t *testing.T) {
-	for _, test := range testCases {
-		test.config.StreamName = test.name
-		test.config.MaxConnections = 1
-		test.config.Client = test.putter
-		p := New(test.config)
-		failures := p.NotifyFailures()
-		var ewg sync.WaitGroup
-		ewg.Add(1)
-		go func() {
-			defer ewg.Done()
-			for err := range failures {
-				t.Error(err)
-			}
-		}()
-		p.Start()
-		var wg sync.WaitGroup
-		wg.Add(len(test.records))
-		for _, r := range test.records {
-			go func(s string) {
-				err := p.Put([]byte(s), s)
-				if err != nil {
-					t.Error(err)
-				}
-				wg.Done()
-			}(r)
-		}
-		wg.Wait()
-		p.Stop()
-		ewg.Wait()
-		for k, v := range test.putter.incoming {
-			if len(v) != len(test.outgoing[k]) {
-				t.Errorf("failed test: %s\n\texcpeted:%v\n\tactual:  %v", test.name,
-					test.outgoing, test.putter.incoming)
-			}
-		}
-	}
-}
-
-func TestNotify(t *testing.T) {
-	kError := errors.New("ResourceNotFoundException: Stream foo under account X not found")
-	p := New(&Config{
-		StreamName:          "foo",
-		MaxConnections:      1,
-		BatchCount:          1,
-		AggregateBatchCount: 10,
-		Client: &clientMock{
-			incoming:  make(map[int][]string),
-			responses: []responseMock{{Error: kError}},
+				{Error: &types.ResourceNotFoundException{}},
+			},
 		},
 	})
 	p.Start()
-	records := genBulk(10, "bar")
-	failures := p.NotifyFailures()
-	failed := 0
-	done := make(chan struct{})
-	// timeout test after 10 seconds
-	timeout := time.After(10 * time.Second)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case failure, ok := <-failures:
-				if !ok {
-					// expect producer close the failures channel
-					close(done)
-					return
-				}
-				failed += len(failure.(*FailureRecord).UserRecords)
-			case <-timeout:
-				return
-			}
-		}
-	}()
-	for _, r := range records {
-		p.Put([]byte(r), r)
-	}
+	require.NoError(t, p.Put([]byte("hello"), "hello"))
+	require.NoError(t, p.Put([]byte("world"), "world"))
 	p.Stop()
-	wg.Wait()
 
-	if failed != len(records) {
-		t.Errorf("failed test: NotifyFailure\n\texcpeted:%v\n\tactual:%v", failed, len(records))
-	}
+	require.Equal(t, 2, metrics.userRecordsPut)
+	require.Equal(t, 2, metrics.kinesisRecords)
+	require.Equal(t, 1, metrics.retried)
+	require.Equal(t, 1, metrics.throttled)
+	require.Equal(t, 1, metrics.dropped)
+	require.Equal(t, map[string]int{throttledErrorCode: 1, "ResourceNotFoundException": 1}, metrics.failed)
+	// the shards of the stream are unknown, so throttled records have no shard id
+	require.Equal(t, map[string]int{"1": 1}, metrics.shardRecords)
+	require.Equal(t, map[string]int{"": 1}, metrics.shardThrottles)
+	// retries do not count towards the buffering time
+	require.Equal(t, 2, metrics.buffered)
+}
 
-	select {
-	case <-done:
-	default:
-		t.Error("failed test: NotifyFailure\n\texpect failures channel to be closed")
-	}
+func TestFormatErrorCodes(t *testing.T) {
+	require.Equal(t, "", formatErrorCodes(nil))
+	require.Equal(t,
+		"InternalFailure=1,ProvisionedThroughputExceededException=2",
+		formatErrorCodes(map[string]int{throttledErrorCode: 2, "InternalFailure": 1}),
+	)
 }
 
 func mockGetShards(startingShards, shards []types.Shard, updated bool, err error) GetShardsFunc {
@@ -569,7 +2555,7 @@ func BenchmarkProducer(b *testing.B) {
 			go func() {
 				defer close(failuresDone)
 				for f := range failures {
-					b.Fatal(f.Error())
+					b.Error(f.Error())
 				}
 			}()
 
@@ -593,7 +2579,7 @@ func BenchmarkProducer(b *testing.B) {
 						record := records[index*each+j]
 						err := p.PutUserRecord(record)
 						if err != nil {
-							b.Fatal(err)
+							b.Error(err)
 						}
 					}
 					workerWG.Done()
@@ -605,3 +2591,36 @@ func BenchmarkProducer(b *testing.B) {
 		})
 	}
 }
+
+func TestMaxChunkSizeBacklogFull(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:       "foo",
+		MaxChunkSize:     1024,
+		BacklogCount:     3,
+		MaxBufferedBytes: 3000,
+		MaxConnections:   1,
+		FlushInterval:    time.Hour,
+		Logger:           &NopLogger{},
+		Client:           client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the bytes held by the paused producer leave room for the first chunk only, none of
+	// them is put
+	p.Pause()
+	require.NoError(t, p.Put(make([]byte, 1000), "foo", WithoutAggregation()))
+	require.IsType(t, &ErrBacklogFull{}, p.TryPut(make([]byte, 2500), "foo"))
+	require.Equal(t, 1003, p.Stats().BufferedBytes)
+	require.EqualError(t, p.Put(make([]byte, 4000), "foo"), "kinesis: record of 5 chunks exceeds BacklogCount 3")
+
+	p.Resume()
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, [][]byte{make([]byte, 1000)}, client.data)
+}
@@ -0,0 +1,131 @@
+package producertest
+
+import (
+	"sync"
+	"time"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+// FakeClock is a producer.Clock whose time only moves with Advance. Tickers and timers
+// fire once the time is advanced past their deadline, so tests do not wait for the flush
+// interval or backoff. It is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters map[*fakeTimer]struct{}
+}
+
+var _ producer.Clock = (*FakeClock)(nil)
+
+// NewFakeClock creates a FakeClock starting at now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now, waiters: make(map[*fakeTimer]struct{})}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the time forward by d and fires the tickers and timers that are due. Like
+// time.Ticker, a ticker fires at most once per Advance and drops the ticks that were not
+// received.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.waiters {
+		if t.deadline.After(c.now) {
+			continue
+		}
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+		if t.period == 0 {
+			delete(c.waiters, t)
+			continue
+		}
+		for !t.deadline.After(c.now) {
+			t.deadline = t.deadline.Add(t.period)
+		}
+	}
+	c.cond.Broadcast()
+}
+
+// BlockUntil blocks until at least n tickers and timers are waiting, e.g. so that the
+// backoff of a retry has started before calling Advance
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// NewTicker creates a ticker firing every d
+func (c *FakeClock) NewTicker(d time.Duration) producer.Ticker {
+	if d <= 0 {
+		panic("producertest: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(d, d)}
+}
+
+// NewTimer creates a timer firing once after d
+func (c *FakeClock) NewTimer(d time.Duration) producer.Timer {
+	return c.add(d, 0)
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1), deadline: c.now.Add(d), period: period}
+	if d <= 0 && period == 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.waiters[t] = struct{}{}
+	c.cond.Broadcast()
+	return t
+}
+
+// fakeTimer is a ticker, or a timer if period is 0, of a FakeClock
+type fakeTimer struct {
+	clock    *FakeClock
+	ch       chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+// fakeTicker is a fakeTimer with the Stop method of a ticker
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+func (t *fakeTimer) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("producertest: non-positive interval for Reset")
+	}
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.deadline, t.period = t.clock.now.Add(d), d
+	t.clock.waiters[t] = struct{}{}
+	t.clock.cond.Broadcast()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, ok := t.clock.waiters[t]
+	delete(t.clock.waiters, t)
+	t.clock.cond.Broadcast()
+	return ok
+}
@@ -0,0 +1,51 @@
+package producertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	producer "github.com/achunariov/kinesis-producer"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFakeClock(start)
+	ticker := clock.NewTicker(time.Second)
+	timer := clock.NewTimer(2 * time.Second)
+
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), <-ticker.C())
+	require.Empty(t, timer.C())
+
+	// ticks that were not received are dropped
+	clock.Advance(2 * time.Second)
+	require.Equal(t, start.Add(3*time.Second), <-ticker.C())
+	require.Empty(t, ticker.C())
+	require.Equal(t, start.Add(3*time.Second), <-timer.C())
+	require.False(t, timer.Stop())
+
+	ticker.Reset(5 * time.Second)
+	clock.Advance(time.Second)
+	require.Empty(t, ticker.C())
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	require.Empty(t, ticker.C())
+	require.Equal(t, start.Add(time.Hour+4*time.Second), clock.Now())
+}
+
+func TestFakeClockFlushInterval(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	putter := NewPutter()
+	p := NewProducer(t, putter, producer.WithClock(clock), producer.WithFlushInterval(time.Minute))
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+
+	// wait for the flush ticker to be created
+	clock.BlockUntil(1)
+	require.Empty(t, putter.Delivered())
+	clock.Advance(time.Minute)
+	require.Eventually(t, func() bool {
+		return len(putter.Delivered()) == 1 && p.Stats().LastFlush.Equal(clock.Now())
+	}, time.Second, time.Millisecond)
+}
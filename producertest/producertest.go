@@ -0,0 +1,194 @@
+// Package producertest provides an in-memory producer.Putter for tests of code using the
+// producer, and helpers to check the user records it received.
+//
+//	putter := producertest.NewPutter()
+//	p := producertest.NewProducer(t, putter)
+//	p.Put([]byte("hello"), "foo")
+//	p.Flush(ctx)
+//	producertest.RequireDelivered(t, putter, "hello")
+package producertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	producer "github.com/achunariov/kinesis-producer"
+	"github.com/achunariov/kinesis-producer/deaggregation"
+)
+
+const (
+	// ThrottledCode is the error code of the records rejected because of ThrottleRate
+	ThrottledCode = "ProvisionedThroughputExceededException"
+	// FailureCode is the error code of the records rejected because of FailureRate
+	FailureCode = "InternalFailure"
+)
+
+// Record is a user record delivered to a Putter
+type Record struct {
+	deaggregation.Record
+	// Stream is the stream name, or ARN, of the request the record was delivered with
+	Stream string
+}
+
+// Putter implements producer.Putter in memory. It records every PutRecords request and
+// accepts their records, except for the ones it is configured to reject. It is safe for
+// concurrent use.
+type Putter struct {
+	// ThrottleRate is the probability, between 0 and 1, that a record is rejected with
+	// ThrottledCode
+	ThrottleRate float64
+	// FailureRate is the probability, between 0 and 1, that a record is rejected with
+	// FailureCode
+	FailureRate float64
+	// Err is returned by PutRecords instead of a response if not nil
+	Err error
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	requests  []*k.PutRecordsInput
+	delivered []Record
+	seq       int
+}
+
+var _ producer.Putter = (*Putter)(nil)
+
+// NewPutter creates a Putter accepting every record. Records are rejected according to
+// ThrottleRate and FailureRate with a fixed seed, so that runs are reproducible.
+func NewPutter() *Putter {
+	return NewPutterWithSeed(1)
+}
+
+// NewPutterWithSeed creates a Putter rejecting records with the random source of seed
+func NewPutterWithSeed(seed int64) *Putter {
+	return &Putter{rand: rand.New(rand.NewSource(seed))}
+}
+
+// PutRecords records the request and returns the result of each of its records
+func (p *Putter) PutRecords(_ context.Context, input *k.PutRecordsInput, _ ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, input)
+	if p.Err != nil {
+		return nil, p.Err
+	}
+
+	stream := aws.ToString(input.StreamName)
+	if stream == "" {
+		stream = aws.ToString(input.StreamARN)
+	}
+	var (
+		failed  int32
+		results = make([]types.PutRecordsResultEntry, len(input.Records))
+	)
+	for i, entry := range input.Records {
+		switch r := p.rand.Float64(); {
+		case r < p.ThrottleRate:
+			results[i] = types.PutRecordsResultEntry{
+				ErrorCode:    aws.String(ThrottledCode),
+				ErrorMessage: aws.String("Rate exceeded for shard shardId-000000000000"),
+			}
+			failed++
+			continue
+		case r < p.ThrottleRate+p.FailureRate:
+			results[i] = types.PutRecordsResultEntry{
+				ErrorCode:    aws.String(FailureCode),
+				ErrorMessage: aws.String("Internal service failure."),
+			}
+			failed++
+			continue
+		}
+		userRecords, err := deaggregation.DeaggregateEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, userRecord := range userRecords {
+			p.delivered = append(p.delivered, Record{Record: userRecord, Stream: stream})
+		}
+		p.seq++
+		results[i] = types.PutRecordsResultEntry{
+			ShardId:        aws.String("shardId-000000000000"),
+			SequenceNumber: aws.String(strconv.Itoa(p.seq)),
+		}
+	}
+	return &k.PutRecordsOutput{FailedRecordCount: aws.Int32(failed), Records: results}, nil
+}
+
+// Requests returns the PutRecords requests received so far, including rejected ones
+func (p *Putter) Requests() []*k.PutRecordsInput {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*k.PutRecordsInput(nil), p.requests...)
+}
+
+// Delivered returns the user records of the accepted records so far, deaggregated, in the
+// order they were received
+func (p *Putter) Delivered() []Record {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Record(nil), p.delivered...)
+}
+
+// Reset forgets the requests and records received so far
+func (p *Putter) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests, p.delivered = nil, nil
+}
+
+// NewProducer creates and starts a producer putting records to the "test" stream with
+// putter. The producer flushes every 10ms, logs nothing and is stopped at the end of the
+// test. opts are applied after these defaults.
+func NewProducer(tb testing.TB, putter producer.Putter, opts ...producer.Option) *producer.Producer {
+	tb.Helper()
+	defaults := []producer.Option{
+		producer.WithStreamName("test"),
+		producer.WithClient(putter),
+		producer.WithFlushInterval(10 * time.Millisecond),
+		producer.WithLogger(&producer.NopLogger{}),
+	}
+	p, err := producer.NewProducer(append(defaults, opts...)...)
+	if err != nil {
+		tb.Fatalf("producertest: %v", err)
+	}
+	p.Start()
+	tb.Cleanup(p.Stop)
+	return p
+}
+
+// RequireDelivered fails the test unless the data of the user records delivered to putter
+// is data, in any order
+func RequireDelivered(tb testing.TB, putter *Putter, data ...string) {
+	tb.Helper()
+	if err := matchDelivered(putter.Delivered(), data); err != nil {
+		tb.Fatalf("producertest: %v", err)
+	}
+}
+
+// matchDelivered returns an error unless the data of records is data, in any order
+func matchDelivered(records []Record, data []string) error {
+	remaining := append([]Record(nil), records...)
+next:
+	for _, d := range data {
+		for i, r := range remaining {
+			if bytes.Equal(r.Data, []byte(d)) {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				continue next
+			}
+		}
+		return fmt.Errorf("record %q was not delivered", d)
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("%d unexpected records were delivered, first %q", len(remaining), remaining[0].Data)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package producertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+
+	producer "github.com/achunariov/kinesis-producer"
+	"github.com/achunariov/kinesis-producer/deaggregation"
+)
+
+func TestPutterRetries(t *testing.T) {
+	putter := NewPutter()
+	putter.ThrottleRate = 0.3
+	putter.FailureRate = 0.2
+	p := NewProducer(t, putter, producer.WithBackoff(&producer.FixedBackoff{}))
+
+	var data []string
+	for i := 0; i < 20; i++ {
+		data = append(data, fmt.Sprint(i))
+		require.NoError(t, p.Put([]byte(data[i]), data[i], producer.WithoutAggregation()))
+	}
+	require.NoError(t, p.Flush(context.Background()))
+
+	RequireDelivered(t, putter, data...)
+	require.Greater(t, len(putter.Requests()), 1)
+	for _, r := range putter.Delivered() {
+		require.Equal(t, "test", r.Stream)
+		require.Equal(t, string(r.Data), r.PartitionKey)
+	}
+}
+
+func TestPutterDeaggregates(t *testing.T) {
+	putter := NewPutter()
+	p := NewProducer(t, putter)
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.NoError(t, p.Put([]byte("world"), "bar"))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Len(t, putter.Requests(), 1)
+	RequireDelivered(t, putter, "hello", "world")
+	putter.Reset()
+	require.Empty(t, putter.Requests())
+	require.Empty(t, putter.Delivered())
+}
+
+func TestPutterErr(t *testing.T) {
+	putter := NewPutter()
+	putter.Err = errors.New("unavailable")
+	out, err := putter.PutRecords(context.Background(), &k.PutRecordsInput{
+		StreamName: aws.String("test"),
+		Records:    []types.PutRecordsRequestEntry{{Data: []byte("hello"), PartitionKey: aws.String("foo")}},
+	})
+	require.Nil(t, out)
+	require.Equal(t, putter.Err, err)
+	require.Len(t, putter.Requests(), 1)
+	require.Empty(t, putter.Delivered())
+}
+
+func TestMatchDelivered(t *testing.T) {
+	records := []Record{
+		{Record: deaggregation.Record{Data: []byte("a")}},
+		{Record: deaggregation.Record{Data: []byte("b")}},
+		{Record: deaggregation.Record{Data: []byte("a")}},
+	}
+	require.NoError(t, matchDelivered(records, []string{"a", "a", "b"}))
+	require.EqualError(t, matchDelivered(records, []string{"a", "b", "c"}), `record "c" was not delivered`)
+	require.EqualError(t, matchDelivered(records, []string{"a", "b"}), `1 unexpected records were delivered, first "a"`)
+}
@@ -0,0 +1,99 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+)
+
+const (
+	// putterRefreshRetryDelay is the delay before a failed refresh is retried, unless
+	// PutterRefreshInterval is shorter
+	putterRefreshRetryDelay = 10 * time.Second
+	// putterRefreshTimeout bounds a refresh, which is not tied to any request
+	putterRefreshTimeout = 30 * time.Second
+)
+
+// PutterFactory builds the Putter PutRecords requests are sent with, e.g. a kinesis.Client
+// with freshly assumed-role credentials or a new endpoint, see Config.PutterFactory.
+type PutterFactory func(ctx context.Context) (Putter, error)
+
+// refreshingPutter is a Putter rebuilt with the PutterFactory every PutterRefreshInterval.
+// The Putter is rebuilt in the background by a single goroutine and the current one is
+// kept until a new one is built, so a slow or failing factory does not hold back or fail
+// the requests.
+type refreshingPutter struct {
+	factory  PutterFactory
+	interval time.Duration
+	clock    Clock
+	logger   Logger
+
+	mu        sync.Mutex
+	putter    Putter
+	refreshAt time.Time
+	// refreshing is set while a refresh is in progress
+	refreshing bool
+}
+
+// newRefreshingPutter builds the first Putter with the factory of the config
+func newRefreshingPutter(ctx context.Context, config *Config) (*refreshingPutter, error) {
+	r := &refreshingPutter{
+		factory:  config.PutterFactory,
+		interval: config.PutterRefreshInterval,
+		clock:    config.Clock,
+		logger:   config.Logger,
+	}
+	putter, err := r.build(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("kinesis: build putter: %w", err)
+	}
+	r.putter = putter
+	r.refreshAt = r.clock.Now().Add(r.interval)
+	return r, nil
+}
+
+// PutRecords sends the request with the current Putter, and starts rebuilding it if it is
+// due for refresh
+func (r *refreshingPutter) PutRecords(ctx context.Context, params *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+	return r.get().PutRecords(ctx, params, optFns...)
+}
+
+func (r *refreshingPutter) get() Putter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.interval > 0 && !r.refreshing && !r.clock.Now().Before(r.refreshAt) {
+		r.refreshing = true
+		go r.refresh()
+	}
+	return r.putter
+}
+
+// refresh rebuilds the Putter, outside of the lock so that the requests are not held back
+func (r *refreshingPutter) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), putterRefreshTimeout)
+	defer cancel()
+	putter, err := r.build(ctx)
+	now := r.clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshing = false
+	if err != nil {
+		r.logger.Error("refreshing putter", err)
+		r.refreshAt = now.Add(min(r.interval, putterRefreshRetryDelay))
+		return
+	}
+	r.putter = putter
+	r.refreshAt = now.Add(r.interval)
+}
+
+func (r *refreshingPutter) build(ctx context.Context) (Putter, error) {
+	putter, err := r.factory(ctx)
+	if err == nil && putter == nil {
+		err = errors.New("nil Putter")
+	}
+	return putter, err
+}
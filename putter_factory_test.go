@@ -0,0 +1,120 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshingPutter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		builds   int
+		attempts int
+		fail     bool
+		used     []int
+		// release lets the refresh complete
+		release = make(chan struct{}, 1)
+	)
+	factory := func(ctx context.Context) (Putter, error) {
+		mu.Lock()
+		first := attempts == 0
+		attempts++
+		mu.Unlock()
+		if !first {
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return nil, errors.New("assume role failed")
+		}
+		builds++
+		id := builds
+		return PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			used = append(used, id)
+			return &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}, nil
+		}), nil
+	}
+	refreshed := func(n int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return attempts == n
+		}
+	}
+	r, err := newRefreshingPutter(context.Background(), &Config{
+		PutterFactory:         factory,
+		PutterRefreshInterval: 50 * time.Millisecond,
+		Clock:                 systemClock{},
+		Logger:                &NopLogger{},
+	})
+	require.NoError(t, err)
+	put := func() {
+		_, err := r.PutRecords(context.Background(), &k.PutRecordsInput{})
+		require.NoError(t, err)
+	}
+	idle := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return !r.refreshing
+	}
+
+	put()
+	time.Sleep(50 * time.Millisecond)
+	// the refresh does not hold back the requests, which use the current putter meanwhile
+	put()
+	put()
+	require.Eventually(t, refreshed(2), time.Second, time.Millisecond)
+	release <- struct{}{}
+	require.Eventually(t, idle, time.Second, time.Millisecond)
+	put()
+
+	// the previous putter is kept if the factory fails
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	put()
+	require.Eventually(t, refreshed(3), time.Second, time.Millisecond)
+	release <- struct{}{}
+	require.Eventually(t, idle, time.Second, time.Millisecond)
+	put()
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []int{1, 1, 1, 2, 2, 2}, used)
+	require.Equal(t, 2, builds)
+}
+
+func TestPutterFactory(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p, err := NewProducer(
+		WithStreamName("foo"),
+		WithLogger(&NopLogger{}),
+		WithPutterFactory(func(ctx context.Context) (Putter, error) { return client, nil }, 0),
+	)
+	require.NoError(t, err)
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+	require.Len(t, client.data, 1)
+
+	_, err = NewProducer(
+		WithStreamName("foo"),
+		WithPutterFactory(func(ctx context.Context) (Putter, error) { return nil, errors.New("assume role failed") }, time.Hour),
+	)
+	require.EqualError(t, err, "kinesis: build putter: assume role failed")
+}
@@ -0,0 +1,219 @@
+package producer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// Kinesis per shard write limits
+const (
+	shardRecordsPerSecond = 1000
+	shardBytesPerSecond   = 1 << 20 // 1MiB
+)
+
+// AIMD parameters of the adaptive rate limit of a shard, see Config.AdaptiveRateLimit
+const (
+	// adaptiveDecrease multiplies the rate of a throttled shard, at most once per
+	// adaptiveWindow so that the throttles of a single burst count once
+	adaptiveDecrease = 0.5
+	adaptiveWindow   = time.Second
+	// adaptiveIncrease is the fraction of the limits the rate grows back by per second
+	adaptiveIncrease = 0.05
+	// adaptiveMinFactor is the least fraction of the limits a shard is slowed down to
+	adaptiveMinFactor = 0.1
+)
+
+// tokenBucket is a token bucket that allows tokens to be borrowed against the future.
+// Callers are expected to wait the returned duration before proceeding.
+type tokenBucket struct {
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: rate,
+		tokens:   rate,
+		last:     now,
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller must wait
+// before the tokens are available.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// shardLimiter holds the records and bytes buckets of a single shard
+type shardLimiter struct {
+	records *tokenBucket
+	bytes   *tokenBucket
+	// factor is the fraction of the limits used with AdaptiveRateLimit. It is decreased
+	// when the shard throttles, at decreased, and grows back since adjusted
+	factor              float64
+	decreased, adjusted time.Time
+}
+
+// setFactor scales the buckets to factor of the limits
+func (s *shardLimiter) setFactor(factor, limit float64) {
+	s.factor = factor
+	for _, b := range []struct {
+		bucket *tokenBucket
+		rate   float64
+	}{{s.records, shardRecordsPerSecond}, {s.bytes, shardBytesPerSecond}} {
+		b.bucket.rate = b.rate * limit * factor
+		b.bucket.capacity = b.bucket.rate
+		b.bucket.tokens = min(b.bucket.tokens, b.bucket.capacity)
+	}
+}
+
+// RateLimiter paces PutRecords requests so that records sent to each shard stay below
+// the Kinesis per shard limits of 1000 records/s and 1MiB/s. Shards are resolved using
+// the ShardMap; records that can not be mapped to a shard are not limited.
+type RateLimiter struct {
+	sync.Mutex
+	shardMap *ShardMap
+	// limit is the fraction of the per shard limits that may be used
+	limit  float64
+	shards map[string]*shardLimiter
+	// adaptive slows down throttled shards, see Config.AdaptiveRateLimit
+	adaptive bool
+	// now returns the current time, see Config.Clock
+	now func() time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that keeps `headroom` percent of the per shard
+// limits in reserve.
+func NewRateLimiter(shardMap *ShardMap, headroom int) *RateLimiter {
+	return &RateLimiter{
+		shardMap: shardMap,
+		limit:    float64(100-headroom) / 100,
+		shards:   make(map[string]*shardLimiter),
+		now:      time.Now,
+	}
+}
+
+// Reserve takes the tokens required to send the entries and returns how long the caller
+// must wait before sending them.
+func (l *RateLimiter) Reserve(entries []types.PutRecordsRequestEntry) time.Duration {
+	type usage struct {
+		records int
+		bytes   int
+	}
+	perShard := make(map[string]*usage)
+	for _, entry := range entries {
+		key, ok := l.shardMap.ShardKey(entry)
+		if !ok {
+			continue
+		}
+		u, ok := perShard[key]
+		if !ok {
+			u = &usage{}
+			perShard[key] = u
+		}
+		u.records++
+		u.bytes += len(entry.Data) + len(aws.ToString(entry.PartitionKey))
+	}
+	if len(perShard) == 0 {
+		return 0
+	}
+
+	var (
+		now   = l.now()
+		delay time.Duration
+	)
+	l.Lock()
+	defer l.Unlock()
+	for key, u := range perShard {
+		shard := l.shard(key, now)
+		if d := shard.records.reserve(float64(u.records), now); d > delay {
+			delay = d
+		}
+		if d := shard.bytes.reserve(float64(u.bytes), now); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// Throttled slows down the shards of the entries rejected for exceeding their
+// throughput, if the RateLimiter is adaptive. The rate of each shard is halved, at most
+// once a second, and grows back by 5% of the limits per second, down to 10% of them.
+func (l *RateLimiter) Throttled(entries []types.PutRecordsRequestEntry) {
+	if !l.adaptive {
+		return
+	}
+	keys := make(map[string]struct{})
+	for _, entry := range entries {
+		if key, ok := l.shardMap.ShardKey(entry); ok {
+			keys[key] = struct{}{}
+		}
+	}
+	now := l.now()
+	l.Lock()
+	defer l.Unlock()
+	for key := range keys {
+		shard := l.shard(key, now)
+		if now.Sub(shard.decreased) < adaptiveWindow {
+			continue
+		}
+		shard.decreased = now
+		shard.setFactor(max(shard.factor*adaptiveDecrease, adaptiveMinFactor), l.limit)
+	}
+}
+
+// shard returns the limiter of the shard with the key, creating it if needed. The rate
+// of an adaptive limiter grows back since the last call. Callers must hold the lock.
+func (l *RateLimiter) shard(key string, now time.Time) *shardLimiter {
+	shard, ok := l.shards[key]
+	if !ok {
+		shard = &shardLimiter{
+			records:  newTokenBucket(shardRecordsPerSecond*l.limit, now),
+			bytes:    newTokenBucket(shardBytesPerSecond*l.limit, now),
+			factor:   1,
+			adjusted: now,
+		}
+		l.shards[key] = shard
+		return shard
+	}
+	if l.adaptive && shard.factor < 1 {
+		growth := now.Sub(shard.adjusted).Seconds() * adaptiveIncrease
+		// the buckets are refilled at the old rate first
+		shard.records.reserve(0, now)
+		shard.bytes.reserve(0, now)
+		shard.setFactor(min(shard.factor+growth, 1), l.limit)
+	}
+	shard.adjusted = now
+	return shard
+}
+
+// SetHeadroom changes the percent of the per shard limits kept in reserve. The usage of
+// the shards is reset.
+func (l *RateLimiter) SetHeadroom(headroom int) {
+	l.Lock()
+	l.limit = float64(100-headroom) / 100
+	l.shards = make(map[string]*shardLimiter)
+	l.Unlock()
+}
+
+// Reset drops the state of all shards. Called after the shard map has been updated.
+func (l *RateLimiter) Reset() {
+	l.Lock()
+	l.shards = make(map[string]*shardLimiter)
+	l.Unlock()
+}
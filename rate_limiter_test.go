@@ -0,0 +1,138 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func mockEntries(count int, explicitHashKey string, size int) []types.PutRecordsRequestEntry {
+	out := make([]types.PutRecordsRequestEntry, count)
+	for i := range out {
+		out[i] = types.PutRecordsRequestEntry{
+			Data:            make([]byte, size),
+			PartitionKey:    aws.String("a"),
+			ExplicitHashKey: aws.String(explicitHashKey),
+		}
+	}
+	return out
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	shards, _, _ := StaticGetShardsFunc(2)(nil)
+	first := *shards[0].HashKeyRange.StartingHashKey
+	second := *shards[1].HashKeyRange.StartingHashKey
+
+	testCases := []struct {
+		name     string
+		shards   []types.Shard
+		headroom int
+		requests [][]types.PutRecordsRequestEntry
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:     "does not limit without shards",
+			requests: [][]types.PutRecordsRequestEntry{mockEntries(500, first, 1), mockEntries(500, first, 1), mockEntries(500, first, 1)},
+		},
+		{
+			name:     "does not delay within the records limit",
+			shards:   shards,
+			requests: [][]types.PutRecordsRequestEntry{mockEntries(500, first, 1), mockEntries(500, first, 1)},
+		},
+		{
+			name:     "delays when exceeding the records limit",
+			shards:   shards,
+			requests: [][]types.PutRecordsRequestEntry{mockEntries(500, first, 1), mockEntries(500, first, 1), mockEntries(500, first, 1)},
+			min:      400 * time.Millisecond,
+			max:      500 * time.Millisecond,
+		},
+		{
+			name:     "limits shards independently",
+			shards:   shards,
+			requests: [][]types.PutRecordsRequestEntry{mockEntries(500, first, 1), mockEntries(500, first, 1), mockEntries(500, second, 1)},
+		},
+		{
+			name:     "delays when exceeding the bytes limit",
+			shards:   shards,
+			requests: [][]types.PutRecordsRequestEntry{mockEntries(2, first, 1<<20)},
+			min:      900 * time.Millisecond,
+			max:      time.Second + 10*time.Millisecond,
+		},
+		{
+			name:     "keeps headroom in reserve",
+			shards:   shards,
+			headroom: 50,
+			requests: [][]types.PutRecordsRequestEntry{mockEntries(500, first, 1), mockEntries(250, first, 1)},
+			min:      400 * time.Millisecond,
+			max:      500 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := NewRateLimiter(NewShardMap(tc.shards, 1), tc.headroom)
+			var delay time.Duration
+			for _, request := range tc.requests {
+				delay = limiter.Reserve(request)
+			}
+			require.True(t, delay >= tc.min, "expected %v >= %v", delay, tc.min)
+			require.True(t, delay <= tc.max, "expected %v <= %v", delay, tc.max)
+		})
+	}
+}
+
+func TestRateLimiterAdaptive(t *testing.T) {
+	shards, _, _ := StaticGetShardsFunc(2)(nil)
+	first := *shards[0].HashKeyRange.StartingHashKey
+	second := *shards[1].HashKeyRange.StartingHashKey
+	now := time.Now()
+	limiter := NewRateLimiter(NewShardMap(shards, 1), 0)
+	limiter.now = func() time.Time { return now }
+	limiter.adaptive = true
+	factor := func(key string) float64 {
+		limiter.Lock()
+		defer limiter.Unlock()
+		return limiter.shard(key, now).factor
+	}
+
+	require.Zero(t, limiter.Reserve(mockEntries(500, first, 1)))
+	limiter.Throttled(mockEntries(1, first, 1))
+	require.Equal(t, 0.5, factor(first))
+	require.Equal(t, 1.0, factor(second), "other shards should not be slowed down")
+
+	// throttles of the same burst count once
+	limiter.Throttled(mockEntries(1, first, 1))
+	require.Equal(t, 0.5, factor(first))
+
+	// the rate grew back for a second before it is halved again, and the burst of the
+	// shard shrinks with it
+	now = now.Add(time.Second)
+	limiter.Throttled(mockEntries(1, first, 1))
+	require.InDelta(t, 0.275, factor(first), 1e-9)
+	require.Greater(t, limiter.Reserve(mockEntries(500, first, 1)), 500*time.Millisecond)
+
+	// the rate grows back gradually
+	now = now.Add(10 * time.Second)
+	require.InDelta(t, 0.775, factor(first), 1e-9)
+	now = now.Add(time.Minute)
+	require.Equal(t, 1.0, factor(first))
+
+	// the rate is not slowed down below the minimum
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		limiter.Throttled(mockEntries(1, first, 1))
+	}
+	require.InDelta(t, adaptiveMinFactor, factor(first), 1e-9)
+}
+
+func TestRateLimiterNotAdaptive(t *testing.T) {
+	shards, _, _ := StaticGetShardsFunc(1)(nil)
+	first := *shards[0].HashKeyRange.StartingHashKey
+	limiter := NewRateLimiter(NewShardMap(shards, 1), 0)
+	limiter.Throttled(mockEntries(1, first, 1))
+	require.Zero(t, limiter.Reserve(mockEntries(1000, first, 1)))
+}
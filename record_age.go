@@ -0,0 +1,69 @@
+package producer
+
+import "time"
+
+// stamp records the time the user record was put, used with MaxRecordAge, BufferingMetrics
+// and StageMetrics. Records created by the Producer from a user record use the time of the
+// user record, and records that are put again, e.g. from the spill queue, keep their time.
+func (p *Producer) stamp(userRecord UserRecord) UserRecord {
+	_, buffering := p.Metrics.(BufferingMetrics)
+	_, stages := p.Metrics.(StageMetrics)
+	if !buffering && !stages && p.MaxRecordAge <= 0 {
+		return userRecord
+	}
+	switch r := userRecord.(type) {
+	case *trackedRecord:
+		if !r.putAt.IsZero() {
+			return r
+		}
+	case *chunkRecord, *claimCheckRecord, *compressedRecord, *encryptedRecord:
+		return userRecord
+	}
+	tracked := track(userRecord)
+	tracked.putAt = p.Clock.Now()
+	return tracked
+}
+
+// recordsBuffered reports the time the user records of work were buffered until now to the
+// Metrics, if they implement BufferingMetrics
+func (wp *WorkerPool) recordsBuffered(work *Work, now time.Time) {
+	m, ok := wp.Metrics.(BufferingMetrics)
+	if !ok {
+		return
+	}
+	for _, record := range work.records {
+		for _, userRecord := range record.UserRecords {
+			if putAt := putTime(userRecord); !putAt.IsZero() {
+				m.UserRecordBuffered(now.Sub(putAt))
+			}
+		}
+	}
+}
+
+// recordAggregated reports the time between the Put of the oldest user record of record and
+// now to the Metrics, if they implement StageMetrics
+func (wp *WorkerPool) recordAggregated(record *AggregatedRecordRequest) {
+	m, ok := wp.Metrics.(StageMetrics)
+	if !ok {
+		return
+	}
+	now := wp.Clock.Now()
+	var oldest time.Time
+	for _, userRecord := range record.UserRecords {
+		if putAt := putTime(userRecord); !putAt.IsZero() && (oldest.IsZero() || putAt.Before(oldest)) {
+			oldest = putAt
+		}
+	}
+	if !oldest.IsZero() {
+		m.AggregationDelay(now.Sub(oldest))
+	}
+}
+
+// expired reports whether the user record was put longer than MaxRecordAge before now
+func (wp *WorkerPool) expired(userRecord UserRecord, now time.Time) bool {
+	if wp.MaxRecordAge <= 0 {
+		return false
+	}
+	putAt := putTime(userRecord)
+	return !putAt.IsZero() && now.Sub(putAt) > wp.MaxRecordAge
+}
@@ -0,0 +1,154 @@
+package producer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/achunariov/kinesis-producer/deaggregation"
+)
+
+// ReplayConfig configures Producer.Replay
+type ReplayConfig struct {
+	// Stream the records are put to. Default to the empty string, the stream recorded with
+	// each record, or the stream selected by Config.StreamRouter if it has none.
+	Stream string
+
+	// Ordered flushes and waits for each record to be delivered before the next one is
+	// put, so that retries do not reorder them. Default to false, records are put in their
+	// original order but may be reordered by retries unless Config.OrderedDelivery is set.
+	Ordered bool
+}
+
+// replayRecord is a line of a file written by FilePutter or FileDeadLetter
+type replayRecord struct {
+	// FileRecord fields
+	Stream          string `json:"stream"`
+	PartitionKey    string `json:"partition_key"`
+	ExplicitHashKey string `json:"explicit_hash_key"`
+	// DeadLetterRecord fields
+	StreamName                string `json:"streamName"`
+	DeadLetterPartitionKey    string `json:"partitionKey"`
+	DeadLetterExplicitHashKey string `json:"explicitHashKey"`
+
+	Data []byte `json:"data"`
+}
+
+// userRecords returns the user records of the line. Aggregated records captured by a
+// FilePutter are deaggregated
+func (r *replayRecord) userRecords() ([]UserRecord, error) {
+	partitionKey, explicitHashKey := r.PartitionKey, r.ExplicitHashKey
+	if partitionKey == "" {
+		partitionKey, explicitHashKey = r.DeadLetterPartitionKey, r.DeadLetterExplicitHashKey
+	}
+	records, err := deaggregation.Deaggregate(r.Data, partitionKey)
+	if err != nil {
+		return nil, err
+	}
+	if !deaggregation.IsAggregatedRecord(r.Data) {
+		records[0].ExplicitHashKey = explicitHashKey
+	}
+	userRecords := make([]UserRecord, len(records))
+	for i, record := range records {
+		if record.ExplicitHashKey == "" {
+			userRecords[i] = NewDataRecord(record.Data, record.PartitionKey)
+			continue
+		}
+		hk, ok := new(big.Int).SetString(record.ExplicitHashKey, 10)
+		if !ok {
+			return nil, &ErrIllegalExplicitHashKey{
+				UserRecord:      NewDataRecord(record.Data, record.PartitionKey),
+				ExplicitHashKey: record.ExplicitHashKey,
+			}
+		}
+		userRecords[i] = NewDataRecordWithExplicitHashKey(record.Data, record.PartitionKey, hk)
+	}
+	return userRecords, nil
+}
+
+// Replay reads the newline delimited JSON records captured by a FilePutter or written by a
+// FileDeadLetter from r, and puts them in order with their partition and explicit hash
+// keys. It returns the number of user records put, and stops at the first line that can
+// not be decoded or record that can not be put, or that fails to be delivered if Ordered
+// is set.
+//
+// Records are replayed as they were captured, so the producer should not apply
+// Interceptors, Envelope or Compression to them again.
+func (p *Producer) Replay(ctx context.Context, r io.Reader, config ReplayConfig) (int, error) {
+	var (
+		reader   = bufio.NewReader(r)
+		replayed int
+	)
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return replayed, err
+		}
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			var record replayRecord
+			if err := json.Unmarshal(b, &record); err != nil {
+				return replayed, fmt.Errorf("kinesis: replay line %d: %w", line, err)
+			}
+			userRecords, rerr := record.userRecords()
+			if rerr != nil {
+				return replayed, fmt.Errorf("kinesis: replay line %d: %w", line, rerr)
+			}
+			stream := config.Stream
+			if stream == "" {
+				stream = record.Stream
+			}
+			if stream == "" {
+				stream = record.StreamName
+			}
+			for _, userRecord := range userRecords {
+				if err := p.replayRecord(ctx, stream, userRecord, config.Ordered); err != nil {
+					return replayed, err
+				}
+				replayed++
+			}
+		}
+		if err == io.EOF {
+			return replayed, nil
+		}
+	}
+}
+
+// replayRecord puts the user record to stream, and waits for it to be delivered if
+// ordered is set
+func (p *Producer) replayRecord(ctx context.Context, stream string, userRecord UserRecord, ordered bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var future *PutFuture
+	if ordered {
+		tracked := track(userRecord)
+		tracked.future = newPutFuture()
+		userRecord, future = tracked, tracked.future
+	}
+	var err error
+	if stream == "" {
+		err = p.PutUserRecord(userRecord)
+	} else {
+		err = p.PutUserRecordToStream(stream, userRecord)
+	}
+	if isRejected(err) {
+		return err
+	}
+	if future == nil {
+		return nil
+	}
+	// send the record now rather than at the next flush interval
+	if err := p.Flush(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-future.Done():
+		return future.Result().Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newDryRunProducer(dir string) *Producer {
+	return New(&Config{
+		StreamName:    "foo",
+		FlushInterval: time.Hour,
+		Logger:        &NopLogger{},
+		DryRun:        &DryRunConfig{Dir: dir, Deaggregate: true},
+	})
+}
+
+func TestReplayCapture(t *testing.T) {
+	// capture aggregated records
+	captureDir := t.TempDir()
+	p := New(&Config{
+		StreamName:    "foo",
+		FlushInterval: time.Hour,
+		Logger:        &NopLogger{},
+		DryRun:        &DryRunConfig{Dir: captureDir},
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "a"))
+	require.NoError(t, p.Put([]byte("world"), "b"))
+	p.Stop()
+	capture, err := os.ReadFile(filepath.Join(captureDir, "foo.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, readFileRecords(t, filepath.Join(captureDir, "foo.jsonl")), 1)
+
+	dir := t.TempDir()
+	p = newDryRunProducer(dir)
+	p.Start()
+	n, err := p.Replay(context.Background(), bytes.NewReader(capture), ReplayConfig{})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	p.Stop()
+
+	records := readFileRecords(t, filepath.Join(dir, "foo.jsonl"))
+	require.Len(t, records, 2)
+	require.Equal(t, "a", records[0].PartitionKey)
+	require.Equal(t, []byte("hello"), records[0].Data)
+	require.Equal(t, "b", records[1].PartitionKey)
+	require.Equal(t, []byte("world"), records[1].Data)
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(DeadLetterRecord{StreamName: "foo", PartitionKey: "a", ExplicitHashKey: "7", Data: []byte("hello")}))
+	buf.WriteString("\n")
+	require.NoError(t, enc.Encode(DeadLetterRecord{StreamName: "foo", PartitionKey: "b", Data: []byte("world")}))
+
+	dir := t.TempDir()
+	p := newDryRunProducer(dir)
+	p.Start()
+	n, err := p.Replay(context.Background(), &buf, ReplayConfig{Stream: "bar", Ordered: true})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	p.Stop()
+
+	records := readFileRecords(t, filepath.Join(dir, "bar.jsonl"))
+	require.Len(t, records, 2)
+	require.Equal(t, "a", records[0].PartitionKey)
+	require.Equal(t, "7", records[0].ExplicitHashKey)
+	require.Equal(t, []byte("hello"), records[0].Data)
+	require.Equal(t, "b", records[1].PartitionKey)
+	// each record was sent in its own request
+	require.NotEqual(t, records[0].SequenceNumber, records[1].SequenceNumber)
+}
+
+func TestReplayInvalidLine(t *testing.T) {
+	p := newDryRunProducer(t.TempDir())
+	p.Start()
+	defer p.Stop()
+	n, err := p.Replay(context.Background(), strings.NewReader(`{"partitionKey":"a","data":"aGVsbG8="}`+"\nnot json\n"), ReplayConfig{})
+	require.Equal(t, 1, n)
+	require.ErrorContains(t, err, "kinesis: replay line 2")
+}
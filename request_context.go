@@ -0,0 +1,102 @@
+package producer
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// requestContext is the context of a PutRecords request, derived from the contexts its
+// user records were put with:
+//
+//   - if every user record was put with the same context, its values, like trace spans or
+//     request IDs, are passed to the request and its optFns
+//   - if every user record was put with a context that can be canceled, the request is
+//     canceled once they are all done, so it is not abandoned while a caller still waits
+//     for one of its records. Its deadline is the latest of theirs.
+//
+// It is always canceled when the pool is aborted.
+type requestContext struct {
+	context.Context
+	// puts are the distinct Put contexts of the records. Nil if one of them can not be
+	// canceled
+	puts   []context.Context
+	stops  []func() bool
+	cancel context.CancelFunc
+}
+
+func newRequestContext(pool context.Context, work *Work) *requestContext {
+	var (
+		seen       = make(map[context.Context]struct{})
+		puts       []context.Context
+		cancelable = true
+		deadlines  = true
+		deadline   time.Time
+	)
+	for _, record := range work.records {
+		for _, userRecord := range record.UserRecords {
+			ctx := putContext(userRecord)
+			if _, ok := seen[ctx]; ok {
+				continue
+			}
+			seen[ctx] = struct{}{}
+			puts = append(puts, ctx)
+			cancelable = cancelable && ctx.Done() != nil
+			d, ok := ctx.Deadline()
+			deadlines = deadlines && ok
+			if d.After(deadline) {
+				deadline = d
+			}
+		}
+	}
+
+	parent := context.Background()
+	if len(puts) == 1 {
+		parent = context.WithoutCancel(puts[0])
+	}
+	ctx, cancel := context.WithCancel(parent)
+	r := &requestContext{Context: ctx, cancel: cancel}
+	r.stops = append(r.stops, context.AfterFunc(pool, cancel))
+	if !cancelable || len(puts) == 0 {
+		return r
+	}
+	r.puts = puts
+	remaining := int32(len(puts))
+	for _, put := range puts {
+		r.stops = append(r.stops, context.AfterFunc(put, func() {
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				cancel()
+			}
+		}))
+	}
+	if deadlines {
+		var cancelDeadline context.CancelFunc
+		r.Context, cancelDeadline = context.WithDeadline(ctx, deadline)
+		r.cancel = func() {
+			cancelDeadline()
+			cancel()
+		}
+	}
+	return r
+}
+
+// canceled reports whether every Put context of the request is done
+func (r *requestContext) canceled() bool {
+	if len(r.puts) == 0 {
+		return false
+	}
+	for _, put := range r.puts {
+		if put.Err() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// stop releases the resources of the context once the request is done
+func (r *requestContext) stop() {
+	for _, stop := range r.stops {
+		stop()
+	}
+	r.cancel()
+}
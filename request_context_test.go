@@ -0,0 +1,133 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+type requestIDKey struct{}
+
+func TestRequestContext(t *testing.T) {
+	newWork := func(contexts ...context.Context) *Work {
+		record := &AggregatedRecordRequest{}
+		for _, ctx := range contexts {
+			tracked := track(NewDataRecord([]byte("hello"), "foo"))
+			tracked.ctx = ctx
+			record.UserRecords = append(record.UserRecords, tracked)
+		}
+		return &Work{records: []*AggregatedRecordRequest{record}}
+	}
+
+	t.Run("Values", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), requestIDKey{}, "42")
+		r := newRequestContext(context.Background(), newWork(ctx, ctx))
+		defer r.stop()
+		require.Equal(t, "42", r.Value(requestIDKey{}))
+
+		r = newRequestContext(context.Background(), newWork(ctx, context.Background()))
+		defer r.stop()
+		require.Nil(t, r.Value(requestIDKey{}))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		r := newRequestContext(context.Background(), newWork(ctx1, ctx2))
+		defer r.stop()
+		cancel1()
+		require.NoError(t, r.Err())
+		require.False(t, r.canceled())
+		cancel2()
+		<-r.Done()
+		require.True(t, r.canceled())
+	})
+
+	t.Run("NotCancelable", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := newRequestContext(context.Background(), newWork(ctx, context.Background()))
+		defer r.stop()
+		cancel()
+		require.NoError(t, r.Err())
+		require.False(t, r.canceled())
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		now := time.Now()
+		ctx1, cancel1 := context.WithDeadline(context.Background(), now.Add(time.Hour))
+		defer cancel1()
+		ctx2, cancel2 := context.WithDeadline(context.Background(), now.Add(2*time.Hour))
+		defer cancel2()
+		r := newRequestContext(context.Background(), newWork(ctx1, ctx2))
+		defer r.stop()
+		deadline, ok := r.Deadline()
+		require.True(t, ok)
+		require.Equal(t, now.Add(2*time.Hour).Round(0), deadline.Round(0))
+	})
+
+	t.Run("Pool", func(t *testing.T) {
+		pool, cancel := context.WithCancel(context.Background())
+		r := newRequestContext(pool, newWork(context.Background()))
+		defer r.stop()
+		cancel()
+		<-r.Done()
+		require.False(t, r.canceled())
+	})
+}
+
+func TestPutWithContextRequest(t *testing.T) {
+	var (
+		values   = make(chan any, 1)
+		canceled = make(chan struct{})
+	)
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		values <- ctx.Value(requestIDKey{})
+		if aws.ToString(input.Records[0].PartitionKey) == "slow" {
+			// block until the caller gives up
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		return &k.PutRecordsOutput{
+			FailedRecordCount: aws.Int32(0),
+			Records:           []types.PutRecordsResultEntry{{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("1")}},
+		}, nil
+	})
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	failures := p.NotifyFailures()
+	p.Start()
+	defer p.Stop()
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "42")
+	require.NoError(t, p.PutWithContext(ctx, []byte("hello"), "foo"))
+	require.NoError(t, p.Flush(context.Background()))
+	require.Equal(t, "42", <-values)
+
+	ctx, cancel := context.WithCancel(ctx)
+	require.NoError(t, p.PutWithContext(ctx, []byte("hello"), "slow"))
+	flushed := make(chan error)
+	go func() {
+		flushed <- p.Flush(context.Background())
+	}()
+	require.Equal(t, "42", <-values)
+	cancel()
+	<-canceled
+
+	var canceledErr *ErrRecordCanceled
+	require.ErrorAs(t, <-failures, &canceledErr)
+	require.Equal(t, "slow", canceledErr.PartitionKey())
+	require.NoError(t, <-flushed)
+	require.Equal(t, int64(1), p.Stats().Drops)
+	require.Equal(t, int64(0), p.Stats().Retries)
+}
@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+type stageMetricsMock struct {
+	NopMetrics
+	mu           sync.Mutex
+	aggregations []time.Duration
+	requests     []time.Duration
+}
+
+func (m *stageMetricsMock) AggregationDelay(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aggregations = append(m.aggregations, d)
+}
+
+func (m *stageMetricsMock) RequestDelay(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, d)
+}
+
+func TestStageDelayConfig(t *testing.T) {
+	c := &Config{StreamName: "foo", Client: &clientMock{}, AggregationMaxDelay: time.Second}
+	c.defaults()
+	require.Equal(t, time.Second, c.FlushInterval)
+	require.NoError(t, c.validate())
+
+	c.RequestMaxDelay = -time.Second
+	require.EqualError(t, c.validate(), "kinesis: RequestMaxDelay must not be negative")
+	c.RequestMaxDelay, c.AggregationMaxDelay = 0, -time.Second
+	require.EqualError(t, c.validate(), "kinesis: AggregationMaxDelay must not be negative")
+}
+
+func TestRequestMaxDelay(t *testing.T) {
+	sent := make(chan []string, 10)
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		var keys []string
+		out := &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+		for _, r := range input.Records {
+			keys = append(keys, aws.ToString(r.PartitionKey))
+			out.Records = append(out.Records, types.PutRecordsResultEntry{ShardId: aws.String("shardId-0")})
+		}
+		sent <- keys
+		return out, nil
+	})
+	metrics := &stageMetricsMock{}
+	p := New(&Config{
+		StreamName:          "foo",
+		MaxConnections:      1,
+		AggregationMaxDelay: 10 * time.Millisecond,
+		RequestMaxDelay:     300 * time.Millisecond,
+		Logger:              &NopLogger{},
+		Client:              client,
+		Metrics:             metrics,
+	})
+	p.Start()
+	defer p.Stop()
+
+	start := time.Now()
+	require.NoError(t, p.Put([]byte("hello"), "a", WithoutAggregation()))
+	// the record is drained at the next flush, but waits for a fuller request
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, p.Put([]byte("world"), "b", WithoutAggregation()))
+	select {
+	case keys := <-sent:
+		require.Equal(t, []string{"a", "b"}, keys)
+		require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch to be sent after RequestMaxDelay")
+	}
+
+	// an explicit flush sends the batches right away
+	require.NoError(t, p.Put([]byte("hello"), "c", WithoutAggregation()))
+	start = time.Now()
+	require.NoError(t, p.Flush(context.Background()))
+	require.Less(t, time.Since(start), 300*time.Millisecond)
+	require.Equal(t, []string{"c"}, <-sent)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	require.Len(t, metrics.aggregations, 3)
+	require.Len(t, metrics.requests, 2)
+	require.GreaterOrEqual(t, metrics.requests[0], 300*time.Millisecond)
+}
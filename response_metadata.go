@@ -0,0 +1,70 @@
+package producer
+
+import (
+	"errors"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ResponseMetadata identifies the response to a PutRecords request, to quote in AWS
+// support cases. Fields are empty when the request did not reach AWS or the Putter is not
+// an SDK client.
+type ResponseMetadata struct {
+	// RequestID is the AWS request id, from the x-amzn-RequestId header
+	RequestID string `json:"requestId,omitempty"`
+	// ExtendedRequestID is the extended AWS request id, from the x-amz-id-2 header
+	ExtendedRequestID string `json:"extendedRequestId,omitempty"`
+	// StatusCode is the HTTP status code of the response
+	StatusCode int `json:"statusCode,omitempty"`
+}
+
+// logValues returns the log values of the fields that are set
+func (m ResponseMetadata) logValues() []LogValue {
+	var values []LogValue
+	if m.RequestID != "" {
+		values = append(values, LogValue{"request_id", m.RequestID})
+	}
+	if m.ExtendedRequestID != "" {
+		values = append(values, LogValue{"extended_request_id", m.ExtendedRequestID})
+	}
+	if m.StatusCode != 0 {
+		values = append(values, LogValue{"status_code", m.StatusCode})
+	}
+	return values
+}
+
+// responseMetadata returns the metadata of a PutRecords response from its output, or from
+// its error if it failed
+func responseMetadata(out *k.PutRecordsOutput, err error) ResponseMetadata {
+	var m ResponseMetadata
+	if out != nil {
+		m.RequestID, _ = awsmiddleware.GetRequestIDMetadata(out.ResultMetadata)
+		if resp, ok := awsmiddleware.GetRawResponse(out.ResultMetadata).(*smithyhttp.Response); ok {
+			m.setResponse(resp)
+		}
+		return m
+	}
+	var reqErr *awshttp.ResponseError
+	if errors.As(err, &reqErr) {
+		m.RequestID = reqErr.ServiceRequestID()
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		m.setResponse(respErr.HTTPResponse())
+	}
+	return m
+}
+
+func (m *ResponseMetadata) setResponse(resp *smithyhttp.Response) {
+	if resp == nil || resp.Response == nil {
+		return
+	}
+	m.StatusCode = resp.StatusCode
+	m.ExtendedRequestID = resp.Header.Get("X-Amz-Id-2")
+	if m.RequestID == "" {
+		m.RequestID = resp.Header.Get("X-Amzn-Requestid")
+	}
+}
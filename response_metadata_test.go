@@ -0,0 +1,73 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseMetadata(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+		hooks []ResponseMetadata
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Header().Set("X-Amzn-Requestid", fmt.Sprintf("req-%d", calls))
+		w.Header().Set("X-Amz-Id-2", fmt.Sprintf("ext-%d", calls))
+		if calls == 1 {
+			w.Write([]byte(`{"FailedRecordCount":1,"Records":[{"ErrorCode":"InternalFailure","ErrorMessage":"boom"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"__type":"ServiceUnavailable","message":"down"}`))
+	}))
+	defer server.Close()
+
+	p, err := NewLocalProducer(server.URL, "foo",
+		WithLogger(&NopLogger{}),
+		WithMaxRetries(1),
+		WithBackoff(&FixedBackoff{}),
+		WithClientOptions(func(o *k.Options) { o.RetryMaxAttempts = 1 }),
+		WithMiddlewares(Hook(nil, func(ctx context.Context, call PutRecordsCall) {
+			mu.Lock()
+			defer mu.Unlock()
+			hooks = append(hooks, call.Metadata)
+		})),
+	)
+	require.NoError(t, err)
+	failures := p.NotifyFailures()
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	var got []*FailureRecord
+	for err := range failures {
+		got = append(got, err.(*FailureRecord))
+	}
+	expected := []ResponseMetadata{
+		{RequestID: "req-1", ExtendedRequestID: "ext-1", StatusCode: http.StatusOK},
+		{RequestID: "req-2", ExtendedRequestID: "ext-2", StatusCode: http.StatusServiceUnavailable},
+	}
+	require.Equal(t, expected, hooks)
+	require.Len(t, got, 1)
+	require.Len(t, got[0].History, 2)
+	for i, attempt := range got[0].History {
+		require.Equal(t, expected[i], attempt.ResponseMetadata)
+	}
+}
+
+func TestResponseMetadataLogValues(t *testing.T) {
+	require.Empty(t, ResponseMetadata{}.logValues())
+	require.Equal(t, []LogValue{{"request_id", "req-1"}, {"status_code", 200}}, ResponseMetadata{RequestID: "req-1", StatusCode: 200}.logValues())
+}
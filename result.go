@@ -0,0 +1,78 @@
+package producer
+
+import "sync"
+
+// PutResult is the delivery outcome of a single user record.
+type PutResult struct {
+	// ShardId of the shard the record was written to. Empty if Err is set.
+	ShardId string
+	// SequenceNumber assigned by Kinesis. Aggregated user records share the sequence
+	// number of the aggregated record they were sent in. Empty if Err is set.
+	SequenceNumber string
+	// Err is the final error if the record could not be delivered
+	Err error
+}
+
+// PutFuture is returned by Producer.PutWithResult and is resolved once the user record
+// has been flushed to Kinesis or has failed permanently.
+type PutFuture struct {
+	once   sync.Once
+	done   chan struct{}
+	result PutResult
+}
+
+func newPutFuture() *PutFuture {
+	return &PutFuture{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once the result is available.
+func (f *PutFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result blocks until the record is delivered or failed and returns the outcome.
+func (f *PutFuture) Result() PutResult {
+	<-f.done
+	return f.result
+}
+
+// resolve sets the result of the future. Only the first call has an effect.
+func (f *PutFuture) resolve(result PutResult) {
+	f.once.Do(func() {
+		f.result = result
+		close(f.done)
+	})
+}
+
+// resolveUserRecords resolves the futures of any user records put with PutWithResult
+func resolveUserRecords(userRecords []UserRecord, result PutResult) {
+	for _, userRecord := range userRecords {
+		switch r := userRecord.(type) {
+		case *trackedRecord:
+			if r.future != nil {
+				r.future.resolve(result)
+			}
+			// the records that failed stay in the WriteAheadLog to be put again
+			if r.ack != nil && result.Err == nil {
+				r.ack()
+			}
+			if r.report != nil {
+				r.report(result)
+			}
+			if r.forget != nil && result.Err != nil {
+				r.forget()
+			}
+			if r.barrier != nil {
+				r.barrier.leave(r)
+			}
+		case *chunkRecord:
+			r.group.resolve(result)
+		case *claimCheckRecord:
+			resolveUserRecords([]UserRecord{r.UserRecord}, result)
+		case *compressedRecord:
+			resolveUserRecords([]UserRecord{r.UserRecord}, result)
+		case *encryptedRecord:
+			resolveUserRecords([]UserRecord{r.UserRecord}, result)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package producer
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// Router returns the partition key and stream of the raw data of a record put with
+// PutData, e.g. from the tenant id of a JSON payload, so that the selection logic lives in
+// the producer rather than at every call site. An empty partition key is replaced by a
+// random one if Config.AutoPartitionKey is set. An empty stream puts the record to the
+// stream selected by Config.StreamRouter, or Config.StreamName.
+type Router func(data []byte) (partitionKey string, stream string, err error)
+
+// NewCachedRouter returns a Router that extracts a key from the data of each record with
+// key, e.g. the tenant id of a JSON payload, and caches the partition key and stream
+// returned by route for the size most recently used keys, e.g. to look up the stream of a
+// tenant once rather than for every record. Errors are not cached. It is safe for
+// concurrent use.
+func NewCachedRouter(key func(data []byte) (string, error), route func(key string) (partitionKey string, stream string, err error), size int) Router {
+	r := &cachedRouter{
+		key:     key,
+		route:   route,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+	return r.Route
+}
+
+type cachedRouter struct {
+	key   func(data []byte) (string, error)
+	route func(key string) (string, string, error)
+	size  int
+
+	mu sync.Mutex
+	// lru holds the cached routeEntry, most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type routeEntry struct {
+	key          string
+	partitionKey string
+	stream       string
+}
+
+func (r *cachedRouter) Route(data []byte) (string, string, error) {
+	key, err := r.key(data)
+	if err != nil {
+		return "", "", err
+	}
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok {
+		r.lru.MoveToFront(e)
+		entry := e.Value.(*routeEntry)
+		r.mu.Unlock()
+		return entry.partitionKey, entry.stream, nil
+	}
+	r.mu.Unlock()
+
+	// route outside the lock. Concurrent misses of the same key add it once
+	partitionKey, stream, err := r.route(key)
+	if err != nil || r.size <= 0 {
+		return partitionKey, stream, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[key]; !ok {
+		r.entries[key] = r.lru.PushFront(&routeEntry{key, partitionKey, stream})
+		if r.lru.Len() > r.size {
+			oldest := r.lru.Back()
+			r.lru.Remove(oldest)
+			delete(r.entries, oldest.Value.(*routeEntry).key)
+		}
+	}
+	return partitionKey, stream, nil
+}
+
+// PutData puts `data` asynchronously with the partition key and stream returned by
+// Config.Router. If the router fails, *ErrRouting is returned. This method is
+// thread-safe.
+func (p *Producer) PutData(data []byte, opts ...PutOption) error {
+	if p.Router == nil {
+		return errors.New("kinesis: PutData requires Config.Router")
+	}
+	partitionKey, stream, err := p.Router(data)
+	if err != nil {
+		return &ErrRouting{UserRecord: NewDataRecord(data, partitionKey), Err: err}
+	}
+	return p.putUserRecord(stream, p.newDataRecord(data, partitionKey), p.putOptions(p.OverflowPolicy, opts))
+}
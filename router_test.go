@@ -0,0 +1,104 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutData(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	require.EqualError(t, p.PutData([]byte("hello")), "kinesis: PutData requires Config.Router")
+
+	boom := errors.New("boom")
+	p = New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Router: func(data []byte) (string, string, error) {
+			tenant, _, ok := bytes.Cut(data, []byte(":"))
+			if !ok {
+				return "", "", boom
+			}
+			if string(tenant) == "bar" {
+				return "bar", "bar", nil
+			}
+			return string(tenant), "", nil
+		},
+	})
+	p.Start()
+	defer p.Stop()
+
+	err := p.PutData([]byte("hello"))
+	require.IsType(t, &ErrRouting{}, err)
+	require.ErrorIs(t, err, boom)
+	require.Equal(t, []byte("hello"), err.(*ErrRouting).Data())
+
+	require.NoError(t, p.PutData([]byte("foo:hello"), WithoutAggregation()))
+	require.NoError(t, p.PutData([]byte("bar:hello"), WithoutAggregation()))
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.ElementsMatch(t, []string{"foo", "bar"}, client.streams)
+	for i, stream := range client.streams {
+		require.Equal(t, []string{stream}, client.incoming[i])
+	}
+}
+
+func TestCachedRouter(t *testing.T) {
+	var routed []string
+	router := NewCachedRouter(
+		func(data []byte) (string, error) {
+			if len(data) == 0 {
+				return "", errors.New("empty")
+			}
+			return string(data[:1]), nil
+		},
+		func(key string) (string, string, error) {
+			routed = append(routed, key)
+			if key == "x" {
+				return "", "", errors.New("unknown tenant")
+			}
+			return "key-" + key, "stream-" + key, nil
+		},
+		2,
+	)
+
+	for _, data := range []string{"a1", "a2", "b1", "a3", "c1", "b2"} {
+		partitionKey, stream, err := router([]byte(data))
+		require.NoError(t, err)
+		require.Equal(t, "key-"+data[:1], partitionKey)
+		require.Equal(t, "stream-"+data[:1], stream)
+	}
+	// b is evicted by c as the least recently used key
+	require.Equal(t, []string{"a", "b", "c", "b"}, routed)
+
+	_, _, err := router(nil)
+	require.EqualError(t, err, "empty")
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		_, _, err = router([]byte("x"))
+		require.EqualError(t, err, "unknown tenant")
+	}
+	require.Equal(t, []string{"a", "b", "c", "b", "x", "x"}, routed)
+}
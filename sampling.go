@@ -0,0 +1,97 @@
+package producer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// SampleNone is the SamplingConfig.Rate dropping every user record, as a Rate of 0 stands
+// for the default rate of 1
+const SampleNone = -1.0
+
+// SamplingConfig configures the head-based sampling and filtering of user records at Put
+// time, see Config.Sampling. Records are filtered then sampled after Interceptors, before
+// they take a backlog slot. Put returns nil for a dropped record and the future of
+// PutWithResult is resolved with an *ErrRecordDropped. Dropped records are counted in
+// Stats.Filtered and Stats.Sampled.
+type SamplingConfig struct {
+	// Rate is the fraction of user records kept, between 0 and 1, or SampleNone to drop
+	// every record. Default to 1, every record is kept.
+	Rate float64
+
+	// ByPartitionKey samples by the hash of the partition key rather than at random, so
+	// that either every record of a partition key is kept or none, e.g. to keep whole
+	// traces. Default to false.
+	ByPartitionKey bool
+
+	// Filter returns false for the user records to drop. It must be safe for concurrent
+	// use. Default to nil, no record is filtered.
+	Filter func(userRecord UserRecord) bool
+}
+
+func (c *SamplingConfig) defaults() {
+	if c.Rate == 0 {
+		c.Rate = 1
+	}
+}
+
+// sampler drops the user records rejected by SamplingConfig
+type sampler struct {
+	config SamplingConfig
+	stats  *stats
+}
+
+func newSampler(config *Config, stats *stats) *sampler {
+	if config.Sampling == nil {
+		return nil
+	}
+	return &sampler{config: *config.Sampling, stats: stats}
+}
+
+// keep reports whether the user record passes the filter and is sampled
+func (s *sampler) keep(userRecord UserRecord) bool {
+	if s.config.Filter != nil && !s.config.Filter(userRecord) {
+		atomic.AddInt64(&s.stats.filtered, 1)
+		return false
+	}
+	if s.config.Rate >= 1 {
+		return true
+	}
+	var sampled bool
+	if s.config.ByPartitionKey {
+		// the MD5 hash Kinesis maps partition keys with is spread evenly
+		sum := md5.Sum([]byte(userRecord.PartitionKey()))
+		sampled = float64(binary.BigEndian.Uint64(sum[:8])) < s.config.Rate*math.MaxUint64
+	} else {
+		sampled = rand.Float64() < s.config.Rate
+	}
+	if !sampled {
+		atomic.AddInt64(&s.stats.sampled, 1)
+	}
+	return sampled
+}
+
+// sample returns nil if the sampler drops the user record, resolving the future of a
+// record put with PutWithResult with an *ErrRecordDropped
+func (p *Producer) sample(userRecord UserRecord) UserRecord {
+	if p.sampler == nil {
+		return userRecord
+	}
+	tracked, ok := userRecord.(*trackedRecord)
+	if ok {
+		userRecord = tracked.UserRecord
+	}
+	if p.sampler.keep(userRecord) {
+		if ok {
+			return tracked
+		}
+		return userRecord
+	}
+	if ok {
+		resolveUserRecords([]UserRecord{tracked}, PutResult{Err: &ErrRecordDropped{unwrapUserRecord(userRecord)}})
+	}
+	return nil
+}
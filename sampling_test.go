@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler(t *testing.T) {
+	s := newSampler(&Config{Sampling: &SamplingConfig{Rate: 0.5, ByPartitionKey: true}}, &stats{})
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		sampled := s.keep(NewDataRecord([]byte("hello"), key))
+		// records of a partition key are all kept or all dropped
+		require.Equal(t, sampled, s.keep(NewDataRecord([]byte("world"), key)))
+		if sampled {
+			kept++
+		}
+	}
+	require.InDelta(t, 500, kept, 100)
+	require.Equal(t, int64(2*(1000-kept)), s.stats.sampled)
+
+	s = newSampler(&Config{Sampling: &SamplingConfig{Rate: 0.5}}, &stats{})
+	kept = 0
+	for i := 0; i < 1000; i++ {
+		if s.keep(NewDataRecord([]byte("hello"), "foo")) {
+			kept++
+		}
+	}
+	require.InDelta(t, 500, kept, 100)
+
+	// SampleNone drops every record, unlike a Rate of 0 which keeps them all
+	for _, config := range []SamplingConfig{{Rate: SampleNone}, {Rate: SampleNone, ByPartitionKey: true}} {
+		s = newSampler(&Config{Sampling: &config}, &stats{})
+		require.False(t, s.keep(NewDataRecord([]byte("hello"), "foo")))
+	}
+	c := &Config{Sampling: &SamplingConfig{}}
+	c.defaults()
+	require.True(t, newSampler(c, &stats{}).keep(NewDataRecord([]byte("hello"), "foo")))
+
+	c = &Config{StreamName: "foo", Client: &clientMock{}, Sampling: &SamplingConfig{Rate: 2}}
+	require.Equal(t, []error{errors.New("kinesis: Sampling.Rate must be between 0 and 1, or SampleNone")}, c.Validate())
+	c.Sampling.Rate = -0.5
+	require.Len(t, c.Validate(), 1)
+	c.Sampling.Rate = SampleNone
+	require.Empty(t, c.Validate())
+	c.Sampling.Rate = 0
+	require.Empty(t, c.Validate())
+	// the defaults are applied to a copy
+	require.Zero(t, c.Sampling.Rate)
+}
+
+func TestSampling(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		Sampling: &SamplingConfig{
+			Filter: func(userRecord UserRecord) bool {
+				return !strings.HasPrefix(userRecord.PartitionKey(), "debug")
+			},
+		},
+	})
+	p.Start()
+	defer p.Stop()
+
+	require.NoError(t, p.Put([]byte("hello"), "foo", WithoutAggregation()))
+	require.NoError(t, p.Put([]byte("hello"), "debug-1", WithoutAggregation()))
+	future, err := p.PutWithResult([]byte("hello"), "debug-2")
+	require.NoError(t, err)
+	var dropped *ErrRecordDropped
+	require.ErrorAs(t, future.Result().Err, &dropped)
+	require.NoError(t, p.Flush(context.Background()))
+
+	require.Equal(t, []string{"foo"}, client.incoming[0])
+	stats := p.Stats()
+	require.Equal(t, int64(2), stats.Filtered)
+	require.Zero(t, stats.Sampled)
+	require.Equal(t, int64(1), stats.Puts)
+}
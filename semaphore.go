@@ -1,30 +1,195 @@
 package producer
 
-// channel based semaphore
-// used to limit the number of concurrent goroutines
-type semaphore chan struct{}
+import (
+	"sync"
+	"sync/atomic"
+)
 
-// acquire a lock, blocking or non-blocking
-func (s semaphore) acquire() {
-	s <- struct{}{}
+// byteSemaphore tracks and limits the total number of bytes held by the producer. A max
+// of 0 means no limit.
+type byteSemaphore struct {
+	sync.Mutex
+	max      int
+	n        int
+	released chan struct{}
+	// waiting is set once tryAcquire failed, until the waiters are woken up
+	waiting bool
 }
 
-// release a lock
-func (s semaphore) release() {
-	<-s
+func newByteSemaphore(max int) *byteSemaphore {
+	return &byteSemaphore{max: max, released: make(chan struct{})}
 }
 
-// wait block until the last goroutine release the lock
-func (s semaphore) wait(count int) {
-	for i := 0; i < count; i++ {
-		s <- struct{}{}
+// tryAcquire reserves n bytes without blocking. A reservation larger than max is allowed
+// when no bytes are held so a single large record can't block forever. If the bytes
+// could not be reserved, the returned channel is closed on the next release.
+func (s *byteSemaphore) tryAcquire(n int) (bool, <-chan struct{}) {
+	s.Lock()
+	defer s.Unlock()
+	if s.max > 0 && s.n > 0 && s.n+n > s.max {
+		s.waiting = true
+		return false, s.released
 	}
+	s.n += n
+	return true, nil
 }
 
-// releases the semaphore for use again after a wait call
-// only use this after calling wait()
-func (s semaphore) open(count int) {
-	for i := 0; i < count; i++ {
-		<-s
+// full reports whether reserving n bytes would exceed max
+func (s *byteSemaphore) full(n int) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.max > 0 && s.n > 0 && s.n+n > s.max
+}
+
+// release n bytes and wake up any waiters
+func (s *byteSemaphore) release(n int) {
+	if n == 0 {
+		return
+	}
+	s.Lock()
+	s.n -= n
+	s.wakeLocked()
+	s.Unlock()
+}
+
+// wake wakes up the waiters without releasing bytes, so that they try again
+func (s *byteSemaphore) wake() {
+	s.Lock()
+	s.wakeLocked()
+	s.Unlock()
+}
+
+func (s *byteSemaphore) wakeLocked() {
+	if s.waiting {
+		close(s.released)
+		s.released = make(chan struct{})
+		s.waiting = false
+	}
+}
+
+// size returns the number of bytes held
+func (s *byteSemaphore) size() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.n
+}
+
+// broadcast wakes up all its waiters at once by closing a channel. The channel is only
+// created once somebody waits, so that signal is cheap otherwise.
+type broadcast struct {
+	mu      sync.Mutex
+	ch      chan struct{}
+	waiting int32
+}
+
+// wait returns a channel closed on the next signal
+func (b *broadcast) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch == nil {
+		b.ch = make(chan struct{})
+		atomic.StoreInt32(&b.waiting, 1)
+	}
+	return b.ch
+}
+
+// signal wakes up the waiters
+func (b *broadcast) signal() {
+	if atomic.LoadInt32(&b.waiting) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch != nil {
+		close(b.ch)
+		b.ch = nil
+		atomic.StoreInt32(&b.waiting, 0)
 	}
 }
+
+// waitRelease returns a channel closed on the next release, if max is set
+func (s *byteSemaphore) waitRelease() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+	// nobody is woken up without a limit
+	s.waiting = s.max > 0
+	return s.released
+}
+
+// countingSemaphore limits the number of concurrent goroutines, e.g. the connections of the
+// worker pool. Its limit can be changed while held: the slots held above a lowered limit
+// are simply not handed out again once released. Only one goroutine acquires it, any may
+// release it.
+type countingSemaphore struct {
+	mu       sync.Mutex
+	n, limit int
+	// free is closed, ready is free while a slot can be acquired
+	free     chan struct{}
+	released broadcast
+}
+
+func newCountingSemaphore(limit int) *countingSemaphore {
+	free := make(chan struct{})
+	close(free)
+	return &countingSemaphore{limit: limit, free: free}
+}
+
+// ready returns a channel ready once a slot may be acquired. Acquire with tryAcquire, as
+// the limit may have been lowered meanwhile.
+func (s *countingSemaphore) ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n < s.limit {
+		return s.free
+	}
+	return s.released.wait()
+}
+
+// tryAcquire acquires a slot without blocking
+func (s *countingSemaphore) tryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n >= s.limit {
+		return false
+	}
+	s.n++
+	return true
+}
+
+// release a slot
+func (s *countingSemaphore) release() {
+	s.mu.Lock()
+	s.n--
+	s.mu.Unlock()
+	s.released.signal()
+}
+
+// resize changes the limit without waiting for the slots held to be released
+func (s *countingSemaphore) resize(limit int) {
+	s.mu.Lock()
+	s.limit = limit
+	s.mu.Unlock()
+	s.released.signal()
+}
+
+// wait blocks until at most n slots are held
+func (s *countingSemaphore) wait(n int) {
+	for {
+		s.mu.Lock()
+		if s.n <= n {
+			s.mu.Unlock()
+			return
+		}
+		released := s.released.wait()
+		s.mu.Unlock()
+		<-released
+	}
+}
+
+// reset releases all the slots for use again after a wait call
+func (s *countingSemaphore) reset() {
+	s.mu.Lock()
+	s.n = 0
+	s.mu.Unlock()
+	s.released.signal()
+}
@@ -6,7 +6,9 @@ import (
 	"math/big"
 	"sort"
 	"sync"
+	"sync/atomic"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 )
@@ -15,12 +17,24 @@ import (
 // Hash key ranges are 0 indexed, so true max is 2^128 - 1
 var maxHashKeyRange = "340282366920938463463374607431768211455"
 
+var maxHashKey, _ = new(big.Int).SetString(maxHashKeyRange, 10)
+
+// validHashKey reports whether hk is within the Kinesis hash key range
+func validHashKey(hk *big.Int) bool {
+	return hk.Sign() >= 0 && hk.Cmp(maxHashKey) <= 0
+}
+
 // ShardLister is the interface that wraps the KinesisAPI.ListShards method.
 type ShardLister interface {
 	ListShards(ctx context.Context, params *k.ListShardsInput, optFns ...func(*k.Options)) (*k.ListShardsOutput, error)
 }
 
-// GetKinesisShardsFunc gets the active list of shards from Kinesis.ListShards API
+// GetKinesisShardsFunc gets the active list of shards from Kinesis.ListShards API.
+// It pages through ListShards using NextToken, filters out closed shards (shards with an
+// EndingSequenceNumber) and sorts the result by StartingHashKey. The returned bool is only
+// true when the hash key ranges of the open shards differ from the current shard list, so
+// it can be used directly as Config.GetShards together with Config.ShardRefreshInterval.
+// streamName may also be the ARN of the stream.
 func GetKinesisShardsFunc(client ShardLister, streamName string) GetShardsFunc {
 	return func(old []types.Shard) ([]types.Shard, bool, error) {
 		var (
@@ -32,6 +46,8 @@ func GetKinesisShardsFunc(client ShardLister, streamName string) GetShardsFunc {
 			input := &k.ListShardsInput{}
 			if next != nil {
 				input.NextToken = next
+			} else if isStreamARN(streamName) {
+				input.StreamARN = &streamName
 			} else {
 				input.StreamName = &streamName
 			}
@@ -130,12 +146,46 @@ func shardsEqual(a, b []types.Shard) bool {
 	return true
 }
 
+// ShardMap maps user records to the aggregator of their shard. Its shards and
+// aggregators are swapped as a whole, copy-on-write, when the shards are updated, so Put
+// only locks the aggregator of the shard. Its embedded lock serializes the updates.
 type ShardMap struct {
 	sync.RWMutex
-	shards      []types.Shard
-	aggregators []*Aggregator
+	table atomic.Pointer[shardTable]
 	// aggregateBatchCount determine the maximum number of items to pack into an aggregated record.
 	aggregateBatchCount int
+	// payloadUnits is the maximum number of PUT payload units of an aggregated record. 0
+	// means no limit, see Config.AggregatePayloadUnits. Accessed atomically
+	payloadUnits int64
+	// hasher maps partition keys to hash keys. nil hashes with MD5, see Config.Hasher
+	hasher Hasher
+	// verify checksums the data of the user records, see Config.VerifyIntegrity
+	verify bool
+	// wireVersion frames the aggregated records, see Config.WireVersion
+	wireVersion byte
+	// format encodes the aggregated records, see Config.AggregationFormat
+	format AggregationFormat
+}
+
+// shardTable holds the shards of a ShardMap and their aggregators. It is never modified
+// once published, UpdateShards replaces it.
+type shardTable struct {
+	shards      []types.Shard
+	aggregators []*Aggregator
+	// ends are the parsed EndingHashKeys of the shards
+	ends []*big.Int
+}
+
+func newShardTable(shards []types.Shard) *shardTable {
+	ends := make([]*big.Int, len(shards))
+	for i, shard := range shards {
+		ends[i], _ = new(big.Int).SetString(*shard.HashKeyRange.EndingHashKey, 10)
+	}
+	return &shardTable{
+		shards:      shards,
+		aggregators: makeAggregators(shards),
+		ends:        ends,
+	}
 }
 
 // NewShardMap initializes an aggregator for each shard.
@@ -146,20 +196,15 @@ type ShardMap struct {
 // aggregator. The aggregator will instead use the PartitionKey of the first UserRecord and
 // no ExplicitHashKey.
 func NewShardMap(shards []types.Shard, aggregateBatchCount int) *ShardMap {
-	return &ShardMap{
-		shards:              shards,
-		aggregators:         makeAggregators(shards),
-		aggregateBatchCount: aggregateBatchCount,
-	}
+	m := &ShardMap{aggregateBatchCount: aggregateBatchCount}
+	m.table.Store(newShardTable(shards))
+	return m
 }
 
-// Put puts a UserRecord into the aggregator that maps to its partition key.
+// Put puts a UserRecord into the aggregator that maps to its partition key. This method
+// is thread-safe.
 func (m *ShardMap) Put(userRecord UserRecord) (*AggregatedRecordRequest, error) {
-	m.RLock()
-	drained, err := m.put(userRecord)
-	// Not using defer to avoid runtime overhead
-	m.RUnlock()
-	return drained, err
+	return m.put(userRecord)
 }
 
 // Size return how many bytes stored in all the aggregators.
@@ -167,7 +212,7 @@ func (m *ShardMap) Put(userRecord UserRecord) (*AggregatedRecordRequest, error)
 func (m *ShardMap) Size() int {
 	m.RLock()
 	size := 0
-	for _, a := range m.aggregators {
+	for _, a := range m.table.Load().aggregators {
 		a.RLock()
 		size += a.Size()
 		a.RUnlock()
@@ -176,14 +221,53 @@ func (m *ShardMap) Size() int {
 	return size
 }
 
+// sizes returns the bytes stored in each aggregator keyed by shard id, like Counts
+func (m *ShardMap) sizes() map[string]int {
+	m.RLock()
+	t := m.table.Load()
+	sizes := make(map[string]int, len(t.aggregators))
+	for i, a := range t.aggregators {
+		var shardId string
+		if len(t.shards) > 0 {
+			shardId = aws.ToString(t.shards[i].ShardId)
+		}
+		a.RLock()
+		sizes[shardId] = a.Size()
+		a.RUnlock()
+	}
+	m.RUnlock()
+	return sizes
+}
+
+// Counts returns the number of user records in each aggregator keyed by shard id. An
+// unsharded map returns the count of its single aggregator with the empty shard id.
+func (m *ShardMap) Counts() map[string]int {
+	m.RLock()
+	t := m.table.Load()
+	counts := make(map[string]int, len(t.aggregators))
+	for i, a := range t.aggregators {
+		var shardId string
+		if len(t.shards) > 0 {
+			shardId = aws.ToString(t.shards[i].ShardId)
+		}
+		a.RLock()
+		counts[shardId] = a.Count()
+		a.RUnlock()
+	}
+	m.RUnlock()
+	return counts
+}
+
 // Drain drains all the aggregators and returns a list of the results
 func (m *ShardMap) Drain() ([]*AggregatedRecordRequest, []error) {
+	// the lock waits for an update of the shards, so the records it redistributes are
+	// drained too
 	m.RLock()
 	var (
 		requests []*AggregatedRecordRequest
 		errs     []error
 	)
-	for _, a := range m.aggregators {
+	for _, a := range m.table.Load().aggregators {
 		a.Lock()
 		req, err := a.Drain()
 		a.Unlock()
@@ -199,14 +283,55 @@ func (m *ShardMap) Drain() ([]*AggregatedRecordRequest, []error) {
 
 // Shards returns the list of shards
 func (m *ShardMap) Shards() []types.Shard {
-	m.RLock()
-	shards := m.shards
-	m.RUnlock()
-	return shards
+	return m.table.Load().shards
+}
+
+// setPayloadUnits sets the maximum number of PUT payload units of an aggregated record
+func (m *ShardMap) setPayloadUnits(units int) {
+	atomic.StoreInt64(&m.payloadUnits, int64(units))
+}
+
+// setHasher sets the Hasher that maps partition keys to shards. Not thread safe, call it
+// before the shard map is used.
+func (m *ShardMap) setHasher(hasher Hasher) {
+	m.hasher = hasher
+	for _, a := range m.table.Load().aggregators {
+		a.hasher = hasher
+	}
+}
+
+// setVerifyIntegrity makes the aggregators verify the data of the user records they drain.
+// Not thread safe, call it before the shard map is used.
+func (m *ShardMap) setVerifyIntegrity(verify bool) {
+	m.verify = verify
+	for _, a := range m.table.Load().aggregators {
+		a.verify = verify
+	}
+}
+
+// setWireVersion sets the version of the wire format of the aggregated records. Not thread
+// safe, call it before the shard map is used.
+func (m *ShardMap) setWireVersion(version byte) {
+	m.wireVersion = version
+	for _, a := range m.table.Load().aggregators {
+		a.wireVersion = version
+	}
+}
+
+// setAggregationFormat sets the format of the aggregated records. Not thread safe, call it
+// before the shard map is used.
+func (m *ShardMap) setAggregationFormat(format AggregationFormat) {
+	m.format = format
+	for _, a := range m.table.Load().aggregators {
+		a.format = format
+	}
 }
 
 // Update the list of shards and redistribute buffered user records.
-// Returns any records that were drained due to redistribution.
+// Returns any records that were drained due to redistribution. The user records of pending
+// aggregated records are aggregated again against the new shards, so that an aggregated
+// record built before a split or merge does not mix user records of different shards.
+// Pending records that were not aggregated are returned as they are.
 // Shards are not updated if an error occurs during redistribution.
 // TODO: Can we optimize this?
 // TODO: How to handle shard splitting? If a shard splits but we don't remap before sending
@@ -219,11 +344,33 @@ func (m *ShardMap) UpdateShards(shards []types.Shard, pendingRecords []*Aggregat
 	m.Lock()
 	defer m.Unlock()
 
+	// hold the aggregators until the new table is published, puts waiting for them retry
+	// with the new table once they are retired
+	old := m.table.Load()
+	for _, agg := range old.aggregators {
+		agg.Lock()
+	}
+	defer func() {
+		for _, agg := range old.aggregators {
+			agg.Unlock()
+		}
+	}()
+
 	update := NewShardMap(shards, m.aggregateBatchCount)
+	update.payloadUnits = atomic.LoadInt64(&m.payloadUnits)
+	update.setHasher(m.hasher)
+	update.setVerifyIntegrity(m.verify)
+	update.setWireVersion(m.wireVersion)
+	update.setAggregationFormat(m.format)
 	var drained []*AggregatedRecordRequest
 
 	// first put any pending UserRecords from inflight requests
 	for _, record := range pendingRecords {
+		if record.plain {
+			// Kinesis routes plain records by their own partition or explicit hash key
+			drained = append(drained, record)
+			continue
+		}
 		for _, userRecord := range record.UserRecords {
 			req, err := update.put(userRecord)
 			if err != nil {
@@ -238,8 +385,7 @@ func (m *ShardMap) UpdateShards(shards []types.Shard, pendingRecords []*Aggregat
 		}
 	}
 	// then redistribute the records still being aggregated
-	for _, agg := range m.aggregators {
-		// We don't need to get the aggregator lock because we have the shard map write lock
+	for _, agg := range old.aggregators {
 		for _, userRecord := range agg.buf {
 			req, err := update.put(userRecord)
 			if err != nil {
@@ -251,32 +397,44 @@ func (m *ShardMap) UpdateShards(shards []types.Shard, pendingRecords []*Aggregat
 		}
 	}
 	// Only update m if we successfully redistributed all the user records
-	m.shards = update.shards
-	m.aggregators = update.aggregators
+	for _, agg := range old.aggregators {
+		agg.retired = true
+		agg.clear()
+	}
+	m.table.Store(update.table.Load())
 	return drained, nil
 }
 
-// puts a UserRecord into the aggregator that maps to its partition key.
-// Not thread safe. acquire lock before calling.
+// puts a UserRecord into the aggregator that maps to its partition key. Only the
+// aggregator is locked, the put is retried if the shards were updated meanwhile.
 func (m *ShardMap) put(userRecord UserRecord) (*AggregatedRecordRequest, error) {
-	bucket := m.bucket(userRecord)
-	if bucket == -1 {
-		return nil, &ShardBucketError{UserRecord: userRecord}
-	}
-	a := m.aggregators[bucket]
-	a.Lock()
-	var (
-		needToDrain = a.WillOverflow(userRecord) || a.Count() >= m.aggregateBatchCount
+	for {
+		t := m.table.Load()
+		bucket := t.bucket(userRecord, m.hasher)
+		if bucket == -1 {
+			return nil, &ShardBucketError{UserRecord: userRecord}
+		}
+		a := t.aggregators[bucket]
+		a.Lock()
+		if a.retired {
+			a.Unlock()
+			continue
+		}
+		payloadUnits := int(atomic.LoadInt64(&m.payloadUnits))
+		var (
+			needToDrain = a.WillOverflow(userRecord) || a.Count() >= m.aggregateBatchCount ||
+				payloadUnits > 0 && a.willExceed(userRecord, payloadUnits*payloadUnitSize)
 
-		drained *AggregatedRecordRequest
-		err     error
-	)
-	if needToDrain {
-		drained, err = a.Drain()
+			drained *AggregatedRecordRequest
+			err     error
+		)
+		if needToDrain {
+			drained, err = a.Drain()
+		}
+		a.Put(userRecord)
+		a.Unlock()
+		return drained, err
 	}
-	a.Put(userRecord)
-	a.Unlock()
-	return drained, err
 }
 
 // bucket returns the index of the shard the given partition key maps to.
@@ -284,35 +442,84 @@ func (m *ShardMap) put(userRecord UserRecord) (*AggregatedRecordRequest, error)
 // Assumes shards is ordered by  contiguous HaskKeyRange ascending. If there are gaps in
 // shard hash key ranges and the partition key falls into one of the gaps, it will be placed
 // in the shard with the larger starting HashKeyRange
-// Not thread safe. acquire lock before calling.
-// TODO: Can we optimize this? Cache for pk -> bucket?
-func (m *ShardMap) bucket(userRecord UserRecord) int {
-	if len(m.shards) == 0 {
+func (t *shardTable) bucket(userRecord UserRecord, hasher Hasher) int {
+	if len(t.shards) == 0 {
 		return 0
 	}
 
 	hk := userRecord.ExplicitHashKey()
 	if hk == nil {
-		hk = hashKey(userRecord.PartitionKey())
+		hk = hashKeyWith(hasher, userRecord.PartitionKey())
 	}
+	return t.hashKeyBucket(hk)
+}
+
+// hashKeyBucket returns the index of the shard the given hash key maps to.
+// Returns -1 if hash key is outside shard range.
+func (t *shardTable) hashKeyBucket(hk *big.Int) int {
 	sortFunc := func(i int) bool {
-		shard := m.shards[i]
-		end := big.NewInt(int64(0))
-		end, _ = end.SetString(*shard.HashKeyRange.EndingHashKey, 10)
 		// end >= hk
-		return end.Cmp(hk) > -1
+		return t.ends[i].Cmp(hk) > -1
 	}
 
 	// Search uses binary search to find and return the smallest index i in [0, n)
 	// at which f(i) is true
 	// See https://golang.org/pkg/sort/#Search
-	bucket := sort.Search(len(m.shards), sortFunc)
-	if bucket == len(m.shards) {
+	bucket := sort.Search(len(t.shards), sortFunc)
+	if bucket == len(t.shards) {
 		return -1
 	}
 	return bucket
 }
 
+// ShardKey returns the StartingHashKey of the shard a PutRecordsRequestEntry will be
+// written to. Returns false if there are no shards or the entry is outside the shard
+// key range.
+func (m *ShardMap) ShardKey(entry types.PutRecordsRequestEntry) (string, bool) {
+	shard, ok := m.shard(entry)
+	if !ok {
+		return "", false
+	}
+	return *shard.HashKeyRange.StartingHashKey, true
+}
+
+// shard returns the shard a PutRecordsRequestEntry will be written to. Returns false if
+// there are no shards or the entry is outside the shard key range.
+func (m *ShardMap) shard(entry types.PutRecordsRequestEntry) (types.Shard, bool) {
+	t := m.table.Load()
+	if len(t.shards) == 0 {
+		return types.Shard{}, false
+	}
+
+	var hk *big.Int
+	if entry.ExplicitHashKey != nil {
+		var ok bool
+		hk, ok = new(big.Int).SetString(*entry.ExplicitHashKey, 10)
+		if !ok {
+			return types.Shard{}, false
+		}
+	} else if entry.PartitionKey != nil {
+		hk = hashKeyWith(m.hasher, *entry.PartitionKey)
+	} else {
+		return types.Shard{}, false
+	}
+
+	bucket := t.hashKeyBucket(hk)
+	if bucket == -1 {
+		return types.Shard{}, false
+	}
+	return t.shards[bucket], true
+}
+
+// hashKeyWith returns the hash key the partition key maps to with hasher, or with MD5 if
+// it is nil
+func hashKeyWith(hasher Hasher, pk string) *big.Int {
+	if hasher == nil {
+		return hashKey(pk)
+	}
+	return hasher.HashKey(pk)
+}
+
 // Calculate a new explicit hash key based on the given partition key.
 // (following the algorithm from the original KPL).
 // Copied from: https://github.com/a8m/kinesis-producer/issues/1#issuecomment-524620994
@@ -3,9 +3,11 @@ package producer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 	"math/rand"
 	"sort"
+	"strconv"
 	"sync"
 	"testing"
 
@@ -70,13 +72,13 @@ func TestNewShardMap(t *testing.T) {
 			require.Equal(t, batchCount, shardMap.aggregateBatchCount)
 
 			if len(shards) == 0 {
-				require.Equal(t, 1, len(shardMap.aggregators))
-				require.Nil(t, shardMap.aggregators[0].explicitHashKey)
+				require.Equal(t, 1, len(shardMap.table.Load().aggregators))
+				require.Nil(t, shardMap.table.Load().aggregators[0].explicitHashKey)
 				return
 			}
 
-			require.Equal(t, len(shards), len(shardMap.aggregators))
-			for i, agg := range shardMap.aggregators {
+			require.Equal(t, len(shards), len(shardMap.table.Load().aggregators))
+			for i, agg := range shardMap.table.Load().aggregators {
 				require.Equal(t, shards[i].HashKeyRange.StartingHashKey, agg.explicitHashKey)
 			}
 		})
@@ -356,7 +358,7 @@ func TestShardMapSize(t *testing.T) {
 	require.Nil(t, drained)
 	require.Nil(t, err)
 
-	expectedSize := calculateRecordFieldSize(0, record.Data()) + calculateStringFieldSize("foo")
+	expectedSize := calculateRecordFieldSize(0, 0, record.Data()) + calculateStringFieldSize("foo") + calculateStringFieldSize(record.ExplicitHashKey().String())
 	require.Equal(t, expectedSize, shardMap.Size())
 
 	record2 := newTestUserRecord("bar", "210141183460469231731687303715884105727", mockData("", 20))
@@ -365,8 +367,8 @@ func TestShardMapSize(t *testing.T) {
 	require.Nil(t, err)
 
 	{
-		expectedSize = calculateRecordFieldSize(0, record.Data()) + calculateStringFieldSize("foo")
-		expectedSize += calculateRecordFieldSize(0, record2.Data()) + calculateStringFieldSize("bar")
+		expectedSize = calculateRecordFieldSize(0, 0, record.Data()) + calculateStringFieldSize("foo") + calculateStringFieldSize(record.ExplicitHashKey().String())
+		expectedSize += calculateRecordFieldSize(0, 0, record2.Data()) + calculateStringFieldSize("bar") + calculateStringFieldSize(record2.ExplicitHashKey().String())
 	}
 	require.Equal(t, expectedSize, shardMap.Size())
 
@@ -377,8 +379,8 @@ func TestShardMapSize(t *testing.T) {
 
 	{
 		// record1 drained on put of record3 so don't include in size
-		expectedSize = calculateRecordFieldSize(0, record2.Data()) + calculateStringFieldSize("bar")
-		expectedSize += calculateRecordFieldSize(0, record3.Data()) + calculateStringFieldSize("foo")
+		expectedSize = calculateRecordFieldSize(0, 0, record2.Data()) + calculateStringFieldSize("bar") + calculateStringFieldSize(record2.ExplicitHashKey().String())
+		expectedSize += calculateRecordFieldSize(0, 0, record3.Data()) + calculateStringFieldSize("foo") + calculateStringFieldSize(record3.ExplicitHashKey().String())
 	}
 	require.Equal(t, expectedSize, shardMap.Size())
 
@@ -389,6 +391,23 @@ func TestShardMapSize(t *testing.T) {
 
 }
 
+func TestShardMapPayloadUnits(t *testing.T) {
+	shardMap := NewShardMap(nil, maxAggregationCount)
+	shardMap.setPayloadUnits(1)
+
+	// the third record would make the aggregated record span two payload units
+	for i := 0; i < 2; i++ {
+		drained, err := shardMap.Put(newTestUserRecord("foo", "", mockData("", 10<<10)))
+		require.Nil(t, err)
+		require.Nil(t, drained)
+	}
+	drained, err := shardMap.Put(newTestUserRecord("foo", "", mockData("", 10<<10)))
+	require.Nil(t, err)
+	require.NotNil(t, drained)
+	require.Len(t, drained.UserRecords, 2)
+	require.Equal(t, 1, payloadUnits(drained.Entry))
+}
+
 func TestStaticGetShardsFunc(t *testing.T) {
 	testCases := []struct {
 		name  string
@@ -821,6 +840,57 @@ func TestShardMapUpdateShards(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:                "updates shards and returns plain pending records as they are",
+			startingShards:      "testdata/TestShardMapUpdateShards/update/startingShards.json",
+			aggregateBatchCount: 4,
+			records: []UserRecord{
+				newTestUserRecord("foo", "100141183460469231731687303715884105727", []byte("hello")),
+				newTestUserRecord("bar", "200141183460469231731687303715884105727", []byte("world")),
+			},
+			newShards: "testdata/TestShardMapUpdateShards/update/newShards.json",
+			pendingRecords: []*AggregatedRecordRequest{
+				&AggregatedRecordRequest{
+					Entry: types.PutRecordsRequestEntry{
+						ExplicitHashKey: aws.String("220141183460469231731687303715884105727"),
+					},
+					UserRecords: []UserRecord{
+						newTestUserRecord("baz", "220141183460469231731687303715884105727", []byte("plain")),
+					},
+					plain: true,
+				},
+			},
+			updateDrained: []*AggregatedRecordRequest{
+				&AggregatedRecordRequest{
+					Entry: types.PutRecordsRequestEntry{
+						ExplicitHashKey: aws.String("220141183460469231731687303715884105727"),
+					},
+					UserRecords: []UserRecord{
+						newTestUserRecord("baz", "", []byte("plain")),
+					},
+				},
+			},
+			postDrained: []*AggregatedRecordRequest{
+				&AggregatedRecordRequest{
+					Entry: types.PutRecordsRequestEntry{
+						// StartingHashKey of first shard
+						ExplicitHashKey: aws.String("0"),
+					},
+					UserRecords: []UserRecord{
+						newTestUserRecord("foo", "", []byte("hello")),
+					},
+				},
+				&AggregatedRecordRequest{
+					Entry: types.PutRecordsRequestEntry{
+						// StartingHashKey of second shard
+						ExplicitHashKey: aws.String("170141183460469231731687303715884105728"),
+					},
+					UserRecords: []UserRecord{
+						newTestUserRecord("bar", "", []byte("world")),
+					},
+				},
+			},
+		},
 		{
 			name:                "updates shards and redistributes records, returning drained records from the process",
 			startingShards:      "testdata/TestShardMapUpdateShards/update_drained/startingShards.json",
@@ -917,10 +987,10 @@ func TestShardMapUpdateShards(t *testing.T) {
 			if tc.expectedError != "" {
 				require.EqualError(t, gotError, tc.expectedError)
 				require.Equal(t, tc.pendingRecords, gotUpdateDrained)
-				require.Equal(t, startingShards, shardMap.shards)
+				require.Equal(t, startingShards, shardMap.Shards())
 			} else {
 				require.Nil(t, gotError)
-				require.Equal(t, newShards, shardMap.shards)
+				require.Equal(t, newShards, shardMap.Shards())
 			}
 
 			compareAggregatedRecordRequests(t, tc.updateDrained, gotUpdateDrained)
@@ -932,3 +1002,67 @@ func TestShardMapUpdateShards(t *testing.T) {
 		})
 	}
 }
+
+func TestShardMapPutDuringUpdate(t *testing.T) {
+	shards, _, _ := StaticGetShardsFunc(4)(nil)
+	shardMap := NewShardMap(shards, maxAggregationCount)
+
+	const goroutines, puts = 8, 500
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		drained int
+	)
+	count := func(req *AggregatedRecordRequest) {
+		if req != nil {
+			mu.Lock()
+			drained += len(req.UserRecords)
+			mu.Unlock()
+		}
+	}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < puts; i++ {
+				req, err := shardMap.Put(newTestUserRecord(fmt.Sprintf("%d-%d", g, i), "", []byte("hello")))
+				require.NoError(t, err)
+				count(req)
+			}
+		}(g)
+	}
+	for i := 0; i < 10; i++ {
+		next, _, _ := StaticGetShardsFunc(2 + i%3)(nil)
+		reqs, err := shardMap.UpdateShards(next, nil)
+		require.NoError(t, err)
+		for _, req := range reqs {
+			count(req)
+		}
+	}
+	wg.Wait()
+
+	reqs, errs := shardMap.Drain()
+	require.Nil(t, errs)
+	for _, req := range reqs {
+		count(req)
+	}
+	require.Equal(t, goroutines*puts, drained, "no user record should be lost or duplicated")
+}
+
+func BenchmarkShardMapPut(b *testing.B) {
+	shards, _, _ := StaticGetShardsFunc(64)(nil)
+	shardMap := NewShardMap(shards, maxAggregationCount)
+	records := make([]UserRecord, 1024)
+	for i := range records {
+		// explicit hash keys leave out the cost of hashing the partition keys
+		records[i] = newTestUserRecord(strconv.Itoa(i), hashKey(strconv.Itoa(i)).String(), []byte("hello"))
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := shardMap.Put(records[i%len(records)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
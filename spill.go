@@ -0,0 +1,287 @@
+package producer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spillSegmentSize is the size at which a new spill segment is started
+const spillSegmentSize = 16 << 20
+
+// spillQueue is a FIFO queue of user records in temporary segment files, used by the
+// OverflowSpill policy. Each entry is the record encoded with encodeLogRecord, prefixed
+// with its length as a big endian uint32.
+type spillQueue struct {
+	mu  sync.Mutex
+	dir string
+	// segments are the paths of the segment files, oldest first. The last one is written to
+	segments []string
+	w        *os.File
+	wsize    int
+	r        *os.File
+	// tracked holds the state of the spilled records that were tracked, by position in the
+	// queue. The data of the records is only on disk
+	tracked map[uint64]*trackedRecord
+	head    uint64
+	tail    uint64
+	// putBack counts the records put back, changed is signaled whenever it grows
+	putBack uint64
+	changed broadcast
+	// empty is closed once the queue is empty and the last record was put back
+	empty  chan struct{}
+	idle   bool
+	closed bool
+}
+
+func newSpillQueue(dir string) (*spillQueue, error) {
+	tmp, err := os.MkdirTemp(dir, "kinesis-spill-")
+	if err != nil {
+		return nil, err
+	}
+	empty := make(chan struct{})
+	close(empty)
+	return &spillQueue{
+		dir:     tmp,
+		tracked: make(map[uint64]*trackedRecord),
+		empty:   empty,
+		idle:    true,
+	}, nil
+}
+
+// len returns the number of records in the queue
+func (q *spillQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int(q.tail - q.head)
+}
+
+// busy reports whether records are in the queue or being put back
+func (q *spillQueue) busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return !q.idle
+}
+
+// push appends the user record put to stream to the queue
+func (q *spillQueue) push(stream string, userRecord UserRecord) error {
+	data := encodeLogRecord(stream, userRecord)
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return os.ErrClosed
+	}
+	if q.w == nil || q.wsize >= spillSegmentSize {
+		path := filepath.Join(q.dir, fmt.Sprintf("%020d", q.tail))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if q.w != nil {
+			q.w.Close()
+		}
+		q.w, q.wsize = f, 0
+		q.segments = append(q.segments, path)
+	}
+	if _, err := q.w.Write(buf); err != nil {
+		return err
+	}
+	q.wsize += len(buf)
+	if r, ok := userRecord.(*trackedRecord); ok {
+		// the record keeps its future, reports and barrier generation, only its data is
+		// on disk
+		tracked := *r
+		tracked.UserRecord = nil
+		q.tracked[q.tail] = &tracked
+	}
+	if q.idle {
+		q.empty, q.idle = make(chan struct{}), false
+	}
+	q.tail++
+	return nil
+}
+
+// pop removes the oldest record from the queue and returns the stream it was put to.
+// Returns a nil user record if the queue is empty.
+func (q *spillQueue) pop() (string, UserRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.head == q.tail {
+		return "", nil, nil
+	}
+	for {
+		if q.r == nil {
+			f, err := os.Open(q.segments[0])
+			if err != nil {
+				return "", nil, err
+			}
+			q.r = f
+		}
+		var size [4]byte
+		_, err := io.ReadFull(q.r, size[:])
+		if err == io.EOF && len(q.segments) > 1 {
+			// the segment was read completely and is no longer written to
+			q.r.Close()
+			os.Remove(q.segments[0])
+			q.r, q.segments = nil, q.segments[1:]
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(q.r, data); err != nil {
+			return "", nil, err
+		}
+		seq := q.head
+		q.head++
+		stream, userRecord, err := decodeLogRecord(data)
+		if err != nil {
+			return "", nil, err
+		}
+		if tracked, ok := q.tracked[seq]; ok {
+			delete(q.tracked, seq)
+			tracked.UserRecord = userRecord
+			userRecord = tracked
+		}
+		return stream, userRecord, nil
+	}
+}
+
+// done signals that a popped record was put back
+func (q *spillQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.putBack++
+	q.changed.signal()
+	if q.head == q.tail && !q.idle {
+		close(q.empty)
+		q.idle = true
+	}
+}
+
+// wait blocks until the queue is empty or ctx is done
+func (q *spillQueue) wait(ctx context.Context) error {
+	q.mu.Lock()
+	empty := q.empty
+	q.mu.Unlock()
+	select {
+	case <-empty:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mark returns the position of the next record pushed, for waitPutBack
+func (q *spillQueue) mark() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.tail
+}
+
+// waitPutBack blocks until the records pushed before mark were put back, or the queue is
+// closed, without waiting for the records pushed since. Returns false if cancel is closed
+// first.
+func (q *spillQueue) waitPutBack(cancel <-chan struct{}, mark uint64) bool {
+	for {
+		q.mu.Lock()
+		if q.putBack >= mark || q.closed {
+			q.mu.Unlock()
+			return true
+		}
+		changed := q.changed.wait()
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// close removes the segments and returns the records that were still in the queue
+func (q *spillQueue) close() []UserRecord {
+	var userRecords []UserRecord
+	for {
+		_, userRecord, err := q.pop()
+		if err != nil || userRecord == nil {
+			break
+		}
+		userRecords = append(userRecords, userRecord)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.changed.signal()
+	if q.r != nil {
+		q.r.Close()
+	}
+	if q.w != nil {
+		q.w.Close()
+	}
+	os.RemoveAll(q.dir)
+	return userRecords
+}
+
+// spills reports whether the user record must be spilled to disk instead of being put
+func (p *Producer) spills(userRecord UserRecord, size int, policy OverflowPolicy) bool {
+	if policy != OverflowSpill || p.spill == nil {
+		return false
+	}
+	switch userRecord.(type) {
+	case *chunkRecord, *claimCheckRecord, *compressedRecord, *encryptedRecord:
+		return false
+	}
+	// once records are spilled, new records are spilled too so that they are put in order
+	return p.spill.busy() || p.backlog.len() == p.backlog.cap() || p.buffered.full(size)
+}
+
+// spillRecord writes the user record to the spill queue and wakes up drainSpill
+func (p *Producer) spillRecord(stream string, userRecord UserRecord) error {
+	if err := p.spill.push(stream, userRecord); err != nil {
+		return &ErrSpill{UserRecord: unwrapUserRecord(userRecord), Err: err}
+	}
+	select {
+	case p.spilled <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// drainSpill puts the spilled records back as capacity frees up. Returns once the
+// Producer is stopped.
+func (p *Producer) drainSpill() {
+	for {
+		select {
+		case <-p.spilled:
+		case <-p.stopped:
+			return
+		}
+		for {
+			stream, userRecord, err := p.spill.pop()
+			if err != nil {
+				p.Logger.Error("spill", err)
+				p.notify(err)
+				break
+			}
+			if userRecord == nil {
+				break
+			}
+			// block until there is room, the record was already accepted
+			if err := p.put(stream, userRecord, putOptions{policy: OverflowBlock}); err != nil {
+				resolveUserRecords([]UserRecord{userRecord}, PutResult{Err: err})
+				p.notify(err)
+			}
+			p.spill.done()
+		}
+	}
+}
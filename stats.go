@@ -0,0 +1,265 @@
+package producer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the state of a Producer returned by Producer.Stats. Counters are
+// totals since the Producer was created.
+type Stats struct {
+	// BacklogLength is the number of Puts holding a backlog slot, at most BacklogCount
+	BacklogLength int
+	// BufferedBytes is the size of the user records put and not yet delivered or failed,
+	// counted towards MaxBufferedBytes
+	BufferedBytes int
+	// Aggregators is the number of user records in the aggregator of each shard, keyed by
+	// stream and shard id. Streams without shards use the empty shard id.
+	Aggregators map[string]map[string]int
+	// Circuit is the state of the circuit breaker. Always closed if disabled
+	Circuit CircuitState
+	// FailedOver reports whether requests are sent to the standby stream of Config.Failover
+	FailedOver bool
+	// InFlight is the number of PutRecords requests waiting for a response
+	InFlight int
+	// Stalls is the number of PutRecords requests reported by the Watchdog
+	Stalls int64
+	// Puts is the number of user records accepted by Put
+	Puts int64
+	// Requests is the number of PutRecords requests that got a response
+	Requests int64
+	// Flushes is the number of times the aggregators were flushed
+	Flushes int64
+	// Retries is the number of kinesis records that were retried
+	Retries int64
+	// Throttles is the number of kinesis records rejected due to exceeding the shard
+	// throughput
+	Throttles int64
+	// Drops is the number of user records that failed permanently
+	Drops int64
+	// Duplicates is the number of user records suppressed by the DeduplicationWindow
+	Duplicates int64
+	// Filtered is the number of user records dropped by Sampling.Filter
+	Filtered int64
+	// Sampled is the number of user records dropped by Sampling.Rate
+	Sampled int64
+	// PayloadUnits is the estimated number of 25KiB PUT payload units of the records
+	// delivered, which Kinesis bills for
+	PayloadUnits int64
+	// FlushInterval is the current flush interval, which changes with AdaptiveFlush and
+	// UpdateConfig
+	FlushInterval time.Duration
+	// Connections is the current MaxConnections, which changes with ConnectionScaling,
+	// AutoTune and UpdateConfig
+	Connections int
+	// LastFlush is the time of the last flush of the aggregators. Zero if they were never
+	// flushed
+	LastFlush time.Time
+	// Paused reports whether the Producer is paused
+	Paused bool
+	// Spilled is the number of records spilled to disk by the OverflowSpill policy that
+	// were not put back yet
+	Spilled int
+	// Streams are the kinesis records sent to each stream and shard, keyed by stream
+	Streams map[string]StreamStats
+	// Mirror are the counters of the mirror. Nil unless Config.Mirror is set
+	Mirror *MirrorStats
+	// Cost are the estimated costs of the records. Nil unless Config.Cost is set
+	Cost *CostStats
+}
+
+// StreamStats are the counters of the kinesis records sent to a stream, see Stats.Streams
+type StreamStats struct {
+	ShardStats
+	// Shards are the counters of each shard of the stream, keyed by shard id. Throttled
+	// records of a stream without shards are counted with the empty shard id.
+	Shards map[string]ShardStats
+}
+
+// ShardStats are the counters of the kinesis records sent to a stream or shard
+type ShardStats struct {
+	// Records is the number of kinesis records delivered
+	Records int64
+	// Bytes is the size of the kinesis records delivered, including partition keys
+	Bytes int64
+	// Throttles is the number of kinesis records rejected due to exceeding the shard
+	// throughput
+	Throttles int64
+	// Requests is the number of PutRecords requests that sent kinesis records
+	Requests int64
+	// Latency is the mean latency of these requests
+	Latency time.Duration
+}
+
+// stats holds the counters of Stats. They are updated next to the matching Metrics calls.
+type stats struct {
+	inflight int64
+	// pending is the number of requests waiting for a connection
+	pending  int64
+	puts     int64
+	requests int64
+	// latency is the total latency of the requests in nanoseconds
+	latency   int64
+	flushes   int64
+	retries   int64
+	throttles int64
+	drops     int64
+	units     int64
+	// ingested is the size of the records delivered, each rounded up to 1KiB
+	ingested int64
+	// duplicates are the user records suppressed by the deduplicator
+	duplicates int64
+	// filtered and sampled are the user records dropped by the sampler
+	filtered int64
+	sampled  int64
+	// records and bytes are the kinesis records delivered and their size
+	records int64
+	bytes   int64
+	// lastFlush in unix nanoseconds
+	lastFlush int64
+	// flushInterval is the current flush interval
+	flushInterval int64
+	// connections is the current MaxConnections
+	connections int64
+	// consecutiveFailures is the number of failed requests in a row
+	consecutiveFailures int64
+	// shardsRefreshed is the time of the last successful shard refresh in unix nanoseconds
+	shardsRefreshed int64
+	// streams are the counters of each stream and of its shards
+	streams   map[string]*streamCounters
+	streamsMu sync.Mutex
+}
+
+type streamCounters struct {
+	shardCounters
+	shards map[string]*shardCounters
+}
+
+type shardCounters struct {
+	records, bytes, throttles, requests int64
+	latency                             time.Duration
+}
+
+func (c *shardCounters) add(sent *shardCounters, latency time.Duration) {
+	c.records += sent.records
+	c.bytes += sent.bytes
+	c.throttles += sent.throttles
+	c.requests++
+	c.latency += latency
+}
+
+func (c *shardCounters) export() ShardStats {
+	stats := ShardStats{
+		Records:   c.records,
+		Bytes:     c.bytes,
+		Throttles: c.throttles,
+		Requests:  c.requests,
+	}
+	if c.requests > 0 {
+		stats.Latency = c.latency / time.Duration(c.requests)
+	}
+	return stats
+}
+
+// sent counts the kinesis records of a PutRecords request to stream, keyed by shard id
+func (s *stats) sent(stream string, shards map[string]*shardCounters, latency time.Duration) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[string]*streamCounters)
+	}
+	counters, ok := s.streams[stream]
+	if !ok {
+		counters = &streamCounters{shards: make(map[string]*shardCounters)}
+		s.streams[stream] = counters
+	}
+	var total shardCounters
+	for shardID, sent := range shards {
+		shard, ok := counters.shards[shardID]
+		if !ok {
+			shard = &shardCounters{}
+			counters.shards[shardID] = shard
+		}
+		shard.add(sent, latency)
+		total.records += sent.records
+		total.bytes += sent.bytes
+		total.throttles += sent.throttles
+	}
+	counters.add(&total, latency)
+}
+
+// streamCounters returns a copy of the counters of the stream
+func (s *stats) streamCounters(stream string) shardCounters {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if counters, ok := s.streams[stream]; ok {
+		return counters.shardCounters
+	}
+	return shardCounters{}
+}
+
+// streamStats returns the counters of each stream
+func (s *stats) streamStats() map[string]StreamStats {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	streams := make(map[string]StreamStats, len(s.streams))
+	for stream, counters := range s.streams {
+		stats := StreamStats{
+			ShardStats: counters.export(),
+			Shards:     make(map[string]ShardStats, len(counters.shards)),
+		}
+		for shardID, shard := range counters.shards {
+			stats.Shards[shardID] = shard.export()
+		}
+		streams[stream] = stats
+	}
+	return streams
+}
+
+func (s *stats) flushed(now time.Time) {
+	atomic.AddInt64(&s.flushes, 1)
+	atomic.StoreInt64(&s.lastFlush, now.UnixNano())
+}
+
+// Stats returns a snapshot of the state of the producer. This method is thread-safe.
+func (p *Producer) Stats() Stats {
+	s := p.pool.stats
+	stats := Stats{
+		BacklogLength: p.backlog.len(),
+		BufferedBytes: p.buffered.size(),
+		Aggregators:   make(map[string]map[string]int),
+		Circuit:       p.pool.breaker.currentState(),
+		FailedOver:    p.pool.failover.isActive(),
+		InFlight:      int(atomic.LoadInt64(&s.inflight)),
+		Stalls:        p.pool.watchdog.stallCount(),
+		Puts:          atomic.LoadInt64(&s.puts),
+		Requests:      atomic.LoadInt64(&s.requests),
+		Flushes:       atomic.LoadInt64(&s.flushes),
+		Retries:       atomic.LoadInt64(&s.retries),
+		Throttles:     atomic.LoadInt64(&s.throttles),
+		Drops:         atomic.LoadInt64(&s.drops),
+		Duplicates:    atomic.LoadInt64(&s.duplicates),
+		Filtered:      atomic.LoadInt64(&s.filtered),
+		Sampled:       atomic.LoadInt64(&s.sampled),
+		PayloadUnits:  atomic.LoadInt64(&s.units),
+		FlushInterval: time.Duration(atomic.LoadInt64(&s.flushInterval)),
+		Connections:   int(atomic.LoadInt64(&s.connections)),
+		Paused:        p.Paused(),
+		Streams:       s.streamStats(),
+	}
+	if p.spill != nil {
+		stats.Spilled = p.spill.len()
+	}
+	if p.pool.mirror != nil {
+		stats.Mirror = p.pool.mirror.stats()
+	}
+	stats.Cost = p.costStats()
+	if lastFlush := atomic.LoadInt64(&s.lastFlush); lastFlush != 0 {
+		stats.LastFlush = time.Unix(0, lastFlush)
+	}
+	for stream, shardMap := range p.streamShardMaps() {
+		stats.Aggregators[stream] = shardMap.Counts()
+	}
+	return stats
+}
@@ -0,0 +1,53 @@
+package producer
+
+import (
+	"sync"
+	"time"
+)
+
+// stickyPartitioner hands out the partition key shared by keyless records, see
+// Config.StickyPartitionInterval
+type stickyPartitioner struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	interval time.Duration
+	// maxCount and maxSize are the limits of an aggregated record
+	maxCount, maxSize int
+
+	key         string
+	count, size int
+	since       time.Time
+}
+
+func newStickyPartitioner(config *Config) *stickyPartitioner {
+	if !config.AutoPartitionKey || config.StickyPartitionInterval <= 0 {
+		return nil
+	}
+	maxSize := maxRecordSize
+	if config.AggregatePayloadUnits > 0 {
+		maxSize = config.AggregatePayloadUnits * payloadUnitSize
+	}
+	return &stickyPartitioner{
+		now:      config.Clock.Now,
+		interval: config.StickyPartitionInterval,
+		maxCount: config.AggregateBatchCount,
+		maxSize:  maxSize,
+	}
+}
+
+// partitionKey returns the partition key of a keyless record of size bytes. The key is
+// rotated once the records put with it would overflow an aggregated record, or after
+// interval.
+func (s *stickyPartitioner) partitionKey(size int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// the partition key is counted once, records with the same key share it
+	if s.key == "" || s.count >= s.maxCount || s.size+size+len(s.key) > s.maxSize ||
+		now.Sub(s.since) >= s.interval {
+		s.key, s.count, s.size, s.since = randomPartitionKey(), 0, 0, now
+	}
+	s.count++
+	s.size += size
+	return s.key
+}
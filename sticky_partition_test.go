@@ -0,0 +1,42 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStickyPartitioner(t *testing.T) {
+	now := time.Now()
+	config := &Config{
+		AutoPartitionKey:        true,
+		StickyPartitionInterval: time.Second,
+		AggregateBatchCount:     3,
+		AggregatePayloadUnits:   1,
+		Clock:                   systemClock{},
+	}
+	s := newStickyPartitioner(config)
+	s.now = func() time.Time { return now }
+
+	// records share the key until the aggregated record is full
+	key := s.partitionKey(10)
+	require.Equal(t, key, s.partitionKey(10))
+	require.Equal(t, key, s.partitionKey(10))
+	next := s.partitionKey(10)
+	require.NotEqual(t, key, next)
+
+	// or would overflow its payload units
+	key = next
+	next = s.partitionKey(payloadUnitSize - 40)
+	require.NotEqual(t, key, next)
+
+	// or after StickyPartitionInterval
+	key = s.partitionKey(10)
+	require.NotEqual(t, next, key)
+	require.Equal(t, key, s.partitionKey(10))
+	now = now.Add(time.Second)
+	require.NotEqual(t, key, s.partitionKey(10))
+
+	require.Nil(t, newStickyPartitioner(&Config{AutoPartitionKey: true}))
+}
@@ -0,0 +1,232 @@
+package producer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TenantQuota limits the rate a tenant of a Pool puts user records at. Bursts of up to a
+// second of the rate, and of at least one record, are allowed. Zero values are unlimited.
+type TenantQuota struct {
+	// RecordsPerSecond is the maximum number of user records per second
+	RecordsPerSecond float64
+	// BytesPerSecond is the maximum size of the user records per second, including their
+	// partition keys
+	BytesPerSecond float64
+}
+
+// TenantStats are the counters of a tenant, see Pool.Stats
+type TenantStats struct {
+	// Puts is the number of user records accepted, and Bytes their size including their
+	// partition keys
+	Puts  int64
+	Bytes int64
+	// Throttled is the number of Puts that waited for the quota
+	Throttled int64
+	// Rejected is the number of TryPuts that failed with *ErrQuotaExceeded
+	Rejected int64
+	// Errors is the number of Puts that failed with another error
+	Errors int64
+}
+
+// Pool multiplexes many logical producers, the tenants, over a single Producer, so they
+// share its connections, buffers and goroutines. Each tenant has its own quota, so a
+// tenant over its quota waits without holding back the others, and its own stats.
+//
+// Tenants are kept until RemoveTenant is called, so remove those that are gone when their
+// names are not bounded, e.g. customer ids. A Pool does not start or stop the Producer.
+type Pool struct {
+	producer     *Producer
+	defaultQuota TenantQuota
+
+	mu      sync.Mutex
+	tenants map[string]*Tenant
+}
+
+// NewPool creates a Pool putting the records of its tenants with p. Tenants get
+// defaultQuota unless SetQuota is called.
+func NewPool(p *Producer, defaultQuota TenantQuota) *Pool {
+	return &Pool{
+		producer:     p,
+		defaultQuota: defaultQuota,
+		tenants:      make(map[string]*Tenant),
+	}
+}
+
+// Tenant returns the tenant with the name, creating it with the default quota on first
+// use. This method is thread-safe.
+func (pool *Pool) Tenant(name string) *Tenant {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	t, ok := pool.tenants[name]
+	if !ok {
+		t = newTenant(name, pool.producer, pool.defaultQuota)
+		pool.tenants[name] = t
+	}
+	return t
+}
+
+// RemoveTenant forgets the tenant with the name and its stats. Puts in progress complete,
+// and a later call to Tenant creates it again with the default quota.
+func (pool *Pool) RemoveTenant(name string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	delete(pool.tenants, name)
+}
+
+// SetQuota sets the quota of the tenant with the name, creating it if needed
+func (pool *Pool) SetQuota(name string, quota TenantQuota) {
+	pool.Tenant(name).setQuota(quota)
+}
+
+// Stats returns a snapshot of the stats of each tenant, keyed by name
+func (pool *Pool) Stats() map[string]TenantStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	stats := make(map[string]TenantStats, len(pool.tenants))
+	for name, t := range pool.tenants {
+		stats[name] = t.Stats()
+	}
+	return stats
+}
+
+// Tenant puts user records with the Producer of its Pool, within its quota
+type Tenant struct {
+	name     string
+	producer *Producer
+
+	// mu guards the buckets, which are nil if unlimited
+	mu      sync.Mutex
+	records *tokenBucket
+	bytes   *tokenBucket
+
+	puts, bytesPut, throttled, rejected, errors int64
+}
+
+func newTenant(name string, p *Producer, quota TenantQuota) *Tenant {
+	t := &Tenant{name: name, producer: p}
+	t.setQuota(quota)
+	return t
+}
+
+func (t *Tenant) setQuota(quota TenantQuota) {
+	now := t.producer.Clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.records, t.bytes = nil, nil
+	if quota.RecordsPerSecond > 0 {
+		t.records = newTokenBucket(quota.RecordsPerSecond, now)
+		// a rate below one record per second still lets a record through
+		t.records.capacity = max(t.records.capacity, 1)
+		t.records.tokens = t.records.capacity
+	}
+	if quota.BytesPerSecond > 0 {
+		t.bytes = newTokenBucket(quota.BytesPerSecond, now)
+	}
+}
+
+// Name returns the name of the tenant
+func (t *Tenant) Name() string {
+	return t.name
+}
+
+// Put puts data using partitionKey, after waiting for the quota of the tenant. See
+// Producer.Put. *ErrStoppedProducer is returned if the Producer is stopped meanwhile.
+func (t *Tenant) Put(data []byte, partitionKey string, opts ...PutOption) error {
+	return t.PutUserRecord(t.producer.newDataRecord(data, partitionKey), opts...)
+}
+
+// PutUserRecord is the same as Put but accepts a UserRecord.
+func (t *Tenant) PutUserRecord(userRecord UserRecord, opts ...PutOption) error {
+	size := userRecord.Size() + len(userRecord.PartitionKey())
+	for i := 0; ; i++ {
+		d := t.take(size)
+		if d == 0 {
+			break
+		}
+		if i == 0 {
+			atomic.AddInt64(&t.throttled, 1)
+		}
+		timer := t.producer.Clock.NewTimer(d)
+		select {
+		case <-timer.C():
+		case <-t.producer.stopped:
+			timer.Stop()
+			return &ErrStoppedProducer{userRecord}
+		}
+	}
+	return t.count(size, t.producer.PutUserRecord(userRecord, opts...))
+}
+
+// TryPut is the same as Put but never blocks. If the tenant is over its quota,
+// *ErrQuotaExceeded is returned. See Producer.TryPut.
+func (t *Tenant) TryPut(data []byte, partitionKey string, opts ...PutOption) error {
+	return t.TryPutUserRecord(t.producer.newDataRecord(data, partitionKey), opts...)
+}
+
+// TryPutUserRecord is the same as TryPut but accepts a UserRecord.
+func (t *Tenant) TryPutUserRecord(userRecord UserRecord, opts ...PutOption) error {
+	size := userRecord.Size() + len(userRecord.PartitionKey())
+	if t.take(size) > 0 {
+		atomic.AddInt64(&t.rejected, 1)
+		return &ErrQuotaExceeded{UserRecord: userRecord, Tenant: t.name}
+	}
+	return t.count(size, t.producer.TryPutUserRecord(userRecord, opts...))
+}
+
+// Stats returns a snapshot of the stats of the tenant
+func (t *Tenant) Stats() TenantStats {
+	return TenantStats{
+		Puts:      atomic.LoadInt64(&t.puts),
+		Bytes:     atomic.LoadInt64(&t.bytesPut),
+		Throttled: atomic.LoadInt64(&t.throttled),
+		Rejected:  atomic.LoadInt64(&t.rejected),
+		Errors:    atomic.LoadInt64(&t.errors),
+	}
+}
+
+// take takes the tokens of a user record of size bytes if they are available, or returns
+// how long to wait until they are. Nothing is taken ahead of time, so a Put that gives up
+// waiting leaves the quota to the others. A record larger than the bytes bucket takes it
+// once full, so that it does not wait forever.
+func (t *Tenant) take(size int) time.Duration {
+	now := t.producer.Clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var d time.Duration
+	for _, b := range []struct {
+		bucket *tokenBucket
+		n      float64
+	}{{t.records, 1}, {t.bytes, float64(size)}} {
+		if b.bucket == nil {
+			continue
+		}
+		// refill the bucket without taking anything
+		b.bucket.reserve(0, now)
+		if missing := min(b.n, b.bucket.capacity) - b.bucket.tokens; missing > 0 {
+			d = max(d, time.Duration(missing/b.bucket.rate*float64(time.Second)))
+		}
+	}
+	if d > 0 {
+		return d
+	}
+	if t.records != nil {
+		t.records.tokens--
+	}
+	if t.bytes != nil {
+		t.bytes.tokens -= float64(size)
+	}
+	return 0
+}
+
+// count updates the stats after a put of size bytes that returned err
+func (t *Tenant) count(size int, err error) error {
+	if isRejected(err) {
+		atomic.AddInt64(&t.errors, 1)
+		return err
+	}
+	atomic.AddInt64(&t.puts, 1)
+	atomic.AddInt64(&t.bytesPut, int64(size))
+	return err
+}
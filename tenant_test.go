@@ -0,0 +1,106 @@
+package producer
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	dir := t.TempDir()
+	p := newDryRunProducer(dir)
+	p.Start()
+	pool := NewPool(p, TenantQuota{RecordsPerSecond: 2})
+	pool.SetQuota("big", TenantQuota{})
+
+	small := pool.Tenant("small")
+	require.Equal(t, "small", small.Name())
+	require.NoError(t, small.TryPut([]byte("hello"), "a"))
+	require.NoError(t, small.TryPut([]byte("hello"), "a"))
+	err := small.TryPut([]byte("hello"), "a")
+	var quotaErr *ErrQuotaExceeded
+	require.True(t, errors.As(err, &quotaErr))
+	require.Equal(t, "small", quotaErr.Tenant)
+
+	// the other tenants are not held back
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pool.Tenant("big").TryPut([]byte("world"), "b"))
+	}
+	p.Stop()
+
+	require.Len(t, readFileRecords(t, filepath.Join(dir, "foo.jsonl")), 12)
+	stats := pool.Stats()
+	require.Equal(t, TenantStats{Puts: 2, Bytes: 12, Rejected: 1}, stats["small"])
+	require.Equal(t, TenantStats{Puts: 10, Bytes: 60}, stats["big"])
+}
+
+func TestTenantPutWaitsForQuota(t *testing.T) {
+	p := newDryRunProducer(t.TempDir())
+	p.Start()
+	defer p.Stop()
+	tenant := NewPool(p, TenantQuota{RecordsPerSecond: 100, BytesPerSecond: 1 << 20}).Tenant("a")
+
+	start := time.Now()
+	for i := 0; i < 110; i++ {
+		require.NoError(t, tenant.Put([]byte("hello"), "a"))
+	}
+	// the burst of 100 records is followed by the others at 100/s, less the tokens refilled
+	// in the meantime
+	stats := tenant.Stats()
+	require.Equal(t, int64(110), stats.Puts)
+	require.Greater(t, stats.Throttled, int64(0))
+	require.LessOrEqual(t, stats.Throttled, int64(10))
+	require.GreaterOrEqual(t, time.Since(start), time.Duration(stats.Throttled-1)*10*time.Millisecond)
+}
+
+func TestTenantQuotaBurst(t *testing.T) {
+	p := newDryRunProducer(t.TempDir())
+	p.Start()
+	defer p.Stop()
+	pool := NewPool(p, TenantQuota{RecordsPerSecond: 0.5})
+
+	// a rate below one record per second lets a record through
+	tenant := pool.Tenant("slow")
+	require.NoError(t, tenant.TryPut([]byte("hello"), "a"))
+	require.IsType(t, &ErrQuotaExceeded{}, tenant.TryPut([]byte("hello"), "a"))
+
+	// a record larger than the bytes per second is let through once the bucket is full
+	pool.SetQuota("large", TenantQuota{BytesPerSecond: 10})
+	tenant = pool.Tenant("large")
+	require.NoError(t, tenant.TryPut([]byte("hello world"), "a"))
+	require.IsType(t, &ErrQuotaExceeded{}, tenant.TryPut([]byte("hello"), "a"))
+
+	// removed tenants are created again with the default quota
+	pool.RemoveTenant("large")
+	require.NotContains(t, pool.Stats(), "large")
+	require.NoError(t, pool.Tenant("large").TryPut([]byte("hello world"), "a"))
+}
+
+func TestTenantPutStopped(t *testing.T) {
+	p := newDryRunProducer(t.TempDir())
+	p.Start()
+	tenant := NewPool(p, TenantQuota{RecordsPerSecond: 0.001}).Tenant("a")
+	require.NoError(t, tenant.Put([]byte("hello"), "a"))
+
+	// a Put waiting for the quota returns once the producer is stopped
+	errc := make(chan error)
+	go func() {
+		errc <- tenant.Put([]byte("hello"), "a")
+	}()
+	require.Eventually(t, func() bool {
+		return tenant.Stats().Throttled == 1
+	}, time.Second, time.Millisecond)
+	p.Stop()
+	select {
+	case err := <-errc:
+		require.IsType(t, &ErrStoppedProducer{}, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Put must return once the producer is stopped")
+	}
+
+	// the Put that gave up is not counted
+	require.Equal(t, int64(1), tenant.Stats().Puts)
+}
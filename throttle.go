@@ -0,0 +1,127 @@
+package producer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultThrottleInterval = time.Second
+
+// Throttle slows down the Producer on external signals, e.g. the lag of downstream
+// consumers or an exhausted budget. It is set with Producer.SetThrottle or returned by a
+// ThrottleHook, and applies until the next one. The zero value does not throttle.
+type Throttle struct {
+	// Rate is the maximum number of user records per second accepted by Put. Bursts of up
+	// to a second of the rate are allowed. Puts wait for their turn, and TryPut, or Put
+	// with the OverflowError policy, returns *ErrThrottled. Zero is unlimited.
+	Rate float64
+	// Pause stops flushing records, like Producer.Pause, until a Throttle without it is
+	// set. A Producer paused with Producer.Pause stays paused until Producer.Resume, and
+	// Producer.Resume does not resume a Producer paused by a Throttle.
+	Pause bool
+}
+
+// ThrottleHook feeds external signals into the Producer, see Config.ThrottleHook
+type ThrottleHook interface {
+	// Throttle returns the Throttle to apply until the next call. It is called from a
+	// goroutine of its own, so a slow call only delays the next Throttle.
+	Throttle() Throttle
+}
+
+// ThrottleHookFunc is an adapter to use a function as a ThrottleHook
+type ThrottleHookFunc func() Throttle
+
+func (f ThrottleHookFunc) Throttle() Throttle {
+	return f()
+}
+
+// throttle holds the Throttle applied to the Producer
+type throttle struct {
+	mu sync.Mutex
+	// bucket paces the Puts. Nil if unlimited
+	bucket *tokenBucket
+	// limited is 1 while bucket is set, so that Puts skip the lock otherwise
+	limited int32
+}
+
+// SetThrottle applies t to the Producer until the next call, or the next call of the
+// Config.ThrottleHook. This method is thread-safe.
+func (p *Producer) SetThrottle(t Throttle) {
+	now := p.Clock.Now()
+	p.throttle.mu.Lock()
+	switch {
+	case t.Rate <= 0:
+		p.throttle.bucket = nil
+	case p.throttle.bucket == nil:
+		p.throttle.bucket = newTokenBucket(t.Rate, now)
+	default:
+		// the tokens earned at the previous rate are kept, within the new capacity
+		b := p.throttle.bucket
+		b.reserve(0, now)
+		b.rate, b.capacity, b.tokens = t.Rate, t.Rate, min(b.tokens, t.Rate)
+	}
+	if p.throttle.bucket != nil {
+		atomic.StoreInt32(&p.throttle.limited, 1)
+	} else {
+		atomic.StoreInt32(&p.throttle.limited, 0)
+	}
+	p.throttlePause(t.Pause)
+	p.throttle.mu.Unlock()
+}
+
+// pollThrottleHook applies the Throttle returned by the ThrottleHook every
+// ThrottleInterval, until the Producer is stopped. It runs on its own goroutine so that a
+// slow hook does not hold back the main loop.
+func (p *Producer) pollThrottleHook() {
+	defer close(p.hookDone)
+	tick := p.Clock.NewTicker(p.ThrottleInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C():
+			p.SetThrottle(p.ThrottleHook.Throttle())
+		case <-p.stopped:
+			return
+		}
+	}
+}
+
+// throttled waits for the turn of the user record with the rate of the Throttle and the
+// Cost.Budget. Returns *ErrThrottled instead of waiting with the OverflowError policy.
+func (p *Producer) throttled(userRecord UserRecord, policy OverflowPolicy) error {
+	if err := p.budgeted(userRecord, policy); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&p.throttle.limited) == 0 {
+		return nil
+	}
+	now := p.Clock.Now()
+	p.throttle.mu.Lock()
+	b := p.throttle.bucket
+	var d time.Duration
+	switch {
+	case b == nil:
+	case policy == OverflowError:
+		// refill the bucket without taking anything
+		if b.reserve(0, now); b.tokens < 1 {
+			p.throttle.mu.Unlock()
+			return &ErrThrottled{unwrapUserRecord(userRecord)}
+		}
+		b.tokens--
+	default:
+		d = b.reserve(1, now)
+	}
+	p.throttle.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	timer := p.Clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-p.stopped:
+		return &ErrStoppedProducer{unwrapUserRecord(userRecord)}
+	}
+}
@@ -0,0 +1,134 @@
+package producer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottleRate(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	p.SetThrottle(Throttle{Rate: 10})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, p.TryPut([]byte("hello"), "foo"))
+	}
+	require.IsType(t, &ErrThrottled{}, p.TryPut([]byte("hello"), "foo"))
+
+	// puts wait for their turn
+	start := time.Now()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	p.SetThrottle(Throttle{})
+	require.NoError(t, p.TryPut([]byte("hello"), "foo"))
+}
+
+func TestThrottleHook(t *testing.T) {
+	var pause int32
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         &clientMock{},
+		ThrottleHook: ThrottleHookFunc(func() Throttle {
+			return Throttle{Pause: atomic.LoadInt32(&pause) == 1}
+		}),
+		ThrottleInterval: 5 * time.Millisecond,
+	})
+	p.Start()
+	defer p.Stop()
+
+	atomic.StoreInt32(&pause, 1)
+	require.Eventually(t, p.Paused, time.Second, time.Millisecond)
+	atomic.StoreInt32(&pause, 0)
+	require.Eventually(t, func() bool { return !p.Paused() }, time.Second, time.Millisecond)
+}
+
+func TestThrottleKeepsPause(t *testing.T) {
+	p := New(&Config{
+		StreamName: "foo",
+		Logger:     &NopLogger{},
+		Client:     &clientMock{},
+	})
+
+	// a producer paused by the application is not resumed by a Throttle
+	p.Pause()
+	p.SetThrottle(Throttle{Pause: true})
+	p.SetThrottle(Throttle{})
+	require.True(t, p.Paused())
+
+	p.Resume()
+	p.SetThrottle(Throttle{Pause: true})
+	require.True(t, p.Paused())
+	p.SetThrottle(Throttle{})
+	require.False(t, p.Paused())
+
+	// a producer paused by the application while paused by a Throttle stays paused
+	p.SetThrottle(Throttle{Pause: true})
+	p.Pause()
+	p.SetThrottle(Throttle{})
+	require.True(t, p.Paused())
+	p.Resume()
+	require.False(t, p.Paused())
+
+	// and Resume does not resume a producer paused by a Throttle
+	p.SetThrottle(Throttle{Pause: true})
+	p.Resume()
+	require.True(t, p.Paused())
+	p.SetThrottle(Throttle{})
+	require.False(t, p.Paused())
+}
+
+func TestThrottleHookSlow(t *testing.T) {
+	release := make(chan struct{})
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+		ThrottleHook: ThrottleHookFunc(func() Throttle {
+			<-release
+			return Throttle{}
+		}),
+		ThrottleInterval: time.Millisecond,
+	})
+	p.Start()
+
+	// a hook that blocks does not hold back the main loop
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, p.Flush(ctx))
+	require.Equal(t, 1, client.calls)
+
+	close(release)
+	p.Stop()
+}
@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/achunariov/kinesis-producer"
+
+var (
+	streamNameKey      = attribute.Key("kinesis.stream_name")
+	batchSizeKey       = attribute.Key("kinesis.batch_size")
+	recordCountKey     = attribute.Key("kinesis.record_count")
+	userRecordCountKey = attribute.Key("kinesis.user_record_count")
+	userRecordSizeKey  = attribute.Key("kinesis.user_record.size")
+	failedCountKey     = attribute.Key("kinesis.failed_record_count")
+	throttledCountKey  = attribute.Key("kinesis.throttled_record_count")
+	retryAttemptKey    = attribute.Key("kinesis.retry_attempt")
+)
+
+// startPutRecordsSpan starts the span for a PutRecords request. The span is linked to
+// the spans of every Put that contributed a record. If all records were put with the same
+// span, that span is used as the parent so the trace continues through to the AWS call.
+func startPutRecordsSpan(ctx context.Context, tracer trace.Tracer, streamName string, work *Work) (context.Context, trace.Span) {
+	var (
+		links       []trace.Link
+		seen        = make(map[trace.SpanID]struct{})
+		userRecords int
+	)
+	for _, record := range work.records {
+		userRecords += len(record.UserRecords)
+		for _, userRecord := range record.UserRecords {
+			r, ok := userRecord.(*trackedRecord)
+			if !ok {
+				continue
+			}
+			sc := trace.SpanContextFromContext(r.ctx)
+			if !sc.IsValid() {
+				continue
+			}
+			if _, ok := seen[sc.SpanID()]; ok {
+				continue
+			}
+			seen[sc.SpanID()] = struct{}{}
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	if len(links) == 1 {
+		ctx = trace.ContextWithSpanContext(ctx, links[0].SpanContext)
+	}
+
+	return tracer.Start(ctx, "kinesis-producer.PutRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			streamNameKey.String(streamName),
+			batchSizeKey.Int(work.size),
+			recordCountKey.Int(len(work.records)),
+			userRecordCountKey.Int(userRecords),
+			retryAttemptKey.Int(work.attempt),
+		),
+	)
+}
@@ -0,0 +1,100 @@
+package producer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		Logger:         &NopLogger{},
+		TracerProvider: provider,
+		Client: &clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{
+					Response: &k.PutRecordsOutput{
+						FailedRecordCount: aws.Int32(0),
+						Records: []types.PutRecordsResultEntry{
+							{SequenceNumber: aws.String("3"), ShardId: aws.String("1")},
+						},
+					},
+				},
+			},
+		},
+	})
+	p.Start()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	require.NoError(t, p.PutWithContext(ctx, []byte("hello"), "foo"))
+	parent.End()
+	p.Stop()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "kinesis-producer.Put")
+	require.Contains(t, spans, "kinesis-producer.Flush")
+	require.Contains(t, spans, "kinesis-producer.PutRecords")
+
+	put := spans["kinesis-producer.Put"]
+	putRecords := spans["kinesis-producer.PutRecords"]
+	require.Equal(t, parent.SpanContext().SpanID(), put.Parent().SpanID())
+	require.Equal(t, put.SpanContext().SpanID(), putRecords.Parent().SpanID())
+	require.Len(t, putRecords.Links(), 1)
+
+	attrs := make(map[string]int64)
+	for _, kv := range putRecords.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInt64()
+	}
+	require.Equal(t, int64(1), attrs[string(recordCountKey)])
+	require.Equal(t, int64(1), attrs[string(userRecordCountKey)])
+	require.Equal(t, int64(0), attrs[string(throttledCountKey)])
+}
+
+func TestTracingPut(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		Logger:         &NopLogger{},
+		TracerProvider: provider,
+		Client: &clientMock{
+			incoming: make(map[int][]string),
+			responses: []responseMock{
+				{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+			},
+		},
+	})
+	p.Start()
+	require.NoError(t, p.Put([]byte("hello"), "foo"))
+	p.Stop()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "kinesis-producer.Put")
+	require.Contains(t, spans, "kinesis-producer.PutRecords")
+
+	put := spans["kinesis-producer.Put"]
+	require.False(t, put.Parent().IsValid())
+	require.Contains(t, put.Attributes(), streamNameKey.String("foo"))
+	require.Contains(t, put.Attributes(), userRecordSizeKey.Int(5))
+	require.Equal(t, put.SpanContext().SpanID(), spans["kinesis-producer.PutRecords"].Parent().SpanID())
+}
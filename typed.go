@@ -0,0 +1,76 @@
+package producer
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Marshaler encodes values of type T into record data
+type Marshaler[T any] interface {
+	Marshal(v T) ([]byte, error)
+}
+
+// PartitionKeyFunc returns the partition key of a value of type T
+type PartitionKeyFunc[T any] func(v T) string
+
+// JSONMarshaler encodes values with encoding/json
+type JSONMarshaler[T any] struct{}
+
+// Marshal encodes v as JSON, with the pooled encoders of Producer.PutJSON
+func (JSONMarshaler[T]) Marshal(v T) ([]byte, error) {
+	return marshalJSON(v, 0)
+}
+
+// ProtoMarshaler encodes protocol buffer messages in the wire format
+type ProtoMarshaler[T proto.Message] struct{}
+
+// Marshal encodes v in the protocol buffer wire format
+func (ProtoMarshaler[T]) Marshal(v T) ([]byte, error) {
+	return marshalProto(v)
+}
+
+// marshalProto encodes msg in the protocol buffer wire format into data allocated at its
+// final size
+func marshalProto(msg proto.Message) ([]byte, error) {
+	opts := proto.MarshalOptions{UseCachedSize: true}
+	return opts.MarshalAppend(make([]byte, 0, opts.Size(msg)), msg)
+}
+
+// PutProto encodes msg in the protocol buffer wire format and puts it like
+// PutWithContext. The record data is allocated at its final size, computed before
+// encoding. Returns *ErrMarshal if msg could not be encoded. This method is thread-safe.
+func (p *Producer) PutProto(ctx context.Context, msg proto.Message, partitionKey string, opts ...PutOption) error {
+	data, err := marshalProto(msg)
+	if err != nil {
+		return &ErrMarshal{Err: err}
+	}
+	return p.PutWithContext(ctx, data, partitionKey, opts...)
+}
+
+// TypedProducer puts values of type T, marshaling them with Marshaler and putting them
+// with the partition key returned by PartitionKey
+type TypedProducer[T any] struct {
+	Producer     *Producer
+	Marshaler    Marshaler[T]
+	PartitionKey PartitionKeyFunc[T]
+}
+
+// NewTypedProducer returns a TypedProducer putting values of type T to p
+func NewTypedProducer[T any](p *Producer, marshaler Marshaler[T], partitionKey PartitionKeyFunc[T]) *TypedProducer[T] {
+	return &TypedProducer[T]{
+		Producer:     p,
+		Marshaler:    marshaler,
+		PartitionKey: partitionKey,
+	}
+}
+
+// Put marshals v and puts it like Producer.PutWithContext. Returns ErrMarshal if v could
+// not be marshaled.
+func (tp *TypedProducer[T]) Put(ctx context.Context, v T, opts ...PutOption) error {
+	data, err := tp.Marshaler.Marshal(v)
+	if err != nil {
+		return &ErrMarshal{Err: err}
+	}
+	return tp.Producer.PutWithContext(ctx, data, tp.PartitionKey(v), opts...)
+}
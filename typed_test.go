@@ -0,0 +1,89 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/achunariov/kinesis-producer/pb"
+)
+
+type typedEvent struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestTypedProducer(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	events := NewTypedProducer[typedEvent](p, JSONMarshaler[typedEvent]{}, func(e typedEvent) string {
+		return e.ID
+	})
+	tags := NewTypedProducer[*pb.Tag](p, ProtoMarshaler[*pb.Tag]{}, func(t *pb.Tag) string {
+		return t.GetKey()
+	})
+	ctx := context.Background()
+	require.NoError(t, events.Put(ctx, typedEvent{ID: "1", Name: "hello"}, WithoutAggregation()))
+	require.NoError(t, tags.Put(ctx, &pb.Tag{Key: aws.String("2"), Value: aws.String("world")}, WithoutAggregation()))
+	require.NoError(t, p.Flush(ctx))
+
+	require.ElementsMatch(t, []string{"1", "2"}, client.incoming[0])
+	tag, err := proto.Marshal(&pb.Tag{Key: aws.String("2"), Value: aws.String("world")})
+	require.NoError(t, err)
+	require.ElementsMatch(t, [][]byte{[]byte(`{"id":"1","name":"hello"}`), tag}, client.data)
+
+	invalid := NewTypedProducer[chan int](p, JSONMarshaler[chan int]{}, func(chan int) string {
+		return "3"
+	})
+	err = invalid.Put(ctx, make(chan int))
+	require.IsType(t, &ErrMarshal{}, err)
+}
+
+func TestPutProto(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	ctx := context.Background()
+	tag := &pb.Tag{Key: aws.String("1"), Value: aws.String("hello")}
+	require.NoError(t, p.PutProto(ctx, tag, "1", WithoutAggregation()))
+	// required fields are checked
+	require.IsType(t, &ErrMarshal{}, p.PutProto(ctx, &pb.Tag{}, "2"))
+	require.NoError(t, p.Flush(ctx))
+
+	expected, err := proto.Marshal(tag)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{expected}, client.data)
+	require.Equal(t, len(client.data[0]), cap(client.data[0]))
+}
@@ -0,0 +1,145 @@
+package producer
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// configUpdate holds the settings of a running Producer changed by UpdateConfig, the
+// ConnectionScaling and AutoTune
+type configUpdate struct {
+	flushInterval     time.Duration
+	batchCount        int
+	batchSize         int
+	maxConnections    int
+	rateLimitHeadroom int
+	verbose           bool
+}
+
+// settingsOf returns the settings of c that can be updated
+func settingsOf(c *Config) configUpdate {
+	return configUpdate{
+		flushInterval:     c.FlushInterval,
+		batchCount:        c.BatchCount,
+		batchSize:         c.BatchSize,
+		maxConnections:    c.MaxConnections,
+		rateLimitHeadroom: c.RateLimitHeadroom,
+		verbose:           c.Verbose,
+	}
+}
+
+// configRequest is an UpdateConfig sent to the main loop. Only the settings changed by the
+// caller, from the snapshot it started from to its update, are applied, so that the
+// changes made by the main loop since the snapshot, e.g. to MaxConnections by the
+// ConnectionScaling, are not undone.
+type configRequest struct {
+	from, to configUpdate
+	done     chan struct{}
+}
+
+// merge applies the settings changed by the request to current
+func (r configRequest) merge(current configUpdate) configUpdate {
+	if r.to.flushInterval != r.from.flushInterval {
+		current.flushInterval = r.to.flushInterval
+	}
+	if r.to.batchCount != r.from.batchCount {
+		current.batchCount = r.to.batchCount
+	}
+	if r.to.batchSize != r.from.batchSize {
+		current.batchSize = r.to.batchSize
+	}
+	if r.to.maxConnections != r.from.maxConnections {
+		current.maxConnections = r.to.maxConnections
+	}
+	if r.to.rateLimitHeadroom != r.from.rateLimitHeadroom {
+		current.rateLimitHeadroom = r.to.rateLimitHeadroom
+	}
+	if r.to.verbose != r.from.verbose {
+		current.verbose = r.to.verbose
+	}
+	return current
+}
+
+// UpdateConfig changes the configuration of a running Producer. fn is called with a copy
+// of the current configuration and only changes to FlushInterval, BatchCount, BatchSize,
+// MaxConnections, RateLimitHeadroom and Verbose are applied; other fields are ignored.
+// An error is returned if the resulting configuration is invalid. Lowering
+// MaxConnections lets the requests in flight complete, no new request is sent until fewer
+// are in flight. Before Start, the changes are applied right away.
+//
+// Settings left unchanged by fn keep their current value, even if ConnectionScaling or
+// AutoTune changed MaxConnections meanwhile. With ConnectionScaling, a MaxConnections set
+// by fn is clamped to its bounds and the scaler carries on from it, and AutoTune sets it
+// again once the capacity of the streams changes.
+func (p *Producer) UpdateConfig(fn func(*Config)) error {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	// the live configuration belongs to the main loop, the update starts from its snapshot
+	snapshot := p.snapshot.Load()
+	c := snapshot.clone()
+	fn(c)
+	candidate := snapshot.clone()
+	candidate.FlushInterval = c.FlushInterval
+	candidate.BatchCount = c.BatchCount
+	candidate.BatchSize = c.BatchSize
+	candidate.MaxConnections = c.MaxConnections
+	candidate.RateLimitHeadroom = c.RateLimitHeadroom
+	candidate.Verbose = c.Verbose
+	candidate.defaults()
+	if err := candidate.validate(); err != nil {
+		return err
+	}
+
+	request := configRequest{
+		from: settingsOf(snapshot),
+		to:   settingsOf(candidate),
+		done: make(chan struct{}),
+	}
+	if !p.started {
+		// the main loop is not running yet and reads the configuration once started
+		update := request.merge(settingsOf(p.Config))
+		p.applyUpdate(update)
+		atomic.StoreInt64(&p.pool.stats.flushInterval, int64(update.flushInterval))
+		return nil
+	}
+	select {
+	case p.updates <- request:
+	case <-p.stopped:
+		return &ErrStoppedProducer{}
+	}
+	<-request.done
+	return nil
+}
+
+// applyUpdate applies a configuration update from the main loop, or from UpdateConfig
+// before Start, and publishes the snapshot of the configuration read by UpdateConfig
+func (p *Producer) applyUpdate(update configUpdate) {
+	p.FlushInterval = update.flushInterval
+	p.Verbose = update.verbose
+	if p.started {
+		p.pool.reconfigure(update)
+	} else {
+		p.pool.configure(update)
+	}
+
+	p.streamsMu.Lock()
+	p.RateLimitHeadroom = update.rateLimitHeadroom
+	for stream := range p.streams {
+		if limiter := p.pool.limiter(stream); limiter != nil {
+			limiter.SetHeadroom(update.rateLimitHeadroom)
+		}
+	}
+	p.streamsMu.Unlock()
+
+	// the level of a logger set by the user is left untouched
+	if logger, ok := p.Logger.(*StdLogger); ok && logger.level != nil {
+		level := slog.LevelInfo
+		if update.verbose {
+			level = slog.LevelDebug
+		}
+		logger.level.Set(level)
+	}
+	p.snapshot.Store(p.Config.clone())
+}
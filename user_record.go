@@ -1,8 +1,16 @@
 package producer
 
-import "math/big"
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
 
-// UserRecord represents an individual record that is meant for aggregation
+// UserRecord represents an individual record that is meant for aggregation. Every Put
+// method has a variant accepting a UserRecord, e.g. PutUserRecord or PutBatch, so that
+// applications can put their own record types without copying them into a DataRecord: the
+// slice returned by Data is aggregated as it is.
 type UserRecord interface {
 	// PartitionKey returns the partition key of the record
 	PartitionKey() string
@@ -17,8 +25,9 @@ type UserRecord interface {
 }
 
 type DataRecord struct {
-	partitionKey string
-	data         []byte
+	partitionKey    string
+	explicitHashKey *big.Int
+	data            []byte
 }
 
 func NewDataRecord(data []byte, partitionKey string) *DataRecord {
@@ -28,7 +37,136 @@ func NewDataRecord(data []byte, partitionKey string) *DataRecord {
 	}
 }
 
+// NewDataRecordWithExplicitHashKey creates a DataRecord that is mapped to a shard using
+// explicitHashKey instead of the MD5 hash of partitionKey.
+func NewDataRecordWithExplicitHashKey(data []byte, partitionKey string, explicitHashKey *big.Int) *DataRecord {
+	return &DataRecord{
+		partitionKey:    partitionKey,
+		explicitHashKey: explicitHashKey,
+		data:            data,
+	}
+}
+
 func (r *DataRecord) PartitionKey() string      { return r.partitionKey }
-func (r *DataRecord) ExplicitHashKey() *big.Int { return nil }
+func (r *DataRecord) ExplicitHashKey() *big.Int { return r.explicitHashKey }
 func (r *DataRecord) Data() []byte              { return r.data }
 func (r *DataRecord) Size() int                 { return len(r.data) }
+
+// trackedRecord wraps a UserRecord with the state the Producer needs to report on its
+// delivery. It is never exposed to users, see unwrapUserRecords.
+type trackedRecord struct {
+	UserRecord
+	// ctx is the context given at Put time
+	ctx context.Context
+	// future is resolved on delivery if the record was put with PutWithResult
+	future *PutFuture
+	// ack acknowledges the record in the WriteAheadLog once it is resolved. Nil without a
+	// WriteAheadLog
+	ack func()
+	// report reports the result to NotifyDeliveries. Nil unless put with WithCorrelationID
+	report func(PutResult)
+	// forget removes the idempotency key of the record if it failed, so that it can be put
+	// again. Nil without Config.DeduplicationWindow
+	forget func()
+	// barrier counts the record in generation gen until it is resolved, and left is set
+	// once it was removed. Nil without Config.Barriers
+	barrier *barrier
+	gen     uint64
+	left    bool
+	// putAt is the time the record was put. Only set with MaxRecordAge or BufferingMetrics
+	putAt time.Time
+}
+
+// chunkRecord is a chunk of a user record split with MaxChunkSize. It uses the partition
+// and explicit hash key of the user record it was split from.
+type chunkRecord struct {
+	UserRecord
+	data  []byte
+	group *chunkGroup
+}
+
+func (r *chunkRecord) Data() []byte { return r.data }
+func (r *chunkRecord) Size() int    { return len(r.data) }
+
+// chunkGroup tracks the delivery of the chunks of a user record
+type chunkGroup struct {
+	userRecord UserRecord
+	remaining  int32
+}
+
+// resolve records the result of a chunk. The future of the user record is resolved with
+// the first error or once every chunk has been delivered.
+func (g *chunkGroup) resolve(result PutResult) {
+	if result.Err != nil || atomic.AddInt32(&g.remaining, -1) == 0 {
+		resolveUserRecords([]UserRecord{g.userRecord}, result)
+	}
+}
+
+// track wraps the user record in a trackedRecord if it is not one already
+func track(userRecord UserRecord) *trackedRecord {
+	if r, ok := userRecord.(*trackedRecord); ok {
+		return r
+	}
+	return &trackedRecord{UserRecord: userRecord, ctx: context.Background()}
+}
+
+// putContext returns the context the user record was put with
+func putContext(userRecord UserRecord) context.Context {
+	switch r := userRecord.(type) {
+	case *trackedRecord:
+		return r.ctx
+	case *chunkRecord:
+		return putContext(r.group.userRecord)
+	case *claimCheckRecord:
+		return putContext(r.UserRecord)
+	case *compressedRecord:
+		return putContext(r.UserRecord)
+	case *encryptedRecord:
+		return putContext(r.UserRecord)
+	}
+	return context.Background()
+}
+
+// putTime returns the time the user record was put. Zero if it was not recorded
+func putTime(userRecord UserRecord) time.Time {
+	switch r := userRecord.(type) {
+	case *trackedRecord:
+		return r.putAt
+	case *chunkRecord:
+		return putTime(r.group.userRecord)
+	case *claimCheckRecord:
+		return putTime(r.UserRecord)
+	case *compressedRecord:
+		return putTime(r.UserRecord)
+	case *encryptedRecord:
+		return putTime(r.UserRecord)
+	}
+	return time.Time{}
+}
+
+// unwrapUserRecords returns the user records as they were originally passed to the
+// Producer, without any internal wrappers
+func unwrapUserRecords(userRecords []UserRecord) []UserRecord {
+	out := make([]UserRecord, len(userRecords))
+	for i, userRecord := range userRecords {
+		out[i] = unwrapUserRecord(userRecord)
+	}
+	return out
+}
+
+func unwrapUserRecord(userRecord UserRecord) UserRecord {
+	switch r := userRecord.(type) {
+	case *trackedRecord:
+		return r.UserRecord
+	case *chunkRecord:
+		// failures of a chunk are reported with the user record it was split from
+		return unwrapUserRecord(r.group.userRecord)
+	case *claimCheckRecord:
+		return unwrapUserRecord(r.UserRecord)
+	case *compressedRecord:
+		return unwrapUserRecord(r.UserRecord)
+	case *encryptedRecord:
+		return unwrapUserRecord(r.UserRecord)
+	}
+	return userRecord
+}
@@ -0,0 +1,86 @@
+package producer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	mirror := &MirrorConfig{Client: &clientMock{}}
+	c := &Config{
+		StreamName: "foo",
+		Client:     &clientMock{},
+		Mirror:     mirror,
+	}
+	require.Empty(t, c.Validate())
+	// the configuration is not modified
+	require.Zero(t, c.BatchSize)
+	require.Zero(t, mirror.BacklogCount)
+
+	// none of the sub-configurations is modified either
+	c = &Config{
+		StreamName:        "foo",
+		Client:            &clientMock{},
+		CircuitBreaker:    &CircuitBreakerConfig{ConsecutiveFailures: 3},
+		CreateStream:      &CreateStreamConfig{},
+		Envelope:          &EnvelopeConfig{},
+		AutoScaling:       &AutoScalingConfig{},
+		Mirror:            &MirrorConfig{Client: &clientMock{}},
+		Failover:          &FailoverConfig{},
+		Watchdog:          &WatchdogConfig{},
+		ConnectionScaling: &ConnectionScalingConfig{},
+		DryRun:            &DryRunConfig{},
+		Sampling:          &SamplingConfig{},
+		Cost:              &CostConfig{},
+	}
+	before := make(map[string]any)
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.Kind() == reflect.Pointer && !field.IsNil() && field.Elem().Kind() == reflect.Struct {
+			before[v.Type().Field(i).Name] = field.Elem().Interface()
+		}
+	}
+	c.Validate()
+	for name, value := range before {
+		require.Equal(t, value, v.FieldByName(name).Elem().Interface(), name)
+	}
+	require.Len(t, before, 11)
+
+	c = &Config{
+		BatchCount:         1000,
+		BatchSize:          1024,
+		AggregateBatchSize: 2048,
+		BacklogCount:       -1,
+		MaxConnections:     300,
+		RequestTimeout:     -time.Second,
+	}
+	require.Equal(t, []string{
+		"kinesis: BacklogCount must not be negative",
+		"kinesis: BatchCount exceeds 500",
+		"kinesis: AggregateBatchSize exceeds BatchSize",
+		"kinesis: MaxConnections must be between 1 and 256",
+		"kinesis: RequestTimeout must not be negative",
+		"kinesis: StreamName length must be at least 1",
+		"kinesis: Client, PutterFactory or DryRun must be set",
+	}, errorStrings(c.Validate()))
+	// New reports the first problem
+	_, err := NewProducer(WithBatchCount(1000), WithRequestTimeout(-time.Second))
+	require.EqualError(t, err, "kinesis: BatchCount exceeds 500")
+}
+
+func TestAggregateBatchSizeDefault(t *testing.T) {
+	c := &Config{BatchSize: 1024}
+	c.defaults()
+	require.Equal(t, 1024, c.AggregateBatchSize)
+}
+
+func errorStrings(errs []error) []string {
+	s := make([]string, len(errs))
+	for i, err := range errs {
+		s[i] = err.Error()
+	}
+	return s
+}
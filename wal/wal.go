@@ -0,0 +1,364 @@
+// Package wal implements a write-ahead log of records stored in segment files, used by
+// the producer to survive process crashes without losing accepted records.
+//
+// Records are appended to the active segment and acknowledged once they no longer need to
+// be replayed. An acknowledgement is appended as a marker entry. Segments are deleted
+// once every record in them and in all older segments has been acknowledged.
+//
+// Each entry of a segment is:
+//
+//	checksum uint32 CRC-32 (Castagnoli) of the rest of the entry
+//	length   uint32 length of the data
+//	type     byte   1 for records, 2 for acknowledgements
+//	seq      uint64 sequence number of the record
+//
+// followed by the data of records. All integers are big endian. Segments are named after
+// the sequence number of their first entry.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	headerSize = 17
+
+	entryRecord byte = 1
+	entryAck    byte = 2
+
+	segmentExt = ".wal"
+
+	// DefaultSegmentSize is the default size at which a new segment is started
+	DefaultSegmentSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrClosed is returned by Log methods called after Close
+var ErrClosed = errors.New("wal: log is closed")
+
+// Options configures a Log
+type Options struct {
+	// SegmentSize is the size in bytes at which a new segment is started. Default to
+	// DefaultSegmentSize
+	SegmentSize int64
+	// Sync flushes every entry to stable storage before returning. Without Sync, records
+	// survive process crashes but not crashes of the operating system.
+	Sync bool
+}
+
+// segment is a segment file and the number of its records that were not acknowledged
+type segment struct {
+	path    string
+	first   uint64
+	pending int
+}
+
+// Log is a write-ahead log in a directory. It is safe for concurrent use.
+type Log struct {
+	mu       sync.Mutex
+	dir      string
+	opts     Options
+	segments []*segment
+	// pending maps the sequence numbers of records that were not acknowledged to their
+	// segment
+	pending map[uint64]*segment
+	// replayable is the sequence number of the first record appended after Open. Older
+	// records are returned by Replay
+	replayable uint64
+	next       uint64
+	active     *os.File
+	size       int64
+	closed     bool
+}
+
+// Open opens the log in dir, creating the directory if needed. A partially written entry
+// at the end of the last segment, e.g. after a crash, is discarded.
+func Open(dir string, opts *Options) (*Log, error) {
+	l := &Log{
+		dir:     dir,
+		pending: make(map[uint64]*segment),
+	}
+	if opts != nil {
+		l.opts = *opts
+	}
+	if l.opts.SegmentSize <= 0 {
+		l.opts.SegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*segment
+	for _, path := range paths {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{path: path, first: first})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].first < segments[j].first })
+
+	acked := make(map[uint64]struct{})
+	for i, s := range segments {
+		valid, err := readSegment(s.path, func(typ byte, seq uint64, _ []byte) error {
+			if seq >= l.next {
+				l.next = seq + 1
+			}
+			if typ == entryAck {
+				acked[seq] = struct{}{}
+				return nil
+			}
+			s.pending++
+			l.pending[seq] = s
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if i == len(segments)-1 {
+			// drop a torn write so that new entries can be appended after the valid ones
+			if err := os.Truncate(s.path, valid); err != nil {
+				return nil, err
+			}
+			l.size = valid
+		}
+	}
+	for seq := range acked {
+		if s, ok := l.pending[seq]; ok {
+			s.pending--
+			delete(l.pending, seq)
+		}
+	}
+	l.segments = segments
+	l.replayable = l.next
+
+	if len(l.segments) == 0 {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	} else {
+		last := l.segments[len(l.segments)-1]
+		f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		l.active = f
+	}
+	if err := l.compact(); err != nil {
+		l.active.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append appends a record and returns its sequence number
+func (l *Log) Append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if l.size >= l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	seq := l.next
+	if err := l.write(entryRecord, seq, data); err != nil {
+		return 0, err
+	}
+	l.next++
+	s := l.segments[len(l.segments)-1]
+	s.pending++
+	l.pending[seq] = s
+	return seq, nil
+}
+
+// Ack acknowledges the record with the sequence number so that it is no longer replayed.
+// Acknowledging a record twice, or an unknown record, has no effect.
+func (l *Log) Ack(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	s, ok := l.pending[seq]
+	if !ok {
+		return nil
+	}
+	if err := l.write(entryAck, seq, nil); err != nil {
+		return err
+	}
+	delete(l.pending, seq)
+	s.pending--
+	return l.compact()
+}
+
+// Replay calls fn with every record that was appended before Open and not acknowledged,
+// in the order they were appended. Replay stops at the first error returned by fn.
+func (l *Log) Replay(fn func(seq uint64, data []byte) error) error {
+	l.mu.Lock()
+	var paths []string
+	for _, s := range l.segments {
+		if s.first < l.replayable {
+			paths = append(paths, s.path)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, path := range paths {
+		_, err := readSegment(path, func(typ byte, seq uint64, data []byte) error {
+			if typ != entryRecord || seq >= l.replayable || !l.isPending(seq) {
+				return nil
+			}
+			return fn(seq, data)
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			// compacted while replaying
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of records that were not acknowledged
+func (l *Log) Pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pending)
+}
+
+// Close closes the active segment. Records that were not acknowledged are replayed after
+// the log is opened again.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.active.Close()
+}
+
+func (l *Log) isPending(seq uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.pending[seq]
+	return ok
+}
+
+// write appends an entry to the active segment
+func (l *Log) write(typ byte, seq uint64, data []byte) error {
+	buf := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(data)))
+	buf[8] = typ
+	binary.BigEndian.PutUint64(buf[9:17], seq)
+	copy(buf[headerSize:], data)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
+	if _, err := l.active.Write(buf); err != nil {
+		// a partial entry would hide the entries appended after it from Replay
+		if terr := l.active.Truncate(l.size); terr != nil {
+			return errors.Join(err, terr)
+		}
+		return err
+	}
+	l.size += int64(len(buf))
+	if l.opts.Sync {
+		return l.active.Sync()
+	}
+	return nil
+}
+
+// rotate starts a new active segment
+func (l *Log) rotate() error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.next, segmentExt))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if l.active != nil {
+		if err := l.active.Close(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l.active = f
+	l.size = 0
+	l.segments = append(l.segments, &segment{path: path, first: l.next})
+	return nil
+}
+
+// compact deletes the oldest segments without pending records. Segments are deleted in
+// order, since the acknowledgements of their records may be stored in newer segments.
+// The active segment is never deleted.
+func (l *Log) compact() error {
+	for len(l.segments) > 1 && l.segments[0].pending == 0 {
+		if err := os.Remove(l.segments[0].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+// readSegment calls fn with every valid entry of the segment and returns the offset after
+// the last valid entry. Reading stops at the first incomplete or corrupt entry.
+func readSegment(path string, fn func(typ byte, seq uint64, data []byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		r      = bufio.NewReader(f)
+		header = make([]byte, headerSize)
+		offset int64
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[4:8]))
+		if offset+headerSize+length > info.Size() {
+			// the length of a torn entry can not be trusted
+			return offset, nil
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return offset, nil
+		}
+		crc := crc32.Checksum(header[4:], crcTable)
+		crc = crc32.Update(crc, crcTable, data)
+		if crc != binary.BigEndian.Uint32(header[0:4]) {
+			return offset, nil
+		}
+		if err := fn(header[8], binary.BigEndian.Uint64(header[9:17]), data); err != nil {
+			return offset, err
+		}
+		offset += int64(headerSize + len(data))
+	}
+}
@@ -0,0 +1,143 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func replay(t *testing.T, l *Log) []string {
+	var records []string
+	require.NoError(t, l.Replay(func(_ uint64, data []byte) error {
+		records = append(records, string(data))
+		return nil
+	}))
+	return records
+}
+
+func TestLogReplay(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	var seqs []uint64
+	for _, s := range []string{"hello", "world", "foo"} {
+		seq, err := l.Append([]byte(s))
+		require.NoError(t, err)
+		seqs = append(seqs, seq)
+	}
+	require.Equal(t, []uint64{0, 1, 2}, seqs)
+	require.NoError(t, l.Ack(seqs[1]))
+	// acknowledging twice has no effect
+	require.NoError(t, l.Ack(seqs[1]))
+	require.Equal(t, 2, l.Pending())
+	// records appended after Open are not replayed
+	require.Empty(t, replay(t, l))
+	require.NoError(t, l.Close())
+	_, err = l.Append([]byte("bar"))
+	require.Equal(t, ErrClosed, err)
+
+	l, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello", "foo"}, replay(t, l))
+	seq, err := l.Append([]byte("bar"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), seq)
+	require.NoError(t, l.Ack(seqs[0]))
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo", "bar"}, replay(t, l))
+	require.NoError(t, l.Close())
+}
+
+func TestLogCompaction(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, &Options{SegmentSize: 1})
+	require.NoError(t, err)
+
+	// every record is written to its own segment
+	var seqs []uint64
+	for _, s := range []string{"hello", "world", "foo"} {
+		seq, err := l.Append([]byte(s))
+		require.NoError(t, err)
+		seqs = append(seqs, seq)
+	}
+	segments := func() int {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+		require.NoError(t, err)
+		return len(paths)
+	}
+	require.Equal(t, 3, segments())
+
+	// segments are only deleted once all older segments are acknowledged
+	require.NoError(t, l.Ack(seqs[1]))
+	require.Equal(t, 3, segments())
+	require.NoError(t, l.Ack(seqs[0]))
+	require.Equal(t, 1, segments())
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, replay(t, l))
+	require.NoError(t, l.Close())
+}
+
+func TestLogTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, nil)
+	require.NoError(t, err)
+	_, err = l.Append([]byte("hello"))
+	require.NoError(t, err)
+	_, err = l.Append([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// cut the last record short
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	info, err := os.Stat(paths[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(paths[0], info.Size()-2))
+
+	l, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello"}, replay(t, l))
+	// new records are appended after the last valid record
+	_, err = l.Append([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello", "foo"}, replay(t, l))
+	require.NoError(t, l.Close())
+}
+
+func TestLogCorruption(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, nil)
+	require.NoError(t, err)
+	_, err = l.Append([]byte("hello"))
+	require.NoError(t, err)
+	_, err = l.Append([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	// flip a byte of the data of the last record
+	data[len(data)-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(paths[0], data, 0o644))
+
+	l, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello"}, replay(t, l))
+	require.NoError(t, l.Close())
+}
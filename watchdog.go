@@ -0,0 +1,183 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWatchdogThreshold = time.Minute
+	// maxGoroutineDump bounds the size of the goroutine stacks logged for stalled requests
+	maxGoroutineDump = 64 << 10
+)
+
+// WatchdogConfig configures the detection of stalled PutRecords requests, e.g. on a hung
+// connection, which otherwise go unnoticed until the backlog fills up, see
+// Config.Watchdog.
+type WatchdogConfig struct {
+	// Threshold is how long a request can run before it is considered stalled. Requests
+	// are checked every half Threshold. Default to twice RequestTimeout, or 1m without
+	// RequestTimeout.
+	Threshold time.Duration
+
+	// Recycle cancels stalled requests so that their worker is freed and retries them, as
+	// if they timed out with ErrRequestTimeout. Default to false, stalled requests are only
+	// logged.
+	Recycle bool
+}
+
+func (c *WatchdogConfig) defaults(config *Config) {
+	if c.Threshold == 0 {
+		c.Threshold = defaultWatchdogThreshold
+		if config.RequestTimeout > 0 {
+			c.Threshold = 2 * config.RequestTimeout
+		}
+	}
+}
+
+// watchdog tracks the requests in flight and reports the ones running for longer than the
+// threshold, once each. The stacks of all goroutines are logged with them, to tell where
+// the workers are blocked.
+type watchdog struct {
+	*WatchdogConfig
+	logger Logger
+	clock  Clock
+
+	mu       sync.Mutex
+	requests map[*watchedRequest]struct{}
+	// stalls is the number of stalled requests so far
+	stalls int64
+}
+
+// watchedRequest is a request in flight
+type watchedRequest struct {
+	stream  string
+	records int
+	attempt int
+	start   time.Time
+	// cancel cancels the request if Recycle is set
+	cancel context.CancelFunc
+	// stalled and recycled are guarded by the mutex of the watchdog
+	stalled, recycled bool
+}
+
+func newWatchdog(config *Config) *watchdog {
+	if config.Watchdog == nil {
+		return nil
+	}
+	return &watchdog{
+		WatchdogConfig: config.Watchdog,
+		logger:         config.Logger,
+		clock:          config.Clock,
+		requests:       make(map[*watchedRequest]struct{}),
+	}
+}
+
+// run checks the requests until done is closed
+func (w *watchdog) run(done <-chan struct{}) {
+	ticker := w.clock.NewTicker(w.Threshold / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			w.check(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// watch tracks the request of work sent with ctx, and returns the context to send it with.
+// done must be called once the request returned. A nil watchdog watches nothing.
+func (w *watchdog) watch(ctx context.Context, stream string, work *Work, start time.Time) (context.Context, *watchedRequest) {
+	if w == nil {
+		return ctx, nil
+	}
+	r := &watchedRequest{
+		stream:  stream,
+		records: len(work.records),
+		attempt: work.attempt,
+		start:   start,
+		cancel:  func() {},
+	}
+	if w.Recycle {
+		ctx, r.cancel = context.WithCancel(ctx)
+	}
+	w.mu.Lock()
+	w.requests[r] = struct{}{}
+	w.mu.Unlock()
+	return ctx, r
+}
+
+// done stops tracking the request and reports whether it was recycled
+func (w *watchdog) done(r *watchedRequest) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.requests, r)
+	r.cancel()
+	return r.recycled
+}
+
+// check reports the requests that stalled since the last check, and recycles them if
+// Recycle is set. The goroutines are dumped before the requests are canceled, so that the
+// stacks show where they are blocked.
+func (w *watchdog) check(now time.Time) {
+	var stalled []*watchedRequest
+	w.mu.Lock()
+	for r := range w.requests {
+		if !r.stalled && now.Sub(r.start) >= w.Threshold {
+			r.stalled = true
+			stalled = append(stalled, r)
+		}
+	}
+	w.mu.Unlock()
+	if len(stalled) == 0 {
+		return
+	}
+	atomic.AddInt64(&w.stalls, int64(len(stalled)))
+	goroutines := goroutineDump()
+	for _, r := range stalled {
+		elapsed := now.Sub(r.start)
+		w.logger.Error("stalled request", fmt.Errorf("PutRecords request running for %s", elapsed),
+			LogValue{"stream", r.stream},
+			LogValue{"records", r.records},
+			LogValue{"attempt", r.attempt},
+			LogValue{"elapsed", elapsed.String()},
+			LogValue{"recycle", w.Recycle},
+			LogValue{"goroutines", goroutines},
+		)
+	}
+	if !w.Recycle {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range stalled {
+		// skip the requests that returned in the meantime
+		if _, ok := w.requests[r]; ok {
+			r.recycled = true
+			r.cancel()
+		}
+	}
+}
+
+// stallCount returns the number of stalled requests so far. Zero for a nil watchdog
+func (w *watchdog) stallCount() int64 {
+	if w == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&w.stalls)
+}
+
+// goroutineDump returns the stacks of all goroutines, truncated to maxGoroutineDump bytes
+func goroutineDump() string {
+	buf := make([]byte, maxGoroutineDump)
+	return string(buf[:runtime.Stack(buf, true)])
+}
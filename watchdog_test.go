@@ -0,0 +1,73 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdog(t *testing.T) {
+	var calls int32
+	client := PutterFunc(func(ctx context.Context, input *k.PutRecordsInput, optFns ...func(*k.Options)) (*k.PutRecordsOutput, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the first request hangs until it is recycled
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &k.PutRecordsOutput{
+			FailedRecordCount: aws.Int32(0),
+			Records:           []types.PutRecordsResultEntry{{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("1")}},
+		}, nil
+	})
+	var buf bytes.Buffer
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Backoff:        &FixedBackoff{},
+		Logger:         &StdLogger{Logger: log.New(&buf, "", 0), Level: slog.LevelError},
+		Client:         client,
+		Watchdog:       &WatchdogConfig{Threshold: 50 * time.Millisecond, Recycle: true},
+	})
+	p.Start()
+	defer p.Stop()
+
+	future, err := p.PutWithResult([]byte("hello"), "foo")
+	require.NoError(t, err)
+	require.NoError(t, p.Flush(context.Background()))
+	select {
+	case <-future.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stalled request to be recycled and retried")
+	}
+	require.NoError(t, future.Result().Err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Equal(t, int64(1), p.Stats().Stalls)
+	require.Equal(t, int64(1), p.Stats().Retries)
+	require.Contains(t, buf.String(), "stalled request")
+	require.Contains(t, buf.String(), "goroutine")
+}
+
+func TestWatchdogDefaults(t *testing.T) {
+	c := &WatchdogConfig{}
+	c.defaults(&Config{})
+	require.Equal(t, time.Minute, c.Threshold)
+	c = &WatchdogConfig{}
+	c.defaults(&Config{RequestTimeout: 5 * time.Second})
+	require.Equal(t, 10*time.Second, c.Threshold)
+
+	var w *watchdog
+	ctx, r := w.watch(context.Background(), "foo", &Work{}, time.Now())
+	require.Equal(t, context.Background(), ctx)
+	require.False(t, w.done(r))
+	require.Zero(t, w.stallCount())
+}
@@ -0,0 +1,110 @@
+// Package wire frames aggregated records in a versioned envelope, so that the format of
+// the records sent by the producer can evolve, e.g. with compression flags, headers or
+// encryption markers, while consumers keep decoding the records of older producers.
+//
+// Version 0 is the KPL aggregated record format, see aggregation-format.md, without any
+// frame. Later versions start with a header:
+//
+//	magic      [3]byte 0x4B 0x50 0x57
+//	version    byte
+//	flags      byte    reserved, 0 in version 1
+//	extensions uint16 big endian length, followed by header fields added by later
+//	           revisions of the version, skipped by decoders that do not know them
+//
+// followed by the aggregated record in the KPL format.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Versions of the wire format
+const (
+	// Version0 is the KPL aggregated record format the KCL deaggregates
+	Version0 byte = 0
+	// Version1 frames the KPL aggregated record in the header of the package
+	Version1 byte = 1
+	// LatestVersion is the latest version of the wire format
+	LatestVersion = Version1
+)
+
+// headerSize is the size of a version 1 header without extensions
+const headerSize = 7
+
+var magicNumber = []byte{0x4B, 0x50, 0x57}
+
+// Frame is a decoded record
+type Frame struct {
+	// Version of the wire format the record was encoded with
+	Version byte
+	// Flags of the header
+	Flags byte
+	// Extensions are the header fields unknown to this decoder
+	Extensions []byte
+	// Payload is the aggregated record in the KPL format
+	Payload []byte
+}
+
+// HeaderSize returns the number of bytes Encode adds in front of an aggregated record with
+// version
+func HeaderSize(version byte) int {
+	if version == Version0 {
+		return 0
+	}
+	return headerSize
+}
+
+// AppendHeader appends the header of version to dst, to encode the aggregated record
+// right after it without copying it
+func AppendHeader(dst []byte, version byte) ([]byte, error) {
+	switch version {
+	case Version0:
+		return dst, nil
+	case Version1:
+		dst = append(dst, magicNumber...)
+		return append(dst, version, 0, 0, 0), nil
+	}
+	return nil, fmt.Errorf("wire: unsupported version %d", version)
+}
+
+// Encode frames the aggregated record payload with version
+func Encode(version byte, payload []byte) ([]byte, error) {
+	out, err := AppendHeader(make([]byte, 0, HeaderSize(version)+len(payload)), version)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, payload...), nil
+}
+
+// IsFramed reports whether data starts with the header of a version 1 or later record
+func IsFramed(data []byte) bool {
+	return len(data) > len(magicNumber) && bytes.HasPrefix(data, magicNumber)
+}
+
+// Decode decodes a record of any version. Data without a header is returned as a version
+// 0 record.
+func Decode(data []byte) (*Frame, error) {
+	if !IsFramed(data) {
+		return &Frame{Version: Version0, Payload: data}, nil
+	}
+	switch version := data[len(magicNumber)]; version {
+	case Version1:
+		if len(data) < headerSize {
+			return nil, errors.New("wire: truncated header")
+		}
+		n := int(binary.BigEndian.Uint16(data[5:headerSize]))
+		if len(data) < headerSize+n {
+			return nil, errors.New("wire: truncated header")
+		}
+		f := &Frame{Version: version, Flags: data[4], Payload: data[headerSize+n:]}
+		if n > 0 {
+			f.Extensions = data[headerSize : headerSize+n]
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("wire: unsupported version %d", version)
+	}
+}
@@ -0,0 +1,40 @@
+package wire
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	payload := []byte{0xF3, 0x89, 0x9A, 0xC2, 1, 2, 3}
+	for _, version := range []byte{Version0, Version1} {
+		data, err := Encode(version, payload)
+		require.NoError(t, err)
+		require.Len(t, data, HeaderSize(version)+len(payload))
+		require.Equal(t, version != Version0, IsFramed(data))
+
+		frame, err := Decode(data)
+		require.NoError(t, err)
+		require.Equal(t, &Frame{Version: version, Payload: payload}, frame)
+	}
+}
+
+func TestDecodeExtensions(t *testing.T) {
+	// header fields of a later revision are skipped
+	data := []byte{0x4B, 0x50, 0x57, 1, 0x80, 0, 2, 'x', 'y', 0xF3}
+	frame, err := Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, &Frame{Version: Version1, Flags: 0x80, Extensions: []byte("xy"), Payload: []byte{0xF3}}, frame)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode([]byte{0x4B, 0x50, 0x57, 9, 0})
+	require.EqualError(t, err, "wire: unsupported version 9")
+	_, err = Decode([]byte{0x4B, 0x50, 0x57, 1, 0})
+	require.EqualError(t, err, "wire: truncated header")
+	_, err = Decode([]byte{0x4B, 0x50, 0x57, 1, 0, 0, 5, 'x'})
+	require.EqualError(t, err, "wire: truncated header")
+	_, err = Encode(9, nil)
+	require.EqualError(t, err, "wire: unsupported version 9")
+}
@@ -2,20 +2,48 @@ package producer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
-	"github.com/jpillora/backoff"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// throttledErrorCode is the PutRecordsResultEntry error code for records rejected due to
+// exceeding the shard throughput
+const throttledErrorCode = "ProvisionedThroughputExceededException"
+
 type Work struct {
 	records []*AggregatedRecordRequest
 	size    int
 	reason  string
-	b       *backoff.Backoff
+	// stream the records are put to. Empty means Config.StreamName or Config.StreamARN
+	stream string
+	// shards the records are written to. Only set with OrderedDelivery or ShardConcurrency
+	shards []string
+	// attempt is the number of retries of this work so far
+	attempt int
+	// delay is the last backoff duration
+	delay time.Duration
+}
+
+// logValues returns the structured fields describing the work for log lines
+func (w *Work) logValues() []LogValue {
+	return []LogValue{
+		{"stream", w.stream},
+		{"records", len(w.records)},
+		{"size", w.size},
+		{"attempt", w.attempt},
+	}
 }
 
 func NewWork(records []*AggregatedRecordRequest, size int, reason string) *Work {
@@ -23,35 +51,104 @@ func NewWork(records []*AggregatedRecordRequest, size int, reason string) *Work
 		records: records,
 		size:    size,
 		reason:  reason,
-		b: &backoff.Backoff{
-			Jitter: true,
-		},
 	}
 }
 
 type WorkerPool struct {
 	*Config
+	// ctx is used for PutRecords requests and is canceled by Abort
+	ctx    context.Context
+	cancel context.CancelFunc
+	tracer trace.Tracer
+	// client is Config.Client wrapped with Config.Middlewares
+	client Putter
+	// stats are the counters of Producer.Stats
+	stats *stats
+	// breaker holds requests after sustained failures. Nil if disabled
+	breaker *circuitBreaker
+	// mirror puts every batch to the mirror stream. Nil if disabled
+	mirror *mirror
+	// failover routes requests to the standby stream. Nil if disabled
+	failover *failover
+	// watchdog reports stalled requests. Nil if disabled
+	watchdog *watchdog
+	// limiters pace requests to stay below the per shard limits of each stream. Empty if
+	// disabled
+	limiters   map[string]*RateLimiter
+	limitersMu sync.RWMutex
+	// shardKey returns the key of the shard an entry of stream is written to. Used with
+	// OrderedDelivery
+	shardKey func(stream string, entry types.PutRecordsRequestEntry) string
+	// shardID returns the id of the shard an entry of stream is written to, or empty if
+	// unknown. Used for the shard stats of throttled records. Nil means unknown
+	shardID func(stream string, entry types.PutRecordsRequestEntry) string
+	// resolved is called with the user records the pool delivered or failed, see
+	// Producer.resolved. Nil if unused
+	resolved func(userRecords []UserRecord)
+	// stale is called when a record of stream was written to another shard than shardID
+	// predicted, see Producer.shardsStale. Nil if unused
+	stale func(stream string)
+	// busy holds the shards with an in flight or retrying request and the work it belongs
+	// to. Used with OrderedDelivery
+	busy map[string]*Work
+	// sending holds the number of requests in flight of each shard. Used with
+	// ShardConcurrency
+	sending map[string]int
+	busyMu  sync.Mutex
+	// abandoned holds the records that were not sent because the pool was aborted
+	abandoned   []*AggregatedRecordRequest
+	abandonedMu sync.Mutex
 	input      chan *AggregatedRecordRequest
+	batches    chan []*AggregatedRecordRequest
 	unfinished chan []*AggregatedRecordRequest
 	flush      chan struct{}
+	idle       chan chan struct{}
 	pause      chan struct{}
+	reconfigs  chan configUpdate
+	// held is set to 1 while the pool does not send requests, see Producer.Pause
+	held int32
+	// wake wakes up the loop when held changes or a request completed
+	wake       chan struct{}
 	done       chan struct{}
 	errs       chan error
 }
 
 func NewWorkerPool(config *Config) *WorkerPool {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &WorkerPool{
 		Config:     config,
+		ctx:        ctx,
+		cancel:     cancel,
+		tracer:     config.TracerProvider.Tracer(tracerName),
+		client:     chain(config.Client, config.Middlewares),
+		stats:      &stats{connections: int64(config.MaxConnections)},
+		breaker:    newCircuitBreaker(config.CircuitBreaker, config.Logger, config.Clock),
+		mirror:     newMirror(config),
+		failover:   newFailover(config),
+		watchdog:   newWatchdog(config),
+		limiters:   make(map[string]*RateLimiter),
+		busy:       make(map[string]*Work),
+		sending:    make(map[string]int),
 		input:      make(chan *AggregatedRecordRequest),
+		batches:    make(chan []*AggregatedRecordRequest),
 		unfinished: make(chan []*AggregatedRecordRequest),
 		flush:      make(chan struct{}),
+		idle:       make(chan chan struct{}),
 		pause:      make(chan struct{}),
+		reconfigs:  make(chan configUpdate),
+		wake:       make(chan struct{}, 1),
 		done:       make(chan struct{}),
 		errs:       make(chan error),
 	}
 }
 
 func (wp *WorkerPool) Start() {
+	if wp.mirror != nil {
+		wp.mirror.start()
+	}
+	if wp.watchdog != nil {
+		go wp.watchdog.run(wp.done)
+	}
 	go wp.loop()
 }
 
@@ -59,10 +156,97 @@ func (wp *WorkerPool) Errors() chan error {
 	return wp.errs
 }
 
+// setLimiter sets the rate limiter for requests to stream
+func (wp *WorkerPool) setLimiter(stream string, limiter *RateLimiter) {
+	wp.limitersMu.Lock()
+	wp.limiters[stream] = limiter
+	wp.limitersMu.Unlock()
+}
+
+// limiter returns the rate limiter for requests to stream, or nil if there is none
+func (wp *WorkerPool) limiter(stream string) *RateLimiter {
+	wp.limitersMu.RLock()
+	defer wp.limitersMu.RUnlock()
+	return wp.limiters[stream]
+}
+
+// next removes and returns the first work of inflight that can be sent. With
+// OrderedDelivery, work is only sent once none of its shards are busy with other work.
+// The shards are busy until the work is delivered or has failed permanently. With
+// ShardConcurrency, work is only sent while its shard has less requests in flight.
+func (wp *WorkerPool) next(inflight []*Work) (*Work, []*Work) {
+	if len(inflight) == 0 {
+		return nil, inflight
+	}
+	if !wp.OrderedDelivery && wp.ShardConcurrency == 0 {
+		return inflight[0], inflight[1:]
+	}
+
+	wp.busyMu.Lock()
+	defer wp.busyMu.Unlock()
+	if wp.ShardConcurrency > 0 {
+		for i, work := range inflight {
+			if shard := work.shards[0]; wp.sending[shard] < wp.ShardConcurrency {
+				wp.sending[shard]++
+				return work, append(inflight[:i:i], inflight[i+1:]...)
+			}
+		}
+		return nil, inflight
+	}
+next:
+	for i, work := range inflight {
+		for _, shard := range work.shards {
+			if owner, ok := wp.busy[shard]; ok && owner != work {
+				continue next
+			}
+		}
+		for _, shard := range work.shards {
+			wp.busy[shard] = work
+		}
+		return work, append(inflight[:i:i], inflight[i+1:]...)
+	}
+	return nil, inflight
+}
+
+// sent releases the shard of work once its request completed, see ShardConcurrency, and
+// wakes up the loop to send the work that waited for it
+func (wp *WorkerPool) sent(work *Work) {
+	if wp.ShardConcurrency > 0 {
+		wp.busyMu.Lock()
+		if wp.sending[work.shards[0]]--; wp.sending[work.shards[0]] == 0 {
+			delete(wp.sending, work.shards[0])
+		}
+		wp.busyMu.Unlock()
+	}
+	select {
+	case wp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish releases the shards of work after it was delivered or failed permanently
+func (wp *WorkerPool) finish(work *Work) {
+	if !wp.OrderedDelivery {
+		return
+	}
+	wp.busyMu.Lock()
+	for _, shard := range work.shards {
+		if wp.busy[shard] == work {
+			delete(wp.busy, shard)
+		}
+	}
+	wp.busyMu.Unlock()
+}
+
 func (wp *WorkerPool) Add(record *AggregatedRecordRequest) {
 	wp.input <- record
 }
 
+// AddBatch adds the records to the pool at once. The pool owns the slice afterwards.
+func (wp *WorkerPool) AddBatch(records []*AggregatedRecordRequest) {
+	wp.batches <- records
+}
+
 func (wp *WorkerPool) Pause() []*AggregatedRecordRequest {
 	wp.pause <- struct{}{}
 	return <-wp.unfinished
@@ -82,79 +266,287 @@ func (wp *WorkerPool) Flush() {
 	wp.flush <- struct{}{}
 }
 
+// Idle returns a channel that is closed once the pool has no buffered, in flight or
+// retrying records. Callers should make sure no records are added while waiting.
+func (wp *WorkerPool) Idle() <-chan struct{} {
+	ch := make(chan struct{})
+	wp.idle <- ch
+	return ch
+}
+
 func (wp *WorkerPool) Close() {
 	close(wp.input)
 }
 
+// Abort cancels in flight requests and stops any further sends or retries. Work that
+// has not been delivered is collected and can be retrieved with Abandoned.
+func (wp *WorkerPool) Abort() {
+	wp.cancel()
+}
+
+// Abandoned returns the records that were not delivered due to Abort
+func (wp *WorkerPool) Abandoned() []*AggregatedRecordRequest {
+	wp.abandonedMu.Lock()
+	defer wp.abandonedMu.Unlock()
+	return wp.abandoned
+}
+
+func (wp *WorkerPool) abandon(work *Work) {
+	wp.abandonedMu.Lock()
+	wp.abandoned = append(wp.abandoned, work.records...)
+	wp.abandonedMu.Unlock()
+}
+
+// packedBatches is the number of batches of a stream that are filled at the same time.
+// Records are packed into the first batch with room left, so that a record that does not
+// fit does not send a batch that is far from full.
+const packedBatches = 8
+
+// batch is the buffer of records of a single stream, or a single shard with
+// ShardConcurrency, waiting to be sent
+type batch struct {
+	stream  string
+	records []*AggregatedRecordRequest
+	size    int
+	// shards of the records. Only set with OrderedDelivery
+	shards map[string]struct{}
+	// start is the time the first record was added, see Config.RequestMaxDelay
+	start time.Time
+}
+
+// fits reports whether a record of size bytes written to shard can be added to the batch
+func (b *batch) fits(size int, shard string, limit int) bool {
+	if b.size+size > limit {
+		return false
+	}
+	_, ok := b.shards[shard]
+	return !ok
+}
+
 func (wp *WorkerPool) loop() {
 	var (
-		buf                   = make([]*AggregatedRecordRequest, 0, wp.BatchCount)
-		inflight    []*Work   = nil
-		retry                 = make(chan *Work)
-		size                  = 0
-		connections semaphore = make(chan struct{}, wp.MaxConnections)
-		closed      semaphore = make(chan struct{}, wp.MaxConnections)
+		// batches of each stream, or each shard with ShardConcurrency, being filled. Only
+		// streams with buffered records have an entry
+		bufs = make(map[string][]*batch)
+		// records put with WithPriority of each stream, or each shard with ShardConcurrency,
+		// sent before any other work once a connection is open
+		urgent                = make(map[string]*batch)
+		inflight    []*Work = nil
+		retry               = make(chan *Work)
+		connections         = newCountingSemaphore(wp.MaxConnections)
 	)
 
-	// create new work item from buffer and append to inflight work
-	flushBuf := func(reason string) {
-		if size == 0 {
-			return
+	// prepend work item to start of inflight buffer. Work that needs to be retried is
+	// prepended for prioritization over new work
+	prepend := func(work *Work) {
+		inf := make([]*Work, len(inflight)+1)
+		inf[0] = work
+		copy(inf[1:], inflight)
+		inflight = nil
+		inflight = inf
+	}
+
+	// create new work items from the priority records of all streams and prepend them to
+	// inflight work
+	flushUrgent := func() {
+		for key, buf := range urgent {
+			delete(urgent, key)
+			work := NewWork(buf.records, buf.size, "priority")
+			work.stream = buf.stream
+			if wp.ShardConcurrency > 0 {
+				work.shards = []string{key}
+			}
+			prepend(work)
+		}
+	}
+
+	// create new work item from the i-th batch of a stream and append to inflight work
+	flushBatch := func(key string, i int, reason string) {
+		buf := bufs[key][i]
+		if m, ok := wp.Metrics.(StageMetrics); ok {
+			m.RequestDelay(wp.Clock.Now().Sub(buf.start))
+		}
+		if len(bufs[key]) == 1 {
+			delete(bufs, key)
+		} else {
+			bufs[key] = append(bufs[key][:i:i], bufs[key][i+1:]...)
+		}
+		work := NewWork(buf.records, buf.size, reason)
+		work.stream = buf.stream
+		for shard := range buf.shards {
+			work.shards = append(work.shards, shard)
+		}
+		if wp.ShardConcurrency > 0 {
+			work.shards = []string{key}
 		}
-		work := NewWork(buf, size, reason)
-		buf = make([]*AggregatedRecordRequest, 0, wp.BatchCount)
-		size = 0
 		inflight = append(inflight, work)
 	}
 
-	// Push aggregated record into the buffer. Flush buffer into new work item if push will
-	// exceed size limits
+	// create new work items from the buffers of all streams
+	flushBuf := func(reason string) {
+		flushUrgent()
+		for key, bs := range bufs {
+			for range bs {
+				flushBatch(key, 0, reason)
+			}
+		}
+	}
+
+	// create new work items from the batches whose first record was added RequestMaxDelay
+	// or longer before now
+	flushDelayed := func(now time.Time) {
+		for key, bs := range bufs {
+			// batches are removed from the end so that the indexes of the others hold
+			for i := len(bs) - 1; i >= 0; i-- {
+				if now.Sub(bs[i].start) >= wp.RequestMaxDelay {
+					flushBatch(key, i, "request max delay")
+				}
+			}
+		}
+	}
+
+	// Push aggregated record into the first batch of its stream it fits in. If none has
+	// room left, the fullest batch is flushed into a new work item to make room for a new
+	// batch
 	push := func(record *AggregatedRecordRequest) {
+		if record.stream == "" {
+			record.stream = wp.defaultStream()
+		}
+		wp.recordAggregated(record)
 		rsize := len(record.Entry.Data) + len([]byte(*record.Entry.PartitionKey))
-		if size+rsize > wp.BatchSize {
-			// if this record would overflow the batch buffer, send it inflight
-			flushBuf("batch size")
+		key := record.stream
+		var shard string
+		if wp.OrderedDelivery || wp.ShardConcurrency > 0 {
+			shard = wp.shardKey(record.stream, record.Entry)
+		}
+		if wp.ShardConcurrency > 0 {
+			// each shard is batched and sent on its own
+			key = shard
+		}
+		if record.priority {
+			buf, ok := urgent[key]
+			if ok && (len(buf.records) >= wp.BatchCount || buf.size+rsize > wp.BatchSize) {
+				flushUrgent()
+				ok = false
+			}
+			if !ok {
+				buf = &batch{stream: record.stream}
+				urgent[key] = buf
+			}
+			buf.records = append(buf.records, record)
+			buf.size += rsize
+			return
+		}
+		// Kinesis does not guarantee the order of records in a single request, so with
+		// OrderedDelivery a request may only contain one record per shard, and records are
+		// not packed into older batches
+		limit := packedBatches
+		if wp.OrderedDelivery {
+			limit = 1
+		}
+		i := -1
+		for j, buf := range bufs[key] {
+			if buf.fits(rsize, shard, wp.BatchSize) {
+				i = j
+				break
+			}
+		}
+		if i < 0 && len(bufs[key]) >= limit {
+			fullest := 0
+			for j, buf := range bufs[key] {
+				if buf.size > bufs[key][fullest].size {
+					fullest = j
+				}
+			}
+			reason := "batch size"
+			if wp.OrderedDelivery && bufs[key][fullest].size+rsize <= wp.BatchSize {
+				reason = "ordered delivery"
+			}
+			flushBatch(key, fullest, reason)
 		}
-		buf = append(buf, record)
-		size += rsize
-		if len(buf) >= wp.BatchCount {
-			flushBuf("batch length")
+		if i < 0 {
+			buf := &batch{stream: record.stream, records: make([]*AggregatedRecordRequest, 0, wp.BatchCount), start: wp.Clock.Now()}
+			if wp.OrderedDelivery {
+				buf.shards = make(map[string]struct{})
+			}
+			bufs[key] = append(bufs[key], buf)
+			i = len(bufs[key]) - 1
+		}
+		buf := bufs[key][i]
+		buf.records = append(buf.records, record)
+		buf.size += rsize
+		if wp.OrderedDelivery {
+			buf.shards[shard] = struct{}{}
+		}
+		if len(buf.records) >= wp.BatchCount {
+			flushBatch(key, i, "batch length")
+		} else if buf.size >= wp.BatchSize {
+			flushBatch(key, i, "batch size")
 		}
 	}
 
-	// prepend work item to start of inflight buffer. Work that needs to be retried is
-	// prepended for prioritization over new work
-	prepend := func(work *Work) {
-		inf := make([]*Work, len(inflight)+1)
-		inf[0] = work
-		copy(inf[1:], inflight)
-		inflight = nil
-		inflight = inf
-	}
+	// number of running sends. Updated atomically so that waiters notified of an idle pool
+	// observe the effects of completed sends
+	var active int64
 
 	do := func(work *Work) {
 		failed := wp.send(work)
+		if failed == nil {
+			wp.finish(work)
+		}
+		wp.sent(work)
 		if failed != nil {
 			retry <- failed
 		}
+		atomic.AddInt64(&active, -1)
 		connections.release()
 	}
 
 	var (
-		flush     chan struct{}                 = wp.flush
-		pause     chan struct{}                 = wp.pause
-		input     chan *AggregatedRecordRequest = wp.input
+		flush chan struct{}                 = wp.flush
+		pause chan struct{}                 = wp.pause
+		input chan *AggregatedRecordRequest = wp.input
+		// connections closed for good after stopping. They keep holding their slot, so the
+		// loop can exit when all have closed
 		completed int
+		// blocked is set when all inflight work waits for busy shards. No connection is
+		// opened until the next event of the loop, e.g. a completed request
+		blocked bool
+		// waiters to notify once the pool is idle
+		idle []chan struct{}
+		// delayTickC checks the age of the batches with RequestMaxDelay
+		delayTickC <-chan time.Time
 	)
 
-	// fill up the closed connection semaphore before starting the loop so that when
-	// connections are closed after stopping, the loop can exit when all have closed
-	closed.wait(wp.MaxConnections)
+	if wp.RequestMaxDelay > 0 {
+		delayTick := wp.Clock.NewTicker(max(wp.RequestMaxDelay/4, time.Millisecond))
+		delayTickC = delayTick.C()
+		defer delayTick.Stop()
+	}
 
 	defer close(wp.done)
 
 	for {
+		if len(idle) > 0 && len(bufs) == 0 && len(urgent) == 0 && len(inflight) == 0 && atomic.LoadInt64(&active) == 0 {
+			for _, ch := range idle {
+				close(ch)
+			}
+			idle = nil
+		}
+
+		atomic.StoreInt64(&wp.stats.pending, int64(len(inflight)))
+
+		// no connection is opened while held
+		open := connections.ready()
+		if atomic.LoadInt32(&wp.held) == 1 || blocked {
+			open = nil
+		}
+		blocked = false
+
 		select {
+		case <-wp.wake:
+		case ch := <-wp.idle:
+			idle = append(idle, ch)
 		case record, ok := <-input:
 			if !ok {
 				input = nil
@@ -162,34 +554,55 @@ func (wp *WorkerPool) loop() {
 			} else {
 				push(record)
 			}
+		case records := <-wp.batches:
+			for _, record := range records {
+				push(record)
+			}
 		case <-flush:
 			flushBuf("flush interval")
-		case connections <- struct{}{}:
+		case now := <-delayTickC:
+			flushDelayed(now)
+		case <-open:
+			// the limit may have been lowered since
+			if !connections.tryAcquire() {
+				break
+			}
 			// acquired an open connection
-			// check to see if there is any work in flight that needs to be sent
+			// check to see if there is any work in flight that needs to be sent, starting
+			// with the priority records
+			flushUrgent()
 			var work *Work
-			if len(inflight) > 0 {
-				work, inflight = inflight[0], inflight[1:]
-			}
+			work, inflight = wp.next(inflight)
 
 			if work != nil {
+				atomic.AddInt64(&active, 1)
 				go do(work)
-			} else if input == nil {
+			} else if input == nil && len(inflight) == 0 {
 				// If input is nil, no more work will be coming so close the connection for good
-				closed.release()
+				completed++
+				if completed == wp.MaxConnections {
+					return
+				}
 			} else {
 				// otherwise release it
 				connections.release()
-			}
-		case closed <- struct{}{}:
-			// this case will block until the connections case releases the closed semaphore
-			completed++
-			if completed == wp.MaxConnections {
-				return
+				blocked = len(inflight) > 0
 			}
 		case failed := <-retry:
 			// prioritize work that needs to be resent due to throttling
 			prepend(failed)
+		case update := <-wp.reconfigs:
+			wp.BatchCount, wp.BatchSize = update.batchCount, update.batchSize
+			// the connections are not resized after stopping, as the loop exits once all of
+			// them have closed
+			if update.maxConnections != wp.MaxConnections && input != nil {
+				// the requests in flight complete on their own, no new one is sent above the
+				// lowered limit
+				wp.MaxConnections = update.maxConnections
+				atomic.StoreInt64(&wp.stats.connections, int64(wp.MaxConnections))
+				connections.resize(wp.MaxConnections)
+			}
+			wp.reconfigs <- update
 		case <-pause:
 			// collect failed records that need retry from open connections
 			var wg sync.WaitGroup
@@ -201,11 +614,15 @@ func (wp *WorkerPool) loop() {
 				}
 			}()
 			// wait for open connections to finish
-			connections.wait(wp.MaxConnections - completed)
+			connections.wait(completed)
 			// safe to close retry channel now that no connections are open
 			close(retry)
 			// wait to finish collecting all failed requests
 			wg.Wait()
+			// the inflight work is returned to the producer, so no shard is busy anymore
+			wp.busyMu.Lock()
+			wp.busy = make(map[string]*Work)
+			wp.busyMu.Unlock()
 			// flush out anything remaining in the buffer
 			flushBuf("pause")
 			// capture the inflight requests that did not get finished
@@ -218,11 +635,9 @@ func (wp *WorkerPool) loop() {
 			inflight = nil
 			// send the drained records
 			wp.unfinished <- drained
-			// reset closed connections
-			closed.wait(completed)
+			// reopen connections, including the closed ones
 			completed = 0
-			// reopen connections
-			connections.open(wp.MaxConnections)
+			connections.reset()
 			// collect records to push after resuming
 			// this will block the pool until Resume() is called
 			records := <-wp.unfinished
@@ -238,81 +653,522 @@ func (wp *WorkerPool) loop() {
 	}
 }
 
+// configure changes the batch limits and connections of a pool that has not been started
+func (wp *WorkerPool) configure(update configUpdate) {
+	wp.BatchCount, wp.BatchSize, wp.MaxConnections = update.batchCount, update.batchSize, update.maxConnections
+	atomic.StoreInt64(&wp.stats.connections, int64(wp.MaxConnections))
+}
+
+// reconfigure changes the batch limits and connections of the running pool
+func (wp *WorkerPool) reconfigure(update configUpdate) {
+	wp.reconfigs <- update
+	<-wp.reconfigs
+}
+
 func (wp *WorkerPool) send(work *Work) *Work {
+	if wp.ctx.Err() != nil {
+		wp.abandon(work)
+		return nil
+	}
+
+	if work.stream == "" {
+		work.stream = wp.defaultStream()
+	}
+	streamName := work.stream
+
+	if work.records = wp.unexpired(work.records, work.attempt); len(work.records) == 0 {
+		return nil
+	}
+	if work.records = wp.intact(work.records, work.attempt); len(work.records) == 0 {
+		return nil
+	}
+	work.size = 0
+	for _, r := range work.records {
+		work.size += len(r.Entry.Data) + len(aws.ToString(r.Entry.PartitionKey))
+	}
+
 	count := len(work.records)
-	wp.Logger.Info("flushing records", LogValue{"reason", work.reason}, LogValue{"records", count})
+	wp.Logger.Info("flushing records", append(work.logValues(), LogValue{"reason", work.reason})...)
 
+	// the entries are not pooled, Putters and middlewares may retain the request
 	kinesisRecords := make([]types.PutRecordsRequestEntry, count)
 	for i := 0; i < count; i++ {
 		kinesisRecords[i] = work.records[i].Entry
 	}
+	if wp.mirror != nil && work.attempt == 0 {
+		wp.mirror.add(streamName, kinesisRecords, wp.Clock.Now())
+	}
 
-	out, err := wp.Client.PutRecords(context.Background(), &k.PutRecordsInput{
-		StreamName: &wp.StreamName,
-		Records:    kinesisRecords,
-	})
+	for d, changed := wp.breaker.delay(); d > 0; d, changed = wp.breaker.delay() {
+		if !wp.sleepUntil(d, changed) {
+			wp.abandon(work)
+			return nil
+		}
+	}
+
+	if limiter := wp.limiter(streamName); limiter != nil {
+		if delay := limiter.Reserve(kinesisRecords); delay > 0 && !wp.sleep(delay) {
+			wp.abandon(work)
+			return nil
+		}
+	}
+
+	client := wp.client
+	standby, probe := wp.failover.route()
+	if standby {
+		client = wp.failover.client
+		streamName = wp.failover.stream(streamName)
+	}
+
+	putCtx := newRequestContext(wp.ctx, work)
+	defer putCtx.stop()
+	ctx, span := startPutRecordsSpan(putCtx, wp.tracer, streamName, work)
+	reqCtx, cancel := ctx, context.CancelFunc(func() {})
+	if wp.RequestTimeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, wp.RequestTimeout)
+	}
+	start := wp.Clock.Now()
+	if work.attempt == 0 {
+		wp.recordsBuffered(work, start)
+	}
+	reqCtx, watched := wp.watchdog.watch(reqCtx, streamName, work, start)
+	atomic.AddInt64(&wp.stats.inflight, 1)
+	input := &k.PutRecordsInput{Records: kinesisRecords}
+	if isStreamARN(streamName) {
+		input.StreamARN = &streamName
+	} else {
+		input.StreamName = &streamName
+	}
+	out, err := client.PutRecords(reqCtx, input, wp.ClientOptions...)
+	atomic.AddInt64(&wp.stats.inflight, -1)
+	latency := wp.Clock.Now().Sub(start)
+	metadata := responseMetadata(out, err)
+	// the callers of every record gave up, drop the records rather than retrying them
+	canceled := err != nil && putCtx.canceled()
+	recycled := wp.watchdog.done(watched)
+	if err != nil && !canceled && wp.ctx.Err() == nil {
+		if recycled {
+			err = &ErrRequestTimeout{Timeout: wp.watchdog.Threshold, Err: err}
+		} else if errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+			err = &ErrRequestTimeout{Timeout: wp.RequestTimeout, Err: err}
+		}
+	}
+	cancel()
+	if wp.ctx.Err() == nil && !canceled {
+		failed := err != nil || int(aws.ToInt32(out.FailedRecordCount)) == count
+		wp.breaker.record(failed)
+		if failed {
+			atomic.AddInt64(&wp.stats.consecutiveFailures, 1)
+		} else {
+			atomic.StoreInt64(&wp.stats.consecutiveFailures, 0)
+		}
+		if !standby {
+			wp.failover.record(probe, failed)
+		}
+	}
 
 	if err != nil {
-		wp.Logger.Error("send", err)
-		for _, r := range work.records {
-			failure := &FailureRecord{
-				Err:          err,
-				PartitionKey: *r.Entry.PartitionKey,
-				UserRecords:  r.UserRecords,
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		if wp.ctx.Err() != nil {
+			wp.abandon(work)
+			return nil
+		}
+		if canceled {
+			wp.unexpired(work.records, work.attempt)
+			return nil
+		}
+		wp.requestAttempt(work, streamName, start, err, metadata)
+		if code := errorCode(err); code != "" {
+			wp.recordsFailed(map[string]int{code: count})
+		}
+		if class := wp.ErrorClassifier(err); class != ErrorFatal {
+			wp.Logger.Warn("send", append(append(work.logValues(), LogValue{"error", err}, LogValue{"class", class}), metadata.logValues()...)...)
+			if class == ErrorThrottle {
+				wp.Metrics.RecordsThrottled(count)
+				atomic.AddInt64(&wp.stats.throttles, int64(count))
+				shards := make(map[string]*shardCounters)
+				for _, r := range work.records {
+					wp.entryShard(shards, streamName, r.Entry).throttles++
+				}
+				wp.shardsSent(streamName, shards, latency)
+				if limiter := wp.limiter(streamName); limiter != nil {
+					limiter.Throttled(kinesisRecords)
+				}
 			}
-			if r.Entry.ExplicitHashKey != nil {
-				failure.ExplicitHashKey = *r.Entry.ExplicitHashKey
+			if wp.retriesExhausted(work) {
+				err = &ErrMaxRetriesExceeded{Retries: work.attempt, Err: err}
+			} else {
+				wp.Metrics.RecordsRetried(count)
+				atomic.AddInt64(&wp.stats.retries, int64(count))
+				work.reason = "retry"
+				return wp.backoff(work, int32(count))
 			}
-			wp.errs <- failure
+		}
+		wp.Logger.Error("send", err, append(work.logValues(), metadata.logValues()...)...)
+		for _, r := range work.records {
+			wp.fail(r, err, work.attempt+1)
 		}
 		return nil
 	}
 
-	if wp.Verbose {
+	// the values of every record are only built if they are logged
+	if logEnabled(wp.Logger, slog.LevelDebug) {
 		for i, r := range out.Records {
-			values := make([]LogValue, 2)
+			values := []LogValue{{"stream", streamName}, {"attempt", work.attempt}}
 			if r.ErrorCode != nil {
-				values[0] = LogValue{"ErrorCode", *r.ErrorCode}
-				values[1] = LogValue{"ErrorMessage", *r.ErrorMessage}
+				values = append(values,
+					LogValue{"error_code", aws.ToString(r.ErrorCode)},
+					LogValue{"error_message", aws.ToString(r.ErrorMessage)},
+				)
 			} else {
-				values[0] = LogValue{"ShardId", *r.ShardId}
-				values[1] = LogValue{"SequenceNumber", *r.SequenceNumber}
+				values = append(values,
+					LogValue{"shard_id", aws.ToString(r.ShardId)},
+					LogValue{"sequence_number", aws.ToString(r.SequenceNumber)},
+				)
 			}
-			wp.Logger.Info(fmt.Sprintf("Result[%d]", i), values...)
+			wp.Logger.Debug(fmt.Sprintf("Result[%d]", i), values...)
 		}
 	}
 
+	var (
+		userRecords int
+		throttled   int
+		units       int
+		ingested    int
+		delivered   int
+		bytes       int
+		// number of failed records by error code
+		errorCodes = make(map[string]int)
+		// records delivered and throttled by shard id
+		shards = make(map[string]*shardCounters)
+		// records rejected with an ErrorFatal error, which are not retried
+		fatal     []*AggregatedRecordRequest
+		fatalErrs []error
+		// entries rejected with an ErrorThrottle error
+		throttledEntries []types.PutRecordsRequestEntry
+		// a record was written to another shard than its shard map predicted
+		stale bool
+	)
+	for _, r := range work.records {
+		userRecords += len(r.UserRecords)
+	}
+	for i, r := range out.Records {
+		if r.ErrorCode != nil {
+			errorCodes[*r.ErrorCode]++
+			entryErr := &PutRecordsEntryError{Code: *r.ErrorCode, Message: aws.ToString(r.ErrorMessage)}
+			if i < count {
+				work.records[i].recordAttempt(Attempt{
+					Time:             start,
+					Error:            entryErr.Error(),
+					ErrorCode:        entryErr.Code,
+					ShardId:          wp.entryShardID(streamName, work.records[i]),
+					ResponseMetadata: metadata,
+				})
+			}
+			switch wp.ErrorClassifier(entryErr) {
+			case ErrorThrottle:
+				throttled++
+				if i < count {
+					wp.entryShard(shards, streamName, work.records[i].Entry).throttles++
+					throttledEntries = append(throttledEntries, work.records[i].Entry)
+				}
+			case ErrorFatal:
+				if i < count {
+					fatal = append(fatal, work.records[i])
+					fatalErrs = append(fatalErrs, entryErr)
+				}
+			}
+		} else if i < count {
+			entry := work.records[i].Entry
+			size := len(entry.Data) + len(aws.ToString(entry.PartitionKey))
+			units += payloadUnits(entry)
+			ingested += (size + 1<<10 - 1) &^ (1<<10 - 1)
+			delivered++
+			bytes += size
+			shard := shardCounter(shards, aws.ToString(r.ShardId))
+			if wp.stale != nil && !stale {
+				predicted := wp.entryShardID(streamName, work.records[i])
+				stale = predicted != "" && predicted != aws.ToString(r.ShardId)
+			}
+			shard.records++
+			shard.bytes += int64(size)
+			wp.resolve(work.records[i].UserRecords, PutResult{
+				ShardId:        aws.ToString(r.ShardId),
+				SequenceNumber: aws.ToString(r.SequenceNumber),
+			})
+		}
+	}
+	wp.Metrics.RequestSent(count, userRecords, work.size, latency)
+	atomic.AddInt64(&wp.stats.requests, 1)
+	atomic.AddInt64(&wp.stats.latency, int64(latency))
+	atomic.AddInt64(&wp.stats.units, int64(units))
+	atomic.AddInt64(&wp.stats.ingested, int64(ingested))
+	atomic.AddInt64(&wp.stats.records, int64(delivered))
+	atomic.AddInt64(&wp.stats.bytes, int64(bytes))
+	wp.shardsSent(streamName, shards, latency)
+	if stale {
+		wp.stale(streamName)
+	}
+	if limiter := wp.limiter(streamName); limiter != nil && len(throttledEntries) > 0 {
+		limiter.Throttled(throttledEntries)
+	}
+	span.SetAttributes(
+		failedCountKey.Int(int(aws.ToInt32(out.FailedRecordCount))),
+		throttledCountKey.Int(throttled),
+	)
+	span.End()
+
 	failed := *out.FailedRecordCount
 	if failed == 0 {
 		return nil
 	}
+	wp.recordsFailed(errorCodes)
 
-	duration := work.b.Duration()
+	if throttled > 0 {
+		wp.Metrics.RecordsThrottled(throttled)
+		atomic.AddInt64(&wp.stats.throttles, int64(throttled))
+	}
 
-	wp.Logger.Info(
-		"put failures",
-		LogValue{"failures", failed},
-		LogValue{"backoff", duration.String()},
-	)
-	time.Sleep(duration)
+	for i, r := range fatal {
+		wp.fail(r, fatalErrs[i], work.attempt+1)
+	}
+	failed -= int32(len(fatal))
+	if failed <= 0 {
+		return nil
+	}
+
+	if wp.retriesExhausted(work) {
+		for i, r := range out.Records {
+			if r.ErrorCode != nil && i < count && !containsRecord(fatal, work.records[i]) {
+				wp.fail(work.records[i], &ErrMaxRetriesExceeded{
+					Retries: work.attempt,
+					Err: &PutRecordsEntryError{
+						Code:    *r.ErrorCode,
+						Message: aws.ToString(r.ErrorMessage),
+					},
+				}, work.attempt+1)
+			}
+		}
+		return nil
+	}
+	wp.Metrics.RecordsRetried(int(failed))
+	atomic.AddInt64(&wp.stats.retries, int64(failed))
 
 	// change the logging state for the next itertion
 	work.reason = "retry"
-	work.records = failures(work.records, out.Records, failed)
+	work.records = failures(work.records, out.Records, fatal, failed)
+	work.size = 0
+	for _, r := range work.records {
+		work.size += len(r.Entry.Data) + len(aws.ToString(r.Entry.PartitionKey))
+	}
+	return wp.backoff(work, failed, append([]LogValue{{"error_codes", formatErrorCodes(errorCodes)}}, metadata.logValues()...)...)
+}
+
+// shardsSent counts the kinesis records of a PutRecords request to stream by shard in the
+// stats, and reports them to the Metrics if they implement ShardMetrics
+func (wp *WorkerPool) shardsSent(stream string, shards map[string]*shardCounters, latency time.Duration) {
+	wp.stats.sent(stream, shards, latency)
+	m, ok := wp.Metrics.(ShardMetrics)
+	if !ok {
+		return
+	}
+	for shardID, sent := range shards {
+		if sent.records > 0 {
+			m.ShardRecordsSent(stream, shardID, int(sent.records), int(sent.bytes), latency)
+		}
+		if sent.throttles > 0 {
+			m.ShardRecordsThrottled(stream, shardID, int(sent.throttles))
+		}
+	}
+}
+
+// entryShard returns the counters of the shard entry is written to, which Kinesis does not
+// tell for failed records
+func (wp *WorkerPool) entryShard(shards map[string]*shardCounters, stream string, entry types.PutRecordsRequestEntry) *shardCounters {
+	var shardID string
+	if wp.shardID != nil {
+		shardID = wp.shardID(stream, entry)
+	}
+	return shardCounter(shards, shardID)
+}
+
+func shardCounter(shards map[string]*shardCounters, shardID string) *shardCounters {
+	shard, ok := shards[shardID]
+	if !ok {
+		shard = &shardCounters{}
+		shards[shardID] = shard
+	}
+	return shard
+}
+
+// recordsFailed reports the number of failed records by error code to the Metrics, if they
+// implement RecordErrorMetrics
+func (wp *WorkerPool) recordsFailed(codes map[string]int) {
+	m, ok := wp.Metrics.(RecordErrorMetrics)
+	if !ok {
+		return
+	}
+	for code, count := range codes {
+		m.RecordsFailed(code, count)
+	}
+}
+
+// formatErrorCodes formats the number of failed records by error code for logs, e.g.
+// "InternalFailure=1,ProvisionedThroughputExceededException=2"
+func formatErrorCodes(codes map[string]int) string {
+	out := make([]string, 0, len(codes))
+	for code, count := range codes {
+		out = append(out, fmt.Sprintf("%s=%d", code, count))
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+// resolve resolves the user records delivered or failed by the pool
+func (wp *WorkerPool) resolve(userRecords []UserRecord, result PutResult) {
+	resolveUserRecords(userRecords, result)
+	if wp.resolved != nil {
+		wp.resolved(userRecords)
+	}
+}
+
+// payloadUnits returns the number of PUT payload units the entry is billed for
+func payloadUnits(entry types.PutRecordsRequestEntry) int {
+	size := len(entry.Data) + len(aws.ToString(entry.PartitionKey))
+	return (size + payloadUnitSize - 1) / payloadUnitSize
+}
+
+// retriesExhausted reports whether work has been retried MaxRetries times
+func (wp *WorkerPool) retriesExhausted(work *Work) bool {
+	return wp.MaxRetries > 0 && work.attempt >= wp.MaxRetries
+}
+
+// fail reports a record that could not be delivered to the DeadLetter and NotifyFailures
+func (wp *WorkerPool) fail(record *AggregatedRecordRequest, err error, attempts int) {
+	wp.Metrics.RecordsDropped(len(record.UserRecords))
+	atomic.AddInt64(&wp.stats.drops, int64(len(record.UserRecords)))
+	wp.resolve(record.UserRecords, PutResult{Err: err})
+	failure := newFailureRecord(record, err, attempts)
+	failure.Class = wp.ErrorClassifier(err)
+	wp.sendDeadLetter(failure)
+	wp.errs <- failure
+}
+
+// unexpired drops the user records whose Put context is done or that are older than
+// MaxRecordAge, and returns the records left to send. Aggregated records that lost some of
+// their user records are aggregated again.
+func (wp *WorkerPool) unexpired(records []*AggregatedRecordRequest, attempts int) []*AggregatedRecordRequest {
+	out := records[:0]
+	now := wp.Clock.Now()
+	for _, record := range records {
+		var kept, expired []UserRecord
+		for _, userRecord := range record.UserRecords {
+			if err := putContext(userRecord).Err(); err != nil {
+				wp.cancelUserRecord(userRecord, err)
+			} else if wp.expired(userRecord, now) {
+				expired = append(expired, userRecord)
+			} else {
+				kept = append(kept, userRecord)
+			}
+		}
+		if len(expired) > 0 {
+			wp.fail(&AggregatedRecordRequest{Entry: record.Entry, UserRecords: expired, stream: record.stream, attempts: record.attempts}, &ErrRecordExpired{MaxRecordAge: wp.MaxRecordAge}, attempts)
+		}
+		switch {
+		case len(kept) == len(record.UserRecords):
+			out = append(out, record)
+		case len(kept) > 0:
+			// only aggregated records have more than one user record. The explicit hash key
+			// is kept so the record is still written to the same shard
+			a := NewAggregator(record.Entry.ExplicitHashKey)
+			a.wireVersion = byte(wp.WireVersion)
+			a.format = wp.AggregationFormat
+			for _, userRecord := range kept {
+				a.Put(userRecord)
+			}
+			reaggregated, err := a.Drain()
+			if err != nil {
+				wp.fail(&AggregatedRecordRequest{Entry: record.Entry, UserRecords: kept, stream: record.stream, attempts: record.attempts}, err, 0)
+				continue
+			}
+			reaggregated.stream = record.stream
+			reaggregated.attempts = record.attempts
+			out = append(out, reaggregated)
+		}
+	}
+	return out
+}
+
+// cancelUserRecord reports a user record dropped because its Put context is done
+func (wp *WorkerPool) cancelUserRecord(userRecord UserRecord, err error) {
+	canceled := &ErrRecordCanceled{UserRecord: unwrapUserRecord(userRecord), Err: err}
+	wp.resolve([]UserRecord{userRecord}, PutResult{Err: canceled})
+	wp.Metrics.RecordsDropped(1)
+	atomic.AddInt64(&wp.stats.drops, 1)
+	wp.errs <- canceled
+}
+
+// backoff sleeps for the duration given by the configured Backoff before work is retried.
+// values are added to the log line. Returns nil if the pool was aborted while sleeping.
+func (wp *WorkerPool) backoff(work *Work, failed int32, values ...LogValue) *Work {
+	work.attempt++
+	work.delay = wp.Backoff.Duration(work.attempt, work.delay)
+
+	wp.Logger.Warn(
+		"put failures",
+		append(append(work.logValues(), LogValue{"failures", failed}, LogValue{"backoff", work.delay.String()}), values...)...,
+	)
+
+	if !wp.sleep(work.delay) {
+		wp.abandon(work)
+		return nil
+	}
 	return work
 }
 
+// sleep waits for the duration. Returns false if the pool was aborted while sleeping.
+func (wp *WorkerPool) sleep(d time.Duration) bool {
+	return wp.sleepUntil(d, nil)
+}
+
+// sleepUntil is sleep returning early once wake is closed
+func (wp *WorkerPool) sleepUntil(d time.Duration, wake <-chan struct{}) bool {
+	timer := wp.Clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-wake:
+		return true
+	case <-wp.ctx.Done():
+		return false
+	}
+}
+
 // failures returns the failed records as indicated in the response.
 func failures(
 	records []*AggregatedRecordRequest,
 	response []types.PutRecordsResultEntry,
+	fatal []*AggregatedRecordRequest,
 	count int32,
 ) []*AggregatedRecordRequest {
 	out := make([]*AggregatedRecordRequest, 0, count)
 	for i, record := range response {
-		if record.ErrorCode != nil {
+		if record.ErrorCode != nil && !containsRecord(fatal, records[i]) {
 			out = append(out, records[i])
 		}
 	}
 	return out
 }
+
+// containsRecord reports whether record is one of records
+func containsRecord(records []*AggregatedRecordRequest, record *AggregatedRecordRequest) bool {
+	for _, r := range records {
+		if r == record {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package producer
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// WriteAheadLog durably stores the user records accepted by Put until they are delivered,
+// e.g. wal.Log. Records that were not acknowledged, because the process crashed or they
+// failed, are put again by Start, so delivery is at least once.
+//
+// Append is called synchronously by Put. Ack may be called more than once for a record.
+type WriteAheadLog interface {
+	// Append stores a record and returns its sequence number
+	Append(data []byte) (uint64, error)
+	// Ack acknowledges the record so that it is no longer replayed
+	Ack(seq uint64) error
+	// Replay calls fn with the records that were not acknowledged before the log was opened
+	Replay(fn func(seq uint64, data []byte) error) error
+}
+
+// logs reports whether the user record must be appended to the WriteAheadLog. Records
+// created by the Producer from a user record and replayed records are not appended.
+func (p *Producer) logs(userRecord UserRecord) bool {
+	if p.WriteAheadLog == nil {
+		return false
+	}
+	switch r := userRecord.(type) {
+	case *trackedRecord:
+		return r.ack == nil
+	case *chunkRecord, *claimCheckRecord, *compressedRecord, *encryptedRecord:
+		return false
+	}
+	return true
+}
+
+// logRecord appends the user record put to stream to the WriteAheadLog, if it must be
+func (p *Producer) logRecord(stream string, userRecord UserRecord) (UserRecord, error) {
+	if !p.logs(userRecord) {
+		return userRecord, nil
+	}
+	tracked, err := p.appendLog(stream, userRecord)
+	if err != nil {
+		return nil, err
+	}
+	return tracked, nil
+}
+
+// appendLog appends the user record put to stream to the WriteAheadLog and returns the
+// tracked record that acknowledges it once resolved
+func (p *Producer) appendLog(stream string, userRecord UserRecord) (*trackedRecord, error) {
+	seq, err := p.WriteAheadLog.Append(encodeLogRecord(stream, userRecord))
+	if err != nil {
+		return nil, &ErrWriteAheadLog{UserRecord: unwrapUserRecord(userRecord), Err: err}
+	}
+	tracked := track(userRecord)
+	tracked.ack = p.acker(seq)
+	return tracked, nil
+}
+
+// acker returns the function acknowledging the record with the sequence number
+func (p *Producer) acker(seq uint64) func() {
+	return func() {
+		if err := p.WriteAheadLog.Ack(seq); err != nil {
+			p.Logger.Error("write ahead log ack", err, LogValue{"seq", seq})
+		}
+	}
+}
+
+// replay puts the records of the WriteAheadLog that were not acknowledged. Records that
+// can not be put are reported to NotifyFailures and acknowledged.
+func (p *Producer) replay() {
+	var replayed int
+	err := p.WriteAheadLog.Replay(func(seq uint64, data []byte) error {
+		ack := p.acker(seq)
+		stream, userRecord, err := decodeLogRecord(data)
+		if err != nil {
+			p.Logger.Error("write ahead log replay", err, LogValue{"seq", seq})
+			ack()
+			return nil
+		}
+		tracked := track(userRecord)
+		tracked.ack = ack
+		if err := p.put(stream, tracked, p.putOptions(p.OverflowPolicy, nil)); err != nil {
+			p.notify(err)
+			return nil
+		}
+		replayed++
+		return nil
+	})
+	if err != nil {
+		p.Logger.Error("write ahead log replay", err)
+	}
+	if replayed > 0 {
+		p.Logger.Info("replayed write ahead log", LogValue{"records", replayed})
+	}
+}
+
+// encodeLogRecord encodes the stream, partition key, explicit hash key and data of the
+// user record, each prefixed with its length as an uvarint
+func encodeLogRecord(stream string, userRecord UserRecord) []byte {
+	var ehk string
+	if hk := userRecord.ExplicitHashKey(); hk != nil {
+		ehk = hk.String()
+	}
+	fields := [][]byte{[]byte(stream), []byte(userRecord.PartitionKey()), []byte(ehk), userRecord.Data()}
+	var buf []byte
+	for _, field := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+	return buf
+}
+
+var errInvalidLogRecord = errors.New("invalid write ahead log record")
+
+// decodeLogRecord decodes a record encoded with encodeLogRecord
+func decodeLogRecord(buf []byte) (string, UserRecord, error) {
+	fields := make([][]byte, 4)
+	for i := range fields {
+		n, size := binary.Uvarint(buf)
+		if size <= 0 || uint64(len(buf)-size) < n {
+			return "", nil, errInvalidLogRecord
+		}
+		fields[i] = buf[size : size+int(n)]
+		buf = buf[size+int(n):]
+	}
+	stream, partitionKey, data := string(fields[0]), string(fields[1]), fields[3]
+	if len(fields[2]) == 0 {
+		return stream, NewDataRecord(data, partitionKey), nil
+	}
+	hk, ok := new(big.Int).SetString(string(fields[2]), 10)
+	if !ok {
+		return "", nil, errInvalidLogRecord
+	}
+	return stream, NewDataRecordWithExplicitHashKey(data, partitionKey, hk), nil
+}
@@ -0,0 +1,137 @@
+package producer
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// WriterOptions configures a Writer
+type WriterOptions struct {
+	// Delimiter separates the records written. Default to '\n'. A trailing '\r' is not
+	// removed
+	Delimiter byte
+	// Split frames the records written, e.g. bufio.ScanLines. Overrides Delimiter
+	Split bufio.SplitFunc
+	// PartitionKey returns the partition key of a record. Default to a random UUID
+	PartitionKey func(data []byte) string
+	// Stream the records are put to. Default to the stream of the Producer
+	Stream string
+	// PutOptions applied to every record
+	PutOptions []PutOption
+}
+
+// Writer is an io.WriteCloser putting a record for every delimited chunk of the data
+// written. Empty chunks are skipped. Writer is safe for concurrent use, although records
+// of concurrent writes may interleave.
+type Writer struct {
+	p      *Producer
+	opts   WriterOptions
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+}
+
+// NewWriter returns a Writer putting records to p. opts may be nil
+func NewWriter(p *Producer, opts *WriterOptions) *Writer {
+	w := &Writer{p: p}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.Split == nil {
+		delimiter := w.opts.Delimiter
+		if delimiter == 0 {
+			delimiter = '\n'
+		}
+		w.opts.Split = splitDelimiter(delimiter)
+	}
+	if w.opts.PartitionKey == nil {
+		w.opts.PartitionKey = func([]byte) string {
+			return uuid.New().String()
+		}
+	}
+	return w
+}
+
+// Write buffers b and puts every complete record. An incomplete record at the end of b is
+// kept until the next Write or Close. Write blocks like Put when the backlog is full.
+//
+// If a record can not be put, Write returns the number of bytes of b up to that record
+// along with the error. The rest of b is not kept, so that the caller can write it again.
+func (w *Writer) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, &ErrClosedWriter{}
+	}
+	buffered := len(w.buf)
+	w.buf = append(w.buf, b...)
+	consumed, err := w.emit(false)
+	if err != nil {
+		// the record that failed may start in the data of a previous Write
+		w.buf = w.buf[consumed:max(consumed, buffered)]
+		return max(consumed-buffered, 0), err
+	}
+	w.buf = w.buf[consumed:]
+	return len(b), nil
+}
+
+// Close puts the remaining buffered data as the last record. It does not stop the
+// Producer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return &ErrClosedWriter{}
+	}
+	w.closed = true
+	_, err := w.emit(true)
+	w.buf = nil
+	return err
+}
+
+// emit puts the records framed by the split function and returns the number of bytes of
+// the buffer they consumed. A record that could not be put is not consumed.
+func (w *Writer) emit(atEOF bool) (int, error) {
+	consumed := 0
+	for consumed < len(w.buf) {
+		advance, token, err := w.opts.Split(w.buf[consumed:], atEOF)
+		if err != nil {
+			return consumed, err
+		}
+		if advance == 0 && token == nil {
+			// more data is needed
+			break
+		}
+		if len(token) > 0 {
+			// the token points into the buffer, which is reused by the next Write
+			data := append([]byte(nil), token...)
+			partitionKey := w.opts.PartitionKey(data)
+			if w.opts.Stream != "" {
+				err = w.p.PutToStream(w.opts.Stream, data, partitionKey, w.opts.PutOptions...)
+			} else {
+				err = w.p.Put(data, partitionKey, w.opts.PutOptions...)
+			}
+			if err != nil {
+				return consumed, err
+			}
+		}
+		consumed += advance
+	}
+	return consumed, nil
+}
+
+// splitDelimiter returns a bufio.SplitFunc splitting on delimiter
+func splitDelimiter(delimiter byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, delimiter); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,133 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	k "github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     *WriterOptions
+		writes   []string
+		expected []string
+	}{
+		{
+			name:     "splits on newlines",
+			writes:   []string{"hello\nwor", "ld\n\nfoo"},
+			expected: []string{"hello", "world", "foo"},
+		},
+		{
+			name:     "splits on delimiter",
+			opts:     &WriterOptions{Delimiter: '|'},
+			writes:   []string{"hello|world|"},
+			expected: []string{"hello", "world"},
+		},
+		{
+			name:     "splits with split func",
+			opts:     &WriterOptions{Split: bufio.ScanWords},
+			writes:   []string{"hello  wor", "ld\r\n"},
+			expected: []string{"hello", "world"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &clientMock{
+				incoming: make(map[int][]string),
+				responses: []responseMock{
+					{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+				},
+			}
+			p := New(&Config{
+				StreamName:     "foo",
+				MaxConnections: 1,
+				FlushInterval:  time.Hour,
+				Logger:         &NopLogger{},
+				Client:         client,
+			})
+			p.Start()
+			defer p.Stop()
+
+			opts := tc.opts
+			if opts == nil {
+				opts = &WriterOptions{}
+			}
+			opts.PutOptions = []PutOption{WithoutAggregation()}
+			w := NewWriter(p, opts)
+			for _, s := range tc.writes {
+				n, err := w.Write([]byte(s))
+				require.NoError(t, err)
+				require.Equal(t, len(s), n)
+			}
+			require.NoError(t, w.Close())
+			require.NoError(t, p.Flush(context.Background()))
+
+			var data []string
+			for _, d := range client.data {
+				data = append(data, string(d))
+			}
+			require.ElementsMatch(t, tc.expected, data)
+
+			_, err := w.Write([]byte("hello"))
+			require.IsType(t, &ErrClosedWriter{}, err)
+		})
+	}
+}
+
+func TestWriterPutError(t *testing.T) {
+	client := &clientMock{
+		incoming: make(map[int][]string),
+		responses: []responseMock{
+			{Response: &k.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}},
+		},
+	}
+	p := New(&Config{
+		StreamName:     "foo",
+		MaxConnections: 1,
+		FlushInterval:  time.Hour,
+		Logger:         &NopLogger{},
+		Client:         client,
+	})
+	p.Start()
+	defer p.Stop()
+
+	// the records "bad" have an illegal partition key
+	w := NewWriter(p, &WriterOptions{
+		PartitionKey: func(data []byte) string {
+			if string(data) == "bad" {
+				return strings.Repeat("k", 257)
+			}
+			return "key"
+		},
+		PutOptions: []PutOption{WithoutAggregation()},
+	})
+
+	// the bytes of the records put are reported and the rest is not kept
+	n, err := w.Write([]byte("foo\nbad\nbar\n"))
+	require.Error(t, err)
+	require.Equal(t, 4, n)
+	n, err = w.Write([]byte("bar\nb"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	// the record that failed started in the previous Write, which keeps it buffered
+	n, err = w.Write([]byte("ad\nbaz\n"))
+	require.Error(t, err)
+	require.Equal(t, 0, n)
+	require.NoError(t, w.Close())
+	require.NoError(t, p.Flush(context.Background()))
+
+	var data []string
+	for _, d := range client.data {
+		data = append(data, string(d))
+	}
+	require.ElementsMatch(t, []string{"foo", "bar", "b"}, data)
+}